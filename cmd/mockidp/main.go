@@ -0,0 +1,230 @@
+// Command mockidp is a local OpenID Connect provider for trying federated
+// login without a real one. It approves every authorization request at
+// once, as the user given by its flags, and is for development only.
+//
+// application.yaml enables no providers. Add it as "mock" for a local run
+// only, through the environment:
+//
+//	go run ./cmd/mockidp
+//	APP_FEDERATION__PROVIDERS__MOCK__ISSUER=http://localhost:9090 \
+//	APP_FEDERATION__PROVIDERS__MOCK__CLIENT_ID=local-app \
+//	APP_FEDERATION__PROVIDERS__MOCK__CLIENT_SECRET=local-secret \
+//	APP_FEDERATION__PROVIDERS__MOCK__SCOPES=openid,profile,email \
+//	APP_FEDERATION__PROVIDERS__MOCK__AUTO_CREATE=true \
+//	go run .
+//	open http://localhost:8083/api/v1/auth/federated/mock/login
+//
+// Add ?login_hint=someone@example.com to the authorization URL to sign in
+// as a different upstream account; its subject is derived from the email.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"hash/crc32"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"test123/utils/jwt"
+)
+
+type pendingCode struct {
+	clientID      string
+	redirectURI   string
+	codeChallenge string
+	nonce         string
+	subject       int
+	email         string
+	expiresAt     time.Time
+}
+
+type idp struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	subject      int
+	email        string
+	name         string
+
+	signer *jwt.Jwt
+	keys   *jwt.KeyRing
+
+	mu    sync.Mutex
+	codes map[string]pendingCode
+}
+
+func main() {
+	listen := flag.String("listen", "localhost:9090", "address to listen on")
+	issuer := flag.String("issuer", "http://localhost:9090", "issuer URL, as configured in federation.providers")
+	clientID := flag.String("client-id", "local-app", "the only client accepted")
+	clientSecret := flag.String("client-secret", "local-secret", "its secret")
+	subject := flag.Int("sub", 1001, "subject of the signed-in user")
+	email := flag.String("email", "mock.user@example.com", "email of the signed-in user")
+	name := flag.String("name", "Mock User", "name of the signed-in user")
+	flag.Parse()
+
+	key, err := jwt.GenerateKey(jwt.AlgES256)
+	if err != nil {
+		log.Fatalf("failed to generate signing key: %v", err)
+	}
+	keys := jwt.NewKeyRing(key)
+
+	p := &idp{
+		issuer:       *issuer,
+		clientID:     *clientID,
+		clientSecret: *clientSecret,
+		subject:      *subject,
+		email:        *email,
+		name:         *name,
+		signer:       jwt.NewJwtWithKeys(keys),
+		keys:         keys,
+		codes:        map[string]pendingCode{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/jwks", p.jwks)
+
+	log.Printf("mock identity provider %s listening on %s", p.issuer, *listen)
+	log.Fatal(http.ListenAndServe(*listen, mux))
+}
+
+func respond(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(payload)
+}
+
+func (p *idp) discovery(w http.ResponseWriter, r *http.Request) {
+	respond(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{jwt.AlgES256},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *idp) jwks(w http.ResponseWriter, r *http.Request) {
+	respond(w, http.StatusOK, p.keys.JWKS(time.Now()))
+}
+
+// authorize approves the request straight away and redirects back with a
+// code.
+func (p *idp) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != p.clientID || q.Get("response_type") != "code" || q.Get("redirect_uri") == "" {
+		respond(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		respond(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	c := pendingCode{
+		clientID:      p.clientID,
+		redirectURI:   q.Get("redirect_uri"),
+		codeChallenge: q.Get("code_challenge"),
+		nonce:         q.Get("nonce"),
+		subject:       p.subject,
+		email:         p.email,
+		expiresAt:     time.Now().Add(time.Minute),
+	}
+	if hint := q.Get("login_hint"); hint != "" {
+		c.email = hint
+		c.subject = int(crc32.ChecksumIEEE([]byte(hint)) & 0x7fffffff)
+	}
+
+	code := randomString()
+	p.mu.Lock()
+	p.codes[code] = c
+	p.mu.Unlock()
+
+	rq := redirect.Query()
+	rq.Set("code", code)
+	if state := q.Get("state"); state != "" {
+		rq.Set("state", state)
+	}
+	redirect.RawQuery = rq.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// token redeems a code for an id_token, checking the client credentials
+// and the PKCE verifier.
+func (p *idp) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		respond(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if id != p.clientID || subtle.ConstantTimeCompare([]byte(secret), []byte(p.clientSecret)) != 1 {
+		respond(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	p.mu.Lock()
+	c, found := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	if !found || time.Now().After(c.expiresAt) || c.redirectURI != r.PostForm.Get("redirect_uri") {
+		respond(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "unknown, expired or mismatched code"})
+		return
+	}
+	if c.codeChallenge != "" {
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != c.codeChallenge {
+			respond(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "code_verifier does not match"})
+			return
+		}
+	}
+
+	claims := map[string]interface{}{
+		"email":          c.email,
+		"email_verified": true,
+		"name":           p.name,
+	}
+	if c.nonce != "" {
+		claims["nonce"] = c.nonce
+	}
+	idToken, err := p.signer.GenerateIDToken(p.issuer, p.clientID, c.subject, 5*time.Minute, claims)
+	if err != nil {
+		respond(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respond(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"test123/config"
+	consumers "test123/kafka/consumers"
+	producers "test123/kafka/producers"
+	"test123/logger"
+	"test123/notifier"
+	"test123/repositories"
+	"test123/service"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type runner interface {
+	Run(ctx context.Context) error
+}
+
+func main() {
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger.SetServiceName("notification-service")
+
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		panic(" Failed to load config: " + err.Error())
+	}
+
+	pool, err := repositories.Connect(ctx, cfg)
+	if err != nil {
+		panic(" Failed to connect to database: " + err.Error())
+	}
+	defer pool.Close()
+
+	producer := producers.NewKafkaNotificationProducer(cfg.Kafka.Brokers, cfg.Kafka.Topic)
+	defer producer.Close()
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     cfg.Redis.Host + ":" + strconv.Itoa(cfg.Redis.Port),
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
+		PoolSize: cfg.Redis.PoolSize,
+	})
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		panic(" Failed to connect to redis: " + err.Error())
+	}
+	defer rdb.Close()
+
+	sms, err := notifier.NewSMSProvider(cfg.SMS)
+	if err != nil {
+		panic(" Failed to set up sms provider: " + err.Error())
+	}
+
+	templates := service.NewTemplateService(repositories.NewTemplateRepo(pool))
+
+	dispatcher := notifier.NewDispatcher(
+		repositories.NewDeliveryRepo(pool),
+		templates,
+		service.NewPreferenceService(repositories.NewPreferenceRepo(pool)),
+		notifier.NewInboxChannel(repositories.NewInboxRepo(pool), rdb),
+		notifier.NewSMTPChannel(cfg.SMTP),
+		notifier.NewSMSChannel(sms),
+		notifier.NewPushChannel(),
+	)
+
+	schedule := notifier.NewRetrySchedule(cfg.Kafka.Topic, cfg.Kafka.RetryDelays, cfg.Kafka.DeadLetterTopic)
+
+	// one consumer group per topic so a long delay on one tier never holds
+	// back the others
+	var runners []runner
+	var readers []*consumers.KafkaNotificationConsumer
+
+	primary := consumers.NewKafkaNotificationConsumer(cfg.Kafka.Brokers, cfg.Kafka.ConsumerGroupID, cfg.Kafka.Topic)
+	readers = append(readers, primary)
+	runners = append(runners, notifier.NewWorker(primary, dispatcher, producer, schedule, 0))
+
+	for tier, delay := range cfg.Kafka.RetryDelays {
+		topic := schedule.RetryTopic(tier)
+		c := consumers.NewKafkaNotificationConsumer(cfg.Kafka.Brokers, cfg.Kafka.ConsumerGroupID+"."+topic, topic)
+		readers = append(readers, c)
+		runners = append(runners, notifier.NewWorker(c, dispatcher, producer, schedule, delay))
+	}
+
+	dlq := consumers.NewKafkaNotificationConsumer(cfg.Kafka.Brokers, cfg.Kafka.ConsumerGroupID+".dlq", cfg.Kafka.DeadLetterTopic)
+	readers = append(readers, dlq)
+	runners = append(runners, notifier.NewDeadLetterArchiver(dlq, repositories.NewDeadLetterRepo(pool)))
+
+	defer func() {
+		for _, c := range readers {
+			c.Close()
+		}
+	}()
+
+	fmt.Println(" Starting notification consumer on topic", cfg.Kafka.Topic)
+
+	var wg sync.WaitGroup
+	for _, r := range runners {
+		wg.Add(1)
+		go func(r runner) {
+			defer wg.Done()
+			if err := r.Run(ctx); err != nil {
+				logger.Error("main", "runner stopped with error", map[string]interface{}{"error": err.Error()})
+				stop()
+			}
+		}(r)
+	}
+	wg.Wait()
+}
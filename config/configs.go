@@ -1,13 +1,34 @@
 package config
 
-import "fmt"
+import (
+	"fmt"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
 
 type Config struct {
-	Listen   string   `koanf:"listen"`
+	Listen string `koanf:"listen"`
+	// addresses or CIDRs of reverse proxies whose X-Forwarded-For and
+	// X-Real-IP headers are believed; empty trusts no forwarded headers
+	TrustedProxies []string `koanf:"trusted_proxies"`
+
 	Postgres Postgres `koanf:"postgres"`
 	Redis    Redis    `koanf:"redis"`
 
 	Kafka Kafka `koanf:"kafka"`
+	SMTP  SMTP  `koanf:"smtp"`
+	SMS   SMS   `koanf:"sms"`
+
+	Auth           Auth           `koanf:"auth"`
+	LoginThrottle  LoginThrottle  `koanf:"login_throttle"`
+	PasswordPolicy PasswordPolicy `koanf:"password_policy"`
+	RateLimit      RateLimit      `koanf:"rate_limit"`
+	Bloom          Bloom          `koanf:"bloom"`
+
+	Federation Federation `koanf:"federation"`
 }
 
 type Postgres struct {
@@ -16,6 +37,7 @@ type Postgres struct {
 	User     string `koanf:"user"`
 	Password string `koanf:"password"`
 	Dbname   string `koanf:"db"`
+	SSLMode  string `koanf:"sslmode"`
 }
 
 type Redis struct {
@@ -23,6 +45,7 @@ type Redis struct {
 	Port     int    `koanf:"port"`
 	Password string `koanf:"password"`
 	DB       int    `koanf:"db"`
+	PoolSize int    `koanf:"pool_size"`
 }
 
 type Kafka struct {
@@ -30,6 +53,156 @@ type Kafka struct {
 	Topic   string   `koanf:"topic"`
 
 	ProducerGroupID string `koanf:"producer_group"`
+	ConsumerGroupID string `koanf:"consumer_group"`
+
+	// failed deliveries wait on one delay topic per entry, then go to the DLQ
+	RetryDelays     []time.Duration `koanf:"retry_delays"`
+	DeadLetterTopic string          `koanf:"dead_letter_topic"`
+}
+
+// SMS picks the text message gateway: "log" only logs messages, "file"
+// appends them to FilePath.
+type SMS struct {
+	Provider string `koanf:"provider"`
+	FilePath string `koanf:"file_path"`
+}
+
+type SMTP struct {
+	Host     string `koanf:"host"`
+	Port     int    `koanf:"port"`
+	Username string `koanf:"username"`
+	Password string `koanf:"password"`
+	From     string `koanf:"from"`
+}
+
+type Auth struct {
+	JWTSecret       string        `koanf:"jwt_secret"`
+	AccessTokenTTL  time.Duration `koanf:"access_token_ttl"`
+	RefreshTokenTTL time.Duration `koanf:"refresh_token_ttl"`
+	ResetTokenTTL   time.Duration `koanf:"reset_token_ttl"`
+	// the reset token is appended as ?token=
+	ResetURLBase string `koanf:"reset_url_base"`
+
+	// email verification links; the token is appended as ?token=. The undo
+	// link goes to the old address when the email is changed.
+	VerifyEmailURLBase string        `koanf:"verify_email_url_base"`
+	EmailUndoURLBase   string        `koanf:"email_undo_url_base"`
+	EmailVerifyTTL     time.Duration `koanf:"email_verify_ttl"`
+	EmailUndoTTL       time.Duration `koanf:"email_undo_ttl"`
+
+	// passwordless login by emailed link or 6-digit code; the token is
+	// appended to MagicLinkURLBase as ?token=
+	MagicLinkURLBase     string        `koanf:"magic_link_url_base"`
+	MagicLinkTTL         time.Duration `koanf:"magic_link_ttl"`
+	MagicLinkMaxAttempts int           `koanf:"magic_link_max_attempts"`
+
+	// phone verification codes sent by SMS
+	PhoneOTPTTL         time.Duration `koanf:"phone_otp_ttl"`
+	PhoneOTPMaxAttempts int           `koanf:"phone_otp_max_attempts"`
+
+	// asymmetric signing keys, stored sealed under JWTSecret
+	SigningAlgorithm    string        `koanf:"signing_algorithm"` // RS256, ES256 or EdDSA
+	KeyRotationInterval time.Duration `koanf:"key_rotation_interval"`
+	KeyPublishLead      time.Duration `koanf:"key_publish_lead"`
+	KeyReloadInterval   time.Duration `koanf:"key_reload_interval"`
+
+	// TOTP two-factor: issuer shown in authenticator apps, lifetime of the
+	// challenge token between password and code, and codes tried per challenge
+	MFAIssuer       string        `koanf:"mfa_issuer"`
+	MFAChallengeTTL time.Duration `koanf:"mfa_challenge_ttl"`
+	MFAMaxAttempts  int           `koanf:"mfa_max_attempts"`
+
+	// OAuth2: lifetime of authorization codes and of client_credentials
+	// access tokens
+	OAuthCodeTTL        time.Duration `koanf:"oauth_code_ttl"`
+	OAuthClientTokenTTL time.Duration `koanf:"oauth_client_token_ttl"`
+
+	// OpenID Connect: the public base URL of this service, used as the
+	// "iss" of id_tokens and in discovery, and how long id_tokens last
+	Issuer     string        `koanf:"issuer"`
+	IDTokenTTL time.Duration `koanf:"id_token_ttl"`
+
+	// WebAuthn passkeys: the relying party ID (a registrable domain, no
+	// scheme or port), the name authenticators show, the exact origins the
+	// sign-in pages are served from, and how long a ceremony may take
+	WebAuthnRPID         string        `koanf:"webauthn_rp_id"`
+	WebAuthnRPName       string        `koanf:"webauthn_rp_name"`
+	WebAuthnOrigins      []string      `koanf:"webauthn_origins"`
+	WebAuthnChallengeTTL time.Duration `koanf:"webauthn_challenge_ttl"`
+}
+
+// LoginThrottle slows down password guessing. Failed logins are counted
+// in a sliding Window per account and per client IP. Past DelayAfter
+// failures the next attempt must wait, starting at BaseDelay and doubling
+// up to MaxDelay; at LockoutAfter failures the account or IP is locked for
+// the lockout duration.
+type LoginThrottle struct {
+	Window    time.Duration `koanf:"window"`
+	BaseDelay time.Duration `koanf:"base_delay"`
+	MaxDelay  time.Duration `koanf:"max_delay"`
+
+	AccountDelayAfter   int           `koanf:"account_delay_after"`
+	AccountLockoutAfter int           `koanf:"account_lockout_after"`
+	AccountLockout      time.Duration `koanf:"account_lockout"`
+
+	// an IP may be a NAT or proxy in front of many users, so its limits
+	// should be looser
+	IPDelayAfter   int           `koanf:"ip_delay_after"`
+	IPLockoutAfter int           `koanf:"ip_lockout_after"`
+	IPLockout      time.Duration `koanf:"ip_lockout"`
+}
+
+// PasswordPolicy decides which new passwords are accepted. MinStrength is
+// a 0-4 score from the guess estimator, HistorySize is how many previous
+// passwords may not be reused, and a non-zero MaxAge forces a change once
+// a password gets older. BreachedListPath is an optional file of SHA-1
+// hashes of leaked passwords, one per line.
+type PasswordPolicy struct {
+	MinLength int `koanf:"min_length"`
+	MaxLength int `koanf:"max_length"`
+
+	RequireUpper  bool `koanf:"require_upper"`
+	RequireLower  bool `koanf:"require_lower"`
+	RequireDigit  bool `koanf:"require_digit"`
+	RequireSymbol bool `koanf:"require_symbol"`
+
+	MinStrength      int           `koanf:"min_strength"`
+	HistorySize      int           `koanf:"history_size"`
+	MaxAge           time.Duration `koanf:"max_age"`
+	BreachedListPath string        `koanf:"breached_list_path"`
+}
+
+// RateLimit caps authenticated requests per user per window.
+type RateLimit struct {
+	Requests int           `koanf:"requests"`
+	Window   time.Duration `koanf:"window"`
+}
+
+// Federation lists the external OpenID Connect providers users can sign
+// in with, keyed by the name used in the login URL.
+type Federation struct {
+	Providers map[string]FederationProvider `koanf:"providers"`
+	// how long a user has to finish signing in at the provider
+	StateTTL time.Duration `koanf:"state_ttl"`
+}
+
+// FederationProvider is one upstream provider; endpoints and keys come
+// from its discovery document under Issuer.
+type FederationProvider struct {
+	Issuer       string   `koanf:"issuer"`
+	ClientID     string   `koanf:"client_id"`
+	ClientSecret string   `koanf:"client_secret"`
+	Scopes       []string `koanf:"scopes"`
+	// create a local user on first sign-in instead of refusing it
+	AutoCreate bool `koanf:"auto_create"`
+}
+
+var providerName = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// Bloom sizes the username bloom filter.
+type Bloom struct {
+	ExpectedItems     uint    `koanf:"expected_items"`
+	FalsePositiveRate float64 `koanf:"false_positive_rate"`
 }
 
 func (c *Config) Validate() error {
@@ -37,6 +210,9 @@ func (c *Config) Validate() error {
 	if c.Listen == "" {
 		return fmt.Errorf("listen address is required")
 	}
+	if _, err := c.TrustedProxyPrefixes(); err != nil {
+		return err
+	}
 
 	// postgres
 	if c.Postgres.Host == "" {
@@ -64,10 +240,180 @@ func (c *Config) Validate() error {
 	}
 	// password can be empty (public redis)
 	// db can be 0 → valid
+	if c.Redis.PoolSize <= 0 {
+		return fmt.Errorf("redis pool_size must be positive")
+	}
+
+	// kafka
+	if len(c.Kafka.Brokers) == 0 {
+		return fmt.Errorf("kafka brokers are required")
+	}
+	if c.Kafka.Topic == "" {
+		return fmt.Errorf("kafka topic is required")
+	}
+
+	// sms
+	switch c.SMS.Provider {
+	case "log":
+	case "file":
+		if c.SMS.FilePath == "" {
+			return fmt.Errorf("sms file_path is required for the file provider")
+		}
+	default:
+		return fmt.Errorf("sms provider must be log or file")
+	}
+
+	// auth
+	if len(c.Auth.JWTSecret) < 16 {
+		return fmt.Errorf("auth jwt_secret must be at least 16 characters")
+	}
+	if c.Auth.AccessTokenTTL <= 0 || c.Auth.RefreshTokenTTL <= 0 || c.Auth.ResetTokenTTL <= 0 {
+		return fmt.Errorf("auth token TTLs must be positive")
+	}
+	if c.Auth.RefreshTokenTTL < c.Auth.AccessTokenTTL {
+		return fmt.Errorf("auth refresh_token_ttl must not be shorter than access_token_ttl")
+	}
+	if u, err := url.Parse(c.Auth.ResetURLBase); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("auth reset_url_base must be an absolute URL")
+	}
+	for _, base := range []string{c.Auth.VerifyEmailURLBase, c.Auth.EmailUndoURLBase} {
+		if u, err := url.Parse(base); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("auth verify_email_url_base and email_undo_url_base must be absolute URLs")
+		}
+	}
+	if c.Auth.EmailVerifyTTL <= 0 || c.Auth.EmailUndoTTL <= 0 {
+		return fmt.Errorf("auth email_verify_ttl and email_undo_ttl must be positive")
+	}
+	if u, err := url.Parse(c.Auth.MagicLinkURLBase); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("auth magic_link_url_base must be an absolute URL")
+	}
+	if c.Auth.MagicLinkTTL <= 0 || c.Auth.MagicLinkMaxAttempts <= 0 {
+		return fmt.Errorf("auth magic_link_ttl and magic_link_max_attempts must be positive")
+	}
+	if c.Auth.PhoneOTPTTL <= 0 || c.Auth.PhoneOTPMaxAttempts <= 0 {
+		return fmt.Errorf("auth phone_otp_ttl and phone_otp_max_attempts must be positive")
+	}
+
+	if c.Auth.MFAIssuer == "" || c.Auth.MFAChallengeTTL <= 0 || c.Auth.MFAMaxAttempts <= 0 {
+		return fmt.Errorf("auth mfa_issuer, mfa_challenge_ttl and mfa_max_attempts are required")
+	}
+	if c.Auth.OAuthCodeTTL <= 0 || c.Auth.OAuthClientTokenTTL <= 0 {
+		return fmt.Errorf("auth oauth_code_ttl and oauth_client_token_ttl must be positive")
+	}
+	if u, err := url.Parse(c.Auth.Issuer); err != nil || u.Scheme == "" || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("auth issuer must be an absolute URL without query or fragment")
+	}
+	if c.Auth.IDTokenTTL <= 0 {
+		return fmt.Errorf("auth id_token_ttl must be positive")
+	}
+	if c.Auth.WebAuthnRPID == "" || strings.ContainsAny(c.Auth.WebAuthnRPID, ":/") {
+		return fmt.Errorf("auth webauthn_rp_id must be a domain without scheme or port")
+	}
+	if c.Auth.WebAuthnRPName == "" || len(c.Auth.WebAuthnOrigins) == 0 || c.Auth.WebAuthnChallengeTTL <= 0 {
+		return fmt.Errorf("auth webauthn_rp_name, webauthn_origins and webauthn_challenge_ttl are required")
+	}
+	for _, o := range c.Auth.WebAuthnOrigins {
+		if u, err := url.Parse(o); err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			return fmt.Errorf("auth webauthn_origins must be scheme://host[:port] origins")
+		}
+	}
+
+	switch c.Auth.SigningAlgorithm {
+	case "RS256", "ES256", "EdDSA":
+	default:
+		return fmt.Errorf("auth signing_algorithm must be RS256, ES256 or EdDSA")
+	}
+	if c.Auth.KeyReloadInterval <= 0 {
+		return fmt.Errorf("auth key_reload_interval must be positive")
+	}
+	// other instances and JWKS caches need time to see a key before it signs
+	if c.Auth.KeyPublishLead < c.Auth.KeyReloadInterval {
+		return fmt.Errorf("auth key_publish_lead must be at least key_reload_interval")
+	}
+	if c.Auth.KeyRotationInterval <= c.Auth.KeyPublishLead {
+		return fmt.Errorf("auth key_rotation_interval must be longer than key_publish_lead")
+	}
+
+	// login throttle
+	t := c.LoginThrottle
+	if t.Window <= 0 || t.BaseDelay <= 0 || t.MaxDelay < t.BaseDelay {
+		return fmt.Errorf("login_throttle window and base_delay must be positive and max_delay at least base_delay")
+	}
+	if t.AccountDelayAfter <= 0 || t.AccountLockoutAfter <= t.AccountDelayAfter || t.AccountLockout <= 0 {
+		return fmt.Errorf("login_throttle account_lockout_after must exceed account_delay_after, and both and account_lockout must be positive")
+	}
+	if t.IPDelayAfter <= 0 || t.IPLockoutAfter <= t.IPDelayAfter || t.IPLockout <= 0 {
+		return fmt.Errorf("login_throttle ip_lockout_after must exceed ip_delay_after, and both and ip_lockout must be positive")
+	}
+
+	// password policy
+	pp := c.PasswordPolicy
+	if pp.MinLength <= 0 || pp.MaxLength < pp.MinLength {
+		return fmt.Errorf("password_policy min_length must be positive and max_length at least min_length")
+	}
+	if pp.MinStrength < 0 || pp.MinStrength > 4 {
+		return fmt.Errorf("password_policy min_strength must be between 0 and 4")
+	}
+	if pp.HistorySize < 0 || pp.MaxAge < 0 {
+		return fmt.Errorf("password_policy history_size and max_age must not be negative")
+	}
+
+	// rate limit
+	if c.RateLimit.Requests <= 0 || c.RateLimit.Window <= 0 {
+		return fmt.Errorf("rate_limit requests and window must be positive")
+	}
+
+	// federation
+	for name, p := range c.Federation.Providers {
+		if !providerName.MatchString(name) {
+			return fmt.Errorf("federation provider name %q must be lowercase letters, digits and dashes", name)
+		}
+		if u, err := url.Parse(p.Issuer); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("federation provider %s issuer must be an absolute URL", name)
+		}
+		if p.ClientID == "" {
+			return fmt.Errorf("federation provider %s client_id is required", name)
+		}
+	}
+	if c.Federation.StateTTL <= 0 {
+		return fmt.Errorf("federation state_ttl must be positive")
+	}
+
+	// bloom
+	if c.Bloom.ExpectedItems == 0 {
+		return fmt.Errorf("bloom expected_items must be positive")
+	}
+	if c.Bloom.FalsePositiveRate <= 0 || c.Bloom.FalsePositiveRate >= 1 {
+		return fmt.Errorf("bloom false_positive_rate must be between 0 and 1")
+	}
 
 	return nil
 }
 
+// TrustedProxyPrefixes parses TrustedProxies; a bare address is a single
+// host prefix.
+func (c *Config) TrustedProxyPrefixes() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(c.TrustedProxies))
+	for _, s := range c.TrustedProxies {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if p, err := netip.ParsePrefix(s); err == nil {
+			prefixes = append(prefixes, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("trusted_proxies entry %q is not an IP address or CIDR", s)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+// DefaultConfig is the base layer Load starts from. It has no JWT secret,
+// so one must come from the config file, the environment or a flag.
 var DefaultConfig = Config{
 	Listen: "localhost:8083",
 
@@ -77,6 +423,7 @@ var DefaultConfig = Config{
 		User:     "postgres",
 		Password: "pavan",
 		Dbname:   "gotest",
+		SSLMode:  "disable",
 	},
 
 	Redis: Redis{
@@ -84,10 +431,96 @@ var DefaultConfig = Config{
 		Port:     6379,
 		Password: "",
 		DB:       0,
+		PoolSize: 10,
 	},
 	Kafka: Kafka{
 		Brokers:         []string{"localhost:9092"},
 		Topic:           "email-service",
 		ProducerGroupID: "notify-producer",
+		ConsumerGroupID: "notification-service",
+		RetryDelays:     []time.Duration{time.Minute, 10 * time.Minute, time.Hour},
+		DeadLetterTopic: "email-service.dlq",
+	},
+	SMTP: SMTP{
+		Host: "localhost",
+		Port: 1025,
+		From: "no-reply@localhost",
+	},
+	SMS: SMS{
+		Provider: "log",
+	},
+	Auth: Auth{
+		AccessTokenTTL:  15 * time.Minute,
+		RefreshTokenTTL: 4 * time.Hour,
+		ResetTokenTTL:   10 * time.Minute,
+		ResetURLBase:    "http://localhost:8083/api/v1/auth/reset-password",
+
+		VerifyEmailURLBase: "http://localhost:8083/api/v1/auth/verify-email",
+		EmailUndoURLBase:   "http://localhost:8083/api/v1/auth/email-change/undo",
+		EmailVerifyTTL:     24 * time.Hour,
+		EmailUndoTTL:       7 * 24 * time.Hour,
+
+		MagicLinkURLBase:     "http://localhost:8083/api/v1/auth/magic-link/consume",
+		MagicLinkTTL:         10 * time.Minute,
+		MagicLinkMaxAttempts: 5,
+
+		PhoneOTPTTL:         10 * time.Minute,
+		PhoneOTPMaxAttempts: 5,
+
+		SigningAlgorithm:    "ES256",
+		KeyRotationInterval: 30 * 24 * time.Hour,
+		KeyPublishLead:      time.Hour,
+		KeyReloadInterval:   time.Minute,
+
+		MFAIssuer:       "test123",
+		MFAChallengeTTL: 5 * time.Minute,
+		MFAMaxAttempts:  5,
+
+		OAuthCodeTTL:        time.Minute,
+		OAuthClientTokenTTL: time.Hour,
+
+		Issuer:     "http://localhost:8083",
+		IDTokenTTL: time.Hour,
+
+		WebAuthnRPID:         "localhost",
+		WebAuthnRPName:       "test123",
+		WebAuthnOrigins:      []string{"http://localhost:8083"},
+		WebAuthnChallengeTTL: 5 * time.Minute,
+	},
+	LoginThrottle: LoginThrottle{
+		Window:    15 * time.Minute,
+		BaseDelay: time.Second,
+		MaxDelay:  30 * time.Second,
+
+		AccountDelayAfter:   3,
+		AccountLockoutAfter: 10,
+		AccountLockout:      15 * time.Minute,
+
+		IPDelayAfter:   20,
+		IPLockoutAfter: 100,
+		IPLockout:      15 * time.Minute,
+	},
+	PasswordPolicy: PasswordPolicy{
+		MinLength: 8,
+		MaxLength: 128,
+
+		RequireUpper:  true,
+		RequireLower:  true,
+		RequireDigit:  true,
+		RequireSymbol: true,
+
+		MinStrength: 2,
+		HistorySize: 5,
+	},
+	RateLimit: RateLimit{
+		Requests: 100,
+		Window:   2 * time.Minute,
+	},
+	Bloom: Bloom{
+		ExpectedItems:     1_000_000,
+		FalsePositiveRate: 0.01,
+	},
+	Federation: Federation{
+		StateTTL: 10 * time.Minute,
 	},
 }
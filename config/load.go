@@ -0,0 +1,108 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/knadh/koanf/parsers/yaml"
+	"github.com/knadh/koanf/providers/confmap"
+	"github.com/knadh/koanf/providers/env"
+	"github.com/knadh/koanf/providers/file"
+	"github.com/knadh/koanf/providers/structs"
+	"github.com/knadh/koanf/v2"
+)
+
+const (
+	// DefaultPath is read when no -config flag or APP_CONFIG is given. A
+	// missing default file is not an error.
+	DefaultPath = "application.yaml"
+
+	// EnvPrefix marks environment overrides. Nested keys are separated by
+	// a double underscore: APP_POSTGRES__HOST, APP_AUTH__JWT_SECRET.
+	EnvPrefix = "APP_"
+)
+
+// Load builds the configuration from, in increasing priority:
+// DefaultConfig, the YAML file, APP_* environment variables and
+// command-line flags, then validates it.
+//
+// Every key can be set as a flag named after its path, for example
+// -postgres.host=db or -kafka.brokers=a:9092,b:9092. Lists are
+// comma-separated and durations use Go syntax ("15m").
+func Load(args []string) (Config, error) {
+	k := koanf.New(".")
+
+	if err := k.Load(structs.Provider(DefaultConfig, "koanf"), nil); err != nil {
+		return Config{}, fmt.Errorf("load defaults: %w", err)
+	}
+
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	path := fs.String("config", "", "path to the YAML config file (default "+DefaultPath+")")
+	keys := k.Keys()
+	sort.Strings(keys)
+	for _, key := range keys {
+		fs.String(key, "", fmt.Sprintf("override %s (default %v)", key, k.Get(key)))
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	// file
+	explicit := true
+	if *path == "" {
+		*path = os.Getenv(EnvPrefix + "CONFIG")
+	}
+	if *path == "" {
+		*path, explicit = DefaultPath, false
+	}
+	if err := k.Load(file.Provider(*path), yaml.Parser()); err != nil {
+		if explicit || !errors.Is(err, os.ErrNotExist) {
+			return Config{}, fmt.Errorf("load %s: %w", *path, err)
+		}
+	}
+
+	// environment
+	err := k.Load(env.Provider(EnvPrefix, ".", func(s string) string {
+		return strings.ReplaceAll(strings.ToLower(strings.TrimPrefix(s, EnvPrefix)), "__", ".")
+	}), nil)
+	if err != nil {
+		return Config{}, fmt.Errorf("load environment: %w", err)
+	}
+
+	// flags, only the ones actually given
+	overrides := map[string]interface{}{}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "config" {
+			overrides[f.Name] = f.Value.String()
+		}
+	})
+	if err := k.Load(confmap.Provider(overrides, "."), nil); err != nil {
+		return Config{}, fmt.Errorf("load flags: %w", err)
+	}
+
+	var cfg Config
+	err = k.UnmarshalWithConf("", &cfg, koanf.UnmarshalConf{
+		DecoderConfig: &mapstructure.DecoderConfig{
+			DecodeHook: mapstructure.ComposeDecodeHookFunc(
+				mapstructure.StringToTimeDurationHookFunc(),
+				mapstructure.StringToSliceHookFunc(","),
+			),
+			WeaklyTypedInput: true,
+			Result:           &cfg,
+			TagName:          "koanf",
+		},
+	})
+	if err != nil {
+		return Config{}, fmt.Errorf("decode config: %w", err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, nil
+}
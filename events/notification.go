@@ -15,6 +15,7 @@ type NotificationEvent struct {
 	Title            string            `json:"title,omitempty"`
 	Message          string            `json:"message"`
 	Target           string            `json:"target"` // email / phone / deviceToken
+	Locale           string            `json:"locale,omitempty"`
 	Metadata         map[string]string `json:"metadata,omitempty"`
 	CreatedAt        time.Time         `json:"created_at"`
 }
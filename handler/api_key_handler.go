@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"test123/errors"
+	"test123/logger"
+	middlewares "test123/middleware"
+	"test123/models"
+	"test123/requests"
+	"test123/service"
+	"test123/utils"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type APIKeyHandler struct {
+	Service *service.APIKeyService
+	Users   *service.UserService
+}
+
+func NewAPIKeyHandler(s *service.APIKeyService, users *service.UserService) *APIKeyHandler {
+	return &APIKeyHandler{Service: s, Users: users}
+}
+
+// apiKeyParams reads the {Id} and, when present, {keyId} URL params.
+func apiKeyParams(r *http.Request) (int, int, bool) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "Id"))
+	if err != nil || userID <= 0 {
+		return 0, 0, false
+	}
+	if p := chi.URLParam(r, "keyId"); p != "" {
+		id, err := strconv.Atoi(p)
+		if err != nil || id <= 0 {
+			return 0, 0, false
+		}
+		return userID, id, true
+	}
+	return userID, 0, true
+}
+
+// GET /users/{Id}/api-keys
+// GET /admin/service-accounts/{Id}/api-keys
+func (h *APIKeyHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := apiKeyParams(r)
+	if !ok {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return
+	}
+
+	list, err := h.Service.List(r.Context(), userID)
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"api_keys": list})
+}
+
+// POST /users/{Id}/api-keys
+// POST /admin/service-accounts/{Id}/api-keys
+// Body: {"name", "scopes", "expires_at"}. The response is the only time the
+// key itself is returned.
+func (h *APIKeyHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := apiKeyParams(r)
+	if !ok {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return
+	}
+	// a leaked key must not be able to mint more keys
+	if p, ok := r.Context().Value(middlewares.PrincipalKey).(*models.Principal); ok && p.APIKeyID != 0 {
+		utils.RespondJSON(w, http.StatusForbidden, map[string]string{"error": "api keys cannot create api keys"})
+		return
+	}
+	var req requests.APIKeyReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidJSON.Error()})
+		return
+	}
+
+	created, key, err := h.Service.Create(r.Context(), userID, req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		logger.Error("APIKeyHandler.Create", "service failed", map[string]interface{}{"user_id": userID, "error": err.Error()})
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusCreated, map[string]interface{}{"api_key": created, "key": key})
+}
+
+// DELETE /users/{Id}/api-keys/{keyId}
+// DELETE /admin/service-accounts/{Id}/api-keys/{keyId}
+func (h *APIKeyHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := apiKeyParams(r)
+	if !ok {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return
+	}
+
+	if err := h.Service.Revoke(r.Context(), userID, id); err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "api key revoked"})
+}
+
+// GET /admin/service-accounts
+func (h *APIKeyHandler) ListServiceAccounts(w http.ResponseWriter, r *http.Request) {
+	list, err := h.Users.ListServiceAccounts(r.Context())
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"service_accounts": list})
+}
+
+// POST /admin/service-accounts
+// Body: {"name", "username"}. Grant roles through /admin/assign-role.
+func (h *APIKeyHandler) CreateServiceAccount(w http.ResponseWriter, r *http.Request) {
+	var req requests.ServiceAccountReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidJSON.Error()})
+		return
+	}
+
+	user, err := h.Users.CreateServiceAccount(r.Context(), req.Name, req.Username)
+	if err != nil {
+		logger.Error("APIKeyHandler.CreateServiceAccount", "service failed", map[string]interface{}{"username": req.Username, "error": err.Error()})
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusCreated, user)
+}
+
+// ServiceAccountOnly makes the {Id} routes it guards answer 404 unless
+// {Id} is a service account, so admins only manage keys of machines.
+func (h *APIKeyHandler) ServiceAccountOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _, ok := apiKeyParams(r)
+		if !ok {
+			utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+			return
+		}
+		if _, err := h.Users.GetServiceAccount(r.Context(), userID); err != nil {
+			utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -2,8 +2,11 @@ package handler
 
 import (
 	"encoding/json"
+	"net"
 	"net/http"
+	"strconv"
 
+	"test123/models"
 	"test123/requests"
 	"test123/service"
 	"test123/utils"
@@ -66,10 +69,126 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	status, resp := h.AuthService.Login(r.Context(), body.Username, body.Password)
+	client := models.SessionClient{
+		Device:    body.Device,
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+	}
+
+	status, resp := h.AuthService.Login(r.Context(), body.Username, body.Password, client)
+	if wait, ok := resp["retry_after"].(int); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(wait))
+	}
+	utils.RespondJSON(w, status, resp)
+}
+
+// POST /auth/change-password
+func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var body requests.ChangePasswordReq
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+		return
+	}
+
+	status, resp := h.AuthService.ChangePassword(r.Context(), body.Username, body.CurrentPassword, body.NewPassword, body.Code, clientIP(r))
+	if wait, ok := resp["retry_after"].(int); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(wait))
+	}
+	utils.RespondJSON(w, status, resp)
+}
+
+// POST /auth/login/mfa
+func (h *AuthHandler) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var body requests.MFALoginReq
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+		return
+	}
+
+	client := models.SessionClient{
+		Device:    body.Device,
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+	}
+
+	status, resp := h.AuthService.LoginMFA(r.Context(), body.MFAToken, body.Code, client)
+	utils.RespondJSON(w, status, resp)
+}
+
+// POST /auth/verify-email?token=xyz
+func (h *AuthHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	status, resp := h.AuthService.VerifyEmail(r.Context(), r.URL.Query().Get("token"))
+	utils.RespondJSON(w, status, resp)
+}
+
+// POST /auth/verify-email/resend
+func (h *AuthHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	type req struct {
+		Email string `json:"email"`
+	}
+
+	var body req
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+		return
+	}
+
+	status, resp := h.AuthService.ResendVerification(r.Context(), body.Email)
+	utils.RespondJSON(w, status, resp)
+}
+
+// POST /auth/email-change/undo?token=xyz
+func (h *AuthHandler) UndoEmailChange(w http.ResponseWriter, r *http.Request) {
+	status, resp := h.AuthService.UndoEmailChange(r.Context(), r.URL.Query().Get("token"))
+	utils.RespondJSON(w, status, resp)
+}
+
+// POST /auth/magic-link
+func (h *AuthHandler) SendMagicLink(w http.ResponseWriter, r *http.Request) {
+	var body requests.MagicLinkReq
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+		return
+	}
+
+	status, resp := h.AuthService.SendMagicLink(r.Context(), body.Email, body.Mode, clientIP(r))
 	utils.RespondJSON(w, status, resp)
 }
 
+// POST /auth/magic-link/consume?token=xyz
+// or with {"email", "code"} in the body for the OTP variant
+func (h *AuthHandler) ConsumeMagicLink(w http.ResponseWriter, r *http.Request) {
+	var body requests.MagicLinkConsumeReq
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+			return
+		}
+	}
+	if token := r.URL.Query().Get("token"); token != "" {
+		body.Token = token
+	}
+
+	client := models.SessionClient{
+		Device:    body.Device,
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+	}
+
+	status, resp := h.AuthService.ConsumeMagicLink(r.Context(), body.Token, body.Email, body.Code, client)
+	utils.RespondJSON(w, status, resp)
+}
+
+// clientIP returns the caller's address without the port. RealIP may have
+// already replaced RemoteAddr with a bare IP.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 // POST /auth/logout
 func (h *AuthHandler) WipeOutSession(w http.ResponseWriter, r *http.Request) {
 	var body requests.LoginTokenRequest
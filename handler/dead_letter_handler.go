@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/service"
+	"test123/utils"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type DeadLetterHandler struct {
+	Service *service.DeadLetterService
+}
+
+func NewDeadLetterHandler(s *service.DeadLetterService) *DeadLetterHandler {
+	return &DeadLetterHandler{Service: s}
+}
+
+func deadLetterID(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, errors.ErrInvalidParams
+	}
+	return id, nil
+}
+
+// GET /admin/dead-letters?status=dead&cursor=123&limit=20
+func (h *DeadLetterHandler) List(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	cursor, _ := strconv.ParseInt(r.URL.Query().Get("cursor"), 10, 64)
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	list, err := h.Service.List(r.Context(), status, cursor, limit)
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	var next *int64
+	if len(list) > 0 {
+		next = &list[len(list)-1].ID
+	}
+
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{
+		"dead_letters": list,
+		"next_cursor":  next,
+	})
+}
+
+// GET /admin/dead-letters/{id}
+func (h *DeadLetterHandler) Get(w http.ResponseWriter, r *http.Request) {
+	id, err := deadLetterID(r)
+	if err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	d, err := h.Service.Get(r.Context(), id)
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	// show the event as JSON when it decodes, raw text otherwise
+	var payload interface{} = string(d.Payload)
+	if json.Valid(d.Payload) {
+		payload = json.RawMessage(d.Payload)
+	}
+
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{
+		"dead_letter": d,
+		"payload":     payload,
+	})
+}
+
+// POST /admin/dead-letters/{id}/replay
+func (h *DeadLetterHandler) Replay(w http.ResponseWriter, r *http.Request) {
+	id, err := deadLetterID(r)
+	if err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if err := h.Service.Replay(r.Context(), id); err != nil {
+		logger.Error("DeadLetterHandler.Replay", "service failed", map[string]interface{}{"error": err.Error()})
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	utils.RespondJSON(w, utils.HttpStatusFromSuccess("accepted"), map[string]string{"message": "dead letter queued for replay"})
+}
+
+// DELETE /admin/dead-letters/{id}
+func (h *DeadLetterHandler) Discard(w http.ResponseWriter, r *http.Request) {
+	id, err := deadLetterID(r)
+	if err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if err := h.Service.Discard(r.Context(), id); err != nil {
+		logger.Error("DeadLetterHandler.Discard", "service failed", map[string]interface{}{"error": err.Error()})
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "dead letter discarded"})
+}
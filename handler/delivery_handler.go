@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"net/http"
+
+	"test123/service"
+	"test123/utils"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type DeliveryHandler struct {
+	Service *service.DeliveryService
+}
+
+func NewDeliveryHandler(s *service.DeliveryService) *DeliveryHandler {
+	return &DeliveryHandler{Service: s}
+}
+
+// GET /admin/notifications/{eventId}
+func (h *DeliveryHandler) GetByEventID(w http.ResponseWriter, r *http.Request) {
+	deliveries, err := h.Service.GetByEventID(r.Context(), chi.URLParam(r, "eventId"))
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{
+		"event_id":   chi.URLParam(r, "eventId"),
+		"deliveries": deliveries,
+	})
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+	"test123/service"
+	"test123/utils"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type FederationHandler struct {
+	Service *service.FederationService
+}
+
+func NewFederationHandler(s *service.FederationService) *FederationHandler {
+	return &FederationHandler{Service: s}
+}
+
+// federationStateCookie ties the callback to the browser that started the
+// sign-in, so a callback URL planted by someone else is refused.
+const federationStateCookie = "federation_state"
+
+func (h *FederationHandler) setStateCookie(w http.ResponseWriter, state string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     federationStateCookie,
+		Value:    state,
+		Path:     "/api/v1/auth/federated/",
+		MaxAge:   int(h.Service.Config.StateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(h.Service.Issuer, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// GET /auth/federated/{provider}/login?device=...
+// Redirects the browser to the provider.
+func (h *FederationHandler) Login(w http.ResponseWriter, r *http.Request) {
+	authURL, state, err := h.Service.AuthorizationURL(r.Context(), chi.URLParam(r, "provider"), 0, r.URL.Query().Get("device"))
+	if err != nil {
+		logger.Error("FederationHandler.Login", "failed to start sign-in", map[string]interface{}{"error": err.Error()})
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	h.setStateCookie(w, state)
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// GET /auth/federated/{provider}/callback?code=...&state=...
+// Where the provider sends the browser back. Answers like /auth/login, or
+// with the linked identity when the flow was started to link an account.
+func (h *FederationHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	cookie, err := r.Cookie(federationStateCookie)
+	if err != nil || cookie.Value != q.Get("state") {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": "sign-in was started in another browser"})
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: federationStateCookie, Path: "/api/v1/auth/federated/", MaxAge: -1})
+
+	client := models.SessionClient{
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+	}
+	status, resp := h.Service.Callback(r.Context(), chi.URLParam(r, "provider"), q.Get("state"), q.Get("code"), q.Get("error"), client)
+	w.Header().Set("Cache-Control", "no-store")
+	utils.RespondJSON(w, status, resp)
+}
+
+// GET /users/{Id}/identities
+func (h *FederationHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "Id"))
+	if err != nil || userID <= 0 {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return
+	}
+
+	identities, err := h.Service.ListIdentities(r.Context(), userID)
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{
+		"identities": identities,
+		"providers":  h.Service.Providers(),
+	})
+}
+
+// POST /users/{Id}/identities/{provider}
+// Starts linking: the browser must then open authorization_url and finish
+// at the provider, which returns it to the callback.
+func (h *FederationHandler) Link(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "Id"))
+	if err != nil || userID <= 0 {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return
+	}
+
+	authURL, state, err := h.Service.AuthorizationURL(r.Context(), chi.URLParam(r, "provider"), userID, "")
+	if err != nil {
+		logger.Error("FederationHandler.Link", "failed to start linking", map[string]interface{}{"user_id": userID, "error": err.Error()})
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	h.setStateCookie(w, state)
+	utils.RespondJSON(w, http.StatusOK, map[string]string{"authorization_url": authURL})
+}
+
+// DELETE /users/{Id}/identities/{provider}
+func (h *FederationHandler) Unlink(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "Id"))
+	if err != nil || userID <= 0 {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return
+	}
+
+	if err := h.Service.Unlink(r.Context(), userID, chi.URLParam(r, "provider")); err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "identity unlinked"})
+}
@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/service"
+	"test123/utils"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type InboxHandler struct {
+	Service   *service.InboxService
+	Heartbeat time.Duration
+}
+
+func NewInboxHandler(s *service.InboxService) *InboxHandler {
+	return &InboxHandler{Service: s, Heartbeat: 25 * time.Second}
+}
+
+// GET /users/{Id}/notifications?cursor=123&limit=20&unread=true
+func (h *InboxHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "Id"))
+	if err != nil || userID <= 0 {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return
+	}
+
+	cursor, _ := strconv.ParseInt(r.URL.Query().Get("cursor"), 10, 64)
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+
+	items, err := h.Service.List(r.Context(), userID, cursor, limit, unreadOnly)
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	var next *int64
+	if len(items) > 0 {
+		next = &items[len(items)-1].ID
+	}
+
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{
+		"notifications": items,
+		"next_cursor":   next,
+	})
+}
+
+// GET /users/{Id}/notifications/unread-count
+func (h *InboxHandler) UnreadCount(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "Id"))
+	if err != nil || userID <= 0 {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return
+	}
+
+	n, err := h.Service.UnreadCount(r.Context(), userID)
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]int{"unread": n})
+}
+
+// POST /users/{Id}/notifications/{notificationId}/read
+func (h *InboxHandler) MarkRead(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "Id"))
+	if err != nil || userID <= 0 {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "notificationId"), 10, 64)
+	if err != nil || id <= 0 {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return
+	}
+
+	n, err := h.Service.MarkRead(r.Context(), userID, []int64{id}, false)
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]int64{"updated": n})
+}
+
+// POST /users/{Id}/notifications/read
+// Body: {"ids": [1, 2, 3]} or {"all": true}
+func (h *InboxHandler) MarkReadBulk(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "Id"))
+	if err != nil || userID <= 0 {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return
+	}
+
+	type req struct {
+		IDs []int64 `json:"ids"`
+		All bool    `json:"all"`
+	}
+
+	var body req
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidJSON.Error()})
+		return
+	}
+
+	n, err := h.Service.MarkRead(r.Context(), userID, body.IDs, body.All)
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]int64{"updated": n})
+}
+
+// GET /users/{Id}/notifications/stream
+// Server-Sent Events: one "notification" event per new inbox item, plus a
+// comment line every Heartbeat to keep proxies from closing the stream.
+func (h *InboxHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "Id"))
+	if err != nil || userID <= 0 {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return
+	}
+
+	rc := http.NewResponseController(w)
+
+	sub := h.Service.Subscribe(r.Context(), userID)
+	defer sub.Close()
+
+	// wait for the subscription before telling the client it is live
+	if _, err := sub.Receive(r.Context()); err != nil {
+		logger.Error("InboxHandler.Stream", "subscribe failed", map[string]interface{}{"error": err.Error()})
+		utils.RespondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": errors.ErrServiceUnavailable.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	if err := rc.Flush(); err != nil {
+		logger.Error("InboxHandler.Stream", "streaming unsupported", map[string]interface{}{"error": err.Error()})
+		return
+	}
+
+	heartbeat := time.NewTicker(h.Heartbeat)
+	defer heartbeat.Stop()
+
+	messages := sub.Channel()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+
+		case m, ok := <-messages:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(w, "event: notification\ndata: %s\n\n", m.Payload)
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
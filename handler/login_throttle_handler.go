@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+
+	"test123/errors"
+	"test123/service"
+	"test123/utils"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type LoginThrottleHandler struct {
+	Throttle *service.LoginThrottle
+	Users    *service.UserService
+}
+
+func NewLoginThrottleHandler(t *service.LoginThrottle, users *service.UserService) *LoginThrottleHandler {
+	return &LoginThrottleHandler{Throttle: t, Users: users}
+}
+
+// POST /admin/users/{Id}/unlock
+// Lifts a login lockout and forgets the user's failed logins.
+func (h *LoginThrottleHandler) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "Id"))
+	if err != nil || userID <= 0 {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return
+	}
+	if _, err := h.Users.GetUserByID(r.Context(), userID); err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	if err := h.Throttle.UnlockUser(r.Context(), userID); err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "user unlocked"})
+}
+
+// POST /admin/ips/{ip}/unlock
+// Lifts a login lockout of a client IP, e.g. an office NAT.
+func (h *LoginThrottleHandler) UnlockIP(w http.ResponseWriter, r *http.Request) {
+	ip := net.ParseIP(chi.URLParam(r, "ip"))
+	if ip == nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return
+	}
+
+	if err := h.Throttle.UnlockIP(r.Context(), ip.String()); err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "ip unlocked"})
+}
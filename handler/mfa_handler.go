@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"test123/errors"
+	"test123/requests"
+	"test123/service"
+	"test123/utils"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type MFAHandler struct {
+	Service *service.MFAService
+}
+
+func NewMFAHandler(s *service.MFAService) *MFAHandler {
+	return &MFAHandler{Service: s}
+}
+
+// GET /users/{Id}/mfa
+func (h *MFAHandler) Status(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "Id"))
+	if err != nil || userID <= 0 {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return
+	}
+
+	st, err := h.Service.Status(r.Context(), userID)
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, st)
+}
+
+// POST /users/{Id}/mfa/totp
+func (h *MFAHandler) Enroll(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "Id"))
+	if err != nil || userID <= 0 {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return
+	}
+
+	secret, uri, err := h.Service.Enroll(r.Context(), userID)
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]string{
+		"secret":           secret,
+		"provisioning_uri": uri,
+	})
+}
+
+// POST /users/{Id}/mfa/totp/confirm
+func (h *MFAHandler) Confirm(w http.ResponseWriter, r *http.Request) {
+	userID, body, ok := h.parseCode(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := h.Service.Confirm(r.Context(), userID, body.Code)
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"recovery_codes": codes})
+}
+
+// DELETE /users/{Id}/mfa/totp
+func (h *MFAHandler) Disable(w http.ResponseWriter, r *http.Request) {
+	userID, body, ok := h.parseCode(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.Service.Disable(r.Context(), userID, body.Code); err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "two-factor authentication disabled"})
+}
+
+// POST /users/{Id}/mfa/recovery-codes
+func (h *MFAHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID, body, ok := h.parseCode(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := h.Service.RegenerateRecoveryCodes(r.Context(), userID, body.Code)
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"recovery_codes": codes})
+}
+
+func (h *MFAHandler) parseCode(w http.ResponseWriter, r *http.Request) (int, requests.CodeReq, bool) {
+	var body requests.CodeReq
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "Id"))
+	if err != nil || userID <= 0 {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return 0, body, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidJSON.Error()})
+		return 0, body, false
+	}
+	if body.Code == "" {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrMissingField.Error()})
+		return 0, body, false
+	}
+	return userID, body, true
+}
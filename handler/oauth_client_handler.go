@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+	"test123/service"
+	"test123/utils"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type OAuthClientHandler struct {
+	Service *service.OAuthClientService
+}
+
+func NewOAuthClientHandler(s *service.OAuthClientService) *OAuthClientHandler {
+	return &OAuthClientHandler{Service: s}
+}
+
+type oauthClientReq struct {
+	Name         string   `json:"name"`
+	Public       bool     `json:"public"`
+	RedirectURIs []string `json:"redirect_uris"`
+	GrantTypes   []string `json:"grant_types"`
+	Scopes       []string `json:"scopes"`
+
+	PostLogoutRedirectURIs []string `json:"post_logout_redirect_uris"`
+}
+
+// GET /admin/oauth/clients
+func (h *OAuthClientHandler) List(w http.ResponseWriter, r *http.Request) {
+	list, err := h.Service.List(r.Context())
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"clients": list})
+}
+
+// POST /admin/oauth/clients
+// The response is the only time a confidential client's secret is returned.
+func (h *OAuthClientHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req oauthClientReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidJSON.Error()})
+		return
+	}
+
+	created, secret, err := h.Service.Create(r.Context(), models.OAuthClient{
+		Name:         req.Name,
+		Public:       req.Public,
+		RedirectURIs: req.RedirectURIs,
+		GrantTypes:   req.GrantTypes,
+		Scopes:       req.Scopes,
+
+		PostLogoutRedirectURIs: req.PostLogoutRedirectURIs,
+	})
+	if err != nil {
+		logger.Error("OAuthClientHandler.Create", "service failed", map[string]interface{}{"error": err.Error()})
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	resp := map[string]interface{}{"client": created}
+	if secret != "" {
+		resp["client_secret"] = secret
+	}
+	utils.RespondJSON(w, http.StatusCreated, resp)
+}
+
+// GET /admin/oauth/clients/{clientId}
+func (h *OAuthClientHandler) Get(w http.ResponseWriter, r *http.Request) {
+	c, err := h.Service.Get(r.Context(), chi.URLParam(r, "clientId"))
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, c)
+}
+
+// PUT /admin/oauth/clients/{clientId}
+// Whether a client is public cannot be changed; register a new one.
+func (h *OAuthClientHandler) Update(w http.ResponseWriter, r *http.Request) {
+	var req oauthClientReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidJSON.Error()})
+		return
+	}
+
+	updated, err := h.Service.Update(r.Context(), models.OAuthClient{
+		ID:           chi.URLParam(r, "clientId"),
+		Name:         req.Name,
+		RedirectURIs: req.RedirectURIs,
+		GrantTypes:   req.GrantTypes,
+		Scopes:       req.Scopes,
+
+		PostLogoutRedirectURIs: req.PostLogoutRedirectURIs,
+	})
+	if err != nil {
+		logger.Error("OAuthClientHandler.Update", "service failed", map[string]interface{}{"error": err.Error()})
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, updated)
+}
+
+// DELETE /admin/oauth/clients/{clientId}
+// Tokens already issued to the client stay valid until they expire.
+func (h *OAuthClientHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	if err := h.Service.Delete(r.Context(), chi.URLParam(r, "clientId")); err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "oauth client deleted"})
+}
+
+// POST /admin/oauth/clients/{clientId}/secret
+func (h *OAuthClientHandler) RotateSecret(w http.ResponseWriter, r *http.Request) {
+	secret, err := h.Service.RotateSecret(r.Context(), chi.URLParam(r, "clientId"))
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]string{"client_secret": secret})
+}
@@ -0,0 +1,324 @@
+package handler
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"time"
+
+	"test123/logger"
+	middlewares "test123/middleware"
+	"test123/models"
+	"test123/requests"
+	"test123/service"
+	"test123/utils"
+)
+
+type OAuthHandler struct {
+	Service *service.OAuthService
+}
+
+func NewOAuthHandler(s *service.OAuthService) *OAuthHandler {
+	return &OAuthHandler{Service: s}
+}
+
+// authorizeForm is the sign-in page shown for /oauth/authorize. It posts
+// back to itself with the authorization request in hidden fields.
+var authorizeForm = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in to {{.Client}}</title></head>
+<body>
+<h1>Sign in to continue to {{.Client}}</h1>
+<p>{{.Client}} is asking for: {{.Scope}}</p>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+<form method="post">
+<input type="hidden" name="response_type" value="{{.Req.ResponseType}}">
+<input type="hidden" name="client_id" value="{{.Req.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.Req.RedirectURI}}">
+<input type="hidden" name="scope" value="{{.Req.Scope}}">
+<input type="hidden" name="state" value="{{.Req.State}}">
+<input type="hidden" name="code_challenge" value="{{.Req.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.Req.CodeChallengeMethod}}">
+<input type="hidden" name="nonce" value="{{.Req.Nonce}}">
+<label>Username or email <input name="username" value="{{.Username}}" autocomplete="username" required></label>
+<label>Password <input type="password" name="password" autocomplete="current-password" required></label>
+{{if .MFA}}<label>Two-factor code <input name="code" autocomplete="one-time-code" required></label>{{end}}
+<button type="submit">Sign in</button>
+</form>
+</body>
+</html>
+`))
+
+type authorizePage struct {
+	Client   string
+	Scope    string
+	Req      requests.OAuthAuthorizeReq
+	Username string
+	MFA      bool
+	Error    string
+}
+
+func authorizeReq(r *http.Request) requests.OAuthAuthorizeReq {
+	return requests.OAuthAuthorizeReq{
+		ResponseType:        r.Form.Get("response_type"),
+		ClientID:            r.Form.Get("client_id"),
+		RedirectURI:         r.Form.Get("redirect_uri"),
+		Scope:               r.Form.Get("scope"),
+		State:               r.Form.Get("state"),
+		CodeChallenge:       r.Form.Get("code_challenge"),
+		CodeChallengeMethod: r.Form.Get("code_challenge_method"),
+		Nonce:               r.Form.Get("nonce"),
+	}
+}
+
+// redirectAuthorize sends the browser back to the client with params and
+// the request's state.
+func redirectAuthorize(w http.ResponseWriter, r *http.Request, redirectURI, state string, params url.Values) {
+	u, _ := url.Parse(redirectURI)
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	if state != "" {
+		q.Set("state", state)
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// GET  /oauth/authorize
+// POST /oauth/authorize
+// A request carrying a first-party access token is approved straight away;
+// otherwise the user signs in on the form, which posts back here.
+func (h *OAuthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+		return
+	}
+	req := authorizeReq(r)
+
+	client, redirectURI, err := h.Service.ResolveClient(r.Context(), req.ClientID, req.RedirectURI)
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	// req keeps redirect_uri as sent: the token request only has to repeat
+	// it when it was given here
+
+	scope, oerr := h.Service.CheckAuthorize(r.Context(), client, req)
+	if oerr != nil {
+		redirectAuthorize(w, r, redirectURI, req.State, url.Values{"error": {oerr.Code}, "error_description": {oerr.Description}})
+		return
+	}
+
+	page := authorizePage{Client: client.Name, Scope: scope, Req: req}
+	var userID int
+	authTime := time.Now()
+
+	switch {
+	case r.Method == http.MethodPost:
+		page.Username = r.PostForm.Get("username")
+		user, err := h.Service.AuthenticateUser(r.Context(), page.Username, r.PostForm.Get("password"), r.PostForm.Get("code"), clientIP(r))
+		if err != nil {
+			page.MFA = service.IsOAuthMFARequired(err) || r.PostForm.Get("code") != ""
+			page.Error = "Sign-in failed: " + err.Error()
+			if service.IsOAuthMFARequired(err) {
+				page.Error = "Enter the code from your authenticator app or a recovery code."
+			}
+			renderAuthorizeForm(w, http.StatusUnauthorized, page)
+			return
+		}
+		userID = user.ID
+
+	case r.Header.Get("Authorization") != "":
+		principal, err := h.Service.Auth.Authorize(r.Context(), r)
+		// a token issued to some client cannot approve another one, and
+		// API keys are for calling the API, not for signing in
+		if err != nil || principal.ClientID != "" || principal.APIKeyID != 0 {
+			utils.RespondJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		userID = principal.UserID
+		authTime = h.Service.AuthTime(r.Context(), principal)
+
+	default:
+		renderAuthorizeForm(w, http.StatusOK, page)
+		return
+	}
+
+	code, err := h.Service.IssueCode(r.Context(), client, req, scope, userID, authTime)
+	if err != nil {
+		logger.Error("OAuthHandler.Authorize", "failed to issue code", map[string]interface{}{"client_id": client.ID, "error": err.Error()})
+		redirectAuthorize(w, r, redirectURI, req.State, url.Values{"error": {"server_error"}, "error_description": {"failed to issue code"}})
+		return
+	}
+	redirectAuthorize(w, r, redirectURI, req.State, url.Values{"code": {code}})
+}
+
+func renderAuthorizeForm(w http.ResponseWriter, status int, page authorizePage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+	if err := authorizeForm.Execute(w, page); err != nil {
+		logger.Error("OAuthHandler.Authorize", "failed to render form", map[string]interface{}{"error": err.Error()})
+	}
+}
+
+// POST /oauth/token
+// Form encoded as RFC 6749 asks; clients authenticate with HTTP Basic or
+// client_id/client_secret in the form.
+func (h *OAuthHandler) Token(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	if err := r.ParseForm(); err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "body must be form encoded"})
+		return
+	}
+
+	req := requests.OAuthTokenReq{
+		GrantType:    r.PostForm.Get("grant_type"),
+		Code:         r.PostForm.Get("code"),
+		RedirectURI:  r.PostForm.Get("redirect_uri"),
+		CodeVerifier: r.PostForm.Get("code_verifier"),
+		RefreshToken: r.PostForm.Get("refresh_token"),
+		Scope:        r.PostForm.Get("scope"),
+		ClientID:     r.PostForm.Get("client_id"),
+		ClientSecret: r.PostForm.Get("client_secret"),
+	}
+
+	basicID, basicSecret, basic := basicCredentials(r)
+
+	device := models.SessionClient{
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+	}
+
+	resp, oerr := h.Service.Token(r.Context(), req, basicID, basicSecret, device)
+	if oerr != nil {
+		respondOAuthError(w, oerr, basic)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, resp)
+}
+
+// basicCredentials returns the client credentials sent with HTTP Basic.
+// They are form encoded before being base64 encoded.
+func basicCredentials(r *http.Request) (string, string, bool) {
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	}
+	return id, secret, ok
+}
+
+// tokenHintReq reads the form /auth/introspect and /auth/revoke take.
+func tokenHintReq(r *http.Request) requests.OAuthTokenHintReq {
+	return requests.OAuthTokenHintReq{
+		Token:         r.PostForm.Get("token"),
+		TokenTypeHint: r.PostForm.Get("token_type_hint"),
+		ClientID:      r.PostForm.Get("client_id"),
+		ClientSecret:  r.PostForm.Get("client_secret"),
+	}
+}
+
+// respondOAuthError answers with an RFC 6749 error body.
+func respondOAuthError(w http.ResponseWriter, oerr *service.OAuthError, basic bool) {
+	if oerr.Code == "invalid_client" && basic {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	utils.RespondJSON(w, oerr.Status(), map[string]string{"error": oerr.Code, "error_description": oerr.Description})
+}
+
+// POST /auth/introspect
+// Form: token, token_type_hint. Resource servers authenticate as
+// confidential OAuth clients and learn whether the token is active.
+func (h *OAuthHandler) Introspect(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	if err := r.ParseForm(); err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "body must be form encoded"})
+		return
+	}
+	basicID, basicSecret, basic := basicCredentials(r)
+
+	resp, oerr := h.Service.Introspect(r.Context(), tokenHintReq(r), basicID, basicSecret)
+	if oerr != nil {
+		respondOAuthError(w, oerr, basic)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, resp)
+}
+
+// POST /auth/revoke
+// Form: token, token_type_hint. OAuth clients authenticate; tokens from
+// /auth/login are revoked without credentials. Answers 200 with no body
+// whether or not the token was valid.
+func (h *OAuthHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "body must be form encoded"})
+		return
+	}
+	basicID, basicSecret, basic := basicCredentials(r)
+
+	if oerr := h.Service.Revoke(r.Context(), tokenHintReq(r), basicID, basicSecret); oerr != nil {
+		respondOAuthError(w, oerr, basic)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// GET  /oauth/userinfo
+// POST /oauth/userinfo
+func (h *OAuthHandler) UserInfo(w http.ResponseWriter, r *http.Request) {
+	principal, ok := r.Context().Value(middlewares.PrincipalKey).(*models.Principal)
+	if !ok {
+		utils.RespondJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	claims, err := h.Service.UserInfo(r.Context(), principal)
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	utils.RespondJSON(w, http.StatusOK, claims)
+}
+
+// GET /.well-known/openid-configuration
+func (h *OAuthHandler) Discovery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	utils.RespondJSON(w, http.StatusOK, h.Service.Discovery())
+}
+
+// GET  /oauth/logout?id_token_hint=...&post_logout_redirect_uri=...&state=...
+// POST /oauth/logout
+// RP-initiated logout: signs out the session the id_token was issued for
+// and goes back to the client when it registered the redirect.
+func (h *OAuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+		return
+	}
+
+	redirect, err := h.Service.EndSession(r.Context(),
+		r.Form.Get("id_token_hint"),
+		r.Form.Get("client_id"),
+		r.Form.Get("post_logout_redirect_uri"),
+		r.Form.Get("state"),
+	)
+	if err != nil {
+		logger.Error("OAuthHandler.Logout", "logout failed", map[string]interface{}{"error": err.Error()})
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	if redirect != "" {
+		http.Redirect(w, r, redirect, http.StatusFound)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "user logged out successfully"})
+}
@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+	"test123/requests"
+	"test123/service"
+	"test123/utils"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type PasskeyHandler struct {
+	Service *service.PasskeyService
+}
+
+func NewPasskeyHandler(s *service.PasskeyService) *PasskeyHandler {
+	return &PasskeyHandler{Service: s}
+}
+
+// passkeyParams reads the {Id} and, when present, {passkeyId} URL params.
+func passkeyParams(r *http.Request) (int, int, bool) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "Id"))
+	if err != nil || userID <= 0 {
+		return 0, 0, false
+	}
+	if p := chi.URLParam(r, "passkeyId"); p != "" {
+		id, err := strconv.Atoi(p)
+		if err != nil || id <= 0 {
+			return 0, 0, false
+		}
+		return userID, id, true
+	}
+	return userID, 0, true
+}
+
+// GET /users/{Id}/passkeys
+func (h *PasskeyHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := passkeyParams(r)
+	if !ok {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return
+	}
+
+	list, err := h.Service.List(r.Context(), userID)
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"passkeys": list})
+}
+
+// POST /users/{Id}/passkeys/options
+// Returns the options to pass to navigator.credentials.create().
+func (h *PasskeyHandler) RegistrationOptions(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := passkeyParams(r)
+	if !ok {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return
+	}
+
+	options, err := h.Service.BeginRegistration(r.Context(), userID)
+	if err != nil {
+		logger.Error("PasskeyHandler.RegistrationOptions", "service failed", map[string]interface{}{"user_id": userID, "error": err.Error()})
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"publicKey": options})
+}
+
+// POST /users/{Id}/passkeys
+// Body: the credential navigator.credentials.create() returned, plus an
+// optional name.
+func (h *PasskeyHandler) Register(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := passkeyParams(r)
+	if !ok {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return
+	}
+	var req requests.PasskeyRegistrationReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidJSON.Error()})
+		return
+	}
+
+	created, err := h.Service.FinishRegistration(r.Context(), userID, req)
+	if err != nil {
+		logger.Error("PasskeyHandler.Register", "service failed", map[string]interface{}{"user_id": userID, "error": err.Error()})
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusCreated, created)
+}
+
+// PUT /users/{Id}/passkeys/{passkeyId}
+func (h *PasskeyHandler) Rename(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := passkeyParams(r)
+	if !ok {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return
+	}
+	var req requests.PasskeyRenameReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidJSON.Error()})
+		return
+	}
+
+	updated, err := h.Service.Rename(r.Context(), userID, id, req.Name)
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, updated)
+}
+
+// DELETE /users/{Id}/passkeys/{passkeyId}
+func (h *PasskeyHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := passkeyParams(r)
+	if !ok {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return
+	}
+
+	if err := h.Service.Delete(r.Context(), userID, id); err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "passkey deleted"})
+}
+
+// POST /auth/passkey/options
+// Body (optional): {"username"}. Returns the options to pass to
+// navigator.credentials.get().
+func (h *PasskeyHandler) LoginOptions(w http.ResponseWriter, r *http.Request) {
+	var req requests.PasskeyOptionsReq
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidJSON.Error()})
+			return
+		}
+	}
+
+	options, err := h.Service.BeginLogin(r.Context(), req.Username)
+	if err != nil {
+		logger.Error("PasskeyHandler.LoginOptions", "service failed", map[string]interface{}{"error": err.Error()})
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"publicKey": options})
+}
+
+// POST /auth/passkey/login
+// Body: the credential navigator.credentials.get() returned. Answers like
+// /auth/login.
+func (h *PasskeyHandler) Login(w http.ResponseWriter, r *http.Request) {
+	var req requests.PasskeyLoginReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+		return
+	}
+
+	client := models.SessionClient{
+		Device:    req.Device,
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+	}
+	status, resp := h.Service.FinishLogin(r.Context(), req, client)
+	utils.RespondJSON(w, status, resp)
+}
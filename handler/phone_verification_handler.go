@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"test123/errors"
+	"test123/requests"
+	"test123/service"
+	"test123/utils"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type PhoneVerificationHandler struct {
+	Service *service.PhoneVerificationService
+}
+
+func NewPhoneVerificationHandler(s *service.PhoneVerificationService) *PhoneVerificationHandler {
+	return &PhoneVerificationHandler{Service: s}
+}
+
+// POST /users/{Id}/phone/verification
+func (h *PhoneVerificationHandler) SendCode(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "Id"))
+	if err != nil || userID <= 0 {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return
+	}
+
+	if err := h.Service.SendCode(r.Context(), userID); err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusAccepted, map[string]string{"message": "verification code sent"})
+}
+
+// POST /users/{Id}/phone/verification/confirm
+func (h *PhoneVerificationHandler) Confirm(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "Id"))
+	if err != nil || userID <= 0 {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return
+	}
+
+	var body requests.CodeReq
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidJSON.Error()})
+		return
+	}
+	if body.Code == "" {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrMissingField.Error()})
+		return
+	}
+
+	if err := h.Service.Confirm(r.Context(), userID, body.Code); err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "mobile number verified"})
+}
@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+	"test123/service"
+	"test123/utils"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type PreferenceHandler struct {
+	Service *service.PreferenceService
+}
+
+func NewPreferenceHandler(s *service.PreferenceService) *PreferenceHandler {
+	return &PreferenceHandler{Service: s}
+}
+
+// GET /users/{Id}/notification-preferences
+func (h *PreferenceHandler) Get(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "Id"))
+	if err != nil || id <= 0 {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return
+	}
+
+	p, err := h.Service.Get(r.Context(), id)
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, p)
+}
+
+// PUT /users/{Id}/notification-preferences
+func (h *PreferenceHandler) Update(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "Id"))
+	if err != nil || id <= 0 {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return
+	}
+
+	var p models.NotificationPreferences
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidJSON.Error()})
+		return
+	}
+	p.UserID = id
+
+	if err := h.Service.Update(r.Context(), p); err != nil {
+		logger.Warn("PreferenceHandler.Update", "update failed", map[string]interface{}{"error": err.Error()})
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "notification preferences updated"})
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"test123/errors"
+	middlewares "test123/middleware"
+	"test123/service"
+	"test123/utils"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type SessionHandler struct {
+	Service *service.SessionService
+}
+
+func NewSessionHandler(s *service.SessionService) *SessionHandler {
+	return &SessionHandler{Service: s}
+}
+
+// GET /users/{Id}/sessions
+// GET /admin/users/{Id}/sessions
+func (h *SessionHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "Id"))
+	if err != nil || userID <= 0 {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return
+	}
+
+	sessions, err := h.Service.List(r.Context(), userID)
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	// only meaningful when users look at their own sessions
+	if callerID, _ := r.Context().Value(middlewares.UserIDKey).(string); callerID == strconv.Itoa(userID) {
+		current, _ := r.Context().Value(middlewares.SessionIDKey).(string)
+		for i := range sessions {
+			sessions[i].Current = sessions[i].ID == current
+		}
+	}
+
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"sessions": sessions})
+}
+
+// DELETE /users/{Id}/sessions/{sid}
+// DELETE /admin/users/{Id}/sessions/{sid}
+func (h *SessionHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "Id"))
+	if err != nil || userID <= 0 {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return
+	}
+
+	if err := h.Service.Revoke(r.Context(), userID, chi.URLParam(r, "sid")); err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "session revoked"})
+}
+
+// DELETE /users/{Id}/sessions?except_current=true
+// DELETE /admin/users/{Id}/sessions
+func (h *SessionHandler) RevokeAll(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "Id"))
+	if err != nil || userID <= 0 {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidField.Error()})
+		return
+	}
+
+	keep := ""
+	if r.URL.Query().Get("except_current") == "true" {
+		keep, _ = r.Context().Value(middlewares.SessionIDKey).(string)
+	}
+
+	n, err := h.Service.RevokeAll(r.Context(), userID, keep)
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]int{"revoked": n})
+}
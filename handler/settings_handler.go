@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"test123/errors"
+	"test123/models"
+	"test123/service"
+	"test123/utils"
+)
+
+type SettingsHandler struct {
+	Service *service.SettingsService
+}
+
+func NewSettingsHandler(s *service.SettingsService) *SettingsHandler {
+	return &SettingsHandler{Service: s}
+}
+
+// GET /admin/settings/auth
+func (h *SettingsHandler) GetAuth(w http.ResponseWriter, r *http.Request) {
+	a, err := h.Service.Auth(r.Context())
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, a)
+}
+
+// PUT /admin/settings/auth
+func (h *SettingsHandler) UpdateAuth(w http.ResponseWriter, r *http.Request) {
+	var a models.AuthSettings
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidJSON.Error()})
+		return
+	}
+
+	if err := h.Service.UpdateAuth(r.Context(), a); err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, a)
+}
@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"test123/logger"
+	"test123/service"
+	"test123/utils"
+)
+
+type SigningKeyHandler struct {
+	Service *service.SigningKeyService
+}
+
+func NewSigningKeyHandler(s *service.SigningKeyService) *SigningKeyHandler {
+	return &SigningKeyHandler{Service: s}
+}
+
+// GET /.well-known/jwks.json
+// Public keys for verifying our access tokens. Cached for less than the
+// publish lead so verifiers see a new key before it signs anything.
+func (h *SigningKeyHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	utils.RespondJSON(w, http.StatusOK, h.Service.Ring.JWKS(time.Now()))
+}
+
+// GET /admin/signing-keys
+func (h *SigningKeyHandler) List(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.Service.List(r.Context())
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"signing_keys": keys})
+}
+
+// POST /admin/signing-keys/rotate
+func (h *SigningKeyHandler) Rotate(w http.ResponseWriter, r *http.Request) {
+	k, err := h.Service.Rotate(r.Context())
+	if err != nil {
+		logger.Error("SigningKeyHandler.Rotate", "service failed", map[string]interface{}{"error": err.Error()})
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusCreated, map[string]interface{}{"signing_key": k})
+}
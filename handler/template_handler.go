@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+	"test123/service"
+	"test123/utils"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type TemplateHandler struct {
+	Service *service.TemplateService
+}
+
+func NewTemplateHandler(s *service.TemplateService) *TemplateHandler {
+	return &TemplateHandler{Service: s}
+}
+
+func templateID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id <= 0 {
+		return 0, errors.ErrInvalidParams
+	}
+	return id, nil
+}
+
+// GET /admin/templates?action=user_created
+func (h *TemplateHandler) List(w http.ResponseWriter, r *http.Request) {
+	list, err := h.Service.List(r.Context(), r.URL.Query().Get("action"))
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"templates": list})
+}
+
+// GET /admin/templates/{id}
+func (h *TemplateHandler) Get(w http.ResponseWriter, r *http.Request) {
+	id, err := templateID(r)
+	if err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	t, err := h.Service.Get(r.Context(), id)
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, t)
+}
+
+// POST /admin/templates
+func (h *TemplateHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var t models.NotificationTemplate
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidJSON.Error()})
+		return
+	}
+
+	created, err := h.Service.Create(r.Context(), t)
+	if err != nil {
+		logger.Error("TemplateHandler.Create", "service failed", map[string]interface{}{"error": err.Error()})
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, utils.HttpStatusFromSuccess("created"), created)
+}
+
+// PUT /admin/templates/{id}
+func (h *TemplateHandler) Update(w http.ResponseWriter, r *http.Request) {
+	id, err := templateID(r)
+	if err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	var t models.NotificationTemplate
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidJSON.Error()})
+		return
+	}
+	t.ID = id
+
+	updated, err := h.Service.Update(r.Context(), t)
+	if err != nil {
+		logger.Error("TemplateHandler.Update", "service failed", map[string]interface{}{"error": err.Error()})
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, updated)
+}
+
+// DELETE /admin/templates/{id}
+func (h *TemplateHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, err := templateID(r)
+	if err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if err := h.Service.Delete(r.Context(), id); err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, utils.HttpStatusFromSuccess("deleted"), nil)
+}
+
+// POST /admin/templates/{id}/preview
+func (h *TemplateHandler) Preview(w http.ResponseWriter, r *http.Request) {
+	id, err := templateID(r)
+	if err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	type req struct {
+		Metadata map[string]string `json:"metadata"`
+	}
+
+	var body req
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidJSON.Error()})
+		return
+	}
+
+	rendered, err := h.Service.Preview(r.Context(), id, body.Metadata)
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, rendered)
+}
+
+// POST /admin/templates/render
+// Renders whichever template a notification with this action and locale
+// would get, including locale fallback.
+func (h *TemplateHandler) Render(w http.ResponseWriter, r *http.Request) {
+	type req struct {
+		Action   string            `json:"action"`
+		Locale   string            `json:"locale"`
+		Metadata map[string]string `json:"metadata"`
+	}
+
+	var body req
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidJSON.Error()})
+		return
+	}
+	if body.Action == "" {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": "action is required"})
+		return
+	}
+
+	rendered, err := h.Service.Render(r.Context(), body.Action, body.Locale, body.Metadata)
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, rendered)
+}
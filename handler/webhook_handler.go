@@ -0,0 +1,235 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+	"test123/service"
+	"test123/utils"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type WebhookHandler struct {
+	Service *service.WebhookService
+}
+
+func NewWebhookHandler(s *service.WebhookService) *WebhookHandler {
+	return &WebhookHandler{Service: s}
+}
+
+type webhookReq struct {
+	URL         string   `json:"url"`
+	Events      []string `json:"events"`
+	Secret      string   `json:"secret"`
+	Description string   `json:"description"`
+	Active      *bool    `json:"active"`
+}
+
+func webhookID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id <= 0 {
+		return 0, errors.ErrInvalidParams
+	}
+	return id, nil
+}
+
+func webhookDeliveryID(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "deliveryId"), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, errors.ErrInvalidParams
+	}
+	return id, nil
+}
+
+// GET /admin/webhooks
+func (h *WebhookHandler) List(w http.ResponseWriter, r *http.Request) {
+	list, err := h.Service.List(r.Context())
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"webhooks": list})
+}
+
+// POST /admin/webhooks
+// The response is the only time the signing secret is returned.
+func (h *WebhookHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req webhookReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidJSON.Error()})
+		return
+	}
+
+	created, err := h.Service.Create(r.Context(), models.WebhookSubscription{
+		URL:         req.URL,
+		Events:      req.Events,
+		Secret:      req.Secret,
+		Description: req.Description,
+	})
+	if err != nil {
+		logger.Error("WebhookHandler.Create", "service failed", map[string]interface{}{"error": err.Error()})
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	utils.RespondJSON(w, http.StatusCreated, map[string]interface{}{
+		"webhook": created,
+		"secret":  created.Secret,
+	})
+}
+
+// GET /admin/webhooks/{id}
+func (h *WebhookHandler) Get(w http.ResponseWriter, r *http.Request) {
+	id, err := webhookID(r)
+	if err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	sub, err := h.Service.Get(r.Context(), id)
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, sub)
+}
+
+// PUT /admin/webhooks/{id}
+// Setting "active": true re-enables an auto-disabled webhook.
+func (h *WebhookHandler) Update(w http.ResponseWriter, r *http.Request) {
+	id, err := webhookID(r)
+	if err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	var req webhookReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": errors.ErrInvalidJSON.Error()})
+		return
+	}
+
+	current, err := h.Service.Get(r.Context(), id)
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	active := current.Active
+	if req.Active != nil {
+		active = *req.Active
+	}
+
+	updated, err := h.Service.Update(r.Context(), models.WebhookSubscription{
+		ID:          id,
+		URL:         req.URL,
+		Events:      req.Events,
+		Description: req.Description,
+		Active:      active,
+	})
+	if err != nil {
+		logger.Error("WebhookHandler.Update", "service failed", map[string]interface{}{"error": err.Error()})
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, updated)
+}
+
+// DELETE /admin/webhooks/{id}
+func (h *WebhookHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, err := webhookID(r)
+	if err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if err := h.Service.Delete(r.Context(), id); err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "webhook deleted"})
+}
+
+// GET /admin/webhooks/{id}/deliveries?status=failed&cursor=123&limit=20
+func (h *WebhookHandler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := webhookID(r)
+	if err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	cursor, _ := strconv.ParseInt(r.URL.Query().Get("cursor"), 10, 64)
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	list, err := h.Service.ListDeliveries(r.Context(), id, status, cursor, limit)
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	var next *int64
+	if len(list) > 0 {
+		next = &list[len(list)-1].ID
+	}
+
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{
+		"deliveries":  list,
+		"next_cursor": next,
+	})
+}
+
+// GET /admin/webhooks/{id}/deliveries/{deliveryId}
+func (h *WebhookHandler) GetDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := webhookID(r)
+	if err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	deliveryID, err := webhookDeliveryID(r)
+	if err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	d, err := h.Service.GetDelivery(r.Context(), id, deliveryID)
+	if err != nil {
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{
+		"delivery": d,
+		"payload":  json.RawMessage(d.Payload),
+	})
+}
+
+// POST /admin/webhooks/{id}/deliveries/{deliveryId}/redeliver
+func (h *WebhookHandler) Redeliver(w http.ResponseWriter, r *http.Request) {
+	id, err := webhookID(r)
+	if err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	deliveryID, err := webhookDeliveryID(r)
+	if err != nil {
+		utils.RespondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	d, err := h.Service.Redeliver(r.Context(), id, deliveryID)
+	if err != nil {
+		logger.Error("WebhookHandler.Redeliver", "service failed", map[string]interface{}{"error": err.Error()})
+		utils.RespondJSON(w, utils.HttpStatusFromError(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	utils.RespondJSON(w, utils.HttpStatusFromSuccess("accepted"), map[string]interface{}{
+		"message":  "delivery queued",
+		"delivery": d,
+	})
+}
@@ -4,16 +4,18 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/netip"
 
 	//"net/smtp"
 	"time"
 
-	//"test123/config"
+	"test123/config"
 	"test123/handler"
 	kafka "test123/kafka/producers"
 	middlewares "test123/middleware"
 	"test123/service"
 	"test123/utils/jwt"
+	"test123/utils/passwordcheck"
 
 	// "test123/utils/jwt"
 
@@ -22,6 +24,7 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/willf/bloom"
+	"golang.org/x/crypto/bcrypt"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
@@ -41,26 +44,64 @@ type Server struct {
 	KafkaProducer   *kafka.KafkaNotificationProducer
 	RoleService     *service.RoleService
 	UserRoleService *service.UserRoleService
+	DeadLetters     *service.DeadLetterService
+	Deliveries      *service.DeliveryService
+	Templates       *service.TemplateService
+	Preferences     *service.PreferenceService
+	Inbox           *service.InboxService
 	BloomFilter     *bloom.BloomFilter
+	OutboxRelay     *service.OutboxRelay
+	SigningKeys     *service.SigningKeyService
+	Sessions        *service.SessionService
+	MFA             *service.MFAService
+	Settings        *service.SettingsService
+	Phones          *service.PhoneVerificationService
+	Webhooks        *service.WebhookService
+	WebhookSender   *service.WebhookDispatcher
+	OAuthClients    *service.OAuthClientService
+	OAuth           *service.OAuthService
+	Federation      *service.FederationService
+	Passkeys        *service.PasskeyService
+	APIKeys         *service.APIKeyService
+
+	TrustedProxies []netip.Prefix
 }
 
 // Constructor
-func NewServer(dbStatus string, db *pgxpool.Pool, rdb *redis.Client, kafka *kafka.KafkaNotificationProducer, bloom *bloom.BloomFilter) *Server {
+func NewServer(cfg config.Config, dbStatus string, db *pgxpool.Pool, rdb *redis.Client, kafka *kafka.KafkaNotificationProducer, bloom *bloom.BloomFilter, breached *passwordcheck.BreachedList) *Server {
 	userRepo := repositories.NewUserRepo(db)
 	profileRepo := repositories.NewProfileRepo(db)
 
 	roleRepo := repositories.NewRoleRepo(db)
 	userroleRepo := repositories.NewUserRoleRepo(db)
+	outboxRepo := repositories.NewOutboxRepo(db)
+	deadLetterRepo := repositories.NewDeadLetterRepo(db)
+	webhookRepo := repositories.NewWebhookRepo(db)
+	txManager := repositories.NewTxManager(db)
 
-	j := jwt.NewJwt("abc")
+	keyRing := jwt.NewKeyRing()
+	j := jwt.NewJwtWithKeys(keyRing)
 
-	userService := service.NewUserService(userRepo, kafka, userroleRepo, rdb, bloom)
+	hasher := service.NewMigratingHasher(service.NewArgon2idHasher(), service.NewBcryptHasher(bcrypt.DefaultCost))
+
+	verifier := service.NewEmailVerifier(j, outboxRepo, rdb, cfg.Auth)
+	passwordPolicy := service.NewPasswordPolicy(repositories.NewPasswordHistoryRepo(db), hasher, breached, cfg.PasswordPolicy)
+	userService := service.NewUserService(userRepo, outboxRepo, webhookRepo, txManager, userroleRepo, rdb, bloom, hasher, verifier, passwordPolicy)
 	profileService := service.NewProfileService(profileRepo)
 
-	authService := service.NewAuthService(userService, rdb, j, kafka)
+	sessionService := service.NewSessionService(rdb, cfg.Auth.RefreshTokenTTL)
+	mfaService := service.NewMFAService(repositories.NewMFARepo(db), userService, outboxRepo, txManager, cfg.Auth)
+	settingsService := service.NewSettingsService(repositories.NewSettingsRepo(db))
+	loginThrottle := service.NewLoginThrottle(rdb, outboxRepo, cfg.LoginThrottle)
+	apiKeyService := service.NewAPIKeyService(repositories.NewAPIKeyRepo(db), userService)
+	authService := service.NewAuthService(userService, rdb, j, outboxRepo, sessionService, mfaService, settingsService, apiKeyService, loginThrottle, cfg.Auth, cfg.RateLimit)
 	roleService := service.NewRoleService(roleRepo)
 	authorizeService := service.NewAuthorizeService(db, rdb)
 	userroleService := service.NewUserRoleService(userroleRepo)
+	oauthClients := service.NewOAuthClientService(repositories.NewOAuthClientRepo(db))
+
+	// already checked by cfg.Validate
+	trustedProxies, _ := cfg.TrustedProxyPrefixes()
 
 	return &Server{
 		DBStatus:       dbStatus,
@@ -74,7 +115,26 @@ func NewServer(dbStatus string, db *pgxpool.Pool, rdb *redis.Client, kafka *kafk
 		RoleService:       roleService,
 		AuthorizseService: authorizeService,
 		UserRoleService:   userroleService,
+		DeadLetters:       service.NewDeadLetterService(deadLetterRepo, outboxRepo, txManager),
+		Deliveries:        service.NewDeliveryService(repositories.NewDeliveryRepo(db)),
+		Templates:         service.NewTemplateService(repositories.NewTemplateRepo(db)),
+		Preferences:       service.NewPreferenceService(repositories.NewPreferenceRepo(db)),
+		Inbox:             service.NewInboxService(repositories.NewInboxRepo(db), rdb),
 		BloomFilter:       bloom,
+		OutboxRelay:       service.NewOutboxRelay(outboxRepo, kafka),
+		SigningKeys:       service.NewSigningKeyService(repositories.NewSigningKeyRepo(db), txManager, keyRing, cfg.Auth),
+		Sessions:          sessionService,
+		MFA:               mfaService,
+		Settings:          settingsService,
+		Phones:            service.NewPhoneVerificationService(userRepo, outboxRepo, rdb, cfg.Auth),
+		Webhooks:          service.NewWebhookService(webhookRepo),
+		WebhookSender:     service.NewWebhookDispatcher(webhookRepo),
+		OAuthClients:      oauthClients,
+		OAuth:             service.NewOAuthService(authService, oauthClients, profileService, rdb, cfg.Auth),
+		Passkeys:          service.NewPasskeyService(authService, repositories.NewWebAuthnRepo(db), rdb, cfg.Auth),
+		Federation:        service.NewFederationService(authService, repositories.NewIdentityRepo(db), rdb, cfg.Federation, cfg.Auth.Issuer),
+		APIKeys:           apiKeyService,
+		TrustedProxies:    trustedProxies,
 	}
 }
 
@@ -86,13 +146,45 @@ func (s *Server) Listen(ctx context.Context, addr string) error {
 	profileHandler := handler.NewProfileHandler(s.ProfileService)
 	authHandler := handler.NewAuthHandler(s.AuthService)
 	adminHandler := handler.NewAdminHandler(s.RoleService, s.UserRoleService, s.UserService)
+	deadLetterHandler := handler.NewDeadLetterHandler(s.DeadLetters)
+	deliveryHandler := handler.NewDeliveryHandler(s.Deliveries)
+	templateHandler := handler.NewTemplateHandler(s.Templates)
+	preferenceHandler := handler.NewPreferenceHandler(s.Preferences)
+	inboxHandler := handler.NewInboxHandler(s.Inbox)
+	webhookHandler := handler.NewWebhookHandler(s.Webhooks)
+	signingKeyHandler := handler.NewSigningKeyHandler(s.SigningKeys)
+	sessionHandler := handler.NewSessionHandler(s.Sessions)
+	mfaHandler := handler.NewMFAHandler(s.MFA)
+	settingsHandler := handler.NewSettingsHandler(s.Settings)
+	phoneHandler := handler.NewPhoneVerificationHandler(s.Phones)
+	oauthHandler := handler.NewOAuthHandler(s.OAuth)
+	oauthClientHandler := handler.NewOAuthClientHandler(s.OAuthClients)
+	federationHandler := handler.NewFederationHandler(s.Federation)
+	passkeyHandler := handler.NewPasskeyHandler(s.Passkeys)
+	apiKeyHandler := handler.NewAPIKeyHandler(s.APIKeys, s.UserService)
+	loginThrottleHandler := handler.NewLoginThrottleHandler(s.AuthService.Throttle, s.UserService)
 
 	r := chi.NewRouter()
 
 	// // Global middleware
+	r.Use(middlewares.RealIP(s.TrustedProxies))
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
+	r.Get("/.well-known/jwks.json", signingKeyHandler.JWKS)
+	r.Get("/.well-known/openid-configuration", oauthHandler.Discovery)
+
+	// OAuth2 authorization server and OpenID Connect provider
+	r.Route("/oauth", func(r chi.Router) {
+		r.Get("/authorize", oauthHandler.Authorize)
+		r.Post("/authorize", oauthHandler.Authorize)
+		r.Post("/token", oauthHandler.Token)
+		r.With(middlewares.AuthMiddleware(s.AuthService)).Get("/userinfo", oauthHandler.UserInfo)
+		r.With(middlewares.AuthMiddleware(s.AuthService)).Post("/userinfo", oauthHandler.UserInfo)
+		r.Get("/logout", oauthHandler.Logout)
+		r.Post("/logout", oauthHandler.Logout)
+	})
+
 	// API group
 	r.Route("/api/v1/", func(r chi.Router) {
 
@@ -104,6 +196,66 @@ func (s *Server) Listen(ctx context.Context, addr string) error {
 			r.With(middlewares.AuthMiddleware(s.AuthService), middlewares.RequirePermission(s.AuthorizseService, "user.update.self")).Put("/{Id}", userHandler.UpdateUser)
 			r.With(middlewares.AuthMiddleware(s.AuthService), middlewares.RequirePermission(s.AuthorizseService, "user.delete.self")).Delete("/{Id}", userHandler.DeleteUser)
 
+			r.With(middlewares.AuthMiddleware(s.AuthService), middlewares.RequirePermission(s.AuthorizseService, "user.read.self")).Get("/{Id}/notification-preferences", preferenceHandler.Get)
+			r.With(middlewares.AuthMiddleware(s.AuthService), middlewares.RequirePermission(s.AuthorizseService, "user.update.self")).Put("/{Id}/notification-preferences", preferenceHandler.Update)
+
+			// In-app inbox
+			r.Route("/{Id}/notifications", func(r chi.Router) {
+				r.Use(middlewares.AuthMiddleware(s.AuthService))
+				r.With(middlewares.RequirePermission(s.AuthorizseService, "user.read.self")).Get("/", inboxHandler.List)
+				r.With(middlewares.RequirePermission(s.AuthorizseService, "user.read.self")).Get("/unread-count", inboxHandler.UnreadCount)
+				r.With(middlewares.RequirePermission(s.AuthorizseService, "user.read.self")).Get("/stream", inboxHandler.Stream)
+				r.With(middlewares.RequirePermission(s.AuthorizseService, "user.update.self")).Post("/read", inboxHandler.MarkReadBulk)
+				r.With(middlewares.RequirePermission(s.AuthorizseService, "user.update.self")).Post("/{notificationId}/read", inboxHandler.MarkRead)
+			})
+
+			// Signed-in devices
+			r.Route("/{Id}/sessions", func(r chi.Router) {
+				r.Use(middlewares.AuthMiddleware(s.AuthService))
+				r.With(middlewares.RequirePermission(s.AuthorizseService, "user.read.self")).Get("/", sessionHandler.List)
+				r.With(middlewares.RequirePermission(s.AuthorizseService, "user.update.self")).Delete("/", sessionHandler.RevokeAll)
+				r.With(middlewares.RequirePermission(s.AuthorizseService, "user.update.self")).Delete("/{sid}", sessionHandler.Revoke)
+			})
+
+			// Two-factor authentication
+			r.Route("/{Id}/mfa", func(r chi.Router) {
+				r.Use(middlewares.AuthMiddleware(s.AuthService))
+				r.With(middlewares.RequirePermission(s.AuthorizseService, "user.read.self")).Get("/", mfaHandler.Status)
+				r.With(middlewares.RequirePermission(s.AuthorizseService, "user.update.self")).Post("/totp", mfaHandler.Enroll)
+				r.With(middlewares.RequirePermission(s.AuthorizseService, "user.update.self")).Post("/totp/confirm", mfaHandler.Confirm)
+				r.With(middlewares.RequirePermission(s.AuthorizseService, "user.update.self")).Delete("/totp", mfaHandler.Disable)
+				r.With(middlewares.RequirePermission(s.AuthorizseService, "user.update.self")).Post("/recovery-codes", mfaHandler.RegenerateRecoveryCodes)
+			})
+
+			// WebAuthn passkeys
+			r.Route("/{Id}/passkeys", func(r chi.Router) {
+				r.Use(middlewares.AuthMiddleware(s.AuthService))
+				r.With(middlewares.RequirePermission(s.AuthorizseService, "user.read.self")).Get("/", passkeyHandler.List)
+				r.With(middlewares.RequirePermission(s.AuthorizseService, "user.update.self")).Post("/options", passkeyHandler.RegistrationOptions)
+				r.With(middlewares.RequirePermission(s.AuthorizseService, "user.update.self")).Post("/", passkeyHandler.Register)
+				r.With(middlewares.RequirePermission(s.AuthorizseService, "user.update.self")).Put("/{passkeyId}", passkeyHandler.Rename)
+				r.With(middlewares.RequirePermission(s.AuthorizseService, "user.update.self")).Delete("/{passkeyId}", passkeyHandler.Delete)
+			})
+
+			// Personal API keys
+			r.Route("/{Id}/api-keys", func(r chi.Router) {
+				r.Use(middlewares.AuthMiddleware(s.AuthService))
+				r.With(middlewares.RequirePermission(s.AuthorizseService, "user.read.self")).Get("/", apiKeyHandler.List)
+				r.With(middlewares.RequirePermission(s.AuthorizseService, "user.update.self")).Post("/", apiKeyHandler.Create)
+				r.With(middlewares.RequirePermission(s.AuthorizseService, "user.update.self")).Delete("/{keyId}", apiKeyHandler.Revoke)
+			})
+
+			// Accounts at external identity providers
+			r.Route("/{Id}/identities", func(r chi.Router) {
+				r.Use(middlewares.AuthMiddleware(s.AuthService))
+				r.With(middlewares.RequirePermission(s.AuthorizseService, "user.read.self")).Get("/", federationHandler.List)
+				r.With(middlewares.RequirePermission(s.AuthorizseService, "user.update.self")).Post("/{provider}", federationHandler.Link)
+				r.With(middlewares.RequirePermission(s.AuthorizseService, "user.update.self")).Delete("/{provider}", federationHandler.Unlink)
+			})
+
+			r.With(middlewares.AuthMiddleware(s.AuthService), middlewares.RequirePermission(s.AuthorizseService, "user.update.self")).Post("/{Id}/phone/verification", phoneHandler.SendCode)
+			r.With(middlewares.AuthMiddleware(s.AuthService), middlewares.RequirePermission(s.AuthorizseService, "user.update.self")).Post("/{Id}/phone/verification/confirm", phoneHandler.Confirm)
+
 			// Profile routes nested under a user
 			r.Route("/{id}/profile", func(r chi.Router) {
 				r.Post("/", profileHandler.CreateProfile)
@@ -114,9 +266,22 @@ func (s *Server) Listen(ctx context.Context, addr string) error {
 		r.Route("/auth", func(r chi.Router) {
 			r.Post("/generate-token", authHandler.GenerateResetToken)
 			r.Post("/reset-password", authHandler.ResetPassword)
+			r.Post("/change-password", authHandler.ChangePassword)
 			r.Post("/login", authHandler.Login)
+			r.Post("/login/mfa", authHandler.LoginMFA)
+			r.Post("/magic-link", authHandler.SendMagicLink)
+			r.Post("/magic-link/consume", authHandler.ConsumeMagicLink)
+			r.Post("/passkey/options", passkeyHandler.LoginOptions)
+			r.Post("/passkey/login", passkeyHandler.Login)
 			r.Post("/logout", authHandler.WipeOutSession)
 			r.Post("/access-token", authHandler.GenerateAccessToken)
+			r.Post("/introspect", oauthHandler.Introspect)
+			r.Post("/revoke", oauthHandler.Revoke)
+			r.Post("/verify-email", authHandler.VerifyEmail)
+			r.Post("/verify-email/resend", authHandler.ResendVerification)
+			r.Post("/email-change/undo", authHandler.UndoEmailChange)
+			r.Get("/federated/{provider}/login", federationHandler.Login)
+			r.Get("/federated/{provider}/callback", federationHandler.Callback)
 		})
 
 		r.Route("/admin", func(r chi.Router) {
@@ -125,10 +290,85 @@ func (s *Server) Listen(ctx context.Context, addr string) error {
 			r.Post("/assign-role", adminHandler.AddRoleToUser)
 			r.Delete("/user/{Id}", adminHandler.DeleteUser)
 
+			r.Post("/users/{Id}/unlock", loginThrottleHandler.UnlockUser)
+			r.Post("/ips/{ip}/unlock", loginThrottleHandler.UnlockIP)
+
+			r.Route("/users/{Id}/sessions", func(r chi.Router) {
+				r.Get("/", sessionHandler.List)
+				r.Delete("/", sessionHandler.RevokeAll)
+				r.Delete("/{sid}", sessionHandler.Revoke)
+			})
+
+			r.Route("/service-accounts", func(r chi.Router) {
+				r.Get("/", apiKeyHandler.ListServiceAccounts)
+				r.Post("/", apiKeyHandler.CreateServiceAccount)
+				r.Route("/{Id}/api-keys", func(r chi.Router) {
+					r.Use(apiKeyHandler.ServiceAccountOnly)
+					r.Get("/", apiKeyHandler.List)
+					r.Post("/", apiKeyHandler.Create)
+					r.Delete("/{keyId}", apiKeyHandler.Revoke)
+				})
+			})
+
+			r.Route("/dead-letters", func(r chi.Router) {
+				r.Get("/", deadLetterHandler.List)
+				r.Get("/{id}", deadLetterHandler.Get)
+				r.Post("/{id}/replay", deadLetterHandler.Replay)
+				r.Delete("/{id}", deadLetterHandler.Discard)
+			})
+
+			r.Get("/notifications/{eventId}", deliveryHandler.GetByEventID)
+
+			r.Route("/templates", func(r chi.Router) {
+				r.Get("/", templateHandler.List)
+				r.Post("/", templateHandler.Create)
+				r.Post("/render", templateHandler.Render)
+				r.Get("/{id}", templateHandler.Get)
+				r.Put("/{id}", templateHandler.Update)
+				r.Delete("/{id}", templateHandler.Delete)
+				r.Post("/{id}/preview", templateHandler.Preview)
+			})
+
+			r.Route("/webhooks", func(r chi.Router) {
+				r.Get("/", webhookHandler.List)
+				r.Post("/", webhookHandler.Create)
+				r.Get("/{id}", webhookHandler.Get)
+				r.Put("/{id}", webhookHandler.Update)
+				r.Delete("/{id}", webhookHandler.Delete)
+				r.Get("/{id}/deliveries", webhookHandler.ListDeliveries)
+				r.Get("/{id}/deliveries/{deliveryId}", webhookHandler.GetDelivery)
+				r.Post("/{id}/deliveries/{deliveryId}/redeliver", webhookHandler.Redeliver)
+			})
+
+			r.Route("/oauth/clients", func(r chi.Router) {
+				r.Get("/", oauthClientHandler.List)
+				r.Post("/", oauthClientHandler.Create)
+				r.Get("/{clientId}", oauthClientHandler.Get)
+				r.Put("/{clientId}", oauthClientHandler.Update)
+				r.Delete("/{clientId}", oauthClientHandler.Delete)
+				r.Post("/{clientId}/secret", oauthClientHandler.RotateSecret)
+			})
+
+			r.Get("/settings/auth", settingsHandler.GetAuth)
+			r.Put("/settings/auth", settingsHandler.UpdateAuth)
+
+			r.Get("/signing-keys", signingKeyHandler.List)
+			r.Post("/signing-keys/rotate", signingKeyHandler.Rotate)
+
 		})
 
 	})
 
+	// tokens cannot be issued until a signing key is loaded
+	if err := s.SigningKeys.EnsureCurrent(ctx); err != nil {
+		return fmt.Errorf("failed to load signing keys: %w", err)
+	}
+
+	// Background workers
+	go s.OutboxRelay.Run(ctx)
+	go s.SigningKeys.Run(ctx)
+	go s.WebhookSender.Run(ctx)
+
 	// HTTP Server
 	server := &http.Server{
 		Addr:    addr,
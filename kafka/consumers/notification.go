@@ -0,0 +1,42 @@
+package kafka
+
+import (
+	"context"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaNotificationConsumer reads notification events as part of a consumer
+// group. Offsets are only committed when the caller calls Commit, so a
+// message that was fetched but not delivered is read again after a restart
+// or rebalance.
+type KafkaNotificationConsumer struct {
+	Reader *kafka.Reader
+}
+
+func NewKafkaNotificationConsumer(brokers []string, groupID string, topics ...string) *KafkaNotificationConsumer {
+	return &KafkaNotificationConsumer{
+		Reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers:     brokers,
+			GroupID:     groupID,
+			GroupTopics: topics,
+			MinBytes:    1,
+			MaxBytes:    10e6,
+			StartOffset: kafka.FirstOffset,
+			// zero commit interval makes CommitMessages synchronous
+			CommitInterval: 0,
+		}),
+	}
+}
+
+func (c *KafkaNotificationConsumer) Fetch(ctx context.Context) (kafka.Message, error) {
+	return c.Reader.FetchMessage(ctx)
+}
+
+func (c *KafkaNotificationConsumer) Commit(ctx context.Context, msgs ...kafka.Message) error {
+	return c.Reader.CommitMessages(ctx, msgs...)
+}
+
+func (c *KafkaNotificationConsumer) Close() error {
+	return c.Reader.Close()
+}
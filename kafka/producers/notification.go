@@ -9,22 +9,42 @@ import (
 
 type KafkaNotificationProducer struct {
 	Writer *kafka.Writer
+	Topic  string
 }
 
+// NewKafkaNotificationProducer builds a synchronous producer: WriteMessages
+// only returns nil once the broker acknowledged the batch, which the outbox
+// relay relies on before marking rows as sent.
 func NewKafkaNotificationProducer(brokers []string, topic string) *KafkaNotificationProducer {
 
 	return &KafkaNotificationProducer{
 		Writer: &kafka.Writer{
-			Addr:     kafka.TCP(brokers...),
-			Topic:    topic,
-			Balancer: &kafka.LeastBytes{},
-			Async: true,
+			Addr:                   kafka.TCP(brokers...),
+			Balancer:               &kafka.LeastBytes{},
+			RequiredAcks:           kafka.RequireAll,
+			AllowAutoTopicCreation: true,
 		},
+		Topic: topic,
 	}
 }
 
 func (p *KafkaNotificationProducer) Send(ctx context.Context, data []byte) error {
-	err := p.Writer.WriteMessages(ctx, kafka.Message{Value: data})
+	return p.Publish(ctx, "", nil, data, nil)
+}
+
+// Publish writes a single message. An empty topic uses the producer's
+// default topic.
+func (p *KafkaNotificationProducer) Publish(ctx context.Context, topic string, key, value []byte, headers []kafka.Header) error {
+	if topic == "" {
+		topic = p.Topic
+	}
+
+	err := p.Writer.WriteMessages(ctx, kafka.Message{
+		Topic:   topic,
+		Key:     key,
+		Value:   value,
+		Headers: headers,
+	})
 	if err != nil {
 		log.Printf(" Kafka Write Error: %v\n", err)
 	}
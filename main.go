@@ -13,6 +13,7 @@ import (
 	kafka "test123/kafka/producers"
 
 	"test123/repositories"
+	"test123/utils/passwordcheck"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
@@ -57,17 +58,20 @@ func InitializeServer(cfg config.Config, ctx context.Context) (
 		Addr:     cfg.Redis.Host + ":" + strconv.Itoa(cfg.Redis.Port),
 		Password: cfg.Redis.Password,
 		DB:       cfg.Redis.DB,
-		PoolSize: 10,
+		PoolSize: cfg.Redis.PoolSize,
 	})
 
 	if err := rdb.Ping(ctx).Err(); err != nil {
 		return nil, nil, nil, nil, err
 	}
-	bloomFilter := bloom.NewWithEstimates(1_000_000, 0.01)
+	bloomFilter := bloom.NewWithEstimates(cfg.Bloom.ExpectedItems, cfg.Bloom.FalsePositiveRate)
 	go warmBloomFilter(bloomFilter, pool)
+	breached, err := passwordcheck.LoadBreachedList(cfg.PasswordPolicy.BreachedListPath)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
 	producer := kafka.NewKafkaNotificationProducer(cfg.Kafka.Brokers, cfg.Kafka.Topic)
-	LoadEnv()
-	appServer := http.NewServer("Connected", pool, rdb, producer, bloomFilter)
+	appServer := http.NewServer(cfg, "Connected", pool, rdb, producer, bloomFilter, breached)
 	return appServer, pool, rdb, producer, nil
 }
 
@@ -76,7 +80,13 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	cfg := config.DefaultConfig
+	// .env values take part in the environment layer of the config
+	LoadEnv()
+
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		panic(" Failed to load config: " + err.Error())
+	}
 
 	server, db, rdb, prod, err := InitializeServer(cfg, ctx)
 	if err != nil {
@@ -5,21 +5,25 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"test123/service"
 	"test123/utils"
-	"time"
 )
 
 type authContextKey string
 
-const UserIDKey authContextKey = "userID"
+const (
+	UserIDKey    authContextKey = "userID"
+	SessionIDKey authContextKey = "sessionID"
+	PrincipalKey authContextKey = "principal"
+)
 
 func AuthMiddleware(auth *service.AuthService) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 			// Authorize request
-			userID, err := auth.Authorize(r.Context(), r)
+			principal, err := auth.Authorize(r.Context(), r)
 			if err != nil {
 				utils.RespondJSON(w, 401, map[string]string{
 					"error": "unauthorized",
@@ -28,16 +32,19 @@ func AuthMiddleware(auth *service.AuthService) func(http.Handler) http.Handler {
 
 			}
 
-			//rate limit 100 request in a minute
+			// rate limit per user (or per client acting for itself), configured in rate_limit
 
-			key := fmt.Sprintf("rate_limit:user:%d", userID)
+			key := fmt.Sprintf("rate_limit:user:%d", principal.UserID)
+			if principal.UserID == 0 {
+				key = "rate_limit:client:" + principal.ClientID
+			}
 			count, _ := auth.Redis.Incr(r.Context(), key).Result()
 
 			if count == 1 {
-				auth.Redis.Expire(r.Context(), key, 2*time.Minute)
+				auth.Redis.Expire(r.Context(), key, auth.RateLimit.Window)
 			}
 
-			if count > 100 {
+			if count > int64(auth.RateLimit.Requests) {
 				utils.RespondJSON(w, 429, map[string]string{
 					"error": "rate limit exceeded, try later",
 				})
@@ -45,8 +52,12 @@ func AuthMiddleware(auth *service.AuthService) func(http.Handler) http.Handler {
 			}
 
 			// Inject userID into request context
-			log.Println(userID)
-			ctx := context.WithValue(r.Context(), UserIDKey, userID)
+			ctx := context.WithValue(r.Context(), PrincipalKey, principal)
+			if principal.UserID > 0 {
+				log.Println(principal.UserID)
+				ctx = context.WithValue(ctx, UserIDKey, strconv.Itoa(principal.UserID))
+				ctx = context.WithValue(ctx, SessionIDKey, principal.SessionID)
+			}
 
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
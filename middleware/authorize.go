@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"test123/models"
 	"test123/service"
 	"test123/utils"
 	"time"
@@ -18,6 +19,23 @@ func RequirePermission(s *service.AuthorizeService, permission string) func(http
 	return func(next http.Handler) http.Handler {
 
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// OAuth scopes cap what the token may do, whatever the user holds
+			if principal, ok := r.Context().Value(PrincipalKey).(*models.Principal); ok {
+				if !principal.Allows(permission) {
+					utils.RespondJSON(w, 403, map[string]string{"error": "forbidden - token scope does not cover " + permission})
+					return
+				}
+				// a client acting for itself has only its scopes and no "self"
+				if principal.UserID == 0 {
+					if strings.HasSuffix(permission, ".self") {
+						utils.RespondJSON(w, 403, map[string]string{"error": "forbidden - client tokens cannot act as a user"})
+						return
+					}
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
 			var userID int
 			switch v := r.Context().Value(UserIDKey).(type) {
 			case string:
@@ -0,0 +1,75 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"test123/models"
+	"test123/service"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// newPermissionService returns an AuthorizeService whose cache already
+// holds user 1's permissions, so no database is needed.
+func newPermissionService(t *testing.T, perms ...string) *service.AuthorizeService {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	data, _ := json.Marshal(perms)
+	if err := rdb.Set(context.Background(), "user_perm_1", data, time.Minute).Err(); err != nil {
+		t.Fatalf("seed permissions: %v", err)
+	}
+	return &service.AuthorizeService{Cache: rdb}
+}
+
+func TestRequirePermissionAPIKeyScopes(t *testing.T) {
+	s := newPermissionService(t, "user.read.self", "user.read.all", "user.update.self")
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+	r := chi.NewRouter()
+	r.With(RequirePermission(s, "user.read.self")).Get("/users/{Id}", ok)
+	r.With(RequirePermission(s, "user.read.all")).Get("/users", ok)
+	r.With(RequirePermission(s, "user.delete.self")).Delete("/users/{Id}", ok)
+
+	keyOf := func(scopes ...string) *models.Principal {
+		return &models.Principal{UserID: 1, APIKeyID: 7, Scopes: scopes}
+	}
+
+	tests := []struct {
+		name      string
+		principal *models.Principal
+		method    string
+		path      string
+		status    int
+	}{
+		{"scope and permission", keyOf("user.read.self"), "GET", "/users/1", 200},
+		{"permission the key was not scoped for", keyOf("user.read.self"), "GET", "/users", 403},
+		{"scope the owner does not hold", keyOf("user.delete.self"), "DELETE", "/users/1", 403},
+		{"self scope used on another user", keyOf("user.read.self"), "GET", "/users/2", 403},
+		{"key with every scope of its owner", keyOf("user.read.self", "user.read.all", "user.update.self"), "GET", "/users", 200},
+		{"session token, no scope cap", &models.Principal{UserID: 1, SessionID: "s1"}, "GET", "/users", 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			ctx := context.WithValue(req.Context(), PrincipalKey, tt.principal)
+			ctx = context.WithValue(ctx, UserIDKey, "1")
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req.WithContext(ctx))
+			if w.Code != tt.status {
+				t.Fatalf("got %d %s, want %d", w.Code, w.Body.String(), tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package middlewares
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// RealIP replaces RemoteAddr with the client address reported by a
+// reverse proxy, but only when the request comes from one of trusted.
+// X-Forwarded-For is read right to left, skipping trusted hops, so a
+// client cannot pick its own address by prepending entries. Requests from
+// anywhere else keep their TCP peer address and their headers are ignored.
+func RealIP(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if peer, ok := remoteAddr(r.RemoteAddr); ok && isTrusted(trusted, peer) {
+				if ip, ok := forwardedFor(r, trusted); ok {
+					r.RemoteAddr = ip.String()
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func forwardedFor(r *http.Request, trusted []netip.Prefix) (netip.Addr, bool) {
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+
+	var last netip.Addr
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// whatever lies left of a garbled entry cannot be trusted
+			break
+		}
+		ip = ip.Unmap()
+		if !isTrusted(trusted, ip) {
+			return ip, true
+		}
+		last = ip
+	}
+	if last.IsValid() {
+		return last, true
+	}
+
+	if ip, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return ip.Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+func remoteAddr(addr string) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap(), true
+}
+
+func isTrusted(trusted []netip.Prefix, ip netip.Addr) bool {
+	for _, p := range trusted {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
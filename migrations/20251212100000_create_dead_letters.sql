@@ -0,0 +1,23 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS dead_letters (
+    id BIGSERIAL PRIMARY KEY,
+    event_id UUID,
+    original_topic TEXT NOT NULL,
+    message_key TEXT,
+    payload BYTEA NOT NULL,
+    failure_reason TEXT NOT NULL,
+    retry_count INTEGER NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL DEFAULT 'dead',
+    dlq_partition INTEGER NOT NULL,
+    dlq_offset BIGINT NOT NULL,
+    failed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    resolved_at TIMESTAMPTZ,
+    UNIQUE (dlq_partition, dlq_offset)
+);
+
+CREATE INDEX IF NOT EXISTS idx_dead_letters_status ON dead_letters(status, id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_dead_letters_status;
+DROP TABLE IF EXISTS dead_letters;
@@ -0,0 +1,17 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS notification_deliveries (
+    event_id UUID NOT NULL,
+    channel VARCHAR(20) NOT NULL,
+    user_id INTEGER NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    sent_at TIMESTAMPTZ,
+    PRIMARY KEY (event_id, channel)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS notification_deliveries;
@@ -0,0 +1,36 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS notification_templates (
+    id SERIAL PRIMARY KEY,
+    action VARCHAR(50) NOT NULL,
+    locale VARCHAR(20) NOT NULL DEFAULT 'en',
+    subject TEXT NOT NULL,
+    text_body TEXT NOT NULL,
+    html_body TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (action, locale)
+);
+
+INSERT INTO notification_templates (action, locale, subject, text_body, html_body) VALUES
+(
+    'user_created', 'en',
+    'Welcome, {{.username}}',
+    'Hi {{.username}}, your account has been created successfully.',
+    '<p>Hi {{.username}},</p><p>your account has been created successfully.</p>'
+),
+(
+    'Token', 'en',
+    'Password Reset',
+    'Use this link to reset your password: {{.reset_url}}' || E'\n' || 'The link expires in {{.expires_in_minutes}} minutes.',
+    '<p>Use <a href="{{.reset_url}}">this link</a> to reset your password.</p><p>The link expires in {{.expires_in_minutes}} minutes.</p>'
+),
+(
+    'security', 'en',
+    'Security alert',
+    'Hi {{.username}}, {{.message}}',
+    '<p>Hi {{.username}},</p><p>{{.message}}</p>'
+)
+ON CONFLICT (action, locale) DO NOTHING;
+
+-- +goose Down
+DROP TABLE IF EXISTS notification_templates;
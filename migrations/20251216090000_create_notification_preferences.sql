@@ -0,0 +1,16 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    channels JSONB NOT NULL DEFAULT '{}',
+    actions JSONB NOT NULL DEFAULT '{}',
+    quiet_hours_start VARCHAR(5),
+    quiet_hours_end VARCHAR(5),
+    timezone TEXT NOT NULL DEFAULT 'UTC',
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+ALTER TABLE notification_deliveries ADD COLUMN IF NOT EXISTS skip_reason TEXT;
+
+-- +goose Down
+ALTER TABLE notification_deliveries DROP COLUMN IF EXISTS skip_reason;
+DROP TABLE IF EXISTS notification_preferences;
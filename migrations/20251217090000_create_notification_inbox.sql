@@ -0,0 +1,20 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS notification_inbox (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    event_id UUID NOT NULL UNIQUE,
+    action VARCHAR(50) NOT NULL,
+    title TEXT NOT NULL,
+    body TEXT NOT NULL,
+    metadata JSONB,
+    read_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_inbox_user ON notification_inbox(user_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_notification_inbox_unread ON notification_inbox(user_id) WHERE read_at IS NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_notification_inbox_unread;
+DROP INDEX IF EXISTS idx_notification_inbox_user;
+DROP TABLE IF EXISTS notification_inbox;
@@ -0,0 +1,20 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS signing_keys (
+    kid TEXT PRIMARY KEY,
+    algorithm VARCHAR(10) NOT NULL,
+    -- PKCS#8, AES-GCM sealed with a key derived from auth.jwt_secret
+    private_key BYTEA NOT NULL,
+    -- PKIX DER
+    public_key BYTEA NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    activates_at TIMESTAMPTZ NOT NULL,
+    -- set when a successor is created: the last moment a token signed with
+    -- this key can still be valid
+    retires_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_signing_keys_activates_at ON signing_keys(activates_at DESC);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_signing_keys_activates_at;
+DROP TABLE IF EXISTS signing_keys;
@@ -0,0 +1,23 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    secret_sealed BYTEA NOT NULL,
+    -- NULL until the user proves the app is set up with a first code
+    confirmed_at TIMESTAMPTZ,
+    -- highest time step accepted, so a code cannot be replayed
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (user_id, code_hash)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS user_recovery_codes;
+DROP TABLE IF EXISTS user_totp;
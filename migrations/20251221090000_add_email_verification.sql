@@ -0,0 +1,16 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ;
+-- new address waiting for confirmation; email stays the active one until then
+ALTER TABLE users ADD COLUMN IF NOT EXISTS pending_email VARCHAR(255);
+
+-- runtime switches admins can change without a redeploy
+CREATE TABLE IF NOT EXISTS settings (
+    key TEXT PRIMARY KEY,
+    value JSONB NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS settings;
+ALTER TABLE users DROP COLUMN IF EXISTS pending_email;
+ALTER TABLE users DROP COLUMN IF EXISTS email_verified_at;
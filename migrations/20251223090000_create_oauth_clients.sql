@@ -0,0 +1,18 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS oauth_clients (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    -- sha256 of the client secret; NULL for public clients (SPAs, native
+    -- apps) which authenticate with PKCE instead
+    secret_hash TEXT,
+    public BOOLEAN NOT NULL DEFAULT false,
+    redirect_uris TEXT[] NOT NULL DEFAULT '{}',
+    grant_types TEXT[] NOT NULL,
+    -- permission names the client may ask for
+    scopes TEXT[] NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS oauth_clients;
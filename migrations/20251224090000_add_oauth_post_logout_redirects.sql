@@ -0,0 +1,6 @@
+-- +goose Up
+-- where RP-initiated logout may send the browser afterwards
+ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS post_logout_redirect_uris TEXT[] NOT NULL DEFAULT '{}';
+
+-- +goose Down
+ALTER TABLE oauth_clients DROP COLUMN IF EXISTS post_logout_redirect_uris;
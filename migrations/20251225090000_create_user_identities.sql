@@ -0,0 +1,17 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS user_identities (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- the provider's name in the federation config
+    provider TEXT NOT NULL,
+    -- the provider's "sub" for the account, stable unlike its email
+    subject TEXT NOT NULL,
+    email TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_login_at TIMESTAMPTZ,
+    UNIQUE (provider, subject),
+    UNIQUE (user_id, provider)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS user_identities;
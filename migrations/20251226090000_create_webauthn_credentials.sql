@@ -0,0 +1,20 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- base64url, as browsers send it back in assertions
+    credential_id TEXT NOT NULL UNIQUE,
+    -- COSE_Key from the attested credential data
+    public_key BYTEA NOT NULL,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    transports TEXT[] NOT NULL DEFAULT '{}',
+    aaguid TEXT NOT NULL DEFAULT '',
+    name TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_used_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user_id ON webauthn_credentials (user_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS webauthn_credentials;
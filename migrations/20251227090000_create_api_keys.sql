@@ -0,0 +1,24 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN IF NOT EXISTS service_account BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    -- the public start of the key, shown in listings and used to find it
+    prefix TEXT NOT NULL UNIQUE,
+    -- sha256 of the whole key; the key itself is shown once at creation
+    key_hash TEXT NOT NULL,
+    -- permission names the key is limited to
+    scopes TEXT[] NOT NULL,
+    expires_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_used_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys (user_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS api_keys;
+ALTER TABLE users DROP COLUMN IF EXISTS service_account;
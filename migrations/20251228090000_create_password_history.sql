@@ -0,0 +1,16 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_changed_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE TABLE IF NOT EXISTS password_history (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- the hash the user had before, never the password itself
+    password_hash TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_history_user_id ON password_history (user_id, id DESC);
+
+-- +goose Down
+DROP TABLE IF EXISTS password_history;
+ALTER TABLE users DROP COLUMN IF EXISTS password_changed_at;
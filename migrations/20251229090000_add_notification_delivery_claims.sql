@@ -0,0 +1,10 @@
+-- +goose Up
+-- when the current attempt started; a 'pending' row is only taken over
+-- once this is older than the claim lease
+ALTER TABLE notification_deliveries ADD COLUMN IF NOT EXISTS claimed_at TIMESTAMPTZ;
+UPDATE notification_deliveries SET claimed_at = updated_at WHERE claimed_at IS NULL;
+ALTER TABLE notification_deliveries ALTER COLUMN claimed_at SET DEFAULT now();
+ALTER TABLE notification_deliveries ALTER COLUMN claimed_at SET NOT NULL;
+
+-- +goose Down
+ALTER TABLE notification_deliveries DROP COLUMN IF EXISTS claimed_at;
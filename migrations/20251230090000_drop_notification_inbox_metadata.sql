@@ -0,0 +1,19 @@
+-- +goose Up
+-- metadata held reset, verification and sign-in links and SMS codes
+ALTER TABLE notification_inbox DROP COLUMN IF EXISTS metadata;
+
+UPDATE notification_inbox SET title = 'Password reset requested',
+    body = 'A link to reset your password was sent to your email address.'
+WHERE action = 'Token';
+UPDATE notification_inbox SET title = 'Confirm your email address',
+    body = 'A confirmation link was sent to your new email address.'
+WHERE action = 'email_verification';
+UPDATE notification_inbox SET title = 'Sign-in link requested',
+    body = 'A sign-in link or code was sent to your email address.'
+WHERE action = 'magic_link';
+UPDATE notification_inbox SET title = 'Confirm your mobile number',
+    body = 'A verification code was sent to your mobile number by SMS.'
+WHERE action = 'phone_verification';
+
+-- +goose Down
+ALTER TABLE notification_inbox ADD COLUMN IF NOT EXISTS metadata JSONB;
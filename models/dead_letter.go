@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DeadLetterStatusDead      = "dead"
+	DeadLetterStatusReplayed  = "replayed"
+	DeadLetterStatusDiscarded = "discarded"
+)
+
+// DeadLetter is a notification that exhausted every retry tier.
+type DeadLetter struct {
+	ID            int64      `json:"id"`
+	EventID       *uuid.UUID `json:"event_id,omitempty"`
+	OriginalTopic string     `json:"original_topic"`
+	Key           string     `json:"key,omitempty"`
+	Payload       []byte     `json:"-"`
+	FailureReason string     `json:"failure_reason"`
+	RetryCount    int        `json:"retry_count"`
+	Status        string     `json:"status"`
+	DLQPartition  int        `json:"dlq_partition"`
+	DLQOffset     int64      `json:"dlq_offset"`
+	FailedAt      time.Time  `json:"failed_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ResolvedAt    *time.Time `json:"resolved_at,omitempty"`
+}
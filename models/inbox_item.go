@@ -0,0 +1,47 @@
+package models
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// InboxItem is a notification as shown in the user's in-app inbox. It has
+// no metadata: links and codes travel there and must not be readable by
+// whoever can read the inbox.
+type InboxItem struct {
+	ID        int64      `json:"id"`
+	UserID    int        `json:"user_id"`
+	EventID   uuid.UUID  `json:"event_id"`
+	Action    string     `json:"action"`
+	Title     string     `json:"title"`
+	Body      string     `json:"body"`
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// secretInboxCopy is what the inbox shows for actions whose notification
+// carries a link or code that proves control of an address or number, or
+// signs the user in. The real message only goes to that address or number.
+var secretInboxCopy = map[string][2]string{
+	"Token":              {"Password reset requested", "A link to reset your password was sent to your email address."},
+	"email_verification": {"Confirm your email address", "A confirmation link was sent to your new email address."},
+	"magic_link":         {"Sign-in link requested", "A sign-in link or code was sent to your email address."},
+	"phone_verification": {"Confirm your mobile number", "A verification code was sent to your mobile number by SMS."},
+}
+
+// InboxCopy returns the title and body to store in the inbox for an event
+// of action with the given title and message.
+func InboxCopy(action, title, message string) (string, string) {
+	if c, ok := secretInboxCopy[action]; ok {
+		return c[0], c[1]
+	}
+	return title, message
+}
+
+// InboxChannelKey is the Redis pub/sub channel new inbox items for a user
+// are published on.
+func InboxChannelKey(userID int) string {
+	return "inbox:" + strconv.Itoa(userID)
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// UserTOTP is a user's authenticator app enrollment. The secret is stored
+// sealed; it only counts as enabled once ConfirmedAt is set.
+type UserTOTP struct {
+	UserID       int
+	SecretSealed []byte
+	ConfirmedAt  *time.Time
+	LastUsedStep int64
+	CreatedAt    time.Time
+}
+
+// MFAStatus is what a user sees about their own two-factor setup.
+type MFAStatus struct {
+	TOTPEnabled            bool       `json:"totp_enabled"`
+	EnabledAt              *time.Time `json:"enabled_at,omitempty"`
+	RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
+}
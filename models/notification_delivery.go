@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DeliveryStatusPending = "pending"
+	DeliveryStatusSent    = "sent"
+	DeliveryStatusFailed  = "failed"
+	DeliveryStatusSkipped = "skipped"
+)
+
+// NotificationDelivery records the delivery of one event on one channel.
+type NotificationDelivery struct {
+	EventID    uuid.UUID  `json:"event_id"`
+	Channel    string     `json:"channel"`
+	UserID     int        `json:"user_id"`
+	Action     string     `json:"action"`
+	Status     string     `json:"status"`
+	Attempts   int        `json:"attempts"`
+	LastError  string     `json:"last_error,omitempty"`
+	SkipReason string     `json:"skip_reason,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	SentAt     *time.Time `json:"sent_at,omitempty"`
+}
@@ -0,0 +1,146 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"test123/errors"
+)
+
+// Channels a user can opt in to or out of.
+var NotificationChannels = []string{"email", "sms", "push"}
+
+// mandatoryActions are security and transactional notifications that are
+// always delivered, whatever the user's preferences say.
+var mandatoryActions = map[string]bool{
+	"security":           true,
+	"Token":              true,
+	"email_verification": true,
+	"magic_link":         true,
+	"phone_verification": true,
+	"account_locked":     true,
+}
+
+// IsMandatoryAction reports whether action ignores user preferences.
+func IsMandatoryAction(action string) bool {
+	return mandatoryActions[action]
+}
+
+// NotificationPreferences controls which notifications a user receives.
+// Anything not mentioned is enabled.
+type NotificationPreferences struct {
+	UserID int `json:"user_id"`
+	// Channels switches a channel on or off for every action.
+	Channels map[string]bool `json:"channels"`
+	// Actions overrides Channels for one action, keyed action -> channel.
+	Actions    map[string]map[string]bool `json:"actions"`
+	QuietHours *QuietHours                `json:"quiet_hours,omitempty"`
+	UpdatedAt  time.Time                  `json:"updated_at"`
+}
+
+// QuietHours is a daily window, in the user's timezone, during which
+// optional notifications are not sent. Start after End wraps midnight.
+type QuietHours struct {
+	Start    string `json:"start"` // HH:MM
+	End      string `json:"end"`   // HH:MM
+	Timezone string `json:"timezone"`
+}
+
+func DefaultNotificationPreferences(userID int) *NotificationPreferences {
+	return &NotificationPreferences{
+		UserID:   userID,
+		Channels: map[string]bool{},
+		Actions:  map[string]map[string]bool{},
+	}
+}
+
+// Validate checks channel names, quiet hours and that no mandatory action
+// is being switched off.
+func (p *NotificationPreferences) Validate() error {
+	for ch := range p.Channels {
+		if !isNotificationChannel(ch) {
+			return fmt.Errorf("%w: unknown channel %q", errors.ErrInvalidField, ch)
+		}
+	}
+
+	for action, channels := range p.Actions {
+		for ch, enabled := range channels {
+			if !isNotificationChannel(ch) {
+				return fmt.Errorf("%w: unknown channel %q", errors.ErrInvalidField, ch)
+			}
+			if !enabled && IsMandatoryAction(action) {
+				return fmt.Errorf("%w: %s notifications cannot be disabled", errors.ErrValidationFailed, action)
+			}
+		}
+	}
+
+	if q := p.QuietHours; q != nil {
+		if _, err := time.Parse("15:04", q.Start); err != nil {
+			return fmt.Errorf("%w: quiet_hours.start must be HH:MM", errors.ErrInvalidField)
+		}
+		if _, err := time.Parse("15:04", q.End); err != nil {
+			return fmt.Errorf("%w: quiet_hours.end must be HH:MM", errors.ErrInvalidField)
+		}
+		if q.Timezone == "" {
+			q.Timezone = "UTC"
+		}
+		if _, err := time.LoadLocation(q.Timezone); err != nil {
+			return fmt.Errorf("%w: unknown timezone %q", errors.ErrInvalidField, q.Timezone)
+		}
+	}
+
+	return nil
+}
+
+// Allows reports whether action may be sent on channel. Mandatory actions
+// are always allowed.
+func (p *NotificationPreferences) Allows(action, channel string) bool {
+	if IsMandatoryAction(action) {
+		return true
+	}
+	if enabled, ok := p.Actions[action][channel]; ok {
+		return enabled
+	}
+	if enabled, ok := p.Channels[channel]; ok {
+		return enabled
+	}
+	return true
+}
+
+// InQuietHours reports whether now falls inside the user's quiet hours.
+func (p *NotificationPreferences) InQuietHours(now time.Time) bool {
+	q := p.QuietHours
+	if q == nil {
+		return false
+	}
+
+	loc, err := time.LoadLocation(q.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	start, err1 := time.Parse("15:04", q.Start)
+	end, err2 := time.Parse("15:04", q.End)
+	if err1 != nil || err2 != nil || q.Start == q.End {
+		return false
+	}
+
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	from := start.Hour()*60 + start.Minute()
+	to := end.Hour()*60 + end.Minute()
+
+	if from < to {
+		return minute >= from && minute < to
+	}
+	// window wraps midnight, e.g. 22:00-07:00
+	return minute >= from || minute < to
+}
+
+func isNotificationChannel(ch string) bool {
+	for _, c := range NotificationChannels {
+		if c == ch {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"test123/errors"
+	"time"
+)
+
+const DefaultLocale = "en"
+
+// NotificationTemplate holds the copy for one notification action in one
+// locale. Bodies are Go templates rendered with the event metadata.
+type NotificationTemplate struct {
+	ID        int       `json:"id"`
+	Action    string    `json:"action"`
+	Locale    string    `json:"locale"`
+	Subject   string    `json:"subject"`
+	TextBody  string    `json:"text_body"`
+	HTMLBody  string    `json:"html_body,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RenderedTemplate is a template rendered for a single event.
+type RenderedTemplate struct {
+	TemplateID int    `json:"template_id"`
+	Locale     string `json:"locale"`
+	Subject    string `json:"subject"`
+	Text       string `json:"text"`
+	HTML       string `json:"html,omitempty"`
+}
+
+// Validate checks required fields for NotificationTemplate.
+func (t *NotificationTemplate) Validate() error {
+	if t.Action == "" || t.Subject == "" || t.TextBody == "" {
+		return errors.ErrMissingField
+	}
+	if t.Locale == "" {
+		t.Locale = DefaultLocale
+	}
+	return nil
+}
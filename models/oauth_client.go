@@ -0,0 +1,122 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"test123/errors"
+)
+
+// OAuth2 grant types a client can be registered for.
+const (
+	OAuthGrantAuthorizationCode = "authorization_code"
+	OAuthGrantClientCredentials = "client_credentials"
+	OAuthGrantRefreshToken      = "refresh_token"
+)
+
+var OAuthGrantTypes = []string{
+	OAuthGrantAuthorizationCode,
+	OAuthGrantClientCredentials,
+	OAuthGrantRefreshToken,
+}
+
+// OpenID Connect scopes. They pick the identity claims a client sees
+// rather than permissions, so they are not in the permissions table.
+const (
+	OIDCScopeOpenID  = "openid"
+	OIDCScopeProfile = "profile"
+	OIDCScopeEmail   = "email"
+	OIDCScopePhone   = "phone"
+	OIDCScopeAddress = "address"
+)
+
+var OIDCScopes = []string{
+	OIDCScopeOpenID,
+	OIDCScopeProfile,
+	OIDCScopeEmail,
+	OIDCScopePhone,
+	OIDCScopeAddress,
+}
+
+func IsOIDCScope(scope string) bool {
+	return contains(OIDCScopes, scope)
+}
+
+// OAuthClient is an application registered to get tokens through OAuth2.
+// Its scopes are permission names and cap what its tokens may do. The
+// secret is only returned when it is created or rotated.
+type OAuthClient struct {
+	ID           string    `json:"client_id"`
+	Name         string    `json:"name"`
+	SecretHash   string    `json:"-"`
+	Public       bool      `json:"public"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	GrantTypes   []string  `json:"grant_types"`
+	Scopes       []string  `json:"scopes"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+
+	PostLogoutRedirectURIs []string `json:"post_logout_redirect_uris"`
+}
+
+func (c *OAuthClient) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("%w: name", errors.ErrMissingField)
+	}
+	if len(c.GrantTypes) == 0 {
+		return fmt.Errorf("%w: grant_types", errors.ErrMissingField)
+	}
+	for _, g := range c.GrantTypes {
+		if !isOAuthGrant(g) {
+			return fmt.Errorf("%w: unknown grant type %q", errors.ErrInvalidField, g)
+		}
+	}
+	if c.Public && c.AllowsGrant(OAuthGrantClientCredentials) {
+		return fmt.Errorf("%w: public clients cannot use client_credentials", errors.ErrInvalidField)
+	}
+	if c.AllowsGrant(OAuthGrantAuthorizationCode) && len(c.RedirectURIs) == 0 {
+		return fmt.Errorf("%w: redirect_uris", errors.ErrMissingField)
+	}
+	uris := append(append([]string{}, c.RedirectURIs...), c.PostLogoutRedirectURIs...)
+	for _, uri := range uris {
+		u, err := url.Parse(uri)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Fragment != "" {
+			return fmt.Errorf("%w: redirect uri %q must be absolute and have no fragment", errors.ErrInvalidField, uri)
+		}
+	}
+	if len(c.Scopes) == 0 {
+		return fmt.Errorf("%w: scopes", errors.ErrMissingField)
+	}
+	return nil
+}
+
+func (c *OAuthClient) AllowsGrant(grant string) bool {
+	return contains(c.GrantTypes, grant)
+}
+
+// AllowsRedirect matches uri exactly against the registered redirect URIs.
+func (c *OAuthClient) AllowsRedirect(uri string) bool {
+	return contains(c.RedirectURIs, uri)
+}
+
+func (c *OAuthClient) AllowsPostLogoutRedirect(uri string) bool {
+	return contains(c.PostLogoutRedirectURIs, uri)
+}
+
+func (c *OAuthClient) AllowsScope(scope string) bool {
+	return contains(c.Scopes, scope)
+}
+
+func isOAuthGrant(grant string) bool {
+	return contains(OAuthGrantTypes, grant)
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	OutboxStatusPending = "pending"
+	OutboxStatusSent    = "sent"
+)
+
+// OutboxMessage is an event stored alongside a domain change and relayed
+// to Kafka afterwards. An empty Topic means the producer's default topic.
+type OutboxMessage struct {
+	ID            int64
+	EventID       uuid.UUID
+	Topic         string
+	Key           string
+	Payload       []byte
+	Status        string
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+	SentAt        *time.Time
+}
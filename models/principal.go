@@ -0,0 +1,20 @@
+package models
+
+// Principal is who an authenticated request acts for.
+type Principal struct {
+	// UserID is 0 when an OAuth client acts for itself
+	UserID    int
+	SessionID string
+	// ClientID is set for tokens issued through OAuth
+	ClientID string
+	// APIKeyID is set when the request authenticated with an API key
+	APIKeyID int
+	// Scopes caps the permissions the request may use; nil means the
+	// user's own permissions apply unrestricted
+	Scopes []string
+}
+
+// Allows reports whether the principal's scopes cover permission.
+func (p *Principal) Allows(permission string) bool {
+	return p.Scopes == nil || contains(p.Scopes, permission)
+}
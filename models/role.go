@@ -1,7 +1,7 @@
 package models
 
 type Role struct {
-	Id   int    `json:"name"` //no need for inout
+	Id   int    `json:"id"` //no need for inout
 	Name string `json:"name"`
 }
 
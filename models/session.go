@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Session is one signed-in device. Its ID is the "sid" claim of every
+// token issued for it.
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     int       `json:"user_id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	// Current marks the session the request was made with.
+	Current bool `json:"current"`
+}
+
+// SessionClient describes the device a login comes from.
+type SessionClient struct {
+	Device    string
+	UserAgent string
+	IP        string
+}
@@ -0,0 +1,7 @@
+package models
+
+// AuthSettings are login rules admins can change at runtime. They are
+// stored under the "auth" key of the settings table.
+type AuthSettings struct {
+	RequireVerifiedEmail bool `json:"require_verified_email"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// SigningKey is a stored JWT signing key. PrivateKey is sealed and never
+// leaves the service.
+type SigningKey struct {
+	KID         string     `json:"kid"`
+	Algorithm   string     `json:"algorithm"`
+	PrivateKey  []byte     `json:"-"`
+	PublicKey   []byte     `json:"-"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ActivatesAt time.Time  `json:"activates_at"`
+	RetiresAt   *time.Time `json:"retires_at,omitempty"`
+}
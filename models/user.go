@@ -3,7 +3,6 @@ package models
 import (
 	"regexp"
 	"time"
-	"unicode"
 	"test123/errors"
 )
 
@@ -16,6 +15,17 @@ type User struct {
 	Password     string    `json:"password,omitempty"` // omit in JSON responses
 	MobileNumber string    `json:"mobile_number"`
 	CreatedAt    time.Time `json:"created_at"`
+
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	PendingEmail    string     `json:"pending_email,omitempty"` // awaiting confirmation
+
+	MobileVerifiedAt *time.Time `json:"mobile_verified_at"`
+
+	// service accounts are for machines and sign in with API keys only
+	ServiceAccount bool `json:"service_account"`
+
+	// the password policy's max_age counts from here
+	PasswordChangedAt time.Time `json:"password_changed_at"`
 }
 
 // ===========================
@@ -40,41 +50,7 @@ func (u *User) Validate() error {
 		}
 	}
 
-	//  Validate Password Strength (at least one upper, lower, digit, symbol, min 8)
-	if err := validatePassword(u.Password); err != nil {
-		return err
-	}
-
-	return nil
-}
+	// password strength is the password policy's call, see service.PasswordPolicy
 
-// ===========================
-// PASSWORD STRENGTH CHECKER
-// ===========================
-func validatePassword(password string) error {
-	var (
-		hasMinLen  = len(password) >= 8
-		hasUpper   bool
-		hasLower   bool
-		hasNumber  bool
-		hasSpecial bool
-	)
-
-	for _, ch := range password {
-		switch {
-		case unicode.IsUpper(ch):
-			hasUpper = true
-		case unicode.IsLower(ch):
-			hasLower = true
-		case unicode.IsDigit(ch):
-			hasNumber = true
-		case unicode.IsPunct(ch) || unicode.IsSymbol(ch):
-			hasSpecial = true
-		}
-	}
-
-	if !(hasMinLen && hasUpper && hasLower && hasNumber && hasSpecial) {
-		return errors.ErrWeakPassword
-	}
 	return nil
 }
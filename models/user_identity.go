@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// UserIdentity links a local user to an account at an upstream OpenID
+// Connect provider. Subject is the provider's "sub" claim; Email is only
+// what the provider reported when the link was made.
+type UserIdentity struct {
+	ID          int        `json:"id"`
+	UserID      int        `json:"user_id"`
+	Provider    string     `json:"provider"`
+	Subject     string     `json:"subject"`
+	Email       string     `json:"email,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// WebAuthnCredential is a passkey registered to a user. CredentialID is
+// base64url encoded; PublicKey is the COSE key the authenticator returned.
+type WebAuthnCredential struct {
+	ID           int        `json:"id"`
+	UserID       int        `json:"user_id"`
+	CredentialID string     `json:"credential_id"`
+	PublicKey    []byte     `json:"-"`
+	SignCount    int64      `json:"sign_count"`
+	Transports   []string   `json:"transports"`
+	AAGUID       string     `json:"aaguid,omitempty"`
+	Name         string     `json:"name"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+}
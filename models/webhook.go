@@ -0,0 +1,116 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+
+	"test123/errors"
+)
+
+// Lifecycle events partners can subscribe to.
+const (
+	WebhookEventUserCreated         = "user.created"
+	WebhookEventUserUpdated         = "user.updated"
+	WebhookEventUserDeleted         = "user.deleted"
+	WebhookEventUserPasswordReset   = "user.password_reset"
+	WebhookEventUserPasswordChanged = "user.password_changed"
+
+	// WebhookEventAll subscribes to every event, including future ones.
+	WebhookEventAll = "*"
+)
+
+var WebhookEvents = []string{
+	WebhookEventUserCreated,
+	WebhookEventUserUpdated,
+	WebhookEventUserDeleted,
+	WebhookEventUserPasswordReset,
+	WebhookEventUserPasswordChanged,
+}
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// WebhookSubscription is a partner endpoint that receives signed POSTs for
+// the events it lists. The secret is only returned when it is created.
+type WebhookSubscription struct {
+	ID                  int        `json:"id"`
+	URL                 string     `json:"url"`
+	Events              []string   `json:"events"`
+	Secret              string     `json:"-"`
+	Description         string     `json:"description"`
+	Active              bool       `json:"active"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	DisabledAt          *time.Time `json:"disabled_at,omitempty"`
+	DisabledReason      string     `json:"disabled_reason,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
+
+func (s *WebhookSubscription) Validate() error {
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http(s) URL", errors.ErrInvalidField)
+	}
+	if len(s.Events) == 0 {
+		return fmt.Errorf("%w: events", errors.ErrMissingField)
+	}
+	for _, e := range s.Events {
+		if !isWebhookEvent(e) {
+			return fmt.Errorf("%w: unknown event %q", errors.ErrInvalidField, e)
+		}
+	}
+	return nil
+}
+
+func isWebhookEvent(event string) bool {
+	if event == WebhookEventAll {
+		return true
+	}
+	for _, e := range WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is one attempt log entry: a single event sent to a
+// single subscription. Redeliveries are new rows pointing at the original.
+type WebhookDelivery struct {
+	ID             int64      `json:"id"`
+	SubscriptionID int        `json:"subscription_id"`
+	EventID        uuid.UUID  `json:"event_id"`
+	EventType      string     `json:"event_type"`
+	Payload        []byte     `json:"-"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at"`
+	ResponseStatus *int       `json:"response_status,omitempty"`
+	ResponseBody   string     `json:"response_body,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	DurationMs     *int       `json:"duration_ms,omitempty"`
+	RedeliveryOf   *int64     `json:"redelivery_of,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+}
+
+// WebhookDispatch is a claimed delivery together with where to send it.
+type WebhookDispatch struct {
+	Delivery WebhookDelivery
+	URL      string
+	Secret   string
+}
+
+// WebhookAttempt is the outcome of one HTTP POST.
+type WebhookAttempt struct {
+	ResponseStatus int
+	ResponseBody   string
+	Error          string
+	Duration       time.Duration
+}
@@ -0,0 +1,188 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"test123/errors"
+	"test123/events"
+	"test123/logger"
+	"test123/models"
+	"test123/repositories"
+)
+
+// Message is an event together with the copy rendered for it.
+type Message struct {
+	Event   events.NotificationEvent
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Channel delivers a notification through one medium.
+type Channel interface {
+	Name() string
+	Send(ctx context.Context, msg Message) error
+}
+
+// Renderer renders the template registered for an action and locale.
+type Renderer interface {
+	Render(ctx context.Context, action, locale string, data map[string]string) (*models.RenderedTemplate, error)
+}
+
+// PreferenceProvider returns a user's notification preferences.
+type PreferenceProvider interface {
+	Get(ctx context.Context, userID int) (*models.NotificationPreferences, error)
+}
+
+// Dispatcher stores every event in the in-app inbox and routes it to the
+// channel registered for its NotificationType. Every attempt goes through
+// the delivery ledger, so an event redelivered by Kafka is sent at most
+// once per channel, and through the user's preferences, so opted-out
+// notifications are skipped.
+type Dispatcher struct {
+	channels    map[string]Channel
+	Inbox       Channel
+	Ledger      repositories.DeliveryRepoInterface
+	Renderer    Renderer
+	Preferences PreferenceProvider
+}
+
+func NewDispatcher(ledger repositories.DeliveryRepoInterface, renderer Renderer, preferences PreferenceProvider, inbox Channel, channels ...Channel) *Dispatcher {
+	d := &Dispatcher{
+		channels:    map[string]Channel{},
+		Inbox:       inbox,
+		Ledger:      ledger,
+		Renderer:    renderer,
+		Preferences: preferences,
+	}
+	for _, c := range channels {
+		d.channels[c.Name()] = c
+	}
+	return d
+}
+
+func (d *Dispatcher) Dispatch(ctx context.Context, event events.NotificationEvent) error {
+	c, ok := d.channels[event.NotificationType]
+	if !ok {
+		return fmt.Errorf("no channel registered for notification type %q", event.NotificationType)
+	}
+
+	msg, err := d.render(ctx, event)
+	if err != nil {
+		return err
+	}
+
+	// the inbox keeps a copy of everything, without links or codes;
+	// preferences only govern channels that reach the user outside the app
+	if err := d.deliver(ctx, d.Inbox, msg, false); err != nil {
+		return err
+	}
+	return d.deliver(ctx, c, msg, true)
+}
+
+// render builds the message from the action's template, falling back to the
+// event's own title and message when no template is registered.
+func (d *Dispatcher) render(ctx context.Context, event events.NotificationEvent) (Message, error) {
+	msg := Message{Event: event, Subject: event.Title, Text: event.Message}
+
+	data := map[string]string{
+		"title":   event.Title,
+		"message": event.Message,
+		"target":  event.Target,
+	}
+	for k, v := range event.Metadata {
+		data[k] = v
+	}
+
+	rendered, err := d.Renderer.Render(ctx, event.Action, event.Locale, data)
+	if err == errors.ErrResourceNotFound {
+		return msg, nil
+	}
+	if err != nil {
+		return msg, fmt.Errorf("render %s template: %w", event.Action, err)
+	}
+
+	msg.Subject = rendered.Subject
+	msg.Text = rendered.Text
+	msg.HTML = rendered.HTML
+	return msg, nil
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, c Channel, msg Message, applyPreferences bool) error {
+	event := msg.Event
+
+	send, err := d.Ledger.Begin(ctx, models.NotificationDelivery{
+		EventID: event.EventID,
+		Channel: c.Name(),
+		UserID:  event.UserID,
+		Action:  event.Action,
+	})
+	if err != nil {
+		return err
+	}
+	if !send {
+		logger.Info("Dispatcher.deliver", "skipping duplicate event", map[string]interface{}{
+			"event_id": event.EventID,
+			"channel":  c.Name(),
+		})
+		return nil
+	}
+
+	reason := ""
+	if applyPreferences {
+		if reason, err = d.skipReason(ctx, c, event); err != nil {
+			return err
+		}
+	}
+	if reason != "" {
+		logger.Info("Dispatcher.deliver", "skipping event per user preferences", map[string]interface{}{
+			"event_id": event.EventID,
+			"channel":  c.Name(),
+			"reason":   reason,
+		})
+		return d.Ledger.MarkSkipped(ctx, event.EventID, c.Name(), reason)
+	}
+
+	if err := c.Send(ctx, msg); err != nil {
+		if lErr := d.Ledger.MarkFailed(ctx, event.EventID, c.Name(), err.Error()); lErr != nil {
+			logger.Error("Dispatcher.deliver", "failed to record delivery failure", map[string]interface{}{
+				"event_id": event.EventID,
+				"error":    lErr.Error(),
+			})
+		}
+		return err
+	}
+
+	// a failure here means a redelivery may send the event twice; the send
+	// itself succeeded, so report success
+	if err := d.Ledger.MarkSent(ctx, event.EventID, c.Name()); err != nil {
+		logger.Error("Dispatcher.deliver", "failed to record delivery", map[string]interface{}{
+			"event_id": event.EventID,
+			"error":    err.Error(),
+		})
+	}
+	return nil
+}
+
+// skipReason explains why the user's preferences rule out this delivery,
+// or returns "" when it may go ahead.
+func (d *Dispatcher) skipReason(ctx context.Context, c Channel, event events.NotificationEvent) (string, error) {
+	if models.IsMandatoryAction(event.Action) {
+		return "", nil
+	}
+
+	prefs, err := d.Preferences.Get(ctx, event.UserID)
+	if err != nil {
+		return "", fmt.Errorf("load notification preferences: %w", err)
+	}
+
+	if !prefs.Allows(event.Action, c.Name()) {
+		return "opted out of " + event.Action + " on " + c.Name(), nil
+	}
+	if prefs.InQuietHours(time.Now()) {
+		return "quiet hours", nil
+	}
+	return "", nil
+}
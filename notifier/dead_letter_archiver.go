@@ -0,0 +1,89 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"test123/events"
+	consumers "test123/kafka/consumers"
+	"test123/logger"
+	"test123/models"
+	"test123/repositories"
+)
+
+// DeadLetterArchiver copies messages from the dead-letter topic into the
+// dead_letters table, where admins can inspect, replay or discard them.
+type DeadLetterArchiver struct {
+	Consumer *consumers.KafkaNotificationConsumer
+	Repo     repositories.DeadLetterRepoInterface
+}
+
+func NewDeadLetterArchiver(consumer *consumers.KafkaNotificationConsumer, repo repositories.DeadLetterRepoInterface) *DeadLetterArchiver {
+	return &DeadLetterArchiver{Consumer: consumer, Repo: repo}
+}
+
+func (a *DeadLetterArchiver) Run(ctx context.Context) error {
+	logger.Info("DeadLetterArchiver.Run", "dead-letter archiver started")
+
+	for {
+		msg, err := a.Consumer.Fetch(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				logger.Info("DeadLetterArchiver.Run", "dead-letter archiver stopped")
+				return nil
+			}
+			logger.Error("DeadLetterArchiver.Run", "kafka fetch failed", map[string]interface{}{
+				"error": err.Error(),
+			})
+			continue
+		}
+
+		d := models.DeadLetter{
+			OriginalTopic: headerValue(msg, HeaderOriginalTopic),
+			Key:           string(msg.Key),
+			Payload:       msg.Value,
+			FailureReason: headerValue(msg, HeaderFailureReason),
+			RetryCount:    retryCountOf(msg),
+			DLQPartition:  msg.Partition,
+			DLQOffset:     msg.Offset,
+			FailedAt:      msg.Time,
+		}
+		if t, err := time.Parse(time.RFC3339, headerValue(msg, HeaderFailedAt)); err == nil {
+			d.FailedAt = t
+		}
+		if d.OriginalTopic == "" {
+			d.OriginalTopic = msg.Topic
+		}
+
+		var event events.NotificationEvent
+		if err := json.Unmarshal(msg.Value, &event); err == nil {
+			d.EventID = &event.EventID
+		}
+
+		// keep retrying the insert; committing first would lose the message
+		for {
+			err := a.Repo.Archive(ctx, d)
+			if err == nil {
+				break
+			}
+			logger.Error("DeadLetterArchiver.Run", "failed to archive dead letter, retrying", map[string]interface{}{
+				"offset": strconv.FormatInt(msg.Offset, 10),
+				"error":  err.Error(),
+			})
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(5 * time.Second):
+			}
+		}
+
+		if err := a.Consumer.Commit(ctx, msg); err != nil {
+			logger.Error("DeadLetterArchiver.Run", "offset commit failed", map[string]interface{}{
+				"offset": msg.Offset,
+				"error":  err.Error(),
+			})
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+	"test123/repositories"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// InboxChannel stores every notification in the user's in-app inbox and
+// announces it on Redis so open inbox streams receive it immediately. It
+// keeps the event's own title and message, not the rendered template:
+// templates are filled from the metadata, where links and codes travel.
+type InboxChannel struct {
+	Repo  repositories.InboxRepoInterface
+	Redis *redis.Client
+}
+
+func NewInboxChannel(repo repositories.InboxRepoInterface, rdb *redis.Client) *InboxChannel {
+	return &InboxChannel{Repo: repo, Redis: rdb}
+}
+
+func (c *InboxChannel) Name() string {
+	return "in_app"
+}
+
+func (c *InboxChannel) Send(ctx context.Context, msg Message) error {
+	event := msg.Event
+
+	if event.UserID <= 0 {
+		return nil
+	}
+
+	title, body := models.InboxCopy(event.Action, event.Title, event.Message)
+	item, err := c.Repo.Insert(ctx, models.InboxItem{
+		UserID:  event.UserID,
+		EventID: event.EventID,
+		Action:  event.Action,
+		Title:   title,
+		Body:    body,
+	})
+	if err == errors.ErrUserNotFound {
+		logger.Warn("InboxChannel.Send", "user no longer exists, not storing", map[string]interface{}{
+			"event_id": event.EventID,
+			"user_id":  event.UserID,
+		})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		// already stored by an earlier attempt
+		return nil
+	}
+
+	payload, _ := json.Marshal(item)
+	if err := c.Redis.Publish(ctx, models.InboxChannelKey(event.UserID), payload).Err(); err != nil {
+		// the item is stored; streams only miss the live update
+		logger.Warn("InboxChannel.Send", "failed to publish inbox item", map[string]interface{}{
+			"event_id": event.EventID,
+			"error":    err.Error(),
+		})
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"test123/events"
+	"test123/models"
+	"test123/repositories"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// memoryInbox is an in-memory notification_inbox table.
+type memoryInbox struct {
+	repositories.InboxRepoInterface
+
+	mu    sync.Mutex
+	items []models.InboxItem
+}
+
+func (r *memoryInbox) Insert(ctx context.Context, item models.InboxItem) (*models.InboxItem, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item.ID = int64(len(r.items) + 1)
+	item.CreatedAt = time.Now()
+	r.items = append(r.items, item)
+	return &item, nil
+}
+
+// metadataTemplates renders every action with all of its metadata, like a
+// template that puts the link or code in the message.
+type metadataTemplates struct{}
+
+func (metadataTemplates) Render(ctx context.Context, action, locale string, data map[string]string) (*models.RenderedTemplate, error) {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var text []string
+	for _, k := range keys {
+		text = append(text, k+": "+data[k])
+	}
+	return &models.RenderedTemplate{Subject: data["title"], Text: strings.Join(text, "\n")}, nil
+}
+
+func TestInboxKeepsSecretsOut(t *testing.T) {
+	tests := []struct {
+		name   string
+		event  events.NotificationEvent
+		secret string
+		title  string
+	}{
+		{
+			name: "password reset",
+			event: events.NotificationEvent{NotificationType: "email", Action: "Token", Title: "Password Reset",
+				Message: "Click the link to reset your password.", Target: "alice@example.com",
+				Metadata: map[string]string{"reset_url": "https://app.example.com/reset?token=SECRET-RESET"}},
+			secret: "SECRET-RESET",
+			title:  "Password reset requested",
+		},
+		{
+			name: "magic link code",
+			event: events.NotificationEvent{NotificationType: "email", Action: "magic_link", Title: "Your sign-in code",
+				Message: "Your sign-in code is 918273.", Target: "alice@example.com",
+				Metadata: map[string]string{"otp": "918273"}},
+			secret: "918273",
+			title:  "Sign-in link requested",
+		},
+		{
+			name: "email change alert",
+			event: events.NotificationEvent{NotificationType: "email", Action: "security", Title: "Your email address is being changed",
+				Message: "the email address of your account is being changed to new@example.com.", Target: "alice@example.com",
+				Metadata: map[string]string{"undo_url": "https://app.example.com/undo?token=SECRET-UNDO"}},
+			secret: "SECRET-UNDO",
+			title:  "Your email address is being changed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := miniredis.RunT(t)
+			rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+			defer rdb.Close()
+			sub := rdb.Subscribe(context.Background(), models.InboxChannelKey(7))
+			defer sub.Close()
+			if _, err := sub.Receive(context.Background()); err != nil {
+				t.Fatalf("subscribe: %v", err)
+			}
+
+			inbox := &memoryInbox{}
+			outside := &recordingChannel{name: tt.event.NotificationType}
+			d := NewDispatcher(newMemoryLedger(), metadataTemplates{}, defaultPreferences{}, NewInboxChannel(inbox, rdb), outside)
+
+			event := tt.event
+			event.EventID, event.UserID, event.CreatedAt = uuid.New(), 7, time.Now()
+			if err := d.Dispatch(context.Background(), event); err != nil {
+				t.Fatalf("Dispatch: %v", err)
+			}
+
+			// the secret still reaches the address or number it is for
+			if got := outside.texts(); len(got) != 1 || !strings.Contains(got[0], tt.secret) {
+				t.Fatalf("%s channel got %q, want the message with the secret", tt.event.NotificationType, got)
+			}
+
+			if len(inbox.items) != 1 {
+				t.Fatalf("inbox has %d items, want 1", len(inbox.items))
+			}
+			stored, _ := json.Marshal(inbox.items[0])
+			if strings.Contains(string(stored), tt.secret) {
+				t.Errorf("inbox item holds the secret: %s", stored)
+			}
+			if inbox.items[0].Title != tt.title {
+				t.Errorf("inbox title %q, want %q", inbox.items[0].Title, tt.title)
+			}
+
+			select {
+			case m := <-sub.Channel():
+				if strings.Contains(m.Payload, tt.secret) || strings.Contains(m.Payload, "metadata") {
+					t.Errorf("inbox stream published the secret: %s", m.Payload)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("nothing published to the inbox stream")
+			}
+		})
+	}
+}
+
+// recordingChannel keeps the text of every message sent through it.
+type recordingChannel struct {
+	name string
+
+	mu   sync.Mutex
+	sent []string
+}
+
+func (c *recordingChannel) Name() string { return c.name }
+
+func (c *recordingChannel) Send(ctx context.Context, msg Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent = append(c.sent, msg.Text)
+	return nil
+}
+
+func (c *recordingChannel) texts() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.sent...)
+}
@@ -0,0 +1,86 @@
+package notifier
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Kafka headers carried by events on retry and dead-letter topics.
+const (
+	HeaderRetryCount    = "x-retry-count"
+	HeaderOriginalTopic = "x-original-topic"
+	HeaderFailureReason = "x-failure-reason"
+	HeaderFailedAt      = "x-failed-at"
+)
+
+// RetrySchedule maps failed deliveries onto delay topics, one per tier, and
+// finally onto the dead-letter topic.
+type RetrySchedule struct {
+	BaseTopic       string
+	Delays          []time.Duration
+	DeadLetterTopic string
+}
+
+func NewRetrySchedule(baseTopic string, delays []time.Duration, deadLetterTopic string) *RetrySchedule {
+	return &RetrySchedule{
+		BaseTopic:       baseTopic,
+		Delays:          delays,
+		DeadLetterTopic: deadLetterTopic,
+	}
+}
+
+// RetryTopic names the delay topic for a tier, e.g. "email-service.retry.10m".
+func (s *RetrySchedule) RetryTopic(tier int) string {
+	return fmt.Sprintf("%s.retry.%s", s.BaseTopic, shortDuration(s.Delays[tier]))
+}
+
+// NextTopic returns where a message goes after its retryCount-th failure.
+func (s *RetrySchedule) NextTopic(retryCount int) string {
+	if retryCount < len(s.Delays) {
+		return s.RetryTopic(retryCount)
+	}
+	return s.DeadLetterTopic
+}
+
+func shortDuration(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return strconv.Itoa(int(d/time.Hour)) + "h"
+	case d%time.Minute == 0:
+		return strconv.Itoa(int(d/time.Minute)) + "m"
+	default:
+		return strconv.Itoa(int(d/time.Second)) + "s"
+	}
+}
+
+func headerValue(msg kafka.Message, key string) string {
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func retryCountOf(msg kafka.Message) int {
+	n, _ := strconv.Atoi(headerValue(msg, HeaderRetryCount))
+	return n
+}
+
+// failureHeaders describes the failure of msg for the next hop.
+func failureHeaders(msg kafka.Message, retryCount int, reason error) []kafka.Header {
+	original := headerValue(msg, HeaderOriginalTopic)
+	if original == "" {
+		original = msg.Topic
+	}
+
+	return []kafka.Header{
+		{Key: HeaderRetryCount, Value: []byte(strconv.Itoa(retryCount))},
+		{Key: HeaderOriginalTopic, Value: []byte(original)},
+		{Key: HeaderFailureReason, Value: []byte(reason.Error())},
+		{Key: HeaderFailedAt, Value: []byte(time.Now().UTC().Format(time.RFC3339))},
+	}
+}
@@ -0,0 +1,100 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"test123/config"
+	"test123/logger"
+)
+
+// SMSProvider hands a text message to whatever gateway delivers it.
+type SMSProvider interface {
+	SendSMS(ctx context.Context, to, body string) error
+}
+
+// NewSMSProvider builds the provider named in cfg.
+func NewSMSProvider(cfg config.SMS) (SMSProvider, error) {
+	switch cfg.Provider {
+	case "log":
+		return LogSMSProvider{}, nil
+	case "file":
+		return NewFileSMSProvider(cfg.FilePath), nil
+	default:
+		return nil, fmt.Errorf("unknown sms provider %q", cfg.Provider)
+	}
+}
+
+// SMSChannel delivers sms notifications through an SMSProvider.
+type SMSChannel struct {
+	Provider SMSProvider
+}
+
+func NewSMSChannel(provider SMSProvider) *SMSChannel {
+	return &SMSChannel{Provider: provider}
+}
+
+func (c *SMSChannel) Name() string {
+	return "sms"
+}
+
+func (c *SMSChannel) Send(ctx context.Context, msg Message) error {
+	if msg.Event.Target == "" {
+		return fmt.Errorf("sms has no recipient")
+	}
+	return c.Provider.SendSMS(ctx, msg.Event.Target, msg.Text)
+}
+
+// LogSMSProvider writes messages to the log instead of sending them.
+type LogSMSProvider struct{}
+
+func (LogSMSProvider) SendSMS(ctx context.Context, to, body string) error {
+	logger.Info("LogSMSProvider.SendSMS", "sms not sent, logged only", map[string]interface{}{
+		"to":   to,
+		"body": body,
+	})
+	return nil
+}
+
+// FileSMSProvider appends each message as a JSON line to a file, so codes
+// can be read back when running offline.
+type FileSMSProvider struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func NewFileSMSProvider(path string) *FileSMSProvider {
+	return &FileSMSProvider{Path: path}
+}
+
+type fileSMS struct {
+	To     string    `json:"to"`
+	Body   string    `json:"body"`
+	SentAt time.Time `json:"sent_at"`
+}
+
+func (p *FileSMSProvider) SendSMS(ctx context.Context, to, body string) error {
+	line, err := json.Marshal(fileSMS{To: to, Body: body, SentAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := os.OpenFile(p.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open sms outbox file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write sms outbox file: %w", err)
+	}
+	return nil
+}
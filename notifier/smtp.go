@@ -0,0 +1,129 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"test123/config"
+)
+
+// SMTPChannel delivers email notifications through an SMTP relay.
+type SMTPChannel struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	Timeout  time.Duration
+}
+
+func NewSMTPChannel(cfg config.SMTP) *SMTPChannel {
+	return &SMTPChannel{
+		Host:     cfg.Host,
+		Port:     cfg.Port,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		From:     cfg.From,
+		Timeout:  10 * time.Second,
+	}
+}
+
+func (c *SMTPChannel) Name() string {
+	return "email"
+}
+
+func (c *SMTPChannel) Send(ctx context.Context, msg Message) error {
+	event := msg.Event
+	addr := net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("smtp dial %s: %w", addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, c.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("smtp handshake: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(nil); err != nil {
+			return fmt.Errorf("smtp starttls: %w", err)
+		}
+	}
+	if c.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", c.Username, c.Password, c.Host)); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+
+	if err := client.Mail(c.From); err != nil {
+		return fmt.Errorf("smtp MAIL FROM: %w", err)
+	}
+	if err := client.Rcpt(event.Target); err != nil {
+		return fmt.Errorf("smtp RCPT TO: %w", err)
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("smtp DATA: %w", err)
+	}
+	if _, err := w.Write(c.buildMessage(msg)); err != nil {
+		return fmt.Errorf("smtp write: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp DATA close: %w", err)
+	}
+
+	return client.Quit()
+}
+
+func (c *SMTPChannel) buildMessage(msg Message) []byte {
+	event := msg.Event
+
+	var b strings.Builder
+
+	b.WriteString("From: " + c.From + "\r\n")
+	b.WriteString("To: " + event.Target + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", msg.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("Message-ID: <" + event.EventID.String() + "@" + c.Host + ">\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+
+	if msg.HTML == "" {
+		b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+		b.WriteString("\r\n")
+		b.WriteString(msg.Text + "\r\n")
+		return []byte(b.String())
+	}
+
+	boundary := "alt-" + event.EventID.String()
+	b.WriteString("Content-Type: multipart/alternative; boundary=\"" + boundary + "\"\r\n")
+	b.WriteString("\r\n")
+
+	b.WriteString("--" + boundary + "\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(msg.Text + "\r\n")
+
+	b.WriteString("--" + boundary + "\r\n")
+	b.WriteString("Content-Type: text/html; charset=UTF-8\r\n\r\n")
+	b.WriteString(msg.HTML + "\r\n")
+
+	b.WriteString("--" + boundary + "--\r\n")
+	return []byte(b.String())
+}
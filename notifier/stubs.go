@@ -0,0 +1,28 @@
+package notifier
+
+import (
+	"context"
+
+	"test123/logger"
+)
+
+// PushChannel is a placeholder until a push provider is wired in; it only
+// logs the message it would have sent.
+type PushChannel struct{}
+
+func NewPushChannel() *PushChannel {
+	return &PushChannel{}
+}
+
+func (c *PushChannel) Name() string {
+	return "push"
+}
+
+func (c *PushChannel) Send(ctx context.Context, msg Message) error {
+	logger.Info("PushChannel.Send", "push delivery stubbed", map[string]interface{}{
+		"event_id": msg.Event.EventID,
+		"target":   msg.Event.Target,
+		"title":    msg.Subject,
+	})
+	return nil
+}
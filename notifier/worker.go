@@ -0,0 +1,178 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"test123/events"
+	"test123/logger"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// MessageConsumer fetches messages and commits their offsets, as
+// consumers.KafkaNotificationConsumer does.
+type MessageConsumer interface {
+	Fetch(ctx context.Context) (kafka.Message, error)
+	Commit(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// Publisher writes a message to a topic, as
+// producers.KafkaNotificationProducer does.
+type Publisher interface {
+	Publish(ctx context.Context, topic string, key, value []byte, headers []kafka.Header) error
+}
+
+// Worker consumes notification events from one topic and hands them to the
+// dispatcher. Failed events are forwarded to the next retry tier or the
+// dead-letter topic; an offset is committed only once the event was either
+// delivered or safely forwarded.
+type Worker struct {
+	Consumer   MessageConsumer
+	Dispatcher *Dispatcher
+	Producer   Publisher
+	Schedule   *RetrySchedule
+	// Delay holds each message back until this long after it was written,
+	// zero for the main topic.
+	Delay      time.Duration
+	RetryDelay time.Duration
+	MaxDelay   time.Duration
+}
+
+func NewWorker(consumer MessageConsumer, dispatcher *Dispatcher, producer Publisher, schedule *RetrySchedule, delay time.Duration) *Worker {
+	return &Worker{
+		Consumer:   consumer,
+		Dispatcher: dispatcher,
+		Producer:   producer,
+		Schedule:   schedule,
+		Delay:      delay,
+		RetryDelay: time.Second,
+		MaxDelay:   time.Minute,
+	}
+}
+
+// Run consumes until ctx is cancelled.
+func (w *Worker) Run(ctx context.Context) error {
+	logger.Info("Worker.Run", "notification worker started", map[string]interface{}{
+		"delay": w.Delay.String(),
+	})
+
+	for {
+		msg, err := w.Consumer.Fetch(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				logger.Info("Worker.Run", "notification worker stopped")
+				return nil
+			}
+			logger.Error("Worker.Run", "kafka fetch failed", map[string]interface{}{
+				"error": err.Error(),
+			})
+			continue
+		}
+
+		if !w.waitUntilDue(ctx, msg) {
+			return nil
+		}
+
+		if err := w.handle(ctx, msg); err != nil {
+			if !w.forward(ctx, msg, err) {
+				// shutting down mid-forward, leave the offset uncommitted
+				return nil
+			}
+		}
+
+		if err := w.Consumer.Commit(ctx, msg); err != nil {
+			logger.Error("Worker.Run", "offset commit failed", map[string]interface{}{
+				"topic":     msg.Topic,
+				"partition": msg.Partition,
+				"offset":    msg.Offset,
+				"error":     err.Error(),
+			})
+		}
+	}
+}
+
+// waitUntilDue sleeps until msg has spent Delay on its retry topic.
+func (w *Worker) waitUntilDue(ctx context.Context, msg kafka.Message) bool {
+	if w.Delay <= 0 {
+		return true
+	}
+
+	wait := time.Until(msg.Time.Add(w.Delay))
+	if wait <= 0 {
+		return true
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(wait):
+		return true
+	}
+}
+
+// handle decodes, validates and delivers one message.
+func (w *Worker) handle(ctx context.Context, msg kafka.Message) error {
+	var event events.NotificationEvent
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		return fmt.Errorf("undecodable event: %w", err)
+	}
+
+	if err := event.Validate(); err != nil {
+		return fmt.Errorf("invalid event: %w", err)
+	}
+
+	if err := w.Dispatcher.Dispatch(ctx, event); err != nil {
+		return err
+	}
+
+	logger.Info("Worker.handle", "notification delivered", map[string]interface{}{
+		"event_id": event.EventID,
+		"type":     event.NotificationType,
+		"action":   event.Action,
+		"retries":  retryCountOf(msg),
+	})
+	return nil
+}
+
+// forward moves a failed message to its next retry tier or the dead-letter
+// topic, retrying the publish itself until it succeeds or ctx is cancelled.
+func (w *Worker) forward(ctx context.Context, msg kafka.Message, reason error) bool {
+	retryCount := retryCountOf(msg) + 1
+	topic := w.Schedule.NextTopic(retryCount - 1)
+	headers := failureHeaders(msg, retryCount, reason)
+
+	logger.Warn("Worker.forward", "delivery failed", map[string]interface{}{
+		"topic":       msg.Topic,
+		"offset":      msg.Offset,
+		"retry_count": retryCount,
+		"next_topic":  topic,
+		"error":       reason.Error(),
+	})
+
+	delay := w.RetryDelay
+	for {
+		err := w.Producer.Publish(ctx, topic, msg.Key, msg.Value, headers)
+		if err == nil {
+			return true
+		}
+
+		logger.Error("Worker.forward", "failed to forward message, retrying", map[string]interface{}{
+			"next_topic": topic,
+			"error":      err.Error(),
+		})
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > w.MaxDelay {
+			delay = w.MaxDelay
+		}
+	}
+}
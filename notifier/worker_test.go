@@ -0,0 +1,319 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"test123/errors"
+	"test123/events"
+	"test123/models"
+
+	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
+)
+
+// smtpStandIn is a bare SMTP server that keeps every message it accepts.
+// With rejectRcpt set it refuses all recipients.
+type smtpStandIn struct {
+	ln         net.Listener
+	rejectRcpt bool
+
+	mu       sync.Mutex
+	messages []string
+	received chan struct{}
+}
+
+func startSMTPStandIn(t *testing.T, rejectRcpt bool) *smtpStandIn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &smtpStandIn{ln: ln, rejectRcpt: rejectRcpt, received: make(chan struct{}, 10)}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpStandIn) channel() *SMTPChannel {
+	host, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return &SMTPChannel{Host: host, Port: p, From: "noreply@example.com", Timeout: 5 * time.Second}
+}
+
+func (s *smtpStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 stand-in ready")
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.Fields(line + " ")[0])
+		switch verb {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250 stand-in")
+		case "MAIL":
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			if s.rejectRcpt {
+				tp.PrintfLine("550 no such user")
+			} else {
+				tp.PrintfLine("250 ok")
+			}
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, string(data))
+			s.mu.Unlock()
+			tp.PrintfLine("250 queued")
+			s.received <- struct{}{}
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+// fakeConsumer hands out queued messages, then blocks until cancelled.
+type fakeConsumer struct {
+	msgs      chan kafka.Message
+	committed chan kafka.Message
+}
+
+func newFakeConsumer(msgs ...kafka.Message) *fakeConsumer {
+	c := &fakeConsumer{msgs: make(chan kafka.Message, len(msgs)), committed: make(chan kafka.Message, len(msgs))}
+	for _, m := range msgs {
+		c.msgs <- m
+	}
+	return c
+}
+
+func (c *fakeConsumer) Fetch(ctx context.Context) (kafka.Message, error) {
+	select {
+	case m := <-c.msgs:
+		return m, nil
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	}
+}
+
+func (c *fakeConsumer) Commit(ctx context.Context, msgs ...kafka.Message) error {
+	for _, m := range msgs {
+		c.committed <- m
+	}
+	return nil
+}
+
+type published struct {
+	topic   string
+	headers []kafka.Header
+}
+
+type fakePublisher struct {
+	mu   sync.Mutex
+	sent []published
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, topic string, key, value []byte, headers []kafka.Header) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sent = append(p.sent, published{topic: topic, headers: headers})
+	return nil
+}
+
+// memoryLedger is an in-memory delivery ledger.
+type memoryLedger struct {
+	mu     sync.Mutex
+	status map[string]string
+}
+
+func newMemoryLedger() *memoryLedger {
+	return &memoryLedger{status: map[string]string{}}
+}
+
+func (l *memoryLedger) set(eventID uuid.UUID, channel, status string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.status[eventID.String()+"/"+channel] = status
+}
+
+func (l *memoryLedger) Begin(ctx context.Context, d models.NotificationDelivery) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := d.EventID.String() + "/" + d.Channel
+	if s := l.status[key]; s == models.DeliveryStatusSent || s == models.DeliveryStatusSkipped {
+		return false, nil
+	}
+	l.status[key] = models.DeliveryStatusPending
+	return true, nil
+}
+
+func (l *memoryLedger) MarkSent(ctx context.Context, eventID uuid.UUID, channel string) error {
+	l.set(eventID, channel, models.DeliveryStatusSent)
+	return nil
+}
+
+func (l *memoryLedger) MarkFailed(ctx context.Context, eventID uuid.UUID, channel string, lastErr string) error {
+	l.set(eventID, channel, models.DeliveryStatusFailed)
+	return nil
+}
+
+func (l *memoryLedger) MarkSkipped(ctx context.Context, eventID uuid.UUID, channel string, reason string) error {
+	l.set(eventID, channel, models.DeliveryStatusSkipped)
+	return nil
+}
+
+func (l *memoryLedger) ListByEventID(ctx context.Context, eventID uuid.UUID) ([]models.NotificationDelivery, error) {
+	return nil, nil
+}
+
+type noTemplates struct{}
+
+func (noTemplates) Render(ctx context.Context, action, locale string, data map[string]string) (*models.RenderedTemplate, error) {
+	return nil, errors.ErrResourceNotFound
+}
+
+type defaultPreferences struct{}
+
+func (defaultPreferences) Get(ctx context.Context, userID int) (*models.NotificationPreferences, error) {
+	return &models.NotificationPreferences{UserID: userID}, nil
+}
+
+type discardChannel struct{ name string }
+
+func (c discardChannel) Name() string                                { return c.name }
+func (c discardChannel) Send(ctx context.Context, msg Message) error { return nil }
+
+func emailEvent(t *testing.T) kafka.Message {
+	t.Helper()
+	event := events.NotificationEvent{
+		EventID:          uuid.New(),
+		UserID:           7,
+		NotificationType: "email",
+		Action:           "security",
+		Title:            "New sign-in",
+		Message:          "Someone signed in to your account.",
+		Target:           "user@example.com",
+		CreatedAt:        time.Now(),
+	}
+	value, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("marshal event: %v", err)
+	}
+	return kafka.Message{Topic: "email-service", Partition: 0, Offset: 42, Value: value, Time: time.Now()}
+}
+
+func runWorker(t *testing.T, smtp *smtpStandIn, consumer *fakeConsumer, producer *fakePublisher) (kafka.Message, func()) {
+	t.Helper()
+	dispatcher := NewDispatcher(newMemoryLedger(), noTemplates{}, defaultPreferences{}, discardChannel{"in_app"}, smtp.channel())
+	schedule := NewRetrySchedule("email-service", []time.Duration{time.Minute}, "email-service.dlq")
+	w := NewWorker(consumer, dispatcher, producer, schedule, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.Run(ctx)
+	}()
+
+	select {
+	case m := <-consumer.committed:
+		return m, func() { cancel(); <-done }
+	case <-time.After(10 * time.Second):
+		cancel()
+		t.Fatal("offset was never committed")
+		return kafka.Message{}, nil
+	}
+}
+
+func TestWorkerDeliversEmailAndCommits(t *testing.T) {
+	smtp := startSMTPStandIn(t, false)
+	msg := emailEvent(t)
+	consumer := newFakeConsumer(msg)
+	producer := &fakePublisher{}
+
+	committed, stop := runWorker(t, smtp, consumer, producer)
+	defer stop()
+
+	if committed.Offset != msg.Offset {
+		t.Errorf("committed offset %d, want %d", committed.Offset, msg.Offset)
+	}
+	select {
+	case <-smtp.received:
+	default:
+		t.Fatal("offset committed before the mail was accepted")
+	}
+
+	smtp.mu.Lock()
+	defer smtp.mu.Unlock()
+	if len(smtp.messages) != 1 {
+		t.Fatalf("smtp got %d messages, want 1", len(smtp.messages))
+	}
+	body := smtp.messages[0]
+	for _, want := range []string{"To: user@example.com", "Subject: New sign-in", "Someone signed in to your account."} {
+		if !strings.Contains(body, want) {
+			t.Errorf("message does not contain %q:\n%s", want, body)
+		}
+	}
+
+	producer.mu.Lock()
+	defer producer.mu.Unlock()
+	if len(producer.sent) != 0 {
+		t.Errorf("delivered message was forwarded to %v", producer.sent)
+	}
+}
+
+func TestWorkerForwardsFailedDeliveryAndCommits(t *testing.T) {
+	smtp := startSMTPStandIn(t, true)
+	msg := emailEvent(t)
+	consumer := newFakeConsumer(msg)
+	producer := &fakePublisher{}
+
+	committed, stop := runWorker(t, smtp, consumer, producer)
+	stop()
+
+	if committed.Offset != msg.Offset {
+		t.Errorf("committed offset %d, want %d", committed.Offset, msg.Offset)
+	}
+
+	producer.mu.Lock()
+	defer producer.mu.Unlock()
+	if len(producer.sent) != 1 {
+		t.Fatalf("forwarded %d messages, want 1", len(producer.sent))
+	}
+	if got := producer.sent[0].topic; got != "email-service.retry.1m" {
+		t.Errorf("forwarded to %q, want the first retry tier", got)
+	}
+	retries := kafka.Message{Headers: producer.sent[0].headers}
+	if got := retryCountOf(retries); got != 1 {
+		t.Errorf("retry count %d, want 1", got)
+	}
+	if got := headerValue(retries, HeaderOriginalTopic); got != "email-service" {
+		t.Errorf("original topic %q, want email-service", got)
+	}
+}
@@ -0,0 +1,139 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type APIKeyRepo struct {
+	DB *pgxpool.Pool
+}
+
+func NewAPIKeyRepo(db *pgxpool.Pool) *APIKeyRepo {
+	return &APIKeyRepo{DB: db}
+}
+
+const apiKeyColumns = `id, user_id, name, prefix, key_hash, scopes, expires_at, revoked_at, created_at, last_used_at`
+
+func scanAPIKey(row pgx.Row) (*models.APIKey, error) {
+	var k models.APIKey
+	if err := row.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &k.KeyHash, &k.Scopes, &k.ExpiresAt, &k.RevokedAt,
+		&k.CreatedAt, &k.LastUsedAt); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// apiKeyError maps driver errors onto the repo's error values.
+func apiKeyError(method string, err error) error {
+	if err == pgx.ErrNoRows {
+		return errors.ErrResourceNotFound
+	}
+	if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
+		return errors.ErrDuplicateRequest
+	}
+	logger.Error(method, "db error", map[string]interface{}{
+		"error": err.Error(),
+	})
+	return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+}
+
+func (r *APIKeyRepo) Create(ctx context.Context, k models.APIKey) (*models.APIKey, error) {
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING ` + apiKeyColumns
+
+	created, err := scanAPIKey(r.DB.QueryRow(ctx, query, k.UserID, k.Name, k.Prefix, k.KeyHash, k.Scopes, k.ExpiresAt))
+	if err != nil {
+		return nil, apiKeyError("APIKeyRepo.Create", err)
+	}
+	return created, nil
+}
+
+// ListByUser returns the user's keys, revoked ones included, newest first.
+func (r *APIKeyRepo) ListByUser(ctx context.Context, userID int) ([]models.APIKey, error) {
+	rows, err := r.DB.Query(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE user_id = $1 ORDER BY created_at DESC, id DESC`, userID)
+	if err != nil {
+		return nil, apiKeyError("APIKeyRepo.ListByUser", err)
+	}
+	defer rows.Close()
+
+	list := []models.APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, apiKeyError("APIKeyRepo.ListByUser", err)
+		}
+		list = append(list, *k)
+	}
+	return list, rows.Err()
+}
+
+func (r *APIKeyRepo) GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	k, err := scanAPIKey(r.DB.QueryRow(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE prefix = $1`, prefix))
+	if err != nil {
+		return nil, apiKeyError("APIKeyRepo.GetByPrefix", err)
+	}
+	return k, nil
+}
+
+// Revoke stamps revoked_at on one of the user's keys. Revoking a key
+// twice is reported as not found.
+func (r *APIKeyRepo) Revoke(ctx context.Context, userID, id int) error {
+	val, err := r.DB.Exec(ctx, `
+		UPDATE api_keys SET revoked_at = now()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, id, userID)
+	if err != nil {
+		return apiKeyError("APIKeyRepo.Revoke", err)
+	}
+	if val.RowsAffected() == 0 {
+		return errors.ErrResourceNotFound
+	}
+	return nil
+}
+
+// TouchLastUsed records a use. The stamp is coarse, at most one write a
+// minute per key, so busy keys do not write on every request.
+func (r *APIKeyRepo) TouchLastUsed(ctx context.Context, id int) error {
+	_, err := r.DB.Exec(ctx, `
+		UPDATE api_keys SET last_used_at = now()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')`, id)
+	if err != nil {
+		return apiKeyError("APIKeyRepo.TouchLastUsed", err)
+	}
+	return nil
+}
+
+// UnknownPermissions returns the names that are not in the permissions
+// table, so scopes can only name real permissions.
+func (r *APIKeyRepo) UnknownPermissions(ctx context.Context, names []string) ([]string, error) {
+	query := `
+		SELECT n FROM unnest($1::text[]) AS n
+		WHERE NOT EXISTS (SELECT 1 FROM permissions p WHERE p.name = n)
+	`
+
+	rows, err := r.DB.Query(ctx, query, names)
+	if err != nil {
+		return nil, apiKeyError("APIKeyRepo.UnknownPermissions", err)
+	}
+	defer rows.Close()
+
+	unknown := []string{}
+	for rows.Next() {
+		var n string
+		if err := rows.Scan(&n); err != nil {
+			return nil, apiKeyError("APIKeyRepo.UnknownPermissions", err)
+		}
+		unknown = append(unknown, n)
+	}
+	return unknown, rows.Err()
+}
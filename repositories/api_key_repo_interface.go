@@ -0,0 +1,16 @@
+package repositories
+
+import (
+	"context"
+
+	"test123/models"
+)
+
+type APIKeyRepoInterface interface {
+	Create(ctx context.Context, k models.APIKey) (*models.APIKey, error)
+	ListByUser(ctx context.Context, userID int) ([]models.APIKey, error)
+	GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error)
+	Revoke(ctx context.Context, userID, id int) error
+	TouchLastUsed(ctx context.Context, id int) error
+	UnknownPermissions(ctx context.Context, names []string) ([]string, error)
+}
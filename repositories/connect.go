@@ -11,12 +11,13 @@ import (
 
 func Connect(ctx context.Context, cfg config.Config) (*pgxpool.Pool, error) {
 	dsn := fmt.Sprintf(
-		"postgres://%s:%s@%s:%d/%s?sslmode=disable",
+		"postgres://%s:%s@%s:%d/%s?sslmode=%s",
 		cfg.Postgres.User,
 		cfg.Postgres.Password,
 		cfg.Postgres.Host,
 		cfg.Postgres.Port,
 		cfg.Postgres.Dbname,
+		cfg.Postgres.SSLMode,
 	)
 
 	pool, err := pgxpool.New(ctx, dsn)
@@ -0,0 +1,126 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type DeadLetterRepo struct {
+	DB *pgxpool.Pool
+}
+
+func NewDeadLetterRepo(db *pgxpool.Pool) *DeadLetterRepo {
+	return &DeadLetterRepo{DB: db}
+}
+
+const deadLetterColumns = `id, event_id, original_topic, COALESCE(message_key, ''), payload, failure_reason,
+	retry_count, status, dlq_partition, dlq_offset, failed_at, created_at, resolved_at`
+
+func scanDeadLetter(row pgx.Row) (*models.DeadLetter, error) {
+	var d models.DeadLetter
+	err := row.Scan(&d.ID, &d.EventID, &d.OriginalTopic, &d.Key, &d.Payload, &d.FailureReason,
+		&d.RetryCount, &d.Status, &d.DLQPartition, &d.DLQOffset, &d.FailedAt, &d.CreatedAt, &d.ResolvedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// Archive stores a message read from the dead-letter topic. Re-reading the
+// same partition/offset after a crash is a no-op.
+func (r *DeadLetterRepo) Archive(ctx context.Context, d models.DeadLetter) error {
+	query := `
+		INSERT INTO dead_letters (event_id, original_topic, message_key, payload, failure_reason,
+		                          retry_count, dlq_partition, dlq_offset, failed_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (dlq_partition, dlq_offset) DO NOTHING
+	`
+
+	_, err := r.DB.Exec(ctx, query, d.EventID, d.OriginalTopic, d.Key, d.Payload, d.FailureReason,
+		d.RetryCount, d.DLQPartition, d.DLQOffset, d.FailedAt)
+	if err != nil {
+		logger.Error("DeadLetterRepo.Archive", "db insert failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	return nil
+}
+
+// List returns dead letters newest first. cursor is the last ID of the
+// previous page, zero for the first page; an empty status matches any.
+func (r *DeadLetterRepo) List(ctx context.Context, status string, cursor int64, limit int) ([]models.DeadLetter, error) {
+	query := `
+		SELECT ` + deadLetterColumns + `
+		FROM dead_letters
+		WHERE ($1 = '' OR status = $1)
+		  AND ($2 = 0 OR id < $2)
+		ORDER BY id DESC
+		LIMIT $3
+	`
+
+	rows, err := r.DB.Query(ctx, query, status, cursor, limit)
+	if err != nil {
+		logger.Error("DeadLetterRepo.List", "db query failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return nil, fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	defer rows.Close()
+
+	var list []models.DeadLetter
+	for rows.Next() {
+		d, err := scanDeadLetter(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+		}
+		list = append(list, *d)
+	}
+
+	return list, rows.Err()
+}
+
+func (r *DeadLetterRepo) GetByID(ctx context.Context, id int64) (*models.DeadLetter, error) {
+	d, err := scanDeadLetter(r.DB.QueryRow(ctx, `SELECT `+deadLetterColumns+` FROM dead_letters WHERE id = $1`, id))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, errors.ErrResourceNotFound
+		}
+		logger.Error("DeadLetterRepo.GetByID", "db error", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return nil, fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	return d, nil
+}
+
+// Resolve moves a dead letter out of the 'dead' state. It fails with
+// ErrAlreadyProcessed when the row was already replayed or discarded.
+func (r *DeadLetterRepo) Resolve(ctx context.Context, db DBTX, id int64, status string) error {
+	if db == nil {
+		db = r.DB
+	}
+
+	val, err := db.Exec(ctx,
+		`UPDATE dead_letters SET status = $2, resolved_at = now() WHERE id = $1 AND status = 'dead'`,
+		id, status,
+	)
+	if err != nil {
+		logger.Error("DeadLetterRepo.Resolve", "db update failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+
+	if val.RowsAffected() == 0 {
+		return errors.ErrAlreadyProcessed
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package repositories
+
+import (
+	"context"
+	"test123/models"
+)
+
+type DeadLetterRepoInterface interface {
+	Archive(ctx context.Context, d models.DeadLetter) error
+	List(ctx context.Context, status string, cursor int64, limit int) ([]models.DeadLetter, error)
+	GetByID(ctx context.Context, id int64) (*models.DeadLetter, error)
+	Resolve(ctx context.Context, db DBTX, id int64, status string) error
+}
@@ -0,0 +1,160 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type DeliveryRepo struct {
+	DB *pgxpool.Pool
+}
+
+func NewDeliveryRepo(db *pgxpool.Pool) *DeliveryRepo {
+	return &DeliveryRepo{DB: db}
+}
+
+// deliveryClaimLease is how long an attempt owns its 'pending' row. It
+// must outlast the slowest channel send; past it the attempt is presumed
+// crashed and another consumer may take over.
+const deliveryClaimLease = 5 * time.Minute
+
+// Begin claims a delivery attempt and reports whether the caller should
+// send. It returns false when the event was already sent, or deliberately
+// skipped, on that channel, and ErrDuplicateRequest while another attempt
+// holds the claim, so the event is retried later instead of sent twice.
+func (r *DeliveryRepo) Begin(ctx context.Context, d models.NotificationDelivery) (bool, error) {
+	query := `
+		INSERT INTO notification_deliveries (event_id, channel, user_id, action, status, attempts, claimed_at)
+		VALUES ($1, $2, $3, $4, 'pending', 1, now())
+		ON CONFLICT (event_id, channel) DO UPDATE
+		SET status = 'pending', attempts = notification_deliveries.attempts + 1, claimed_at = now(), updated_at = now()
+		WHERE notification_deliveries.status = 'failed'
+		   OR (notification_deliveries.status = 'pending' AND notification_deliveries.claimed_at <= now() - $5::interval)
+		RETURNING attempts
+	`
+
+	var attempts int
+	err := r.DB.QueryRow(ctx, query, d.EventID, d.Channel, d.UserID, d.Action, deliveryClaimLease.String()).Scan(&attempts)
+	if err == pgx.ErrNoRows {
+		return r.claimRefused(ctx, d)
+	}
+	if err != nil {
+		logger.Error("DeliveryRepo.Begin", "db upsert failed", map[string]interface{}{
+			"event_id": d.EventID,
+			"error":    err.Error(),
+		})
+		return false, fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+
+	return true, nil
+}
+
+// claimRefused tells a finished delivery from one still in flight.
+func (r *DeliveryRepo) claimRefused(ctx context.Context, d models.NotificationDelivery) (bool, error) {
+	var status string
+	err := r.DB.QueryRow(ctx, `
+		SELECT status FROM notification_deliveries WHERE event_id = $1 AND channel = $2
+	`, d.EventID, d.Channel).Scan(&status)
+	if err != nil {
+		logger.Error("DeliveryRepo.Begin", "db select failed", map[string]interface{}{
+			"event_id": d.EventID,
+			"error":    err.Error(),
+		})
+		return false, fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	if status == models.DeliveryStatusPending {
+		return false, fmt.Errorf("%w: delivery of event %s on %s is in progress", errors.ErrDuplicateRequest, d.EventID, d.Channel)
+	}
+	return false, nil
+}
+
+func (r *DeliveryRepo) MarkSent(ctx context.Context, eventID uuid.UUID, channel string) error {
+	_, err := r.DB.Exec(ctx, `
+		UPDATE notification_deliveries
+		SET status = 'sent', sent_at = now(), updated_at = now(), last_error = NULL
+		WHERE event_id = $1 AND channel = $2
+	`, eventID, channel)
+	if err != nil {
+		logger.Error("DeliveryRepo.MarkSent", "db update failed", map[string]interface{}{
+			"event_id": eventID,
+			"error":    err.Error(),
+		})
+		return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	return nil
+}
+
+func (r *DeliveryRepo) MarkFailed(ctx context.Context, eventID uuid.UUID, channel string, lastErr string) error {
+	_, err := r.DB.Exec(ctx, `
+		UPDATE notification_deliveries
+		SET status = 'failed', last_error = $3, updated_at = now()
+		WHERE event_id = $1 AND channel = $2
+	`, eventID, channel, lastErr)
+	if err != nil {
+		logger.Error("DeliveryRepo.MarkFailed", "db update failed", map[string]interface{}{
+			"event_id": eventID,
+			"error":    err.Error(),
+		})
+		return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	return nil
+}
+
+func (r *DeliveryRepo) MarkSkipped(ctx context.Context, eventID uuid.UUID, channel string, reason string) error {
+	_, err := r.DB.Exec(ctx, `
+		UPDATE notification_deliveries
+		SET status = 'skipped', skip_reason = $3, updated_at = now()
+		WHERE event_id = $1 AND channel = $2
+	`, eventID, channel, reason)
+	if err != nil {
+		logger.Error("DeliveryRepo.MarkSkipped", "db update failed", map[string]interface{}{
+			"event_id": eventID,
+			"error":    err.Error(),
+		})
+		return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	return nil
+}
+
+func (r *DeliveryRepo) ListByEventID(ctx context.Context, eventID uuid.UUID) ([]models.NotificationDelivery, error) {
+	rows, err := r.DB.Query(ctx, `
+		SELECT event_id, channel, user_id, action, status, attempts, COALESCE(last_error, ''),
+		       COALESCE(skip_reason, ''), created_at, updated_at, sent_at
+		FROM notification_deliveries
+		WHERE event_id = $1
+		ORDER BY channel
+	`, eventID)
+	if err != nil {
+		logger.Error("DeliveryRepo.ListByEventID", "db query failed", map[string]interface{}{
+			"event_id": eventID,
+			"error":    err.Error(),
+		})
+		return nil, fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	defer rows.Close()
+
+	var list []models.NotificationDelivery
+	for rows.Next() {
+		var d models.NotificationDelivery
+		if err := rows.Scan(&d.EventID, &d.Channel, &d.UserID, &d.Action, &d.Status, &d.Attempts,
+			&d.LastError, &d.SkipReason, &d.CreatedAt, &d.UpdatedAt, &d.SentAt); err != nil {
+			return nil, fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+		}
+		list = append(list, d)
+	}
+
+	if len(list) == 0 {
+		return nil, errors.ErrResourceNotFound
+	}
+
+	return list, rows.Err()
+}
@@ -0,0 +1,16 @@
+package repositories
+
+import (
+	"context"
+	"test123/models"
+
+	"github.com/google/uuid"
+)
+
+type DeliveryRepoInterface interface {
+	Begin(ctx context.Context, d models.NotificationDelivery) (bool, error)
+	MarkSent(ctx context.Context, eventID uuid.UUID, channel string) error
+	MarkFailed(ctx context.Context, eventID uuid.UUID, channel string, lastErr string) error
+	MarkSkipped(ctx context.Context, eventID uuid.UUID, channel string, reason string) error
+	ListByEventID(ctx context.Context, eventID uuid.UUID) ([]models.NotificationDelivery, error)
+}
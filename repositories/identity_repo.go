@@ -0,0 +1,107 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type IdentityRepo struct {
+	DB *pgxpool.Pool
+}
+
+func NewIdentityRepo(db *pgxpool.Pool) *IdentityRepo {
+	return &IdentityRepo{DB: db}
+}
+
+const identityColumns = `id, user_id, provider, subject, email, created_at, last_login_at`
+
+func scanIdentity(row pgx.Row) (*models.UserIdentity, error) {
+	var i models.UserIdentity
+	if err := row.Scan(&i.ID, &i.UserID, &i.Provider, &i.Subject, &i.Email, &i.CreatedAt, &i.LastLoginAt); err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+// identityError maps driver errors onto the repo's error values. A
+// duplicate means the upstream account, or this provider for the user,
+// is already linked.
+func identityError(method string, err error) error {
+	if err == pgx.ErrNoRows {
+		return errors.ErrResourceNotFound
+	}
+	if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
+		return errors.ErrDuplicateRequest
+	}
+	logger.Error(method, "db error", map[string]interface{}{
+		"error": err.Error(),
+	})
+	return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+}
+
+func (r *IdentityRepo) Create(ctx context.Context, i models.UserIdentity) (*models.UserIdentity, error) {
+	query := `
+		INSERT INTO user_identities (user_id, provider, subject, email, last_login_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING ` + identityColumns
+
+	created, err := scanIdentity(r.DB.QueryRow(ctx, query, i.UserID, i.Provider, i.Subject, i.Email, i.LastLoginAt))
+	if err != nil {
+		return nil, identityError("IdentityRepo.Create", err)
+	}
+	return created, nil
+}
+
+func (r *IdentityRepo) GetBySubject(ctx context.Context, provider, subject string) (*models.UserIdentity, error) {
+	query := `SELECT ` + identityColumns + ` FROM user_identities WHERE provider = $1 AND subject = $2`
+
+	i, err := scanIdentity(r.DB.QueryRow(ctx, query, provider, subject))
+	if err != nil {
+		return nil, identityError("IdentityRepo.GetBySubject", err)
+	}
+	return i, nil
+}
+
+func (r *IdentityRepo) ListByUser(ctx context.Context, userID int) ([]models.UserIdentity, error) {
+	rows, err := r.DB.Query(ctx, `SELECT `+identityColumns+` FROM user_identities WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, identityError("IdentityRepo.ListByUser", err)
+	}
+	defer rows.Close()
+
+	list := []models.UserIdentity{}
+	for rows.Next() {
+		i, err := scanIdentity(rows)
+		if err != nil {
+			return nil, identityError("IdentityRepo.ListByUser", err)
+		}
+		list = append(list, *i)
+	}
+	return list, rows.Err()
+}
+
+func (r *IdentityRepo) Delete(ctx context.Context, userID int, provider string) error {
+	val, err := r.DB.Exec(ctx, `DELETE FROM user_identities WHERE user_id = $1 AND provider = $2`, userID, provider)
+	if err != nil {
+		return identityError("IdentityRepo.Delete", err)
+	}
+	if val.RowsAffected() == 0 {
+		return errors.ErrResourceNotFound
+	}
+	return nil
+}
+
+func (r *IdentityRepo) TouchLogin(ctx context.Context, id int) error {
+	if _, err := r.DB.Exec(ctx, `UPDATE user_identities SET last_login_at = now() WHERE id = $1`, id); err != nil {
+		return identityError("IdentityRepo.TouchLogin", err)
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+package repositories
+
+import (
+	"context"
+
+	"test123/models"
+)
+
+type IdentityRepoInterface interface {
+	Create(ctx context.Context, i models.UserIdentity) (*models.UserIdentity, error)
+	GetBySubject(ctx context.Context, provider, subject string) (*models.UserIdentity, error)
+	ListByUser(ctx context.Context, userID int) ([]models.UserIdentity, error)
+	Delete(ctx context.Context, userID int, provider string) error
+	TouchLogin(ctx context.Context, id int) error
+}
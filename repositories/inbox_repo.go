@@ -0,0 +1,139 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type InboxRepo struct {
+	DB *pgxpool.Pool
+}
+
+func NewInboxRepo(db *pgxpool.Pool) *InboxRepo {
+	return &InboxRepo{DB: db}
+}
+
+const inboxColumns = `id, user_id, event_id, action, title, body, read_at, created_at`
+
+func scanInboxItem(row pgx.Row) (*models.InboxItem, error) {
+	var i models.InboxItem
+	if err := row.Scan(&i.ID, &i.UserID, &i.EventID, &i.Action, &i.Title, &i.Body, &i.ReadAt, &i.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+// Insert stores an item once per event. It returns nil, nil when the event
+// is already in the inbox.
+func (r *InboxRepo) Insert(ctx context.Context, item models.InboxItem) (*models.InboxItem, error) {
+	query := `
+		INSERT INTO notification_inbox (user_id, event_id, action, title, body)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (event_id) DO NOTHING
+		RETURNING ` + inboxColumns
+
+	created, err := scanInboxItem(r.DB.QueryRow(ctx, query, item.UserID, item.EventID, item.Action, item.Title, item.Body))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23503" {
+		// user was deleted after the event was written
+		return nil, errors.ErrUserNotFound
+	}
+	if err != nil {
+		logger.Error("InboxRepo.Insert", "db insert failed", map[string]interface{}{
+			"event_id": item.EventID,
+			"error":    err.Error(),
+		})
+		return nil, fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	return created, nil
+}
+
+// List returns a user's items newest first. cursor is the last ID of the
+// previous page, zero for the first page.
+func (r *InboxRepo) List(ctx context.Context, userID int, cursor int64, limit int, unreadOnly bool) ([]models.InboxItem, error) {
+	query := `
+		SELECT ` + inboxColumns + `
+		FROM notification_inbox
+		WHERE user_id = $1
+		  AND ($2 = 0 OR id < $2)
+		  AND (NOT $3 OR read_at IS NULL)
+		ORDER BY id DESC
+		LIMIT $4
+	`
+
+	rows, err := r.DB.Query(ctx, query, userID, cursor, unreadOnly, limit)
+	if err != nil {
+		logger.Error("InboxRepo.List", "db query failed", map[string]interface{}{
+			"user_id": userID,
+			"error":   err.Error(),
+		})
+		return nil, fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	defer rows.Close()
+
+	list := []models.InboxItem{}
+	for rows.Next() {
+		i, err := scanInboxItem(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+		}
+		list = append(list, *i)
+	}
+	return list, rows.Err()
+}
+
+func (r *InboxRepo) MarkRead(ctx context.Context, userID int, ids []int64) (int64, error) {
+	val, err := r.DB.Exec(ctx,
+		`UPDATE notification_inbox SET read_at = now() WHERE user_id = $1 AND id = ANY($2) AND read_at IS NULL`,
+		userID, ids,
+	)
+	if err != nil {
+		logger.Error("InboxRepo.MarkRead", "db update failed", map[string]interface{}{
+			"user_id": userID,
+			"error":   err.Error(),
+		})
+		return 0, fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	return val.RowsAffected(), nil
+}
+
+func (r *InboxRepo) MarkAllRead(ctx context.Context, userID int) (int64, error) {
+	val, err := r.DB.Exec(ctx,
+		`UPDATE notification_inbox SET read_at = now() WHERE user_id = $1 AND read_at IS NULL`,
+		userID,
+	)
+	if err != nil {
+		logger.Error("InboxRepo.MarkAllRead", "db update failed", map[string]interface{}{
+			"user_id": userID,
+			"error":   err.Error(),
+		})
+		return 0, fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	return val.RowsAffected(), nil
+}
+
+func (r *InboxRepo) UnreadCount(ctx context.Context, userID int) (int, error) {
+	var n int
+	err := r.DB.QueryRow(ctx,
+		`SELECT count(*) FROM notification_inbox WHERE user_id = $1 AND read_at IS NULL`,
+		userID,
+	).Scan(&n)
+	if err != nil {
+		logger.Error("InboxRepo.UnreadCount", "db query failed", map[string]interface{}{
+			"user_id": userID,
+			"error":   err.Error(),
+		})
+		return 0, fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	return n, nil
+}
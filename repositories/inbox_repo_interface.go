@@ -0,0 +1,14 @@
+package repositories
+
+import (
+	"context"
+	"test123/models"
+)
+
+type InboxRepoInterface interface {
+	Insert(ctx context.Context, item models.InboxItem) (*models.InboxItem, error)
+	List(ctx context.Context, userID int, cursor int64, limit int, unreadOnly bool) ([]models.InboxItem, error)
+	MarkRead(ctx context.Context, userID int, ids []int64) (int64, error)
+	MarkAllRead(ctx context.Context, userID int) (int64, error)
+	UnreadCount(ctx context.Context, userID int) (int, error)
+}
@@ -0,0 +1,193 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type MFARepo struct {
+	DB *pgxpool.Pool
+}
+
+func NewMFARepo(db *pgxpool.Pool) *MFARepo {
+	return &MFARepo{DB: db}
+}
+
+func (r *MFARepo) GetTOTP(ctx context.Context, userID int) (*models.UserTOTP, error) {
+	query := `
+		SELECT user_id, secret_sealed, confirmed_at, last_used_step, created_at
+		FROM user_totp WHERE user_id = $1
+	`
+
+	var t models.UserTOTP
+	err := r.DB.QueryRow(ctx, query, userID).Scan(&t.UserID, &t.SecretSealed, &t.ConfirmedAt, &t.LastUsedStep, &t.CreatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, errors.ErrResourceNotFound
+		}
+		logger.Error("MFARepo.GetTOTP", "db error", map[string]interface{}{
+			"user_id": userID,
+			"error":   err.Error(),
+		})
+		return nil, fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	return &t, nil
+}
+
+// SavePendingTOTP stores a new unconfirmed secret, replacing an earlier
+// unconfirmed one. A confirmed enrollment is left alone.
+func (r *MFARepo) SavePendingTOTP(ctx context.Context, userID int, sealed []byte) error {
+	query := `
+		INSERT INTO user_totp (user_id, secret_sealed)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret_sealed = EXCLUDED.secret_sealed, last_used_step = 0, created_at = now()
+		WHERE user_totp.confirmed_at IS NULL
+	`
+
+	val, err := r.DB.Exec(ctx, query, userID, sealed)
+	if err != nil {
+		logger.Error("MFARepo.SavePendingTOTP", "db upsert failed", map[string]interface{}{
+			"user_id": userID,
+			"error":   err.Error(),
+		})
+		return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	if val.RowsAffected() == 0 {
+		return fmt.Errorf("%w: two-factor authentication is already enabled", errors.ErrAlreadyProcessed)
+	}
+	return nil
+}
+
+func (r *MFARepo) ConfirmTOTP(ctx context.Context, db DBTX, userID int, step int64) error {
+	if db == nil {
+		db = r.DB
+	}
+
+	query := `
+		UPDATE user_totp SET confirmed_at = now(), last_used_step = $2
+		WHERE user_id = $1 AND confirmed_at IS NULL
+	`
+
+	val, err := db.Exec(ctx, query, userID, step)
+	if err != nil {
+		logger.Error("MFARepo.ConfirmTOTP", "db update failed", map[string]interface{}{
+			"user_id": userID,
+			"error":   err.Error(),
+		})
+		return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	if val.RowsAffected() == 0 {
+		return errors.ErrAlreadyProcessed
+	}
+	return nil
+}
+
+// UseTOTPStep records step as used and reports false when it, or a later
+// step, was already accepted.
+func (r *MFARepo) UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	query := `
+		UPDATE user_totp SET last_used_step = $2
+		WHERE user_id = $1 AND confirmed_at IS NOT NULL AND last_used_step < $2
+	`
+
+	val, err := r.DB.Exec(ctx, query, userID, step)
+	if err != nil {
+		logger.Error("MFARepo.UseTOTPStep", "db update failed", map[string]interface{}{
+			"user_id": userID,
+			"error":   err.Error(),
+		})
+		return false, fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	return val.RowsAffected() == 1, nil
+}
+
+// DeleteTOTP removes the enrollment together with its recovery codes.
+func (r *MFARepo) DeleteTOTP(ctx context.Context, db DBTX, userID int) error {
+	if db == nil {
+		db = r.DB
+	}
+
+	if _, err := db.Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		logger.Error("MFARepo.DeleteTOTP", "db delete failed", map[string]interface{}{
+			"user_id": userID,
+			"error":   err.Error(),
+		})
+		return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+
+	val, err := db.Exec(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		logger.Error("MFARepo.DeleteTOTP", "db delete failed", map[string]interface{}{
+			"user_id": userID,
+			"error":   err.Error(),
+		})
+		return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	if val.RowsAffected() == 0 {
+		return errors.ErrResourceNotFound
+	}
+	return nil
+}
+
+// ReplaceRecoveryCodes drops every existing code, used or not, and stores
+// the new hashes.
+func (r *MFARepo) ReplaceRecoveryCodes(ctx context.Context, db DBTX, userID int, hashes []string) error {
+	if db == nil {
+		db = r.DB
+	}
+
+	query := `
+		WITH dropped AS (DELETE FROM user_recovery_codes WHERE user_id = $1)
+		INSERT INTO user_recovery_codes (user_id, code_hash)
+		SELECT $1, unnest($2::text[])
+	`
+
+	if _, err := db.Exec(ctx, query, userID, hashes); err != nil {
+		logger.Error("MFARepo.ReplaceRecoveryCodes", "db insert failed", map[string]interface{}{
+			"user_id": userID,
+			"error":   err.Error(),
+		})
+		return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	return nil
+}
+
+// UseRecoveryCode marks the matching unused code as used and reports
+// whether there was one.
+func (r *MFARepo) UseRecoveryCode(ctx context.Context, userID int, hash string) (bool, error) {
+	query := `
+		UPDATE user_recovery_codes SET used_at = now()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`
+
+	val, err := r.DB.Exec(ctx, query, userID, hash)
+	if err != nil {
+		logger.Error("MFARepo.UseRecoveryCode", "db update failed", map[string]interface{}{
+			"user_id": userID,
+			"error":   err.Error(),
+		})
+		return false, fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	return val.RowsAffected() == 1, nil
+}
+
+func (r *MFARepo) CountRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	var n int
+	err := r.DB.QueryRow(ctx, `SELECT count(*) FROM user_recovery_codes WHERE user_id = $1 AND used_at IS NULL`, userID).Scan(&n)
+	if err != nil {
+		logger.Error("MFARepo.CountRecoveryCodes", "db error", map[string]interface{}{
+			"user_id": userID,
+			"error":   err.Error(),
+		})
+		return 0, fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	return n, nil
+}
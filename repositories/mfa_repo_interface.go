@@ -0,0 +1,18 @@
+package repositories
+
+import (
+	"context"
+
+	"test123/models"
+)
+
+type MFARepoInterface interface {
+	GetTOTP(ctx context.Context, userID int) (*models.UserTOTP, error)
+	SavePendingTOTP(ctx context.Context, userID int, sealed []byte) error
+	ConfirmTOTP(ctx context.Context, db DBTX, userID int, step int64) error
+	UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error)
+	DeleteTOTP(ctx context.Context, db DBTX, userID int) error
+	ReplaceRecoveryCodes(ctx context.Context, db DBTX, userID int, hashes []string) error
+	UseRecoveryCode(ctx context.Context, userID int, hash string) (bool, error)
+	CountRecoveryCodes(ctx context.Context, userID int) (int, error)
+}
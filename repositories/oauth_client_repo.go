@@ -0,0 +1,150 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type OAuthClientRepo struct {
+	DB *pgxpool.Pool
+}
+
+func NewOAuthClientRepo(db *pgxpool.Pool) *OAuthClientRepo {
+	return &OAuthClientRepo{DB: db}
+}
+
+const oauthClientColumns = `id, name, COALESCE(secret_hash, ''), public, redirect_uris, grant_types, scopes, created_at, updated_at,
+	post_logout_redirect_uris`
+
+func scanOAuthClient(row pgx.Row) (*models.OAuthClient, error) {
+	var c models.OAuthClient
+	if err := row.Scan(&c.ID, &c.Name, &c.SecretHash, &c.Public, &c.RedirectURIs, &c.GrantTypes, &c.Scopes,
+		&c.CreatedAt, &c.UpdatedAt, &c.PostLogoutRedirectURIs); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// oauthClientError maps driver errors onto the repo's error values.
+func oauthClientError(method string, err error) error {
+	if err == pgx.ErrNoRows {
+		return errors.ErrResourceNotFound
+	}
+	logger.Error(method, "db error", map[string]interface{}{
+		"error": err.Error(),
+	})
+	return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+}
+
+func (r *OAuthClientRepo) Create(ctx context.Context, c models.OAuthClient) (*models.OAuthClient, error) {
+	query := `
+		INSERT INTO oauth_clients (id, name, secret_hash, public, redirect_uris, grant_types, scopes, post_logout_redirect_uris)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7, $8)
+		RETURNING ` + oauthClientColumns
+
+	created, err := scanOAuthClient(r.DB.QueryRow(ctx, query, c.ID, c.Name, c.SecretHash, c.Public, c.RedirectURIs, c.GrantTypes, c.Scopes,
+		c.PostLogoutRedirectURIs))
+	if err != nil {
+		return nil, oauthClientError("OAuthClientRepo.Create", err)
+	}
+	return created, nil
+}
+
+// Update replaces the editable fields. Whether a client is public is
+// fixed at registration, as is its secret outside SetSecret.
+func (r *OAuthClientRepo) Update(ctx context.Context, c models.OAuthClient) (*models.OAuthClient, error) {
+	query := `
+		UPDATE oauth_clients
+		SET name = $2, redirect_uris = $3, grant_types = $4, scopes = $5, post_logout_redirect_uris = $6, updated_at = now()
+		WHERE id = $1
+		RETURNING ` + oauthClientColumns
+
+	updated, err := scanOAuthClient(r.DB.QueryRow(ctx, query, c.ID, c.Name, c.RedirectURIs, c.GrantTypes, c.Scopes,
+		c.PostLogoutRedirectURIs))
+	if err != nil {
+		return nil, oauthClientError("OAuthClientRepo.Update", err)
+	}
+	return updated, nil
+}
+
+func (r *OAuthClientRepo) Delete(ctx context.Context, id string) error {
+	val, err := r.DB.Exec(ctx, `DELETE FROM oauth_clients WHERE id = $1`, id)
+	if err != nil {
+		return oauthClientError("OAuthClientRepo.Delete", err)
+	}
+	if val.RowsAffected() == 0 {
+		return errors.ErrResourceNotFound
+	}
+	return nil
+}
+
+func (r *OAuthClientRepo) Get(ctx context.Context, id string) (*models.OAuthClient, error) {
+	query := `SELECT ` + oauthClientColumns + ` FROM oauth_clients WHERE id = $1`
+
+	c, err := scanOAuthClient(r.DB.QueryRow(ctx, query, id))
+	if err != nil {
+		return nil, oauthClientError("OAuthClientRepo.Get", err)
+	}
+	return c, nil
+}
+
+func (r *OAuthClientRepo) List(ctx context.Context) ([]models.OAuthClient, error) {
+	rows, err := r.DB.Query(ctx, `SELECT `+oauthClientColumns+` FROM oauth_clients ORDER BY created_at, id`)
+	if err != nil {
+		return nil, oauthClientError("OAuthClientRepo.List", err)
+	}
+	defer rows.Close()
+
+	list := []models.OAuthClient{}
+	for rows.Next() {
+		c, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, oauthClientError("OAuthClientRepo.List", err)
+		}
+		list = append(list, *c)
+	}
+	return list, rows.Err()
+}
+
+func (r *OAuthClientRepo) SetSecret(ctx context.Context, id, secretHash string) error {
+	val, err := r.DB.Exec(ctx, `UPDATE oauth_clients SET secret_hash = $2, updated_at = now() WHERE id = $1 AND NOT public`, id, secretHash)
+	if err != nil {
+		return oauthClientError("OAuthClientRepo.SetSecret", err)
+	}
+	if val.RowsAffected() == 0 {
+		return errors.ErrResourceNotFound
+	}
+	return nil
+}
+
+// UnknownPermissions returns the names that are not in the permissions
+// table, so scopes can only name real permissions.
+func (r *OAuthClientRepo) UnknownPermissions(ctx context.Context, names []string) ([]string, error) {
+	query := `
+		SELECT n FROM unnest($1::text[]) AS n
+		WHERE NOT EXISTS (SELECT 1 FROM permissions p WHERE p.name = n)
+	`
+
+	rows, err := r.DB.Query(ctx, query, names)
+	if err != nil {
+		return nil, oauthClientError("OAuthClientRepo.UnknownPermissions", err)
+	}
+	defer rows.Close()
+
+	unknown := []string{}
+	for rows.Next() {
+		var n string
+		if err := rows.Scan(&n); err != nil {
+			return nil, oauthClientError("OAuthClientRepo.UnknownPermissions", err)
+		}
+		unknown = append(unknown, n)
+	}
+	return unknown, rows.Err()
+}
@@ -0,0 +1,18 @@
+package repositories
+
+import (
+	"context"
+
+	"test123/models"
+)
+
+type OAuthClientRepoInterface interface {
+	Create(ctx context.Context, c models.OAuthClient) (*models.OAuthClient, error)
+	Update(ctx context.Context, c models.OAuthClient) (*models.OAuthClient, error)
+	Delete(ctx context.Context, id string) error
+	Get(ctx context.Context, id string) (*models.OAuthClient, error)
+	List(ctx context.Context) ([]models.OAuthClient, error)
+	SetSecret(ctx context.Context, id, secretHash string) error
+
+	UnknownPermissions(ctx context.Context, names []string) ([]string, error)
+}
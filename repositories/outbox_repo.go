@@ -0,0 +1,114 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type OutboxRepo struct {
+	DB *pgxpool.Pool
+}
+
+func NewOutboxRepo(db *pgxpool.Pool) *OutboxRepo {
+	return &OutboxRepo{DB: db}
+}
+
+// Enqueue stores msg using db, which should be the transaction of the
+// domain change the event describes. A nil db uses the pool directly.
+func (r *OutboxRepo) Enqueue(ctx context.Context, db DBTX, msg models.OutboxMessage) error {
+	if db == nil {
+		db = r.DB
+	}
+
+	query := `
+		INSERT INTO outbox (event_id, topic, message_key, payload)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), $4)
+	`
+
+	_, err := db.Exec(ctx, query, msg.EventID, msg.Topic, msg.Key, msg.Payload)
+	if err != nil {
+		logger.Error("OutboxRepo.Enqueue", "db insert failed", map[string]interface{}{
+			"event_id": msg.EventID,
+			"error":    err.Error(),
+		})
+		return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+
+	return nil
+}
+
+// ClaimPending leases up to limit due rows by pushing their next attempt
+// into the future, so concurrent relays never pick the same row and rows
+// held by a crashed relay become due again once the lease runs out.
+func (r *OutboxRepo) ClaimPending(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMessage, error) {
+	query := `
+		UPDATE outbox SET next_attempt_at = now() + $2::interval, attempts = attempts + 1
+		WHERE id IN (
+			SELECT id FROM outbox
+			WHERE status = 'pending' AND next_attempt_at <= now()
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, event_id, COALESCE(topic, ''), COALESCE(message_key, ''), payload,
+		          status, attempts, COALESCE(last_error, ''), next_attempt_at, created_at
+	`
+
+	rows, err := r.DB.Query(ctx, query, limit, lease.String())
+	if err != nil {
+		logger.Error("OutboxRepo.ClaimPending", "db query failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return nil, fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	defer rows.Close()
+
+	var msgs []models.OutboxMessage
+	for rows.Next() {
+		var m models.OutboxMessage
+		if err := rows.Scan(&m.ID, &m.EventID, &m.Topic, &m.Key, &m.Payload,
+			&m.Status, &m.Attempts, &m.LastError, &m.NextAttemptAt, &m.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+		}
+		msgs = append(msgs, m)
+	}
+
+	return msgs, rows.Err()
+}
+
+func (r *OutboxRepo) MarkSent(ctx context.Context, id int64) error {
+	_, err := r.DB.Exec(ctx,
+		`UPDATE outbox SET status = 'sent', sent_at = now(), last_error = NULL WHERE id = $1`,
+		id,
+	)
+	if err != nil {
+		logger.Error("OutboxRepo.MarkSent", "db update failed", map[string]interface{}{
+			"id":    id,
+			"error": err.Error(),
+		})
+		return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	return nil
+}
+
+func (r *OutboxRepo) MarkFailed(ctx context.Context, id int64, nextAttempt time.Time, lastErr string) error {
+	_, err := r.DB.Exec(ctx,
+		`UPDATE outbox SET next_attempt_at = $2, last_error = $3 WHERE id = $1`,
+		id, nextAttempt, lastErr,
+	)
+	if err != nil {
+		logger.Error("OutboxRepo.MarkFailed", "db update failed", map[string]interface{}{
+			"id":    id,
+			"error": err.Error(),
+		})
+		return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+package repositories
+
+import (
+	"context"
+	"test123/models"
+	"time"
+)
+
+type OutboxRepoInterface interface {
+	Enqueue(ctx context.Context, db DBTX, msg models.OutboxMessage) error
+	ClaimPending(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMessage, error)
+	MarkSent(ctx context.Context, id int64) error
+	MarkFailed(ctx context.Context, id int64, nextAttempt time.Time, lastErr string) error
+}
@@ -0,0 +1,70 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"test123/errors"
+	"test123/logger"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PasswordHistoryRepo keeps the hashes of users' previous passwords so the
+// password policy can refuse reusing them.
+type PasswordHistoryRepo struct {
+	DB *pgxpool.Pool
+}
+
+func NewPasswordHistoryRepo(db *pgxpool.Pool) *PasswordHistoryRepo {
+	return &PasswordHistoryRepo{DB: db}
+}
+
+func passwordHistoryError(method string, err error) error {
+	logger.Error(method, "db error", map[string]interface{}{
+		"error": err.Error(),
+	})
+	return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+}
+
+func (r *PasswordHistoryRepo) AddTx(ctx context.Context, db DBTX, userID int, hash string) error {
+	if _, err := db.Exec(ctx, `INSERT INTO password_history (user_id, password_hash) VALUES ($1, $2)`, userID, hash); err != nil {
+		return passwordHistoryError("PasswordHistoryRepo.AddTx", err)
+	}
+	return nil
+}
+
+// Recent returns up to limit of the user's previous password hashes,
+// newest first.
+func (r *PasswordHistoryRepo) Recent(ctx context.Context, userID, limit int) ([]string, error) {
+	rows, err := r.DB.Query(ctx, `
+		SELECT password_hash FROM password_history
+		WHERE user_id = $1 ORDER BY id DESC LIMIT $2`, userID, limit)
+	if err != nil {
+		return nil, passwordHistoryError("PasswordHistoryRepo.Recent", err)
+	}
+	defer rows.Close()
+
+	hashes := []string{}
+	for rows.Next() {
+		var h string
+		if err := rows.Scan(&h); err != nil {
+			return nil, passwordHistoryError("PasswordHistoryRepo.Recent", err)
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes, rows.Err()
+}
+
+// PruneTx deletes all but the user's newest keep entries.
+func (r *PasswordHistoryRepo) PruneTx(ctx context.Context, db DBTX, userID, keep int) error {
+	_, err := db.Exec(ctx, `
+		DELETE FROM password_history
+		WHERE user_id = $1 AND id NOT IN (
+			SELECT id FROM password_history WHERE user_id = $1 ORDER BY id DESC LIMIT $2
+		)`, userID, keep)
+	if err != nil {
+		return passwordHistoryError("PasswordHistoryRepo.PruneTx", err)
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+package repositories
+
+import "context"
+
+type PasswordHistoryRepoInterface interface {
+	AddTx(ctx context.Context, db DBTX, userID int, hash string) error
+	Recent(ctx context.Context, userID, limit int) ([]string, error)
+	PruneTx(ctx context.Context, db DBTX, userID, keep int) error
+}
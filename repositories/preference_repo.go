@@ -0,0 +1,77 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PreferenceRepo struct {
+	DB *pgxpool.Pool
+}
+
+func NewPreferenceRepo(db *pgxpool.Pool) *PreferenceRepo {
+	return &PreferenceRepo{DB: db}
+}
+
+func (r *PreferenceRepo) Get(ctx context.Context, userID int) (*models.NotificationPreferences, error) {
+	query := `
+		SELECT user_id, channels, actions, quiet_hours_start, quiet_hours_end, timezone, updated_at
+		FROM notification_preferences WHERE user_id = $1
+	`
+
+	var p models.NotificationPreferences
+	var start, end *string
+	var tz string
+
+	err := r.DB.QueryRow(ctx, query, userID).Scan(&p.UserID, &p.Channels, &p.Actions, &start, &end, &tz, &p.UpdatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, errors.ErrResourceNotFound
+		}
+		logger.Error("PreferenceRepo.Get", "db error", map[string]interface{}{
+			"user_id": userID,
+			"error":   err.Error(),
+		})
+		return nil, fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+
+	if start != nil && end != nil {
+		p.QuietHours = &models.QuietHours{Start: *start, End: *end, Timezone: tz}
+	}
+
+	return &p, nil
+}
+
+func (r *PreferenceRepo) Upsert(ctx context.Context, p models.NotificationPreferences) error {
+	var start, end *string
+	tz := "UTC"
+	if q := p.QuietHours; q != nil {
+		start, end, tz = &q.Start, &q.End, q.Timezone
+	}
+
+	query := `
+		INSERT INTO notification_preferences (user_id, channels, actions, quiet_hours_start, quiet_hours_end, timezone, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, now())
+		ON CONFLICT (user_id) DO UPDATE
+		SET channels = EXCLUDED.channels, actions = EXCLUDED.actions,
+		    quiet_hours_start = EXCLUDED.quiet_hours_start, quiet_hours_end = EXCLUDED.quiet_hours_end,
+		    timezone = EXCLUDED.timezone, updated_at = now()
+	`
+
+	_, err := r.DB.Exec(ctx, query, p.UserID, p.Channels, p.Actions, start, end, tz)
+	if err != nil {
+		logger.Error("PreferenceRepo.Upsert", "db upsert failed", map[string]interface{}{
+			"user_id": p.UserID,
+			"error":   err.Error(),
+		})
+		return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+package repositories
+
+import (
+	"context"
+	"test123/models"
+)
+
+type PreferenceRepoInterface interface {
+	Get(ctx context.Context, userID int) (*models.NotificationPreferences, error)
+	Upsert(ctx context.Context, p models.NotificationPreferences) error
+}
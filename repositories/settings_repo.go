@@ -0,0 +1,65 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"test123/errors"
+	"test123/logger"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// SettingsRepo stores JSON documents by key.
+type SettingsRepo struct {
+	DB *pgxpool.Pool
+}
+
+func NewSettingsRepo(db *pgxpool.Pool) *SettingsRepo {
+	return &SettingsRepo{DB: db}
+}
+
+// Get decodes the value stored under key into dst, or returns
+// ErrResourceNotFound when nothing is stored yet.
+func (r *SettingsRepo) Get(ctx context.Context, key string, dst interface{}) error {
+	var raw []byte
+	err := r.DB.QueryRow(ctx, `SELECT value FROM settings WHERE key = $1`, key).Scan(&raw)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return errors.ErrResourceNotFound
+		}
+		logger.Error("SettingsRepo.Get", "db error", map[string]interface{}{
+			"key":   key,
+			"error": err.Error(),
+		})
+		return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+
+	if err := json.Unmarshal(raw, dst); err != nil {
+		return fmt.Errorf("%w: setting %s: %v", errors.ErrInternalFailure, key, err)
+	}
+	return nil
+}
+
+func (r *SettingsRepo) Set(ctx context.Context, key string, value interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("%w: setting %s: %v", errors.ErrInternalFailure, key, err)
+	}
+
+	query := `
+		INSERT INTO settings (key, value, updated_at) VALUES ($1, $2, now())
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = now()
+	`
+
+	if _, err := r.DB.Exec(ctx, query, key, raw); err != nil {
+		logger.Error("SettingsRepo.Set", "db upsert failed", map[string]interface{}{
+			"key":   key,
+			"error": err.Error(),
+		})
+		return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+package repositories
+
+import "context"
+
+type SettingsRepoInterface interface {
+	Get(ctx context.Context, key string, dst interface{}) error
+	Set(ctx context.Context, key string, value interface{}) error
+}
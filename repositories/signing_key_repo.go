@@ -0,0 +1,114 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// signingKeyLockID is the advisory lock that serialises key rotation
+// across instances.
+const signingKeyLockID = 0x6a776b73 // "jwks"
+
+type SigningKeyRepo struct {
+	DB *pgxpool.Pool
+}
+
+func NewSigningKeyRepo(db *pgxpool.Pool) *SigningKeyRepo {
+	return &SigningKeyRepo{DB: db}
+}
+
+// ListUsable returns keys that can still sign or verify, newest first.
+// A nil db uses the pool directly.
+func (r *SigningKeyRepo) ListUsable(ctx context.Context, db DBTX) ([]models.SigningKey, error) {
+	if db == nil {
+		db = r.DB
+	}
+
+	query := `
+		SELECT kid, algorithm, private_key, public_key, created_at, activates_at, retires_at
+		FROM signing_keys
+		WHERE retires_at IS NULL OR retires_at > now()
+		ORDER BY activates_at DESC
+	`
+
+	rows, err := db.Query(ctx, query)
+	if err != nil {
+		logger.Error("SigningKeyRepo.ListUsable", "db query failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return nil, fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	defer rows.Close()
+
+	var keys []models.SigningKey
+	for rows.Next() {
+		var k models.SigningKey
+		if err := rows.Scan(&k.KID, &k.Algorithm, &k.PrivateKey, &k.PublicKey, &k.CreatedAt, &k.ActivatesAt, &k.RetiresAt); err != nil {
+			return nil, fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (r *SigningKeyRepo) Insert(ctx context.Context, db DBTX, k models.SigningKey) error {
+	if db == nil {
+		db = r.DB
+	}
+
+	query := `
+		INSERT INTO signing_keys (kid, algorithm, private_key, public_key, activates_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+
+	if _, err := db.Exec(ctx, query, k.KID, k.Algorithm, k.PrivateKey, k.PublicKey, k.ActivatesAt); err != nil {
+		logger.Error("SigningKeyRepo.Insert", "db insert failed", map[string]interface{}{
+			"kid":   k.KID,
+			"error": err.Error(),
+		})
+		return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	return nil
+}
+
+// RetireCurrent schedules the end of every key without a retirement date.
+func (r *SigningKeyRepo) RetireCurrent(ctx context.Context, db DBTX, retiresAt time.Time) error {
+	if db == nil {
+		db = r.DB
+	}
+
+	if _, err := db.Exec(ctx, `UPDATE signing_keys SET retires_at = $1 WHERE retires_at IS NULL`, retiresAt); err != nil {
+		logger.Error("SigningKeyRepo.RetireCurrent", "db update failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	return nil
+}
+
+func (r *SigningKeyRepo) DeleteRetired(ctx context.Context, before time.Time) (int64, error) {
+	val, err := r.DB.Exec(ctx, `DELETE FROM signing_keys WHERE retires_at < $1`, before)
+	if err != nil {
+		logger.Error("SigningKeyRepo.DeleteRetired", "db delete failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return 0, fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	return val.RowsAffected(), nil
+}
+
+// LockRotation takes a transaction-scoped advisory lock; db must be a
+// transaction.
+func (r *SigningKeyRepo) LockRotation(ctx context.Context, db DBTX) error {
+	if _, err := db.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, signingKeyLockID); err != nil {
+		return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"test123/models"
+)
+
+type SigningKeyRepoInterface interface {
+	ListUsable(ctx context.Context, db DBTX) ([]models.SigningKey, error)
+	Insert(ctx context.Context, db DBTX, k models.SigningKey) error
+	RetireCurrent(ctx context.Context, db DBTX, retiresAt time.Time) error
+	DeleteRetired(ctx context.Context, before time.Time) (int64, error)
+	LockRotation(ctx context.Context, db DBTX) error
+}
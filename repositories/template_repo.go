@@ -0,0 +1,125 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type TemplateRepo struct {
+	DB *pgxpool.Pool
+}
+
+func NewTemplateRepo(db *pgxpool.Pool) *TemplateRepo {
+	return &TemplateRepo{DB: db}
+}
+
+const templateColumns = `id, action, locale, subject, text_body, COALESCE(html_body, ''), created_at, updated_at`
+
+func scanTemplate(row pgx.Row) (*models.NotificationTemplate, error) {
+	var t models.NotificationTemplate
+	if err := row.Scan(&t.ID, &t.Action, &t.Locale, &t.Subject, &t.TextBody, &t.HTMLBody, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// templateError maps driver errors onto the repo's error values.
+func templateError(method string, err error) error {
+	if err == pgx.ErrNoRows {
+		return errors.ErrResourceNotFound
+	}
+	if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
+		return errors.ErrDuplicateRequest
+	}
+	logger.Error(method, "db error", map[string]interface{}{
+		"error": err.Error(),
+	})
+	return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+}
+
+func (r *TemplateRepo) Create(ctx context.Context, t models.NotificationTemplate) (*models.NotificationTemplate, error) {
+	query := `
+		INSERT INTO notification_templates (action, locale, subject, text_body, html_body)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+		RETURNING ` + templateColumns
+
+	created, err := scanTemplate(r.DB.QueryRow(ctx, query, t.Action, t.Locale, t.Subject, t.TextBody, t.HTMLBody))
+	if err != nil {
+		return nil, templateError("TemplateRepo.Create", err)
+	}
+	return created, nil
+}
+
+func (r *TemplateRepo) Update(ctx context.Context, t models.NotificationTemplate) (*models.NotificationTemplate, error) {
+	query := `
+		UPDATE notification_templates
+		SET action = $2, locale = $3, subject = $4, text_body = $5, html_body = NULLIF($6, ''), updated_at = now()
+		WHERE id = $1
+		RETURNING ` + templateColumns
+
+	updated, err := scanTemplate(r.DB.QueryRow(ctx, query, t.ID, t.Action, t.Locale, t.Subject, t.TextBody, t.HTMLBody))
+	if err != nil {
+		return nil, templateError("TemplateRepo.Update", err)
+	}
+	return updated, nil
+}
+
+func (r *TemplateRepo) Delete(ctx context.Context, id int) error {
+	val, err := r.DB.Exec(ctx, `DELETE FROM notification_templates WHERE id = $1`, id)
+	if err != nil {
+		return templateError("TemplateRepo.Delete", err)
+	}
+	if val.RowsAffected() == 0 {
+		return errors.ErrResourceNotFound
+	}
+	return nil
+}
+
+func (r *TemplateRepo) GetByID(ctx context.Context, id int) (*models.NotificationTemplate, error) {
+	t, err := scanTemplate(r.DB.QueryRow(ctx, `SELECT `+templateColumns+` FROM notification_templates WHERE id = $1`, id))
+	if err != nil {
+		return nil, templateError("TemplateRepo.GetByID", err)
+	}
+	return t, nil
+}
+
+func (r *TemplateRepo) Find(ctx context.Context, action, locale string) (*models.NotificationTemplate, error) {
+	t, err := scanTemplate(r.DB.QueryRow(ctx,
+		`SELECT `+templateColumns+` FROM notification_templates WHERE action = $1 AND locale = $2`,
+		action, locale,
+	))
+	if err != nil {
+		return nil, templateError("TemplateRepo.Find", err)
+	}
+	return t, nil
+}
+
+// List returns all templates, or only those of one action when action is set.
+func (r *TemplateRepo) List(ctx context.Context, action string) ([]models.NotificationTemplate, error) {
+	rows, err := r.DB.Query(ctx,
+		`SELECT `+templateColumns+` FROM notification_templates WHERE ($1 = '' OR action = $1) ORDER BY action, locale`,
+		action,
+	)
+	if err != nil {
+		return nil, templateError("TemplateRepo.List", err)
+	}
+	defer rows.Close()
+
+	var list []models.NotificationTemplate
+	for rows.Next() {
+		t, err := scanTemplate(rows)
+		if err != nil {
+			return nil, templateError("TemplateRepo.List", err)
+		}
+		list = append(list, *t)
+	}
+	return list, rows.Err()
+}
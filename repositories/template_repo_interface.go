@@ -0,0 +1,15 @@
+package repositories
+
+import (
+	"context"
+	"test123/models"
+)
+
+type TemplateRepoInterface interface {
+	Create(ctx context.Context, t models.NotificationTemplate) (*models.NotificationTemplate, error)
+	Update(ctx context.Context, t models.NotificationTemplate) (*models.NotificationTemplate, error)
+	Delete(ctx context.Context, id int) error
+	GetByID(ctx context.Context, id int) (*models.NotificationTemplate, error)
+	Find(ctx context.Context, action, locale string) (*models.NotificationTemplate, error)
+	List(ctx context.Context, action string) ([]models.NotificationTemplate, error)
+}
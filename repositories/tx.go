@@ -0,0 +1,49 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DBTX is satisfied by both *pgxpool.Pool and pgx.Tx so repository methods
+// can run either standalone or inside a caller's transaction.
+type DBTX interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+type Transactor interface {
+	WithTx(ctx context.Context, fn func(tx pgx.Tx) error) error
+}
+
+type TxManager struct {
+	DB *pgxpool.Pool
+}
+
+func NewTxManager(db *pgxpool.Pool) *TxManager {
+	return &TxManager{DB: db}
+}
+
+// WithTx runs fn in a transaction, committing when it returns nil and
+// rolling back otherwise.
+func (m *TxManager) WithTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
@@ -26,6 +26,13 @@ func NewUserRepo(db *pgxpool.Pool) *UserRepo {
 //
 
 func (r *UserRepo) CreateUser(ctx context.Context, user models.User) error {
+	_, err := r.CreateUserTx(ctx, r.DB, user)
+	return err
+}
+
+// CreateUserTx inserts the user using db, so it can take part in a wider
+// transaction, and returns the new user's ID.
+func (r *UserRepo) CreateUserTx(ctx context.Context, db DBTX, user models.User) (int, error) {
 	logger.Info("UserRepo.CreateUser", "creating user", map[string]interface{}{
 		"email":    user.Email,
 		"username": user.Username,
@@ -39,7 +46,7 @@ func (r *UserRepo) CreateUser(ctx context.Context, user models.User) error {
 			"email":    user.Email,
 			"username": user.Username,
 		})
-		return errors.ErrUserExists
+		return 0, errors.ErrUserExists
 	}
 
 	loc, _ := time.LoadLocation("Asia/Kolkata")
@@ -48,31 +55,75 @@ func (r *UserRepo) CreateUser(ctx context.Context, user models.User) error {
 	query := `
 		INSERT INTO users (name, email, username, password, mobile_number, created_at)
 		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
 	`
 
-	_, err := r.DB.Exec(ctx, query,
+	var id int
+	err := db.QueryRow(ctx, query,
 		user.Name,
 		user.Email,
 		user.Username,
 		user.Password,
 		user.MobileNumber,
 		user.CreatedAt,
-	)
+	).Scan(&id)
 
 	if err != nil {
 		logger.Error("UserRepo.CreateUser", "db insert failed", map[string]interface{}{
 			"error": err.Error(),
 		})
-		return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+		return 0, fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
 	}
 
 	logger.Info("UserRepo.CreateUser", "user created successfully", map[string]interface{}{
 		"email": user.Email,
+		"id":    id,
 	})
 
+	return id, nil
+}
+
+// MarkServiceAccountTx flags the user as a service account, which signs in
+// with API keys only.
+func (r *UserRepo) MarkServiceAccountTx(ctx context.Context, db DBTX, id int) error {
+	val, err := db.Exec(ctx, `UPDATE users SET service_account = true WHERE id = $1`, id)
+	if err != nil {
+		logger.Error("UserRepo.MarkServiceAccountTx", "db update failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	if val.RowsAffected() == 0 {
+		return errors.ErrUserNotFound
+	}
 	return nil
 }
 
+// ListServiceAccounts returns every service account, oldest first.
+func (r *UserRepo) ListServiceAccounts(ctx context.Context) ([]models.User, error) {
+	rows, err := r.DB.Query(ctx, `
+		SELECT id, name, email, username, created_at
+		FROM users WHERE service_account
+		ORDER BY id`)
+	if err != nil {
+		logger.Error("UserRepo.ListServiceAccounts", "db query failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return nil, fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		u := models.User{ServiceAccount: true}
+		if err := rows.Scan(&u.ID, &u.Name, &u.Email, &u.Username, &u.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
 //
 // ─────────────────────────────────────────── GET ALL USERS ─────
 //
@@ -129,14 +180,14 @@ func (r *UserRepo) GetUserByID(ctx context.Context, id int) (*models.User, error
 	})
 
 	query := `
-		SELECT id, name, email, username, mobile_number, created_at
+		SELECT id, name, email, username, mobile_number, created_at, email_verified_at, COALESCE(pending_email, ''), mobile_verified_at, service_account, password_changed_at
 		FROM users WHERE id = $1
 	`
 
 	var u models.User
 
 	err := r.DB.QueryRow(ctx, query, id).Scan(
-		&u.ID, &u.Name, &u.Email, &u.Username, &u.MobileNumber, &u.CreatedAt,
+		&u.ID, &u.Name, &u.Email, &u.Username, &u.MobileNumber, &u.CreatedAt, &u.EmailVerifiedAt, &u.PendingEmail, &u.MobileVerifiedAt, &u.ServiceAccount, &u.PasswordChangedAt,
 	)
 
 	if err != nil {
@@ -163,14 +214,14 @@ func (r *UserRepo) GetUserByEmailOrUsername(ctx context.Context, key string) (*m
 	})
 
 	query := `
-		SELECT id, name, email, username, password, mobile_number
+		SELECT id, name, email, username, password, mobile_number, email_verified_at, COALESCE(pending_email, ''), mobile_verified_at, service_account, password_changed_at
 		FROM users WHERE email=$1 OR username=$1
 	`
 
 	var u models.User
 
 	err := r.DB.QueryRow(ctx, query, key).Scan(
-		&u.ID, &u.Name, &u.Email, &u.Username, &u.Password, &u.MobileNumber,
+		&u.ID, &u.Name, &u.Email, &u.Username, &u.Password, &u.MobileNumber, &u.EmailVerifiedAt, &u.PendingEmail, &u.MobileVerifiedAt, &u.ServiceAccount, &u.PasswordChangedAt,
 	)
 
 	if err != nil {
@@ -191,17 +242,22 @@ func (r *UserRepo) GetUserByEmailOrUsername(ctx context.Context, key string) (*m
 //
 
 func (r *UserRepo) UpdateUser(ctx context.Context, user models.User) error {
+	return r.UpdateUserTx(ctx, r.DB, user)
+}
+
+func (r *UserRepo) UpdateUserTx(ctx context.Context, db DBTX, user models.User) error {
 	logger.Info("UserRepo.UpdateUser", "updating user", map[string]interface{}{
 		"id": user.ID,
 	})
 
 	query := `
 		UPDATE users 
-		SET name=$1, email=$2, username=$3, mobile_number=$4
+		SET name=$1, email=$2, username=$3, mobile_number=$4,
+		    mobile_verified_at = CASE WHEN mobile_number IS DISTINCT FROM $4 THEN NULL ELSE mobile_verified_at END
 		WHERE id=$5
 	`
 
-	val, err := r.DB.Exec(ctx, query,
+	val, err := db.Exec(ctx, query,
 		user.Name, user.Email, user.Username, user.MobileNumber, user.ID,
 	)
 	if err != nil {
@@ -226,13 +282,17 @@ func (r *UserRepo) UpdateUser(ctx context.Context, user models.User) error {
 //
 
 func (r *UserRepo) UpdatePassword(ctx context.Context, username string, password string) error {
+	return r.UpdatePasswordTx(ctx, r.DB, username, password)
+}
+
+func (r *UserRepo) UpdatePasswordTx(ctx context.Context, db DBTX, username string, password string) error {
 	logger.Info("UserRepo.UpdatePassword", "updating password", map[string]interface{}{
 		"username": username,
 	})
 
 	query := `UPDATE users SET password=$1 WHERE username=$2`
 
-	val, err := r.DB.Exec(ctx, query, password, username)
+	val, err := db.Exec(ctx, query, password, username)
 	if err != nil {
 		logger.Error("UserRepo.UpdatePassword", "update failed", map[string]interface{}{
 			"error": err.Error(),
@@ -250,18 +310,39 @@ func (r *UserRepo) UpdatePassword(ctx context.Context, username string, password
 	return nil
 }
 
+// SetPasswordTx replaces the user's password with a newly chosen one and
+// restarts its age. Rehashing the same password goes through
+// UpdatePasswordTx instead, which leaves the age alone.
+func (r *UserRepo) SetPasswordTx(ctx context.Context, db DBTX, id int, password string) error {
+	val, err := db.Exec(ctx, `UPDATE users SET password = $1, password_changed_at = now() WHERE id = $2`, password, id)
+	if err != nil {
+		logger.Error("UserRepo.SetPasswordTx", "update failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	if val.RowsAffected() == 0 {
+		return errors.ErrUserNotFound
+	}
+	return nil
+}
+
 //
 // ─────────────────────────────────────────── DELETE USER ─────
 //
 
 func (r *UserRepo) DeleteUser(ctx context.Context, id int) error {
+	return r.DeleteUserTx(ctx, r.DB, id)
+}
+
+func (r *UserRepo) DeleteUserTx(ctx context.Context, db DBTX, id int) error {
 	logger.Warn("UserRepo.DeleteUser", "deleting user", map[string]interface{}{
 		"id": id,
 	})
 
 	query := `DELETE FROM users WHERE id = $1`
 
-	val, err := r.DB.Exec(ctx, query, id)
+	val, err := db.Exec(ctx, query, id)
 	if err != nil {
 		logger.Error("UserRepo.DeleteUser", "db error", map[string]interface{}{
 			"error": err.Error(),
@@ -279,6 +360,126 @@ func (r *UserRepo) DeleteUser(ctx context.Context, id int) error {
 	return nil
 }
 
+//
+// ─────────────────────────────────────────── EMAIL VERIFICATION ─────
+//
+
+// SetPendingEmailTx records an address change awaiting confirmation; an
+// empty email clears it.
+func (r *UserRepo) SetPendingEmailTx(ctx context.Context, db DBTX, id int, email string) error {
+	if db == nil {
+		db = r.DB
+	}
+
+	val, err := db.Exec(ctx, `UPDATE users SET pending_email = NULLIF($2, '') WHERE id = $1`, id, email)
+	if err != nil {
+		logger.Error("UserRepo.SetPendingEmail", "update failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	if val.RowsAffected() == 0 {
+		return errors.ErrUserNotFound
+	}
+	return nil
+}
+
+// MarkEmailVerifiedTx confirms the user's current address, provided it is
+// still email.
+func (r *UserRepo) MarkEmailVerifiedTx(ctx context.Context, db DBTX, id int, email string) error {
+	if db == nil {
+		db = r.DB
+	}
+
+	query := `
+		UPDATE users SET email_verified_at = COALESCE(email_verified_at, now())
+		WHERE id = $1 AND email = $2
+	`
+
+	val, err := db.Exec(ctx, query, id, email)
+	if err != nil {
+		logger.Error("UserRepo.MarkEmailVerified", "update failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	if val.RowsAffected() == 0 {
+		return errors.ErrResourceNotFound
+	}
+	return nil
+}
+
+// ConfirmPendingEmailTx makes the pending address, if it is still email,
+// the user's verified address.
+func (r *UserRepo) ConfirmPendingEmailTx(ctx context.Context, db DBTX, id int, email string) error {
+	if db == nil {
+		db = r.DB
+	}
+
+	query := `
+		UPDATE users SET email = pending_email, pending_email = NULL, email_verified_at = now()
+		WHERE id = $1 AND pending_email = $2
+	`
+
+	val, err := db.Exec(ctx, query, id, email)
+	if err != nil {
+		logger.Error("UserRepo.ConfirmPendingEmail", "update failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	if val.RowsAffected() == 0 {
+		return errors.ErrResourceNotFound
+	}
+	return nil
+}
+
+// RestoreEmailTx puts back oldEmail as the verified address, as long as
+// newEmail is still the current or pending one.
+func (r *UserRepo) RestoreEmailTx(ctx context.Context, db DBTX, id int, oldEmail, newEmail string) error {
+	if db == nil {
+		db = r.DB
+	}
+
+	query := `
+		UPDATE users SET email = $2, pending_email = NULL, email_verified_at = now()
+		WHERE id = $1 AND (email = $3 OR pending_email = $3)
+	`
+
+	val, err := db.Exec(ctx, query, id, oldEmail, newEmail)
+	if err != nil {
+		logger.Error("UserRepo.RestoreEmail", "update failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	if val.RowsAffected() == 0 {
+		return errors.ErrResourceNotFound
+	}
+	return nil
+}
+
+// MarkMobileVerified confirms the user's mobile number, provided it is
+// still number.
+func (r *UserRepo) MarkMobileVerified(ctx context.Context, id int, number string) error {
+	query := `
+		UPDATE users SET mobile_verified_at = now()
+		WHERE id = $1 AND mobile_number = $2
+	`
+
+	val, err := r.DB.Exec(ctx, query, id, number)
+	if err != nil {
+		logger.Error("UserRepo.MarkMobileVerified", "update failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	if val.RowsAffected() == 0 {
+		return errors.ErrResourceNotFound
+	}
+	return nil
+}
+
 //
 // ─────────────────────────────────────────── GET USER BY EMAIL ─────
 //
@@ -289,14 +490,14 @@ func (r *UserRepo) GetUserByEmail(ctx context.Context, email string) (*models.Us
 	})
 
 	query := `
-		SELECT id, name, email, username, password, mobile_number
+		SELECT id, name, email, username, password, mobile_number, email_verified_at, COALESCE(pending_email, ''), mobile_verified_at, service_account, password_changed_at
 		FROM users WHERE email = $1
 	`
 
 	var u models.User
 
 	err := r.DB.QueryRow(ctx, query, email).Scan(
-		&u.ID, &u.Name, &u.Email, &u.Username, &u.Password, &u.MobileNumber,
+		&u.ID, &u.Name, &u.Email, &u.Username, &u.Password, &u.MobileNumber, &u.EmailVerifiedAt, &u.PendingEmail, &u.MobileVerifiedAt, &u.ServiceAccount, &u.PasswordChangedAt,
 	)
 
 	if err != nil {
@@ -333,14 +534,14 @@ func (r *UserRepo) GetUserByUsername(ctx context.Context, username string) (*mod
 	})
 
 	query := `
-		SELECT id, name, email, username, password, mobile_number
+		SELECT id, name, email, username, password, mobile_number, email_verified_at, COALESCE(pending_email, ''), mobile_verified_at, service_account, password_changed_at
 		FROM users WHERE username = $1
 	`
 
 	var u models.User
 
 	err := r.DB.QueryRow(ctx, query, username).Scan(
-		&u.ID, &u.Name, &u.Email, &u.Username, &u.Password, &u.MobileNumber,
+		&u.ID, &u.Name, &u.Email, &u.Username, &u.Password, &u.MobileNumber, &u.EmailVerifiedAt, &u.PendingEmail, &u.MobileVerifiedAt, &u.ServiceAccount, &u.PasswordChangedAt,
 	)
 
 	if err != nil {
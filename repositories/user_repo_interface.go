@@ -8,13 +8,25 @@ import (
 
 type UserRepoInterface interface {
 	CreateUser(ctx context.Context, user models.User) error
+	CreateUserTx(ctx context.Context, db DBTX, user models.User) (int, error)
 	GetAllUsers(ctx context.Context) ([]models.User, error)
 	UpdateUser(ctx context.Context, user models.User) error
+	UpdateUserTx(ctx context.Context, db DBTX, user models.User) error
 	DeleteUser(ctx context.Context, id int) error
+	DeleteUserTx(ctx context.Context, db DBTX, id int) error
 	GetUserByID(ctx context.Context, id int) (*models.User, error)
+	SetPendingEmailTx(ctx context.Context, db DBTX, id int, email string) error
+	MarkEmailVerifiedTx(ctx context.Context, db DBTX, id int, email string) error
+	ConfirmPendingEmailTx(ctx context.Context, db DBTX, id int, email string) error
+	RestoreEmailTx(ctx context.Context, db DBTX, id int, oldEmail, newEmail string) error
+	MarkMobileVerified(ctx context.Context, id int, number string) error
 	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
-	UpdatePassword(ctx context.Context, email string, password string) error
+	UpdatePassword(ctx context.Context, username string, password string) error
+	UpdatePasswordTx(ctx context.Context, db DBTX, username string, password string) error
+	SetPasswordTx(ctx context.Context, db DBTX, id int, password string) error
 	GetUserByEmailOrUsername(ctx context.Context, key string) (*models.User, error)
 	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
+	MarkServiceAccountTx(ctx context.Context, db DBTX, id int) error
+	ListServiceAccounts(ctx context.Context) ([]models.User, error)
 	GetUsersWithFiltersCursor(ctx context.Context, limit int, cursor *time.Time, usernameSearch string, fromDate, toDate *time.Time) ([]models.User, *time.Time, error)
 }
@@ -20,10 +20,14 @@ func NewUserRoleRepo(db *pgxpool.Pool) *UserRoleRepo {
 }
 
 func (r *UserRoleRepo) AddUserRole(ctx context.Context, role string, user int) error {
+	return r.AddUserRoleTx(ctx, r.DB, role, user)
+}
+
+func (r *UserRoleRepo) AddUserRoleTx(ctx context.Context, db DBTX, role string, user int) error {
 
 	//get role id from db with name frm roles
 	var roleID int
-	err := r.DB.QueryRow(ctx,
+	err := db.QueryRow(ctx,
 		`SELECT id FROM roles WHERE name = $1`,
 		role,
 	).Scan(&roleID)
@@ -36,7 +40,7 @@ func (r *UserRoleRepo) AddUserRole(ctx context.Context, role string, user int) e
 	}
 
 	//then add user_id,role_id into user_roles
-	_, err = r.DB.Exec(ctx,
+	_, err = db.Exec(ctx,
 		`INSERT INTO user_roles (user_id, role_id) VALUES ($1, $2)`,
 		user, roleID,
 	)
@@ -4,4 +4,5 @@ import "context"
 
 type UserRoleRepoInterface interface {
 	AddUserRole(ctx context.Context, role string, user int) error
+	AddUserRoleTx(ctx context.Context, db DBTX, role string, user int) error
 }
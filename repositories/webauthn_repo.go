@@ -0,0 +1,124 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type WebAuthnRepo struct {
+	DB *pgxpool.Pool
+}
+
+func NewWebAuthnRepo(db *pgxpool.Pool) *WebAuthnRepo {
+	return &WebAuthnRepo{DB: db}
+}
+
+const webauthnColumns = `id, user_id, credential_id, public_key, sign_count, transports, aaguid, name, created_at, last_used_at`
+
+func scanWebAuthnCredential(row pgx.Row) (*models.WebAuthnCredential, error) {
+	var c models.WebAuthnCredential
+	if err := row.Scan(&c.ID, &c.UserID, &c.CredentialID, &c.PublicKey, &c.SignCount, &c.Transports, &c.AAGUID, &c.Name, &c.CreatedAt, &c.LastUsedAt); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// webauthnError maps driver errors onto the repo's error values. A
+// duplicate is a credential that is already registered.
+func webauthnError(method string, err error) error {
+	if err == pgx.ErrNoRows {
+		return errors.ErrResourceNotFound
+	}
+	if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
+		return errors.ErrDuplicateRequest
+	}
+	logger.Error(method, "db error", map[string]interface{}{
+		"error": err.Error(),
+	})
+	return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+}
+
+func (r *WebAuthnRepo) Create(ctx context.Context, c models.WebAuthnCredential) (*models.WebAuthnCredential, error) {
+	if c.Transports == nil {
+		c.Transports = []string{}
+	}
+	query := `
+		INSERT INTO webauthn_credentials (user_id, credential_id, public_key, sign_count, transports, aaguid, name)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING ` + webauthnColumns
+
+	created, err := scanWebAuthnCredential(r.DB.QueryRow(ctx, query, c.UserID, c.CredentialID, c.PublicKey, c.SignCount, c.Transports, c.AAGUID, c.Name))
+	if err != nil {
+		return nil, webauthnError("WebAuthnRepo.Create", err)
+	}
+	return created, nil
+}
+
+func (r *WebAuthnRepo) ListByUser(ctx context.Context, userID int) ([]models.WebAuthnCredential, error) {
+	rows, err := r.DB.Query(ctx, `SELECT `+webauthnColumns+` FROM webauthn_credentials WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, webauthnError("WebAuthnRepo.ListByUser", err)
+	}
+	defer rows.Close()
+
+	list := []models.WebAuthnCredential{}
+	for rows.Next() {
+		c, err := scanWebAuthnCredential(rows)
+		if err != nil {
+			return nil, webauthnError("WebAuthnRepo.ListByUser", err)
+		}
+		list = append(list, *c)
+	}
+	return list, rows.Err()
+}
+
+func (r *WebAuthnRepo) GetByCredentialID(ctx context.Context, credentialID string) (*models.WebAuthnCredential, error) {
+	c, err := scanWebAuthnCredential(r.DB.QueryRow(ctx, `SELECT `+webauthnColumns+` FROM webauthn_credentials WHERE credential_id = $1`, credentialID))
+	if err != nil {
+		return nil, webauthnError("WebAuthnRepo.GetByCredentialID", err)
+	}
+	return c, nil
+}
+
+// RecordUse stores the new signature counter after a login. It only
+// succeeds while the counter is still prevCount, so two concurrent
+// assertions cannot both move it.
+func (r *WebAuthnRepo) RecordUse(ctx context.Context, id int, prevCount, signCount int64) (bool, error) {
+	val, err := r.DB.Exec(ctx, `
+		UPDATE webauthn_credentials
+		SET sign_count = $3, last_used_at = now()
+		WHERE id = $1 AND sign_count = $2`, id, prevCount, signCount)
+	if err != nil {
+		return false, webauthnError("WebAuthnRepo.RecordUse", err)
+	}
+	return val.RowsAffected() == 1, nil
+}
+
+func (r *WebAuthnRepo) Rename(ctx context.Context, userID, id int, name string) (*models.WebAuthnCredential, error) {
+	query := `UPDATE webauthn_credentials SET name = $3 WHERE id = $1 AND user_id = $2 RETURNING ` + webauthnColumns
+
+	c, err := scanWebAuthnCredential(r.DB.QueryRow(ctx, query, id, userID, name))
+	if err != nil {
+		return nil, webauthnError("WebAuthnRepo.Rename", err)
+	}
+	return c, nil
+}
+
+func (r *WebAuthnRepo) Delete(ctx context.Context, userID, id int) error {
+	val, err := r.DB.Exec(ctx, `DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return webauthnError("WebAuthnRepo.Delete", err)
+	}
+	if val.RowsAffected() == 0 {
+		return errors.ErrResourceNotFound
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+package repositories
+
+import (
+	"context"
+
+	"test123/models"
+)
+
+type WebAuthnRepoInterface interface {
+	Create(ctx context.Context, c models.WebAuthnCredential) (*models.WebAuthnCredential, error)
+	ListByUser(ctx context.Context, userID int) ([]models.WebAuthnCredential, error)
+	GetByCredentialID(ctx context.Context, credentialID string) (*models.WebAuthnCredential, error)
+	RecordUse(ctx context.Context, id int, prevCount, signCount int64) (bool, error)
+	Rename(ctx context.Context, userID, id int, name string) (*models.WebAuthnCredential, error)
+	Delete(ctx context.Context, userID, id int) error
+}
@@ -0,0 +1,325 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type WebhookRepo struct {
+	DB *pgxpool.Pool
+}
+
+func NewWebhookRepo(db *pgxpool.Pool) *WebhookRepo {
+	return &WebhookRepo{DB: db}
+}
+
+const webhookSubscriptionColumns = `id, url, events, secret, description, active, consecutive_failures,
+	disabled_at, COALESCE(disabled_reason, ''), created_at, updated_at`
+
+const webhookDeliveryColumns = `id, subscription_id, event_id, event_type, payload, status, attempts,
+	next_attempt_at, response_status, COALESCE(response_body, '') AS response_body, COALESCE(last_error, '') AS last_error,
+	duration_ms, redelivery_of, created_at, delivered_at`
+
+func scanWebhookSubscription(row pgx.Row) (*models.WebhookSubscription, error) {
+	var s models.WebhookSubscription
+	if err := row.Scan(&s.ID, &s.URL, &s.Events, &s.Secret, &s.Description, &s.Active, &s.ConsecutiveFailures,
+		&s.DisabledAt, &s.DisabledReason, &s.CreatedAt, &s.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func scanWebhookDelivery(row pgx.Row) (*models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	if err := row.Scan(&d.ID, &d.SubscriptionID, &d.EventID, &d.EventType, &d.Payload, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &d.ResponseStatus, &d.ResponseBody, &d.LastError,
+		&d.DurationMs, &d.RedeliveryOf, &d.CreatedAt, &d.DeliveredAt); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// webhookError maps driver errors onto the repo's error values.
+func webhookError(method string, err error) error {
+	if err == pgx.ErrNoRows {
+		return errors.ErrResourceNotFound
+	}
+	logger.Error(method, "db error", map[string]interface{}{
+		"error": err.Error(),
+	})
+	return fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+}
+
+//
+// ─────────────────────────────────────────── SUBSCRIPTIONS ─────
+//
+
+func (r *WebhookRepo) CreateSubscription(ctx context.Context, s models.WebhookSubscription) (*models.WebhookSubscription, error) {
+	query := `
+		INSERT INTO webhook_subscriptions (url, events, secret, description)
+		VALUES ($1, $2, $3, $4)
+		RETURNING ` + webhookSubscriptionColumns
+
+	created, err := scanWebhookSubscription(r.DB.QueryRow(ctx, query, s.URL, s.Events, s.Secret, s.Description))
+	if err != nil {
+		return nil, webhookError("WebhookRepo.CreateSubscription", err)
+	}
+	return created, nil
+}
+
+// UpdateSubscription replaces the editable fields. Re-activating a
+// disabled subscription clears its failure streak.
+func (r *WebhookRepo) UpdateSubscription(ctx context.Context, s models.WebhookSubscription) (*models.WebhookSubscription, error) {
+	query := `
+		UPDATE webhook_subscriptions
+		SET url = $2, events = $3, description = $4, active = $5,
+		    consecutive_failures = CASE WHEN $5 AND NOT active THEN 0 ELSE consecutive_failures END,
+		    disabled_at = CASE WHEN $5 THEN NULL WHEN active THEN now() ELSE disabled_at END,
+		    disabled_reason = CASE WHEN $5 THEN NULL WHEN active THEN 'disabled by admin' ELSE disabled_reason END,
+		    updated_at = now()
+		WHERE id = $1
+		RETURNING ` + webhookSubscriptionColumns
+
+	updated, err := scanWebhookSubscription(r.DB.QueryRow(ctx, query, s.ID, s.URL, s.Events, s.Description, s.Active))
+	if err != nil {
+		return nil, webhookError("WebhookRepo.UpdateSubscription", err)
+	}
+	return updated, nil
+}
+
+func (r *WebhookRepo) DeleteSubscription(ctx context.Context, id int) error {
+	val, err := r.DB.Exec(ctx, `DELETE FROM webhook_subscriptions WHERE id = $1`, id)
+	if err != nil {
+		return webhookError("WebhookRepo.DeleteSubscription", err)
+	}
+	if val.RowsAffected() == 0 {
+		return errors.ErrResourceNotFound
+	}
+	return nil
+}
+
+func (r *WebhookRepo) GetSubscription(ctx context.Context, id int) (*models.WebhookSubscription, error) {
+	query := `SELECT ` + webhookSubscriptionColumns + ` FROM webhook_subscriptions WHERE id = $1`
+
+	s, err := scanWebhookSubscription(r.DB.QueryRow(ctx, query, id))
+	if err != nil {
+		return nil, webhookError("WebhookRepo.GetSubscription", err)
+	}
+	return s, nil
+}
+
+func (r *WebhookRepo) ListSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error) {
+	rows, err := r.DB.Query(ctx, `SELECT `+webhookSubscriptionColumns+` FROM webhook_subscriptions ORDER BY id`)
+	if err != nil {
+		return nil, webhookError("WebhookRepo.ListSubscriptions", err)
+	}
+	defer rows.Close()
+
+	list := []models.WebhookSubscription{}
+	for rows.Next() {
+		s, err := scanWebhookSubscription(rows)
+		if err != nil {
+			return nil, webhookError("WebhookRepo.ListSubscriptions", err)
+		}
+		list = append(list, *s)
+	}
+	return list, rows.Err()
+}
+
+//
+// ─────────────────────────────────────────── DELIVERIES ─────
+//
+
+// Enqueue fans an event out to every active subscription that listens for
+// it, using db so the deliveries commit with the change they describe.
+// A nil db uses the pool directly.
+func (r *WebhookRepo) Enqueue(ctx context.Context, db DBTX, eventID uuid.UUID, eventType string, payload []byte) (int64, error) {
+	if db == nil {
+		db = r.DB
+	}
+
+	query := `
+		INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload)
+		SELECT id, $1, $2, $3 FROM webhook_subscriptions
+		WHERE active AND ($2 = ANY(events) OR '*' = ANY(events))
+	`
+
+	val, err := db.Exec(ctx, query, eventID, eventType, payload)
+	if err != nil {
+		logger.Error("WebhookRepo.Enqueue", "db insert failed", map[string]interface{}{
+			"event_id":   eventID,
+			"event_type": eventType,
+			"error":      err.Error(),
+		})
+		return 0, fmt.Errorf("%w: %v", errors.ErrDatabaseFailure, err)
+	}
+	return val.RowsAffected(), nil
+}
+
+// ClaimDue leases up to limit due deliveries for active subscriptions, the
+// same way OutboxRepo.ClaimPending does for the outbox.
+func (r *WebhookRepo) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDispatch, error) {
+	query := `
+		WITH claimed AS (
+			UPDATE webhook_deliveries SET next_attempt_at = now() + $2::interval, attempts = attempts + 1
+			WHERE id IN (
+				SELECT d.id FROM webhook_deliveries d
+				JOIN webhook_subscriptions s ON s.id = d.subscription_id
+				WHERE d.status = 'pending' AND d.next_attempt_at <= now() AND s.active
+				ORDER BY d.id
+				LIMIT $1
+				FOR UPDATE OF d SKIP LOCKED
+			)
+			RETURNING ` + webhookDeliveryColumns + `
+		)
+		SELECT c.*, s.url, s.secret
+		FROM claimed c JOIN webhook_subscriptions s ON s.id = c.subscription_id
+		ORDER BY c.id
+	`
+
+	rows, err := r.DB.Query(ctx, query, limit, lease.String())
+	if err != nil {
+		return nil, webhookError("WebhookRepo.ClaimDue", err)
+	}
+	defer rows.Close()
+
+	var list []models.WebhookDispatch
+	for rows.Next() {
+		var w models.WebhookDispatch
+		d := &w.Delivery
+		if err := rows.Scan(&d.ID, &d.SubscriptionID, &d.EventID, &d.EventType, &d.Payload, &d.Status, &d.Attempts,
+			&d.NextAttemptAt, &d.ResponseStatus, &d.ResponseBody, &d.LastError,
+			&d.DurationMs, &d.RedeliveryOf, &d.CreatedAt, &d.DeliveredAt,
+			&w.URL, &w.Secret); err != nil {
+			return nil, webhookError("WebhookRepo.ClaimDue", err)
+		}
+		list = append(list, w)
+	}
+	return list, rows.Err()
+}
+
+// RecordSuccess marks the delivery succeeded and resets the subscription's
+// failure streak.
+func (r *WebhookRepo) RecordSuccess(ctx context.Context, d models.WebhookDelivery, a models.WebhookAttempt) error {
+	query := `
+		WITH delivered AS (
+			UPDATE webhook_deliveries
+			SET status = 'succeeded', delivered_at = now(), response_status = $2,
+			    response_body = $3, last_error = NULL, duration_ms = $4
+			WHERE id = $1
+			RETURNING subscription_id
+		)
+		UPDATE webhook_subscriptions SET consecutive_failures = 0
+		WHERE id IN (SELECT subscription_id FROM delivered)
+	`
+
+	_, err := r.DB.Exec(ctx, query, d.ID, a.ResponseStatus, a.ResponseBody, a.Duration.Milliseconds())
+	if err != nil {
+		return webhookError("WebhookRepo.RecordSuccess", err)
+	}
+	return nil
+}
+
+// RecordFailure logs a failed attempt. A nil nextAttempt means the delivery
+// has run out of attempts. The subscription's failure streak grows by one
+// and once it reaches disableAfter the subscription is disabled; the
+// returned bool reports whether that happened on this call.
+func (r *WebhookRepo) RecordFailure(ctx context.Context, d models.WebhookDelivery, a models.WebhookAttempt, nextAttempt *time.Time, disableAfter int) (bool, error) {
+	query := `
+		WITH failed AS (
+			UPDATE webhook_deliveries
+			SET status = CASE WHEN $2::timestamptz IS NULL THEN 'failed' ELSE 'pending' END,
+			    next_attempt_at = COALESCE($2, next_attempt_at),
+			    response_status = NULLIF($3, 0), response_body = NULLIF($4, ''),
+			    last_error = $5, duration_ms = $6
+			WHERE id = $1
+			RETURNING subscription_id
+		)
+		UPDATE webhook_subscriptions s
+		SET consecutive_failures = s.consecutive_failures + 1,
+		    active = s.active AND s.consecutive_failures + 1 < $7::int,
+		    disabled_at = CASE WHEN s.active AND s.consecutive_failures + 1 >= $7::int THEN now() ELSE s.disabled_at END,
+		    disabled_reason = CASE WHEN s.active AND s.consecutive_failures + 1 >= $7::int
+		        THEN 'disabled after ' || $7::int || ' consecutive failures' ELSE s.disabled_reason END,
+		    updated_at = CASE WHEN s.active AND s.consecutive_failures + 1 >= $7::int THEN now() ELSE s.updated_at END
+		WHERE s.id IN (SELECT subscription_id FROM failed)
+		-- now() is fixed for the transaction, so only a row disabled by this
+		-- statement has disabled_at equal to it
+		RETURNING s.disabled_at IS NOT NULL AND s.disabled_at = now()
+	`
+
+	var disabled bool
+	err := r.DB.QueryRow(ctx, query, d.ID, nextAttempt, a.ResponseStatus, a.ResponseBody, a.Error,
+		a.Duration.Milliseconds(), disableAfter).Scan(&disabled)
+	if err == pgx.ErrNoRows {
+		// the subscription was deleted while the request was in flight
+		return false, nil
+	}
+	if err != nil {
+		return false, webhookError("WebhookRepo.RecordFailure", err)
+	}
+	return disabled, nil
+}
+
+func (r *WebhookRepo) ListDeliveries(ctx context.Context, subscriptionID int, status string, cursor int64, limit int) ([]models.WebhookDelivery, error) {
+	query := `
+		SELECT ` + webhookDeliveryColumns + `
+		FROM webhook_deliveries
+		WHERE subscription_id = $1
+		  AND ($2 = '' OR status = $2)
+		  AND ($3 = 0 OR id < $3)
+		ORDER BY id DESC
+		LIMIT $4
+	`
+
+	rows, err := r.DB.Query(ctx, query, subscriptionID, status, cursor, limit)
+	if err != nil {
+		return nil, webhookError("WebhookRepo.ListDeliveries", err)
+	}
+	defer rows.Close()
+
+	list := []models.WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, webhookError("WebhookRepo.ListDeliveries", err)
+		}
+		list = append(list, *d)
+	}
+	return list, rows.Err()
+}
+
+func (r *WebhookRepo) GetDelivery(ctx context.Context, subscriptionID int, id int64) (*models.WebhookDelivery, error) {
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries WHERE id = $1 AND subscription_id = $2`
+
+	d, err := scanWebhookDelivery(r.DB.QueryRow(ctx, query, id, subscriptionID))
+	if err != nil {
+		return nil, webhookError("WebhookRepo.GetDelivery", err)
+	}
+	return d, nil
+}
+
+// Redeliver queues a fresh copy of a logged delivery, leaving the original
+// row untouched so the log keeps every attempt.
+func (r *WebhookRepo) Redeliver(ctx context.Context, subscriptionID int, id int64) (*models.WebhookDelivery, error) {
+	query := `
+		INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload, redelivery_of)
+		SELECT subscription_id, event_id, event_type, payload, id
+		FROM webhook_deliveries WHERE id = $1 AND subscription_id = $2
+		RETURNING ` + webhookDeliveryColumns
+
+	d, err := scanWebhookDelivery(r.DB.QueryRow(ctx, query, id, subscriptionID))
+	if err != nil {
+		return nil, webhookError("WebhookRepo.Redeliver", err)
+	}
+	return d, nil
+}
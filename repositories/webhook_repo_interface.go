@@ -0,0 +1,27 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"test123/models"
+
+	"github.com/google/uuid"
+)
+
+type WebhookRepoInterface interface {
+	CreateSubscription(ctx context.Context, s models.WebhookSubscription) (*models.WebhookSubscription, error)
+	UpdateSubscription(ctx context.Context, s models.WebhookSubscription) (*models.WebhookSubscription, error)
+	DeleteSubscription(ctx context.Context, id int) error
+	GetSubscription(ctx context.Context, id int) (*models.WebhookSubscription, error)
+	ListSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error)
+
+	Enqueue(ctx context.Context, db DBTX, eventID uuid.UUID, eventType string, payload []byte) (int64, error)
+	ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDispatch, error)
+	RecordSuccess(ctx context.Context, d models.WebhookDelivery, a models.WebhookAttempt) error
+	RecordFailure(ctx context.Context, d models.WebhookDelivery, a models.WebhookAttempt, nextAttempt *time.Time, disableAfter int) (bool, error)
+
+	ListDeliveries(ctx context.Context, subscriptionID int, status string, cursor int64, limit int) ([]models.WebhookDelivery, error)
+	GetDelivery(ctx context.Context, subscriptionID int, id int64) (*models.WebhookDelivery, error)
+	Redeliver(ctx context.Context, subscriptionID int, id int64) (*models.WebhookDelivery, error)
+}
@@ -0,0 +1,17 @@
+package requests
+
+import "time"
+
+// APIKeyReq creates an API key. Scopes name the permissions the key may
+// use; without expires_at it lasts until revoked.
+type APIKeyReq struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// ServiceAccountReq creates a service account.
+type ServiceAccountReq struct {
+	Name     string `json:"name"`
+	Username string `json:"username"`
+}
@@ -2,5 +2,5 @@ package requests
 
 type CategoryReq struct{
 
-	Name string `json`
+	Name string `json:"name"`
 }
\ No newline at end of file
@@ -0,0 +1,7 @@
+package requests
+
+// CodeReq carries a one-time code: a TOTP or recovery code, or a code sent
+// by SMS.
+type CodeReq struct {
+	Code string `json:"code"`
+}
@@ -3,5 +3,6 @@ type LoginReq struct{
 
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Device   string `json:"device"`
 }
 
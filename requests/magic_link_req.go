@@ -0,0 +1,15 @@
+package requests
+
+// MagicLinkReq asks for a sign-in link, or a code when Mode is "otp".
+type MagicLinkReq struct {
+	Email string `json:"email"`
+	Mode  string `json:"mode"`
+}
+
+// MagicLinkConsumeReq carries either the link token or the email and code.
+type MagicLinkConsumeReq struct {
+	Token  string `json:"token"`
+	Email  string `json:"email"`
+	Code   string `json:"code"`
+	Device string `json:"device"`
+}
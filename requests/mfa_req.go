@@ -0,0 +1,8 @@
+package requests
+
+// MFALoginReq completes a login that answered with mfa_required.
+type MFALoginReq struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+	Device   string `json:"device"`
+}
@@ -0,0 +1,37 @@
+package requests
+
+// OAuthAuthorizeReq is an OAuth2 authorization request, read from the
+// query string (or the login form, which carries it along).
+type OAuthAuthorizeReq struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+}
+
+// OAuthTokenReq is the form posted to /oauth/token. Client credentials may
+// come in the form or, preferably, as HTTP Basic auth.
+type OAuthTokenReq struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	RefreshToken string
+	Scope        string
+	ClientID     string
+	ClientSecret string
+}
+
+// OAuthTokenHintReq is the form posted to /auth/introspect (RFC 7662) and
+// /auth/revoke (RFC 7009). TokenTypeHint is "access_token" or
+// "refresh_token" and only decides which kind is tried first.
+type OAuthTokenHintReq struct {
+	Token         string
+	TokenTypeHint string
+	ClientID      string
+	ClientSecret  string
+}
@@ -0,0 +1,43 @@
+package requests
+
+// Passkey ceremonies post back the PublicKeyCredential the browser returned,
+// with every binary field base64url encoded.
+
+// PasskeyRegistrationReq finishes navigator.credentials.create().
+type PasskeyRegistrationReq struct {
+	Name     string                     `json:"name"`
+	ID       string                     `json:"id"`
+	Type     string                     `json:"type"`
+	Response PasskeyAttestationResponse `json:"response"`
+}
+
+type PasskeyAttestationResponse struct {
+	ClientDataJSON    string   `json:"clientDataJSON"`
+	AttestationObject string   `json:"attestationObject"`
+	Transports        []string `json:"transports"`
+}
+
+// PasskeyOptionsReq starts a passkey login. Without a username the browser
+// offers every passkey it holds for this site.
+type PasskeyOptionsReq struct {
+	Username string `json:"username"`
+}
+
+// PasskeyLoginReq finishes navigator.credentials.get().
+type PasskeyLoginReq struct {
+	ID       string                   `json:"id"`
+	Type     string                   `json:"type"`
+	Response PasskeyAssertionResponse `json:"response"`
+	Device   string                   `json:"device"`
+}
+
+type PasskeyAssertionResponse struct {
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+	UserHandle        string `json:"userHandle"`
+}
+
+type PasskeyRenameReq struct {
+	Name string `json:"name"`
+}
@@ -0,0 +1,10 @@
+package requests
+
+// ChangePasswordReq replaces a known password. Code is the TOTP or recovery
+// code, needed when the user has two-factor authentication.
+type ChangePasswordReq struct {
+	Username        string `json:"username"`
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+	Code            string `json:"code"`
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+	"test123/utils/jwt"
+)
+
+// Access tokens are stateless, so one revoked before it expires is listed
+// under revoked_access:<jti> until it would have expired anyway. User
+// tokens also die with their session.
+const revokedAccessPrefix = "revoked_access:"
+
+// accessToken is a verified access token that has not been revoked.
+type accessToken struct {
+	Principal *models.Principal
+	JTI       string
+	IssuedAt  int64
+	ExpiresAt int64
+}
+
+// parseAccessToken verifies token and checks it was not revoked. It does
+// not check the session of user tokens; callers either Touch or Check it.
+func (s *AuthService) parseAccessToken(ctx context.Context, token string) (*accessToken, error) {
+	claims, err := s.JWT.DecodeAs(token, jwt.TokenTypeAccess)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid token", errors.ErrUnauthorized)
+	}
+
+	at := &accessToken{
+		Principal: &models.Principal{ClientID: s.JWT.FetchClaim("cid", claims)},
+		JTI:       s.JWT.FetchClaim("jti", claims),
+	}
+	if p := at.Principal; p.ClientID != "" {
+		p.Scopes = strings.Fields(s.JWT.FetchClaim("scope", claims))
+	}
+	if v, ok := claims["iat"].(float64); ok {
+		at.IssuedAt = int64(v)
+	}
+	if v, ok := claims["exp"].(float64); ok {
+		at.ExpiresAt = int64(v)
+	}
+
+	if id, ok := claims["user"].(float64); ok {
+		at.Principal.UserID = int(id)
+		at.Principal.SessionID = s.JWT.FetchClaim("sid", claims)
+		if at.Principal.SessionID == "" {
+			return nil, fmt.Errorf("%w: token has no session", errors.ErrUnauthorized)
+		}
+	} else if at.Principal.ClientID == "" {
+		return nil, fmt.Errorf("%w: token has no subject", errors.ErrUnauthorized)
+	}
+
+	if at.JTI != "" {
+		n, err := s.Redis.Exists(ctx, revokedAccessPrefix+at.JTI).Result()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errors.ErrCacheFailure, err)
+		}
+		if n > 0 {
+			return nil, fmt.Errorf("%w: token revoked", errors.ErrUnauthorized)
+		}
+	}
+	return at, nil
+}
+
+// revokeAccessToken stops at from working before it expires. Tokens
+// without a jti predate revocation and can only be ended with their
+// session.
+func (s *AuthService) revokeAccessToken(ctx context.Context, at *accessToken) error {
+	if at.JTI == "" {
+		if at.Principal.UserID == 0 {
+			return nil
+		}
+		return s.endSession(ctx, at.Principal.UserID, at.Principal.SessionID)
+	}
+
+	ttl := time.Until(time.Unix(at.ExpiresAt, 0))
+	if ttl <= 0 {
+		return nil
+	}
+	if err := s.Redis.Set(ctx, revokedAccessPrefix+at.JTI, 1, ttl).Err(); err != nil {
+		return fmt.Errorf("%w: %v", errors.ErrCacheFailure, err)
+	}
+
+	logger.Info("revokeAccessToken", "access token revoked", map[string]interface{}{
+		"user_id":   at.Principal.UserID,
+		"client_id": at.Principal.ClientID,
+		"jti":       at.JTI,
+	})
+	return nil
+}
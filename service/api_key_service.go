@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"strings"
+	"time"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+	"test123/repositories"
+)
+
+// apiKeyPrefix starts every key so they are easy to spot in code and logs
+// (and for secret scanners).
+const apiKeyPrefix = "tk_"
+
+// APIKeyService issues and checks personal API keys. A key looks like
+// tk_<prefix>_<secret>: the prefix finds the row and is safe to show, the
+// whole key is only stored as a hash and is returned once, at creation.
+type APIKeyService struct {
+	Repo  repositories.APIKeyRepoInterface
+	Users *UserService
+}
+
+func NewAPIKeyService(repo repositories.APIKeyRepoInterface, users *UserService) *APIKeyService {
+	return &APIKeyService{Repo: repo, Users: users}
+}
+
+func (s *APIKeyService) List(ctx context.Context, userID int) ([]models.APIKey, error) {
+	if userID <= 0 {
+		return nil, errors.ErrInvalidParams
+	}
+	return s.Repo.ListByUser(ctx, userID)
+}
+
+// Create issues a key for the user and returns it with the key itself.
+// Keys must name the permissions they may use; they never get more than
+// the user has, since permissions are still looked up for the user.
+func (s *APIKeyService) Create(ctx context.Context, userID int, name string, scopes []string, expiresAt *time.Time) (*models.APIKey, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, "", fmt.Errorf("%w: name is required", errors.ErrMissingField)
+	}
+	if len(scopes) == 0 {
+		return nil, "", fmt.Errorf("%w: at least one scope is required", errors.ErrMissingField)
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, "", fmt.Errorf("%w: expires_at must be in the future", errors.ErrInvalidField)
+	}
+	if _, err := s.Users.GetUserByID(ctx, userID); err != nil {
+		return nil, "", err
+	}
+
+	unknown, err := s.Repo.UnknownPermissions(ctx, scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(unknown) > 0 {
+		return nil, "", fmt.Errorf("%w: unknown scopes %s", errors.ErrInvalidField, strings.Join(unknown, ", "))
+	}
+
+	prefix, err := randomHex(6)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", errors.ErrInternalFailure, err)
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", errors.ErrInternalFailure, err)
+	}
+	key := apiKeyPrefix + prefix + "_" + secret
+
+	created, err := s.Repo.Create(ctx, models.APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    apiKeyPrefix + prefix,
+		KeyHash:   hashClientSecret(key),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	logger.Info("APIKeyService.Create", "api key issued", map[string]interface{}{
+		"user_id": userID,
+		"key_id":  created.ID,
+		"prefix":  created.Prefix,
+	})
+	return created, key, nil
+}
+
+func (s *APIKeyService) Revoke(ctx context.Context, userID, id int) error {
+	if userID <= 0 || id <= 0 {
+		return errors.ErrInvalidParams
+	}
+	if err := s.Repo.Revoke(ctx, userID, id); err != nil {
+		return err
+	}
+	logger.Info("APIKeyService.Revoke", "api key revoked", map[string]interface{}{"user_id": userID, "key_id": id})
+	return nil
+}
+
+// Authenticate looks up the key sent in a request and returns it if it is
+// genuine, unrevoked and unexpired.
+func (s *APIKeyService) Authenticate(ctx context.Context, raw string) (*models.APIKey, error) {
+	rest, ok := strings.CutPrefix(raw, apiKeyPrefix)
+	if !ok {
+		return nil, fmt.Errorf("%w: malformed api key", errors.ErrUnauthorized)
+	}
+	prefix, _, ok := strings.Cut(rest, "_")
+	if !ok || prefix == "" {
+		return nil, fmt.Errorf("%w: malformed api key", errors.ErrUnauthorized)
+	}
+
+	key, err := s.Repo.GetByPrefix(ctx, apiKeyPrefix+prefix)
+	if err != nil {
+		if err == errors.ErrResourceNotFound {
+			return nil, fmt.Errorf("%w: unknown api key", errors.ErrUnauthorized)
+		}
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashClientSecret(raw)), []byte(key.KeyHash)) != 1 {
+		logger.Warn("APIKeyService.Authenticate", "wrong api key secret", map[string]interface{}{"prefix": key.Prefix})
+		return nil, fmt.Errorf("%w: unknown api key", errors.ErrUnauthorized)
+	}
+	if !key.Active(time.Now()) {
+		return nil, fmt.Errorf("%w: api key revoked or expired", errors.ErrUnauthorized)
+	}
+
+	if err := s.Repo.TouchLastUsed(ctx, key.ID); err != nil {
+		logger.Warn("APIKeyService.Authenticate", "failed to record use", map[string]interface{}{"key_id": key.ID, "error": err.Error()})
+	}
+	return key, nil
+}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"context"
+	stderrors "errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"test123/errors"
+	"test123/models"
+	"test123/repositories"
+)
+
+// memoryAPIKeys is an in-memory api_keys table. permissions are the names
+// in the permissions table scopes are checked against.
+type memoryAPIKeys struct {
+	repositories.APIKeyRepoInterface
+
+	mu          sync.Mutex
+	keys        []*models.APIKey
+	permissions []string
+}
+
+func (r *memoryAPIKeys) Create(ctx context.Context, k models.APIKey) (*models.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k.ID = len(r.keys) + 1
+	k.CreatedAt = time.Now()
+	r.keys = append(r.keys, &k)
+	c := k
+	return &c, nil
+}
+
+func (r *memoryAPIKeys) GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, k := range r.keys {
+		if k.Prefix == prefix {
+			c := *k
+			return &c, nil
+		}
+	}
+	return nil, errors.ErrResourceNotFound
+}
+
+func (r *memoryAPIKeys) Revoke(ctx context.Context, userID, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, k := range r.keys {
+		if k.ID == id && k.UserID == userID && k.RevokedAt == nil {
+			now := time.Now()
+			k.RevokedAt = &now
+			return nil
+		}
+	}
+	return errors.ErrResourceNotFound
+}
+
+func (r *memoryAPIKeys) TouchLastUsed(ctx context.Context, id int) error {
+	return nil
+}
+
+func (r *memoryAPIKeys) UnknownPermissions(ctx context.Context, names []string) ([]string, error) {
+	var unknown []string
+	for _, n := range names {
+		if !contains(r.permissions, n) {
+			unknown = append(unknown, n)
+		}
+	}
+	return unknown, nil
+}
+
+func newAPIKeyTest() (*APIKeyService, *memoryAPIKeys) {
+	repo := &memoryAPIKeys{permissions: []string{"user.read.self", "user.read.all", "user.update.self"}}
+	users := &UserService{UserRepo: newMemoryUsers(models.User{ID: 1, Username: "robot", ServiceAccount: true})}
+	return NewAPIKeyService(repo, users), repo
+}
+
+func TestAPIKeyCreateScopes(t *testing.T) {
+	tests := []struct {
+		name    string
+		scopes  []string
+		wantErr error
+	}{
+		{"known scopes", []string{"user.read.self", "user.read.all"}, nil},
+		{"no scopes", nil, errors.ErrMissingField},
+		{"empty scope list", []string{}, errors.ErrMissingField},
+		{"unknown scope", []string{"user.read.self", "user.impersonate"}, errors.ErrInvalidField},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, repo := newAPIKeyTest()
+			created, raw, err := s.Create(context.Background(), 1, "deploy bot", tt.scopes, nil)
+			if tt.wantErr != nil {
+				if !stderrors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+				if len(repo.keys) != 0 {
+					t.Error("a refused key was stored")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			if !reflect.DeepEqual(created.Scopes, tt.scopes) {
+				t.Errorf("stored scopes %v, want %v", created.Scopes, tt.scopes)
+			}
+			if !strings.HasPrefix(raw, created.Prefix+"_") || strings.Contains(created.KeyHash, raw) {
+				t.Errorf("key %q does not match prefix %q or is stored in the clear", raw, created.Prefix)
+			}
+		})
+	}
+}
+
+func TestAPIKeyAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	past := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, s *APIKeyService, repo *memoryAPIKeys) string
+		ok    bool
+	}{
+		{"valid key", func(t *testing.T, s *APIKeyService, repo *memoryAPIKeys) string {
+			return createKey(t, s)
+		}, true},
+		{"wrong secret", func(t *testing.T, s *APIKeyService, repo *memoryAPIKeys) string {
+			raw := createKey(t, s)
+			return raw[:len(raw)-1] + "x"
+		}, false},
+		{"unknown prefix", func(t *testing.T, s *APIKeyService, repo *memoryAPIKeys) string {
+			return "tk_000000000000_" + strings.Repeat("a", 64)
+		}, false},
+		{"not an api key", func(t *testing.T, s *APIKeyService, repo *memoryAPIKeys) string {
+			return "eyJhbGciOiJFUzI1NiJ9.e30.sig"
+		}, false},
+		{"revoked", func(t *testing.T, s *APIKeyService, repo *memoryAPIKeys) string {
+			raw := createKey(t, s)
+			if err := s.Revoke(ctx, 1, 1); err != nil {
+				t.Fatalf("Revoke: %v", err)
+			}
+			return raw
+		}, false},
+		{"expired", func(t *testing.T, s *APIKeyService, repo *memoryAPIKeys) string {
+			raw := createKey(t, s)
+			repo.keys[0].ExpiresAt = &past
+			return raw
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, repo := newAPIKeyTest()
+			raw := tt.setup(t, s, repo)
+			key, err := s.Authenticate(ctx, raw)
+			if tt.ok && err != nil {
+				t.Fatalf("Authenticate: %v", err)
+			}
+			if !tt.ok && !stderrors.Is(err, errors.ErrUnauthorized) {
+				t.Fatalf("got %v %v, want unauthorized", key, err)
+			}
+		})
+	}
+}
+
+func createKey(t *testing.T, s *APIKeyService, scopes ...string) string {
+	t.Helper()
+	if len(scopes) == 0 {
+		scopes = []string{"user.read.self"}
+	}
+	_, raw, err := s.Create(context.Background(), 1, "deploy bot", scopes, nil)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	return raw
+}
+
+// TestAPIKeyPrincipalScopes checks that a request made with a key is capped
+// by the key's scopes, not by whatever its owner may do.
+func TestAPIKeyPrincipalScopes(t *testing.T) {
+	s, _ := newAPIKeyTest()
+	auth := &AuthService{APIKeys: s}
+	raw := createKey(t, s, "user.read.self", "user.update.self")
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+	}{
+		{"X-API-Key header", "X-API-Key", raw},
+		{"ApiKey authorization", "Authorization", "ApiKey " + raw},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/v1/users/1", nil)
+			r.Header.Set(tt.header, tt.value)
+
+			p, err := auth.Authorize(context.Background(), r)
+			if err != nil {
+				t.Fatalf("Authorize: %v", err)
+			}
+			if p.UserID != 1 || p.APIKeyID != 1 || p.SessionID != "" {
+				t.Errorf("principal %+v, want user 1 through key 1 without a session", p)
+			}
+
+			allows := map[string]bool{
+				"user.read.self":   true,
+				"user.update.self": true,
+				"user.read.all":    false,
+				"user.delete.self": false,
+			}
+			for permission, want := range allows {
+				if got := p.Allows(permission); got != want {
+					t.Errorf("Allows(%s) = %v, want %v", permission, got, want)
+				}
+			}
+		})
+	}
+}
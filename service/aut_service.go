@@ -4,15 +4,17 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
-	"fmt"
+	"math"
 	"net/http"
+	"net/url"
 	"strconv"
-	"time"
+	"sync"
 
-	kafka "test123/kafka/producers"
+	"test123/config"
 	"test123/logger"
+	"test123/models"
+	"test123/repositories"
 	"test123/utils"
 	"test123/utils/jwt"
 
@@ -24,19 +26,35 @@ type AuthService struct {
 	UserService *UserService
 	Redis       *redis.Client
 	JWT         *jwt.Jwt
-	prod        *kafka.KafkaNotificationProducer
+	Outbox      repositories.OutboxRepoInterface
+	Sessions    *SessionService
+	MFA         *MFAService
+	Settings    *SettingsService
+	APIKeys     *APIKeyService
+	Throttle    *LoginThrottle
+	Config      config.Auth
+	RateLimit   config.RateLimit
 }
 
-func NewAuthService(userService *UserService, redisClient *redis.Client, jwt *jwt.Jwt, producer *kafka.KafkaNotificationProducer) *AuthService {
+func NewAuthService(userService *UserService, redisClient *redis.Client, jwt *jwt.Jwt, outbox repositories.OutboxRepoInterface, sessions *SessionService, mfa *MFAService, settings *SettingsService, apiKeys *APIKeyService, throttle *LoginThrottle, cfg config.Auth, rateLimit config.RateLimit) *AuthService {
 	return &AuthService{
 		UserService: userService,
 		Redis:       redisClient,
 		JWT:         jwt,
-		prod:        producer,
+		Outbox:      outbox,
+		Sessions:    sessions,
+		MFA:         mfa,
+		Settings:    settings,
+		APIKeys:     apiKeys,
+		Throttle:    throttle,
+		Config:      cfg,
+		RateLimit:   rateLimit,
 	}
 }
 
 // GenerateResetToken generates a password reset token and sends it via email.
+// Like a failed login, the answer does not reveal whether the address is
+// registered.
 func (s *AuthService) GenerateResetToken(ctx context.Context, email string) (int, map[string]string) {
 	logger.Info("GenerateResetToken", "called", map[string]interface{}{"email": email})
 
@@ -45,60 +63,59 @@ func (s *AuthService) GenerateResetToken(ctx context.Context, email string) (int
 		return 400, map[string]string{"error": "email required"}
 	}
 
+	sent := map[string]string{"message": "if the address belongs to an account, a reset link is on its way"}
+
 	user, err := s.UserService.GetByUserByEmail(ctx, email)
 	if err != nil {
-		logger.Error("GenerateResetToken", "user not found", map[string]interface{}{"email": email})
-		return 404, map[string]string{"error": "user not found"}
+		logger.Warn("GenerateResetToken", "user not found", map[string]interface{}{"email": email})
+		return 200, sent
 	}
 
 	if existingToken, _ := s.Redis.Get(ctx, "reset:active:"+user.Username).Result(); existingToken != "" {
 		logger.Info("GenerateResetToken", "active token exists", map[string]interface{}{"username": user.Username})
-		return 400, map[string]string{"error": "A reset link is already sent. Please check your email."}
+		return 200, sent
 	}
 
 	// Generate token
 	tokenBytes := make([]byte, 32)
 	rand.Read(tokenBytes)
 	token := base64.URLEncoding.EncodeToString(tokenBytes)
-	resetURL := fmt.Sprintf("http://localhost:8083/api/v1/auth/reset-password?token=%s", token)
+	resetURL := s.Config.ResetURLBase + "?token=" + url.QueryEscape(token)
+	expiresIn := strconv.Itoa(int(s.Config.ResetTokenTTL.Minutes()))
 
 	event := utils.NewEmailNotificationEvent(
 		user.ID,
 		"Token",
 		"Password Reset",
-		"Click the link to reset your password. Token expires in 10 minutes.",
+		"Click the link to reset your password. Token expires in "+expiresIn+" minutes.",
 		user.Email,
-		map[string]string{"token": resetURL},
+		map[string]string{
+			"token":              resetURL,
+			"reset_url":          resetURL,
+			"username":           user.Username,
+			"expires_in_minutes": expiresIn,
+		},
 	)
-	event.EventID = uuid.New()
-	event.CreatedAt = time.Now()
-	event.NotificationType = "email"
-
-	eventBytes, err := json.Marshal(event)
-	if err != nil {
-		logger.Error("GenerateResetToken", "failed to serialize event", map[string]interface{}{"error": err.Error()})
-		return 500, map[string]string{"error": "failed to serialize event"}
-	}
 
-	if err := s.Redis.Set(ctx, "reset_token:"+token, user.Username, 10*time.Minute).Err(); err != nil {
+	if err := s.Redis.Set(ctx, "reset_token:"+token, user.Username, s.Config.ResetTokenTTL).Err(); err != nil {
 		logger.Error("GenerateResetToken", "failed to store reset_token in redis", map[string]interface{}{"error": err.Error()})
 		return 500, map[string]string{"error": "internal server error"}
 	}
 
-	if err := s.Redis.Set(ctx, "reset:active:"+user.Username, token, 10*time.Minute).Err(); err != nil {
+	if err := s.Redis.Set(ctx, "reset:active:"+user.Username, token, s.Config.ResetTokenTTL).Err(); err != nil {
 		logger.Error("GenerateResetToken", "failed to store active token in redis", map[string]interface{}{"error": err.Error()})
 		return 500, map[string]string{"error": "failed to store active token reference"}
 	}
 
-	s.Redis.Set(ctx, "reset:invalid:"+user.Username, 0, 10*time.Minute)
+	s.Redis.Set(ctx, "reset:invalid:"+user.Username, 0, s.Config.ResetTokenTTL)
 
-	if err := s.prod.Send(ctx, eventBytes); err != nil {
-		logger.Error("GenerateResetToken", "failed to send event to Kafka", map[string]interface{}{"error": err.Error()})
+	if err := enqueueNotification(ctx, s.Outbox, nil, event); err != nil {
+		logger.Error("GenerateResetToken", "failed to store notification event", map[string]interface{}{"error": err.Error()})
 		return 500, map[string]string{"error": "failed to send notification event"}
 	}
 
 	logger.Info("GenerateResetToken", "reset link sent successfully", map[string]interface{}{"username": user.Username, "token": token})
-	return 200, map[string]string{"message": "reset link sent successfully"}
+	return 200, sent
 }
 
 // ResetPassword validates the token and updates the user's password.
@@ -128,7 +145,12 @@ func (s *AuthService) ResetPassword(ctx context.Context, token, newPassword stri
 		return 400, map[string]string{"error": "password required"}
 	}
 
-	if err := s.UserService.UpdatePassword(ctx, username, newPassword); err != nil {
+	if err := s.UserService.ResetPassword(ctx, username, newPassword); err != nil {
+		if utils.HttpStatusFromError(err) == 400 {
+			// a password the policy refuses does not use up the link
+			logger.Warn("ResetPassword", "password rejected by policy", map[string]interface{}{"username": username})
+			return 400, map[string]string{"error": err.Error()}
+		}
 		s.Redis.Incr(ctx, key)
 		logger.Error("ResetPassword", "failed to update password", map[string]interface{}{"username": username, "error": err.Error()})
 		return 500, map[string]string{"error": "failed to update password"}
@@ -138,71 +160,351 @@ func (s *AuthService) ResetPassword(ctx context.Context, token, newPassword stri
 	s.Redis.Del(ctx, "reset_token:"+token)
 	s.Redis.Del(ctx, "reset:active:"+username)
 	s.Redis.Del(ctx, key)
-	s.Redis.Del(ctx, "access_token:"+username)
+
+	// a reset means the old password may be known to someone else
+	if user, err := s.UserService.GetUserByUsername(ctx, username); err == nil {
+		if _, err := s.Sessions.RevokeAll(ctx, user.ID, ""); err != nil {
+			logger.Warn("ResetPassword", "failed to revoke sessions", map[string]interface{}{"username": username, "error": err.Error()})
+		}
+	}
 
 	logger.Info("ResetPassword", "password reset successful", map[string]interface{}{"username": username})
 	return 200, map[string]string{"message": "password reset successful"}
 }
 
-// Login authenticates a user and returns JWT tokens.
-func (s *AuthService) Login(ctx context.Context, username, password string) (int, map[string]interface{}) {
+// VerifyEmail confirms an address from an emailed link.
+func (s *AuthService) VerifyEmail(ctx context.Context, token string) (int, map[string]string) {
+	logger.Info("VerifyEmail", "called", nil)
+
+	if token == "" {
+		return 400, map[string]string{"error": "token required"}
+	}
+	if err := s.UserService.VerifyEmail(ctx, token); err != nil {
+		logger.Error("VerifyEmail", "verification failed", map[string]interface{}{"error": err.Error()})
+		return utils.HttpStatusFromError(err), map[string]string{"error": err.Error()}
+	}
+	return 200, map[string]string{"message": "email verified"}
+}
+
+// ResendVerification mails a new verification link. The answer is the
+// same whether or not the address belongs to an account.
+func (s *AuthService) ResendVerification(ctx context.Context, email string) (int, map[string]string) {
+	logger.Info("ResendVerification", "called", map[string]interface{}{"email": email})
+
+	if email == "" {
+		return 400, map[string]string{"error": "email required"}
+	}
+
+	if user, err := s.UserService.GetByUserByEmail(ctx, email); err == nil {
+		if err := s.UserService.ResendVerification(ctx, user.ID); err != nil {
+			logger.Warn("ResendVerification", "not sent", map[string]interface{}{"user_id": user.ID, "error": err.Error()})
+		}
+	}
+	return 200, map[string]string{"message": "if the address needs verifying, a link is on its way"}
+}
+
+// UndoEmailChange restores the previous address from the link sent to it
+// and signs the account out everywhere, in case the change was hostile.
+func (s *AuthService) UndoEmailChange(ctx context.Context, token string) (int, map[string]string) {
+	logger.Info("UndoEmailChange", "called", nil)
+
+	if token == "" {
+		return 400, map[string]string{"error": "token required"}
+	}
+
+	userID, err := s.UserService.UndoEmailChange(ctx, token)
+	if err != nil {
+		logger.Error("UndoEmailChange", "undo failed", map[string]interface{}{"error": err.Error()})
+		return utils.HttpStatusFromError(err), map[string]string{"error": err.Error()}
+	}
+
+	if _, err := s.Sessions.RevokeAll(ctx, userID, ""); err != nil {
+		logger.Error("UndoEmailChange", "failed to revoke sessions", map[string]interface{}{"user_id": userID, "error": err.Error()})
+	}
+	return 200, map[string]string{"message": "email change undone, all sessions were signed out"}
+}
+
+// Login authenticates a user, opens a session for the client device and
+// returns JWT tokens bound to it.
+func (s *AuthService) Login(ctx context.Context, username, password string, client models.SessionClient) (int, map[string]interface{}) {
 	logger.Info("Login", "called", map[string]interface{}{"username": username})
 
+	user, status, body := s.checkPassword(ctx, username, password, client.IP)
+	if user == nil {
+		return status, body
+	}
+	if s.UserService.Policy.Expired(user) {
+		logger.Warn("Login", "password expired", map[string]interface{}{"username": user.Username})
+		return 403, map[string]interface{}{"error": errPasswordExpired, "password_expired": true}
+	}
+	return s.completeLogin(ctx, user, client)
+}
+
+// errPasswordExpired answers password logins once the password is older
+// than the policy's max_age. Passkey, magic link and federated logins are
+// not affected, they do not use the password.
+const errPasswordExpired = "password expired, change it at /api/v1/auth/change-password to sign in"
+
+// ChangePassword replaces a password the user knows. The current password
+// is checked like a login, throttle included, and a second factor is
+// required when the user has one. It is the way back in once a password
+// has expired, and it signs out every session.
+func (s *AuthService) ChangePassword(ctx context.Context, username, currentPassword, newPassword, code, ip string) (int, map[string]interface{}) {
+	logger.Info("ChangePassword", "called", map[string]interface{}{"username": username})
+
+	if newPassword == "" {
+		return 400, map[string]interface{}{"error": "new_password required"}
+	}
+
+	user, status, body := s.checkPassword(ctx, username, currentPassword, ip)
+	if user == nil {
+		return status, body
+	}
+
+	mfa, err := s.MFA.Enabled(ctx, user.ID)
+	if err != nil {
+		logger.Error("ChangePassword", "failed to check two-factor status", map[string]interface{}{"username": user.Username, "error": err.Error()})
+		return 500, map[string]interface{}{"error": "failed to check two-factor status"}
+	}
+	if mfa {
+		if code == "" {
+			return 401, map[string]interface{}{"error": "two-factor code required", "mfa_required": true}
+		}
+		if err := s.MFA.Verify(ctx, user.ID, code); err != nil {
+			if ferr := s.Throttle.Failure(ctx, loginAccount(user, username), ip, user); ferr != nil {
+				logger.Error("ChangePassword", "failed to record login failure", map[string]interface{}{"error": ferr.Error()})
+			}
+			return utils.HttpStatusFromError(err), map[string]interface{}{"error": err.Error()}
+		}
+	}
+
+	if err := s.UserService.ChangePassword(ctx, user, newPassword); err != nil {
+		status := utils.HttpStatusFromError(err)
+		logger.Warn("ChangePassword", "password not changed", map[string]interface{}{"username": user.Username, "error": err.Error()})
+		if status == 500 {
+			return 500, map[string]interface{}{"error": "failed to change password"}
+		}
+		return status, map[string]interface{}{"error": err.Error()}
+	}
+
+	// every session was opened with the old password
+	if _, err := s.Sessions.RevokeAll(ctx, user.ID, ""); err != nil {
+		logger.Warn("ChangePassword", "failed to revoke sessions", map[string]interface{}{"username": user.Username, "error": err.Error()})
+	}
+
+	logger.Info("ChangePassword", "password changed", map[string]interface{}{"username": user.Username})
+	return 200, map[string]interface{}{"message": "password changed, all sessions were signed out"}
+}
+
+// checkPassword is the first factor shared by every password login. It
+// returns the user, or the status and body to answer with. Unknown names,
+// service accounts and wrong passwords get the same answer, and all count
+// towards the login throttle for the name and for ip.
+func (s *AuthService) checkPassword(ctx context.Context, username, password, ip string) (*models.User, int, map[string]interface{}) {
 	if username == "" || password == "" {
 		logger.Error("Login", "username or password missing", nil)
-		return 400, map[string]interface{}{"error": "username and password required"}
+		return nil, 400, map[string]interface{}{"error": "username and password required"}
 	}
 
 	user, err := s.UserService.GetUserByEmailOrUsername(ctx, username)
 	if err != nil {
-		logger.Error("Login", "user not found", map[string]interface{}{"username": username})
-		return 404, map[string]interface{}{"error": "user not found"}
+		if utils.HttpStatusFromError(err) != 404 {
+			logger.Error("Login", "failed to look up user", map[string]interface{}{"username": username, "error": err.Error()})
+			return nil, 500, map[string]interface{}{"error": "failed to verify password"}
+		}
+		user = nil
+	}
+	account := loginAccount(user, username)
+
+	wait, err := s.Throttle.Wait(ctx, account, ip)
+	if err != nil {
+		logger.Error("Login", "failed to check login throttle", map[string]interface{}{"error": err.Error()})
+		return nil, 500, map[string]interface{}{"error": "failed to verify password"}
+	}
+	if wait > 0 {
+		logger.Warn("Login", "login throttled", map[string]interface{}{"username": username, "ip": ip, "wait": wait.String()})
+		return nil, 429, map[string]interface{}{
+			"error":       "too many failed logins, try again later",
+			"retry_after": int(math.Ceil(wait.Seconds())),
+		}
+	}
+
+	ok := false
+	if user == nil || user.ServiceAccount {
+		s.checkDecoyPassword(password)
+	} else if ok, err = s.UserService.VerifyPassword(ctx, user, password); err != nil {
+		logger.Error("Login", "failed to verify password", map[string]interface{}{"username": username, "error": err.Error()})
+		return nil, 500, map[string]interface{}{"error": "failed to verify password"}
+	}
+	if !ok {
+		if user != nil && user.ServiceAccount {
+			user = nil
+		}
+		if err := s.Throttle.Failure(ctx, account, ip, user); err != nil {
+			logger.Error("Login", "failed to record login failure", map[string]interface{}{"error": err.Error()})
+		}
+		logger.Warn("Login", "invalid credentials", map[string]interface{}{"username": username, "ip": ip})
+		return nil, 401, map[string]interface{}{"error": errInvalidLogin}
+	}
+	s.Throttle.Success(ctx, account)
+
+	rules, err := s.Settings.Auth(ctx)
+	if err != nil {
+		logger.Error("Login", "failed to load auth settings", map[string]interface{}{"error": err.Error()})
+		return nil, 500, map[string]interface{}{"error": "failed to verify password"}
+	}
+	if rules.RequireVerifiedEmail && user.EmailVerifiedAt == nil {
+		logger.Warn("Login", "email not verified", map[string]interface{}{"username": username})
+		return nil, 403, map[string]interface{}{"error": "email address not verified"}
+	}
+
+	return user, 200, nil
+}
+
+// errInvalidLogin is the one answer to a failed password login, so it does
+// not tell whether the account exists.
+const errInvalidLogin = "invalid username or password"
+
+// decoyHash is verified against when a login names nobody, so unknown
+// names take as long to reject as wrong passwords.
+var (
+	decoyHashOnce sync.Once
+	decoyHash     string
+)
+
+func (s *AuthService) checkDecoyPassword(password string) {
+	decoyHashOnce.Do(func() {
+		decoyHash, _ = s.UserService.Hasher.Hash(uuid.NewString())
+	})
+	if decoyHash != "" {
+		s.UserService.Hasher.Verify(password, decoyHash)
+	}
+}
+
+// completeLogin runs after a first factor succeeded: it asks for a second
+// factor when the user has one, and opens the session otherwise.
+func (s *AuthService) completeLogin(ctx context.Context, user *models.User, client models.SessionClient) (int, map[string]interface{}) {
+	mfa, err := s.MFA.Enabled(ctx, user.ID)
+	if err != nil {
+		logger.Error("Login", "failed to check two-factor status", map[string]interface{}{"username": user.Username, "error": err.Error()})
+		return 500, map[string]interface{}{"error": "failed to check two-factor status"}
+	}
+	if mfa {
+		challenge, err := s.issueMFAChallenge(ctx, user)
+		if err != nil {
+			logger.Error("Login", "failed to issue mfa challenge", map[string]interface{}{"username": user.Username, "error": err.Error()})
+			return 500, map[string]interface{}{"error": "failed to start two-factor login"}
+		}
+
+		logger.Info("Login", "first factor accepted, second factor required", map[string]interface{}{"username": user.Username})
+		return 200, map[string]interface{}{
+			"mfa_required": true,
+			"mfa_token":    challenge,
+			"expires_in":   int(s.Config.MFAChallengeTTL.Seconds()),
+		}
 	}
 
-	count, _ := s.Redis.Get(ctx, "attempt_key:"+username).Int()
-	if count > 5 {
-		s.Redis.Expire(ctx, "attempt_key:"+username, 10*time.Minute)
+	return s.openSession(ctx, user, client)
+}
 
-		event := utils.NewEmailNotificationEvent(
-			user.ID,
-			"security",
-			"Verify is it you",
-			"Someone tried to login to your account",
-			user.Email,
-			nil,
-		)
-		eventBytes, _ := json.Marshal(event)
-		s.prod.Send(ctx, eventBytes)
+// LoginMFA completes a two-factor login: it exchanges the challenge token
+// from Login plus a TOTP or recovery code for the token pair.
+func (s *AuthService) LoginMFA(ctx context.Context, mfaToken, code string, client models.SessionClient) (int, map[string]interface{}) {
+	logger.Info("LoginMFA", "called", nil)
 
-		return 429, map[string]interface{}{"error": "too many requests, try after 10 minutes"}
+	if mfaToken == "" || code == "" {
+		return 400, map[string]interface{}{"error": "mfa_token and code required"}
 	}
 
-	if user.Password != password {
-		s.Redis.Incr(ctx, "attempt_key:"+username)
-		logger.Error("Login", "wrong password", map[string]interface{}{"username": username})
-		return 401, map[string]interface{}{"error": "wrong password"}
+	claims, err := s.JWT.DecodeAs(mfaToken, jwt.TokenTypeMFAChallenge)
+	if err != nil {
+		return 401, map[string]interface{}{"error": "invalid or expired mfa token"}
 	}
+	userID := int(claims["user"].(float64))
+	jti := s.JWT.FetchClaim("jti", claims)
 
-	access, err := s.JWT.GenerateJWTtoken(user.ID, 15)
+	attempts, err := s.countMFAAttempt(ctx, jti)
 	if err != nil {
-		logger.Error("Login", "failed to generate access token", map[string]interface{}{"username": username, "error": err.Error()})
-		return 500, map[string]interface{}{"error": "failed to generate access token"}
+		logger.Error("LoginMFA", "failed to count attempt", map[string]interface{}{"user_id": userID, "error": err.Error()})
+		return 500, map[string]interface{}{"error": "failed to verify code"}
+	}
+	if attempts < 0 {
+		return 401, map[string]interface{}{"error": "invalid or expired mfa token"}
+	}
+	if attempts > s.Config.MFAMaxAttempts {
+		s.spendMFAChallenge(ctx, jti)
+		logger.Warn("LoginMFA", "too many invalid codes", map[string]interface{}{"user_id": userID})
+		return 429, map[string]interface{}{"error": "too many invalid codes, log in again"}
 	}
 
-	refresh, err := s.JWT.GenerateJWTtoken(user.ID, 240)
+	user, err := s.UserService.GetUserByID(ctx, userID)
+	if err != nil {
+		return 401, map[string]interface{}{"error": "invalid or expired mfa token"}
+	}
+
+	// wrong codes count towards the login throttle like wrong passwords, so
+	// a fresh challenge per guess does not lift the limit
+	account := loginAccount(user, "")
+	wait, err := s.Throttle.Wait(ctx, account, client.IP)
 	if err != nil {
-		logger.Error("Login", "failed to generate refresh token", map[string]interface{}{"username": username, "error": err.Error()})
-		return 500, map[string]interface{}{"error": "failed to generate refresh token"}
+		logger.Error("LoginMFA", "failed to check login throttle", map[string]interface{}{"error": err.Error()})
+		return 500, map[string]interface{}{"error": "failed to verify code"}
 	}
+	if wait > 0 {
+		logger.Warn("LoginMFA", "login throttled", map[string]interface{}{"username": user.Username, "ip": client.IP, "wait": wait.String()})
+		return 429, map[string]interface{}{
+			"error":       "too many failed logins, try again later",
+			"retry_after": int(math.Ceil(wait.Seconds())),
+		}
+	}
+
+	if err := s.MFA.Verify(ctx, user.ID, code); err != nil {
+		if utils.HttpStatusFromError(err) == 401 {
+			if ferr := s.Throttle.Failure(ctx, account, client.IP, user); ferr != nil {
+				logger.Error("LoginMFA", "failed to record login failure", map[string]interface{}{"error": ferr.Error()})
+			}
+			logger.Error("LoginMFA", "invalid code", map[string]interface{}{"username": user.Username})
+			return 401, map[string]interface{}{"error": "invalid code"}
+		}
+		logger.Error("LoginMFA", "failed to verify code", map[string]interface{}{"username": user.Username, "error": err.Error()})
+		return 500, map[string]interface{}{"error": "failed to verify code"}
+	}
+	s.Throttle.Success(ctx, account)
 
-	s.Redis.Set(ctx, "access_token:"+user.Username, access, 15*time.Minute)
-	s.Redis.Set(ctx, "refresh_token:"+user.Username, refresh, 4*time.Hour)
+	if ok, err := s.spendMFAChallenge(ctx, jti); err != nil || !ok {
+		return 401, map[string]interface{}{"error": "invalid or expired mfa token"}
+	}
 
-	logger.Info("Login", "login successful", map[string]interface{}{"username": username})
-	return 200, map[string]interface{}{"access_token": access, "refresh_token": refresh}
+	return s.openSession(ctx, user, client)
 }
 
-// WipeOutSession logs out the user by deleting tokens from Redis.
+// openSession creates a session for a fully authenticated user and returns
+// the Login response.
+func (s *AuthService) openSession(ctx context.Context, user *models.User, client models.SessionClient) (int, map[string]interface{}) {
+	// every login ends here, so this keeps service accounts out of
+	// passkey, magic link and federated logins too
+	if user.ServiceAccount {
+		logger.Warn("Login", "session refused for a service account", map[string]interface{}{"user_id": user.ID})
+		return 401, map[string]interface{}{"error": "service accounts sign in with API keys"}
+	}
+
+	sid := NewSessionID()
+	tokens, err := s.signTokenPair(user, sid, nil)
+	if err != nil {
+		logger.Error("Login", "failed to generate tokens", map[string]interface{}{"username": user.Username, "error": err.Error()})
+		return 500, map[string]interface{}{"error": "failed to generate tokens"}
+	}
+
+	if _, err := s.Sessions.Create(ctx, sid, user.ID, client, tokens.JTI); err != nil {
+		logger.Error("Login", "failed to create session", map[string]interface{}{"username": user.Username, "error": err.Error()})
+		return 500, map[string]interface{}{"error": "failed to create session"}
+	}
+
+	logger.Info("Login", "login successful", map[string]interface{}{"username": user.Username, "sid": sid})
+	return 200, map[string]interface{}{"access_token": tokens.Access, "refresh_token": tokens.Refresh, "session_id": sid}
+}
+
+// WipeOutSession logs out the session both tokens belong to.
 func (s *AuthService) WipeOutSession(ctx context.Context, accessToken, refreshToken string) (int, map[string]string) {
 	logger.Info("WipeOutSession", "called", nil)
 
@@ -211,40 +513,48 @@ func (s *AuthService) WipeOutSession(ctx context.Context, accessToken, refreshTo
 		return 400, map[string]string{"error": "access token and refresh token required"}
 	}
 
-	claims, err := s.JWT.Decode(accessToken)
+	claims, err := s.JWT.DecodeAs(accessToken, jwt.TokenTypeAccess)
 	if err != nil {
 		logger.Error("WipeOutSession", "invalid access token", map[string]interface{}{"error": err.Error()})
 		return 401, map[string]string{"error": "invalid access token"}
 	}
 
 	userID := int(claims["user"].(float64))
-	user, err := s.UserService.GetUserByID(ctx, userID)
-	if err != nil {
-		logger.Error("WipeOutSession", "user not found", map[string]interface{}{"userID": userID})
-		return 404, map[string]string{"error": "user not found"}
-	}
+	sid := s.JWT.FetchClaim("sid", claims)
 
-	storedToken, err := s.Redis.Get(ctx, "access_token:"+user.Username).Result()
-	if err != nil || storedToken != accessToken {
-		return 400, map[string]string{"error": "token already expired or logged out"}
-	}
-	s.Redis.Del(ctx, "access_token:"+user.Username)
-
-	if _, err := s.JWT.Decode(refreshToken); err != nil {
+	rt, err := refreshClaims(s.JWT, refreshToken)
+	if err != nil || rt.UserID != userID || rt.SID != sid {
 		return 400, map[string]string{"error": "invalid or expired refresh token"}
 	}
 
-	storedRefresh, err := s.Redis.Get(ctx, "refresh_token:"+user.Username).Result()
-	if err != nil || storedRefresh != refreshToken {
+	current, err := s.Sessions.RefreshJTI(ctx, sid)
+	if err != nil {
+		return 400, map[string]string{"error": "token already expired or logged out"}
+	}
+	if current != rt.JTI {
 		return 403, map[string]string{"error": "refresh token invalid or expired"}
 	}
-	s.Redis.Del(ctx, "refresh_token:"+user.Username)
 
-	logger.Info("WipeOutSession", "logout successful", map[string]interface{}{"username": user.Username})
+	if err := s.endSession(ctx, userID, sid); err != nil {
+		logger.Error("WipeOutSession", "failed to revoke session", map[string]interface{}{"sid": sid, "error": err.Error()})
+		return 500, map[string]string{"error": "failed to log out"}
+	}
 	return 200, map[string]string{"message": "user logged out successfully"}
 }
 
-// GenerateAccessToken generates a new access token using a valid refresh token.
+// endSession signs session sid out. It is the last step of every logout,
+// whether the tokens themselves or an OpenID Connect client asked for it.
+func (s *AuthService) endSession(ctx context.Context, userID int, sid string) error {
+	if err := s.Sessions.Revoke(ctx, userID, sid); err != nil {
+		return err
+	}
+	logger.Info("WipeOutSession", "logout successful", map[string]interface{}{"user_id": userID, "sid": sid})
+	return nil
+}
+
+// GenerateAccessToken redeems a refresh token for a new access and refresh
+// token pair. Each refresh token works once; presenting one again ends its
+// session.
 func (s *AuthService) GenerateAccessToken(ctx context.Context, refreshToken string) (int, map[string]string) {
 	logger.Info("GenerateAccessToken", "called", nil)
 
@@ -252,57 +562,84 @@ func (s *AuthService) GenerateAccessToken(ctx context.Context, refreshToken stri
 		return 400, map[string]string{"error": "refresh token required"}
 	}
 
-	claims, err := s.JWT.Decode(refreshToken)
+	rt, err := refreshClaims(s.JWT, refreshToken)
 	if err != nil {
 		return 401, map[string]string{"error": "invalid refresh token"}
 	}
+	// OAuth tokens are scoped; swapping them here would drop the scope
+	if rt.Grant != nil {
+		return 400, map[string]string{"error": "refresh token was issued to an OAuth client, use /oauth/token"}
+	}
+	sid := rt.SID
 
-	userID := int(claims["user"].(float64))
-	user, err := s.UserService.GetUserByID(ctx, userID)
+	user, err := s.UserService.GetUserByID(ctx, rt.UserID)
 	if err != nil {
 		return 404, map[string]string{"error": "user not found"}
 	}
 
-	storedRefresh, err := s.Redis.Get(ctx, "refresh_token:"+user.Username).Result()
-	if err != nil || storedRefresh != refreshToken {
-		return 403, map[string]string{"error": "refresh token invalid or expired"}
+	// sign first: once the session moves on, the presented token is spent
+	tokens, err := s.signTokenPair(user, sid, nil)
+	if err != nil {
+		logger.Error("GenerateAccessToken", "failed to generate tokens", map[string]interface{}{"username": user.Username, "error": err.Error()})
+		return 500, map[string]string{"error": "failed to generate tokens"}
 	}
 
-	newAccess, err := s.JWT.GenerateJWTtoken(userID, 15)
+	result, err := s.Sessions.Rotate(ctx, user.ID, sid, rt.JTI, tokens.JTI)
 	if err != nil {
-		return 500, map[string]string{"error": "failed to generate access token"}
+		logger.Error("GenerateAccessToken", "failed to rotate refresh token", map[string]interface{}{"username": user.Username, "error": err.Error()})
+		return 500, map[string]string{"error": "failed to rotate refresh token"}
 	}
 
-	s.Redis.Set(ctx, "access_token:"+user.Username, newAccess, 15*time.Minute)
-	logger.Info("GenerateAccessToken", "new access token generated", map[string]interface{}{"username": user.Username})
-	return 200, map[string]string{"access_token": newAccess}
+	switch result {
+	case refreshRotated:
+	case refreshUnknown:
+		return 403, map[string]string{"error": "refresh token invalid or expired"}
+	case refreshReplayed:
+		s.handleRefreshReuse(ctx, user, sid)
+		return 401, map[string]string{"error": "refresh token already used, please log in again"}
+	default:
+		return 500, map[string]string{"error": "failed to rotate refresh token"}
+	}
+
+	logger.Info("GenerateAccessToken", "token pair rotated", map[string]interface{}{"username": user.Username, "sid": sid})
+	return 200, map[string]string{"access_token": tokens.Access, "refresh_token": tokens.Refresh}
 }
 
-// Authorize validates the access token in the HTTP request.
-func (s *AuthService) Authorize(ctx context.Context, r *http.Request) (string, error) {
+// Authorize validates the access token or API key in the HTTP request and
+// returns the principal it acts for. User tokens must belong to a live
+// session; tokens a client got for itself through client_credentials carry
+// no user. API keys act for their owner, capped by the key's scopes.
+func (s *AuthService) Authorize(ctx context.Context, r *http.Request) (*models.Principal, error) {
 	logger.Info("Authorize", "called", nil)
 
+	if raw := utils.ExtractAPIKey(r); raw != "" {
+		key, err := s.APIKeys.Authenticate(ctx, raw)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("Authorize", "api key authorization successful", map[string]interface{}{"user_id": key.UserID, "key_id": key.ID})
+		return &models.Principal{UserID: key.UserID, APIKeyID: key.ID, Scopes: key.Scopes}, nil
+	}
+
 	token, err := utils.ExtractToken(r)
 	if err != nil || token == "" {
-		return "", errors.New("missing token")
+		return nil, errors.New("missing token")
 	}
 
-	claims, err := s.JWT.Decode(token)
+	at, err := s.parseAccessToken(ctx, token)
 	if err != nil {
-		return "", errors.New("invalid token")
+		return nil, err
 	}
-
-	userID := int(claims["user"].(float64))
-	user, err := s.UserService.GetUserByID(ctx, userID)
-	if err != nil {
-		return "", errors.New("user not found")
+	p := at.Principal
+	if p.UserID == 0 {
+		logger.Info("Authorize", "client authorization successful", map[string]interface{}{"client_id": p.ClientID})
+		return p, nil
 	}
 
-	storedToken, err := s.Redis.Get(ctx, "access_token:"+user.Username).Result()
-	if err != nil || storedToken != token {
-		return "", errors.New("token expired or logged out")
+	if err := s.Sessions.Touch(ctx, p.UserID, p.SessionID); err != nil {
+		return nil, errors.New("token expired or logged out")
 	}
 
-	logger.Info("Authorize", "authorization successful", map[string]interface{}{"username": user.Username})
-	return strconv.Itoa(userID), nil
+	logger.Info("Authorize", "authorization successful", map[string]interface{}{"user_id": p.UserID, "sid": p.SessionID})
+	return p, nil
 }
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+	"test123/repositories"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+type DeadLetterService struct {
+	Repo   repositories.DeadLetterRepoInterface
+	Outbox repositories.OutboxRepoInterface
+	Tx     repositories.Transactor
+}
+
+func NewDeadLetterService(repo repositories.DeadLetterRepoInterface, outbox repositories.OutboxRepoInterface, tx repositories.Transactor) *DeadLetterService {
+	return &DeadLetterService{
+		Repo:   repo,
+		Outbox: outbox,
+		Tx:     tx,
+	}
+}
+
+func (s *DeadLetterService) List(ctx context.Context, status string, cursor int64, limit int) ([]models.DeadLetter, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	return s.Repo.List(ctx, status, cursor, limit)
+}
+
+func (s *DeadLetterService) Get(ctx context.Context, id int64) (*models.DeadLetter, error) {
+	if id <= 0 {
+		return nil, errors.ErrInvalidParams
+	}
+	return s.Repo.GetByID(ctx, id)
+}
+
+// Replay republishes a dead letter to its original topic through the
+// outbox, with a fresh retry budget. The outbox only holds JSON, so a
+// message dead-lettered for a malformed payload can only be discarded.
+func (s *DeadLetterService) Replay(ctx context.Context, id int64) error {
+	d, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !json.Valid(d.Payload) {
+		return fmt.Errorf("%w: dead letter %d has a non-JSON payload and cannot be replayed, discard it instead", errors.ErrInvalidJSON, id)
+	}
+
+	eventID := uuid.New()
+	if d.EventID != nil {
+		eventID = *d.EventID
+	}
+
+	err = s.Tx.WithTx(ctx, func(tx pgx.Tx) error {
+		if err := s.Repo.Resolve(ctx, tx, d.ID, models.DeadLetterStatusReplayed); err != nil {
+			return err
+		}
+		return s.Outbox.Enqueue(ctx, tx, models.OutboxMessage{
+			EventID: eventID,
+			Topic:   d.OriginalTopic,
+			Key:     d.Key,
+			Payload: d.Payload,
+		})
+	})
+	if err != nil {
+		logger.Error("DeadLetterService.Replay", "replay failed", map[string]interface{}{
+			"id":    id,
+			"error": err.Error(),
+		})
+		return err
+	}
+
+	logger.Info("DeadLetterService.Replay", "dead letter replayed", map[string]interface{}{
+		"id":       id,
+		"event_id": eventID,
+	})
+	return nil
+}
+
+func (s *DeadLetterService) Discard(ctx context.Context, id int64) error {
+	if _, err := s.Get(ctx, id); err != nil {
+		return err
+	}
+
+	if err := s.Repo.Resolve(ctx, nil, id, models.DeadLetterStatusDiscarded); err != nil {
+		return err
+	}
+
+	logger.Info("DeadLetterService.Discard", "dead letter discarded", map[string]interface{}{
+		"id": id,
+	})
+	return nil
+}
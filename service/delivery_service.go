@@ -0,0 +1,28 @@
+package service
+
+import (
+	"context"
+
+	"test123/errors"
+	"test123/models"
+	"test123/repositories"
+
+	"github.com/google/uuid"
+)
+
+type DeliveryService struct {
+	Repo repositories.DeliveryRepoInterface
+}
+
+func NewDeliveryService(repo repositories.DeliveryRepoInterface) *DeliveryService {
+	return &DeliveryService{Repo: repo}
+}
+
+// GetByEventID returns the per-channel delivery status of an event.
+func (s *DeliveryService) GetByEventID(ctx context.Context, eventID string) ([]models.NotificationDelivery, error) {
+	id, err := uuid.Parse(eventID)
+	if err != nil {
+		return nil, errors.ErrInvalidParams
+	}
+	return s.Repo.ListByEventID(ctx, id)
+}
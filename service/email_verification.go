@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"test123/config"
+	"test123/errors"
+	"test123/repositories"
+	"test123/utils"
+	"test123/utils/jwt"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// EmailVerifier mails signed verification and undo links and checks them
+// when they come back. Each link works once.
+type EmailVerifier struct {
+	JWT    *jwt.Jwt
+	Outbox repositories.OutboxRepoInterface
+	Redis  *redis.Client
+	Config config.Auth
+}
+
+func NewEmailVerifier(j *jwt.Jwt, outbox repositories.OutboxRepoInterface, rdb *redis.Client, cfg config.Auth) *EmailVerifier {
+	return &EmailVerifier{JWT: j, Outbox: outbox, Redis: rdb, Config: cfg}
+}
+
+// emailLink is what a verification or undo token proves.
+type emailLink struct {
+	UserID   int
+	Email    string
+	Previous string
+	JTI      string
+}
+
+// SendVerification queues a link confirming that email belongs to the user.
+func (v *EmailVerifier) SendVerification(ctx context.Context, db repositories.DBTX, userID int, username, email string) error {
+	token, err := v.JWT.GenerateEmailToken(userID, jwt.TokenTypeEmailVerify, email, "", v.Config.EmailVerifyTTL, uuid.NewString())
+	if err != nil {
+		return fmt.Errorf("%w: %v", errors.ErrInternalFailure, err)
+	}
+	link := v.Config.VerifyEmailURLBase + "?token=" + url.QueryEscape(token)
+	expiresIn := strconv.Itoa(int(v.Config.EmailVerifyTTL.Hours()))
+
+	event := utils.NewEmailNotificationEvent(
+		userID,
+		"email_verification",
+		"Confirm your email address",
+		"Click the link to confirm your email address. The link expires in "+expiresIn+" hours.",
+		email,
+		map[string]string{
+			"username":         username,
+			"verify_url":       link,
+			"expires_in_hours": expiresIn,
+		},
+	)
+	return enqueueNotification(ctx, v.Outbox, db, event)
+}
+
+// SendChangeAlert tells the old address about a requested change and gives
+// it a link to undo it.
+func (v *EmailVerifier) SendChangeAlert(ctx context.Context, db repositories.DBTX, userID int, username, oldEmail, newEmail string) error {
+	token, err := v.JWT.GenerateEmailToken(userID, jwt.TokenTypeEmailUndo, newEmail, oldEmail, v.Config.EmailUndoTTL, uuid.NewString())
+	if err != nil {
+		return fmt.Errorf("%w: %v", errors.ErrInternalFailure, err)
+	}
+	link := v.Config.EmailUndoURLBase + "?token=" + url.QueryEscape(token)
+	message := "the email address of your account is being changed to " + newEmail + ". If this was not you, use the link to keep this address and sign out everywhere."
+
+	event := utils.NewEmailNotificationEvent(
+		userID,
+		"security",
+		"Your email address is being changed",
+		message,
+		oldEmail,
+		map[string]string{
+			"username":  username,
+			"message":   message,
+			"new_email": newEmail,
+			"undo_url":  link,
+		},
+	)
+	return enqueueNotification(ctx, v.Outbox, db, event)
+}
+
+// parse checks a link token of the given type without spending it.
+func (v *EmailVerifier) parse(token, typ string) (*emailLink, error) {
+	claims, err := v.JWT.DecodeAs(token, typ)
+	if err != nil {
+		return nil, fmt.Errorf("%w: link is invalid or expired", errors.ErrInvalidToken)
+	}
+	id, ok := claims["user"].(float64)
+	l := &emailLink{
+		UserID:   int(id),
+		Email:    v.JWT.FetchClaim("email", claims),
+		Previous: v.JWT.FetchClaim("prev", claims),
+		JTI:      v.JWT.FetchClaim("jti", claims),
+	}
+	if !ok || l.Email == "" || l.JTI == "" {
+		return nil, fmt.Errorf("%w: link is invalid or expired", errors.ErrInvalidToken)
+	}
+	return l, nil
+}
+
+// spend marks a link as used. It reports false if it already was.
+func (v *EmailVerifier) spend(ctx context.Context, l *emailLink) (bool, error) {
+	ttl := v.Config.EmailVerifyTTL
+	if v.Config.EmailUndoTTL > ttl {
+		ttl = v.Config.EmailUndoTTL
+	}
+	ok, err := v.Redis.SetNX(ctx, "email_link:used:"+l.JTI, l.UserID, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", errors.ErrInternalFailure, err)
+	}
+	return ok, nil
+}
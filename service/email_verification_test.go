@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"test123/config"
+	"test123/notifier"
+)
+
+// fakeMailbox is an email channel that keeps the messages instead of
+// sending them.
+type fakeMailbox struct {
+	mu   sync.Mutex
+	sent []notifier.Message
+}
+
+func (f *fakeMailbox) Name() string { return "email" }
+
+func (f *fakeMailbox) Send(ctx context.Context, msg notifier.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+// TestEmailVerificationLinkOnlyByEmail checks the verification link reaches
+// the address it confirms and not the inbox: whoever can read the inbox
+// must not be able to confirm an address they do not own.
+func TestEmailVerificationLinkOnlyByEmail(t *testing.T) {
+	rdb, _ := newTestRedis(t)
+	outbox := &memoryOutbox{}
+	cfg := config.Auth{
+		VerifyEmailURLBase: "https://app.example.com/verify-email",
+		EmailVerifyTTL:     time.Hour,
+	}
+	v := NewEmailVerifier(newTestJWT(t), outbox, rdb, cfg)
+
+	if err := v.SendVerification(context.Background(), nil, 1, "alice", "new@example.com"); err != nil {
+		t.Fatalf("SendVerification: %v", err)
+	}
+	event := outbox.last()
+	link, err := url.Parse(event.Metadata["verify_url"])
+	if err != nil || link.Query().Get("token") == "" {
+		t.Fatalf("queued verify_url %q, want a link with a token", event.Metadata["verify_url"])
+	}
+	token := link.Query().Get("token")
+
+	mail := &fakeMailbox{}
+	inbox := dispatch(t, event, mail)
+
+	if len(mail.sent) != 1 || mail.sent[0].Event.Target != "new@example.com" || !strings.Contains(mail.sent[0].Text, token) {
+		t.Fatalf("mail %+v, want the link sent to new@example.com", mail.sent)
+	}
+	if len(inbox) != 1 {
+		t.Fatalf("inbox has %d items, want 1", len(inbox))
+	}
+	item := inbox[0]
+	for _, s := range []string{item.Title, item.Body} {
+		if strings.Contains(s, token) || strings.Contains(s, cfg.VerifyEmailURLBase) {
+			t.Errorf("inbox item %q / %q holds the verification link", item.Title, item.Body)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"test123/errors"
+	"test123/events"
+	"test123/models"
+	"test123/notifier"
+	"test123/repositories"
+	"test123/utils/jwt"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// Test doubles shared by the service tests. Methods a test does not
+// expect to be called are left to the embedded nil interface and panic.
+
+func newTestRedis(t *testing.T) (*redis.Client, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return rdb, mr
+}
+
+func newTestJWT(t *testing.T) *jwt.Jwt {
+	t.Helper()
+	key, err := jwt.GenerateKey(jwt.AlgES256)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	key.ActivatesAt = time.Now().Add(-time.Minute)
+	return jwt.NewJwtWithKeys(jwt.NewKeyRing(key))
+}
+
+// memoryUsers is an in-memory users table.
+type memoryUsers struct {
+	repositories.UserRepoInterface
+
+	mu     sync.Mutex
+	nextID int
+	users  map[int]*models.User
+}
+
+func newMemoryUsers(users ...models.User) *memoryUsers {
+	r := &memoryUsers{nextID: 1, users: map[int]*models.User{}}
+	for _, u := range users {
+		u := u
+		if u.ID == 0 {
+			u.ID = r.nextID
+		}
+		if u.ID >= r.nextID {
+			r.nextID = u.ID + 1
+		}
+		r.users[u.ID] = &u
+	}
+	return r
+}
+
+func (r *memoryUsers) find(match func(*models.User) bool) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, u := range r.users {
+		if match(u) {
+			c := *u
+			return &c, nil
+		}
+	}
+	return nil, errors.ErrUserNotFound
+}
+
+func (r *memoryUsers) GetUserByID(ctx context.Context, id int) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return u.ID == id })
+}
+
+func (r *memoryUsers) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return strings.EqualFold(u.Email, email) })
+}
+
+func (r *memoryUsers) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return u.Username == username })
+}
+
+func (r *memoryUsers) GetUserByEmailOrUsername(ctx context.Context, key string) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return u.Username == key || strings.EqualFold(u.Email, key) })
+}
+
+func (r *memoryUsers) CreateUserTx(ctx context.Context, db repositories.DBTX, user models.User) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, u := range r.users {
+		if u.Username == user.Username || strings.EqualFold(u.Email, user.Email) {
+			return 0, errors.ErrUserExists
+		}
+	}
+	user.ID = r.nextID
+	r.nextID++
+	r.users[user.ID] = &user
+	return user.ID, nil
+}
+
+func (r *memoryUsers) MarkEmailVerifiedTx(ctx context.Context, db repositories.DBTX, id int, email string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[id]
+	if !ok || u.Email != email {
+		return errors.ErrUserNotFound
+	}
+	now := time.Now()
+	u.EmailVerifiedAt = &now
+	return nil
+}
+
+func (r *memoryUsers) SetPasswordTx(ctx context.Context, db repositories.DBTX, id int, password string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[id]
+	if !ok {
+		return errors.ErrUserNotFound
+	}
+	u.Password = password
+	u.PasswordChangedAt = time.Now()
+	return nil
+}
+
+func (r *memoryUsers) MarkMobileVerified(ctx context.Context, id int, number string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[id]
+	if !ok || u.MobileNumber != number {
+		return errors.ErrResourceNotFound
+	}
+	now := time.Now()
+	u.MobileVerifiedAt = &now
+	return nil
+}
+
+// memoryOutbox keeps the notification events enqueued.
+type memoryOutbox struct {
+	repositories.OutboxRepoInterface
+
+	mu     sync.Mutex
+	events []events.NotificationEvent
+}
+
+func (o *memoryOutbox) Enqueue(ctx context.Context, db repositories.DBTX, msg models.OutboxMessage) error {
+	var e events.NotificationEvent
+	if err := json.Unmarshal(msg.Payload, &e); err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, e)
+	return nil
+}
+
+// actions lists the action of every event enqueued so far.
+func (o *memoryOutbox) actions() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var out []string
+	for _, e := range o.events {
+		out = append(out, e.Action)
+	}
+	return out
+}
+
+func (o *memoryOutbox) last() events.NotificationEvent {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.events) == 0 {
+		return events.NotificationEvent{}
+	}
+	return o.events[len(o.events)-1]
+}
+
+// memoryInbox is an in-memory notification_inbox table.
+type memoryInbox struct {
+	repositories.InboxRepoInterface
+
+	mu    sync.Mutex
+	items []models.InboxItem
+}
+
+func (r *memoryInbox) Insert(ctx context.Context, item models.InboxItem) (*models.InboxItem, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item.ID = int64(len(r.items) + 1)
+	item.CreatedAt = time.Now()
+	r.items = append(r.items, item)
+	return &item, nil
+}
+
+// openLedger lets every delivery through.
+type openLedger struct {
+	repositories.DeliveryRepoInterface
+}
+
+func (openLedger) Begin(ctx context.Context, d models.NotificationDelivery) (bool, error) {
+	return true, nil
+}
+
+func (openLedger) MarkSent(ctx context.Context, eventID uuid.UUID, channel string) error {
+	return nil
+}
+
+// metadataTemplates renders every action as its message followed by all of
+// its metadata, like a template that puts the link or code in the message.
+type metadataTemplates struct{}
+
+func (metadataTemplates) Render(ctx context.Context, action, locale string, data map[string]string) (*models.RenderedTemplate, error) {
+	text := []string{data["message"]}
+	for k, v := range data {
+		if k != "title" && k != "message" {
+			text = append(text, k+": "+v)
+		}
+	}
+	return &models.RenderedTemplate{Subject: data["title"], Text: strings.Join(text, "\n")}, nil
+}
+
+type defaultPreferences struct{}
+
+func (defaultPreferences) Get(ctx context.Context, userID int) (*models.NotificationPreferences, error) {
+	return &models.NotificationPreferences{UserID: userID}, nil
+}
+
+// dispatch delivers event the way the notifier does: to the in-app inbox
+// and to channel. It returns what the inbox stored.
+func dispatch(t *testing.T, event events.NotificationEvent, channel notifier.Channel) []models.InboxItem {
+	t.Helper()
+	rdb, _ := newTestRedis(t)
+	inbox := &memoryInbox{}
+	d := notifier.NewDispatcher(openLedger{}, metadataTemplates{}, defaultPreferences{}, notifier.NewInboxChannel(inbox, rdb), channel)
+	if err := d.Dispatch(context.Background(), event); err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+	return inbox.items
+}
+
+// noRoles and noWebhooks accept what sign-up and password changes store
+// alongside the user and keep nothing.
+type noRoles struct {
+	repositories.UserRoleRepoInterface
+}
+
+func (noRoles) AddUserRoleTx(ctx context.Context, db repositories.DBTX, role string, user int) error {
+	return nil
+}
+
+type noWebhooks struct {
+	repositories.WebhookRepoInterface
+}
+
+func (noWebhooks) Enqueue(ctx context.Context, db repositories.DBTX, eventID uuid.UUID, eventType string, payload []byte) (int64, error) {
+	return 1, nil
+}
+
+// noSettings leaves every runtime setting at its default.
+type noSettings struct{}
+
+func (noSettings) Get(ctx context.Context, key string, dst interface{}) error {
+	return errors.ErrResourceNotFound
+}
+
+func (noSettings) Set(ctx context.Context, key string, value interface{}) error {
+	return nil
+}
+
+// noTx runs fn without a transaction.
+type noTx struct{}
+
+func (noTx) WithTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	return fn(nil)
+}
@@ -0,0 +1,595 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"test123/config"
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+	"test123/repositories"
+	"test123/utils"
+	"test123/utils/jwt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Federated login sends the browser to an external OpenID Connect provider
+// with the authorization code flow, PKCE and a nonce, and signs the user in
+// with the account linked to the provider's "sub". Accounts are matched by
+// link only: an unlinked upstream account whose email belongs to a local
+// user must be linked by that user while signed in, so whoever controls an
+// address at some provider cannot take over the local account.
+
+// discoveryTTL is how long a provider's discovery document is trusted, and
+// keyRefetchInterval how often an unknown kid may trigger a JWKS refetch.
+const (
+	discoveryTTL       = time.Hour
+	keyRefetchInterval = time.Minute
+)
+
+type FederationService struct {
+	Auth       *AuthService
+	Identities repositories.IdentityRepoInterface
+	Redis      *redis.Client
+	Config     config.Federation
+	// Issuer is this service's public base URL; callbacks hang off it
+	Issuer string
+	HTTP   *http.Client
+
+	mu        sync.Mutex
+	upstreams map[string]*upstream
+}
+
+func NewFederationService(auth *AuthService, identities repositories.IdentityRepoInterface, rdb *redis.Client, cfg config.Federation, issuer string) *FederationService {
+	return &FederationService{
+		Auth:       auth,
+		Identities: identities,
+		Redis:      rdb,
+		Config:     cfg,
+		Issuer:     issuer,
+		HTTP:       &http.Client{Timeout: 10 * time.Second},
+		upstreams:  map[string]*upstream{},
+	}
+}
+
+// upstream is what we learned from a provider's discovery document.
+type upstream struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+
+	fetchedAt     time.Time
+	keys          []*jwt.Key
+	keysFetchedAt time.Time
+}
+
+// federationState is what the state parameter of a pending sign-in stands
+// for. LinkUserID is set when a signed-in user is linking an account.
+type federationState struct {
+	Provider   string `json:"provider"`
+	Nonce      string `json:"nonce"`
+	Verifier   string `json:"verifier"`
+	LinkUserID int    `json:"link_user_id,omitempty"`
+	Device     string `json:"device,omitempty"`
+}
+
+func federationStateKey(state string) string {
+	return "federation_state:" + state
+}
+
+// Providers lists the configured provider names.
+func (s *FederationService) Providers() []string {
+	names := make([]string, 0, len(s.Config.Providers))
+	for name := range s.Config.Providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CallbackURL is the redirect URI to register at the provider.
+func (s *FederationService) CallbackURL(provider string) string {
+	return s.Issuer + "/api/v1/auth/federated/" + provider + "/callback"
+}
+
+func (s *FederationService) provider(name string) (config.FederationProvider, error) {
+	p, ok := s.Config.Providers[name]
+	if !ok {
+		return p, fmt.Errorf("%w: unknown identity provider %q", errors.ErrResourceNotFound, name)
+	}
+	return p, nil
+}
+
+// AuthorizationURL starts a sign-in at provider and returns the URL to
+// send the browser to and the state it will come back with. linkUserID is
+// the signed-in user linking the account, or 0 to sign in.
+func (s *FederationService) AuthorizationURL(ctx context.Context, provider string, linkUserID int, device string) (string, string, error) {
+	p, err := s.provider(provider)
+	if err != nil {
+		return "", "", err
+	}
+	up, err := s.discover(ctx, provider, p)
+	if err != nil {
+		return "", "", err
+	}
+
+	st := federationState{Provider: provider, LinkUserID: linkUserID, Device: device}
+	var state string
+	for _, v := range []*string{&state, &st.Nonce, &st.Verifier} {
+		if *v, err = randomToken(32); err != nil {
+			return "", "", fmt.Errorf("%w: %v", errors.ErrInternalFailure, err)
+		}
+	}
+
+	value, _ := json.Marshal(st)
+	if err := s.Redis.Set(ctx, federationStateKey(state), value, s.Config.StateTTL).Err(); err != nil {
+		return "", "", fmt.Errorf("%w: %v", errors.ErrCacheFailure, err)
+	}
+
+	challenge := sha256.Sum256([]byte(st.Verifier))
+	scopes := p.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{models.OIDCScopeOpenID, models.OIDCScopeProfile, models.OIDCScopeEmail}
+	}
+	if !contains(scopes, models.OIDCScopeOpenID) {
+		scopes = append([]string{models.OIDCScopeOpenID}, scopes...)
+	}
+
+	u, err := url.Parse(up.AuthorizationEndpoint)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: provider %s has an invalid authorization_endpoint", errors.ErrDependencyFailure, provider)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", s.CallbackURL(provider))
+	q.Set("scope", strings.Join(scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", st.Nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+
+	return u.String(), state, nil
+}
+
+// Callback finishes a sign-in started by AuthorizationURL. It answers like
+// Login (tokens or an MFA challenge), or with the new link in link mode.
+// upstreamError is the provider's "error" parameter, if it sent one.
+func (s *FederationService) Callback(ctx context.Context, provider, state, code, upstreamError string, client models.SessionClient) (int, map[string]interface{}) {
+	logger.Info("FederationService.Callback", "called", map[string]interface{}{"provider": provider})
+
+	if state == "" {
+		return 400, map[string]interface{}{"error": "state required"}
+	}
+	// GETDEL: a state is good for one callback
+	raw, err := s.Redis.GetDel(ctx, federationStateKey(state)).Result()
+	if err != nil {
+		return 400, map[string]interface{}{"error": "invalid or expired state"}
+	}
+	var st federationState
+	if err := json.Unmarshal([]byte(raw), &st); err != nil || st.Provider != provider {
+		return 400, map[string]interface{}{"error": "invalid or expired state"}
+	}
+
+	if upstreamError != "" {
+		logger.Warn("FederationService.Callback", "provider refused sign-in", map[string]interface{}{"provider": provider, "error": upstreamError})
+		return 401, map[string]interface{}{"error": "sign-in was not completed at " + provider + ": " + upstreamError}
+	}
+	if code == "" {
+		return 400, map[string]interface{}{"error": "code required"}
+	}
+
+	claims, err := s.exchange(ctx, provider, code, st)
+	if err != nil {
+		logger.Error("FederationService.Callback", "code exchange failed", map[string]interface{}{"provider": provider, "error": err.Error()})
+		return utils.HttpStatusFromError(err), map[string]interface{}{"error": err.Error()}
+	}
+
+	if st.LinkUserID > 0 {
+		identity, err := s.link(ctx, st.LinkUserID, provider, claims)
+		if err != nil {
+			return utils.HttpStatusFromError(err), map[string]interface{}{"error": err.Error()}
+		}
+		return 200, map[string]interface{}{"message": provider + " account linked", "identity": identity}
+	}
+
+	user, err := s.signIn(ctx, provider, claims)
+	if err != nil {
+		return utils.HttpStatusFromError(err), map[string]interface{}{"error": err.Error()}
+	}
+
+	rules, err := s.Auth.Settings.Auth(ctx)
+	if err != nil {
+		logger.Error("FederationService.Callback", "failed to load auth settings", map[string]interface{}{"error": err.Error()})
+		return 500, map[string]interface{}{"error": "failed to sign in"}
+	}
+	if rules.RequireVerifiedEmail && user.EmailVerifiedAt == nil {
+		logger.Warn("FederationService.Callback", "email not verified", map[string]interface{}{"username": user.Username})
+		return 403, map[string]interface{}{"error": "email address not verified"}
+	}
+
+	client.Device = st.Device
+	if client.Device == "" {
+		client.Device = "federated:" + provider
+	}
+	return s.Auth.completeLogin(ctx, user, client)
+}
+
+// upstreamClaims are the id_token claims federation relies on.
+type upstreamClaims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+// exchange redeems code at the provider's token endpoint and verifies the
+// id_token that comes back.
+func (s *FederationService) exchange(ctx context.Context, provider, code string, st federationState) (*upstreamClaims, error) {
+	p, err := s.provider(provider)
+	if err != nil {
+		return nil, err
+	}
+	up, err := s.discover(ctx, provider, p)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {s.CallbackURL(provider)},
+		"code_verifier": {st.Verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, up.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrDependencyFailure, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// client_secret_basic: credentials are form encoded before base64
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+
+	resp, err := s.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s token endpoint: %v", errors.ErrDependencyFailure, provider, err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("%w: %s token endpoint answered %s", errors.ErrDependencyFailure, provider, resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		// a bad or reused code is the user's problem, anything else ours
+		if body.Error == "invalid_grant" {
+			return nil, fmt.Errorf("%w: %s rejected the code: %s", errors.ErrUnauthorized, provider, body.ErrorDescription)
+		}
+		return nil, fmt.Errorf("%w: %s token endpoint: %s %s", errors.ErrDependencyFailure, provider, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return nil, fmt.Errorf("%w: %s returned no id_token", errors.ErrDependencyFailure, provider)
+	}
+
+	claims, err := s.verifyIDToken(ctx, provider, p, up, body.IDToken)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s id_token: %v", errors.ErrUnauthorized, provider, err)
+	}
+	if nonce, _ := claims["nonce"].(string); nonce != st.Nonce {
+		return nil, fmt.Errorf("%w: %s id_token nonce mismatch", errors.ErrUnauthorized, provider)
+	}
+
+	c := &upstreamClaims{}
+	c.Subject, _ = claims["sub"].(string)
+	c.Email, _ = claims["email"].(string)
+	c.Name, _ = claims["name"].(string)
+	c.PreferredUsername, _ = claims["preferred_username"].(string)
+	// some providers send the flag as a string
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		c.EmailVerified = v
+	case string:
+		c.EmailVerified = v == "true"
+	}
+	if c.Subject == "" {
+		return nil, fmt.Errorf("%w: %s id_token has no subject", errors.ErrUnauthorized, provider)
+	}
+	return c, nil
+}
+
+// verifyIDToken checks the id_token against the provider's keys, fetching
+// them again once if it names a key we have not seen.
+func (s *FederationService) verifyIDToken(ctx context.Context, provider string, p config.FederationProvider, up *upstream, token string) (map[string]interface{}, error) {
+	keys, err := s.keys(ctx, provider, up, false)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := jwt.VerifyExternal(token, keys, up.Issuer, p.ClientID)
+	if stderrors.Is(err, jwt.ErrUnknownKey) {
+		if keys, err = s.keys(ctx, provider, up, true); err != nil {
+			return nil, err
+		}
+		claims, err = jwt.VerifyExternal(token, keys, up.Issuer, p.ClientID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// signIn finds the local user linked to the upstream account, creating one
+// when the provider allows it.
+func (s *FederationService) signIn(ctx context.Context, provider string, c *upstreamClaims) (*models.User, error) {
+	identity, err := s.Identities.GetBySubject(ctx, provider, c.Subject)
+	if err == nil {
+		if err := s.Identities.TouchLogin(ctx, identity.ID); err != nil {
+			logger.Warn("FederationService.signIn", "failed to record login", map[string]interface{}{"identity_id": identity.ID, "error": err.Error()})
+		}
+		user, err := s.Auth.UserService.GetUserByID(ctx, identity.UserID)
+		if err != nil {
+			return nil, err
+		}
+		s.confirmEmail(ctx, user, c)
+		logger.Info("FederationService.signIn", "linked account signed in", map[string]interface{}{"provider": provider, "user_id": user.ID})
+		return user, nil
+	}
+	if err != errors.ErrResourceNotFound {
+		return nil, err
+	}
+
+	if c.Email == "" {
+		return nil, fmt.Errorf("%w: %s did not share an email address", errors.ErrForbidden, provider)
+	}
+	if _, err := s.Auth.UserService.GetByUserByEmail(ctx, c.Email); err == nil {
+		logger.Warn("FederationService.signIn", "email belongs to an unlinked account", map[string]interface{}{"provider": provider, "email": c.Email})
+		return nil, fmt.Errorf("%w: an account with this email already exists; sign in and link %s from your account", errors.ErrDuplicateRequest, provider)
+	} else if err != errors.ErrUserNotFound {
+		return nil, err
+	}
+
+	p, err := s.provider(provider)
+	if err != nil {
+		return nil, err
+	}
+	if !p.AutoCreate {
+		return nil, fmt.Errorf("%w: no account is linked to this %s account", errors.ErrForbidden, provider)
+	}
+	return s.provision(ctx, provider, c)
+}
+
+// provision creates a local user for an upstream account through the
+// normal sign-up path, so it gets its role, welcome email and webhook.
+// The password is random: the user signs in at the provider, or resets it.
+func (s *FederationService) provision(ctx context.Context, provider string, c *upstreamClaims) (*models.User, error) {
+	username, err := s.freeUsername(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	password, err := randomToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrInternalFailure, err)
+	}
+	name := c.Name
+	if name == "" {
+		name = username
+	}
+
+	if err := s.Auth.UserService.CreateUser(ctx, models.User{
+		Name:     name,
+		Email:    c.Email,
+		Username: username,
+		Password: password,
+	}); err != nil {
+		return nil, err
+	}
+	user, err := s.Auth.UserService.GetByUserByEmail(ctx, c.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if _, err := s.Identities.Create(ctx, models.UserIdentity{
+		UserID:      user.ID,
+		Provider:    provider,
+		Subject:     c.Subject,
+		Email:       c.Email,
+		LastLoginAt: &now,
+	}); err != nil {
+		return nil, err
+	}
+	s.confirmEmail(ctx, user, c)
+
+	logger.Info("FederationService.provision", "user created from federated sign-in", map[string]interface{}{"provider": provider, "user_id": user.ID, "username": username})
+	return user, nil
+}
+
+// confirmEmail marks the user's address verified when the provider vouches
+// for that same address.
+func (s *FederationService) confirmEmail(ctx context.Context, user *models.User, c *upstreamClaims) {
+	if user.EmailVerifiedAt != nil || !c.EmailVerified || !strings.EqualFold(c.Email, user.Email) {
+		return
+	}
+	if err := s.Auth.UserService.MarkEmailVerified(ctx, user.ID, user.Email); err != nil {
+		logger.Warn("FederationService.confirmEmail", "failed to mark email verified", map[string]interface{}{"user_id": user.ID, "error": err.Error()})
+		return
+	}
+	now := time.Now()
+	user.EmailVerifiedAt = &now
+}
+
+var usernameUnsafe = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// freeUsername derives a username from the upstream profile, adding a
+// random suffix while it is taken.
+func (s *FederationService) freeUsername(ctx context.Context, c *upstreamClaims) (string, error) {
+	base := c.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(c.Email, "@")
+	}
+	base = strings.Trim(usernameUnsafe.ReplaceAllString(strings.ToLower(base), ""), "._-")
+	if base == "" {
+		base = "user"
+	}
+
+	candidate := base
+	for i := 0; i < 5; i++ {
+		taken, err := s.Auth.UserService.UsernameExists(ctx, candidate)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+		suffix, err := randomHex(3)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", errors.ErrInternalFailure, err)
+		}
+		candidate = base + "-" + suffix
+	}
+	return "", fmt.Errorf("%w: could not find a free username", errors.ErrInternalFailure)
+}
+
+// link attaches the upstream account to a signed-in user.
+func (s *FederationService) link(ctx context.Context, userID int, provider string, c *upstreamClaims) (*models.UserIdentity, error) {
+	existing, err := s.Identities.GetBySubject(ctx, provider, c.Subject)
+	if err == nil {
+		if existing.UserID == userID {
+			return existing, nil
+		}
+		return nil, fmt.Errorf("%w: this %s account is linked to another user", errors.ErrDuplicateRequest, provider)
+	}
+	if err != errors.ErrResourceNotFound {
+		return nil, err
+	}
+
+	identity, err := s.Identities.Create(ctx, models.UserIdentity{
+		UserID:   userID,
+		Provider: provider,
+		Subject:  c.Subject,
+		Email:    c.Email,
+	})
+	if err != nil {
+		if err == errors.ErrDuplicateRequest {
+			return nil, fmt.Errorf("%w: another %s account is already linked; unlink it first", errors.ErrDuplicateRequest, provider)
+		}
+		return nil, err
+	}
+
+	logger.Info("FederationService.link", "identity linked", map[string]interface{}{"provider": provider, "user_id": userID})
+	return identity, nil
+}
+
+// ListIdentities returns the upstream accounts linked to the user.
+func (s *FederationService) ListIdentities(ctx context.Context, userID int) ([]models.UserIdentity, error) {
+	return s.Identities.ListByUser(ctx, userID)
+}
+
+// Unlink removes the user's link to provider. Users created on first
+// federated sign-in can still get in by resetting their password.
+func (s *FederationService) Unlink(ctx context.Context, userID int, provider string) error {
+	if err := s.Identities.Delete(ctx, userID, provider); err != nil {
+		return err
+	}
+	logger.Info("FederationService.Unlink", "identity unlinked", map[string]interface{}{"provider": provider, "user_id": userID})
+	return nil
+}
+
+// discover returns the provider's cached discovery document, fetching it
+// when missing or stale.
+func (s *FederationService) discover(ctx context.Context, name string, p config.FederationProvider) (*upstream, error) {
+	s.mu.Lock()
+	up := s.upstreams[name]
+	s.mu.Unlock()
+	if up != nil && time.Since(up.fetchedAt) < discoveryTTL {
+		return up, nil
+	}
+
+	issuer := strings.TrimSuffix(p.Issuer, "/")
+	fetched := &upstream{}
+	if err := s.getJSON(ctx, issuer+"/.well-known/openid-configuration", fetched); err != nil {
+		if up != nil {
+			// keep signing in with what we had until the provider is back
+			logger.Warn("FederationService.discover", "refresh failed, using cached document", map[string]interface{}{"provider": name, "error": err.Error()})
+			return up, nil
+		}
+		return nil, fmt.Errorf("%w: %s discovery: %v", errors.ErrDependencyFailure, name, err)
+	}
+	if strings.TrimSuffix(fetched.Issuer, "/") != issuer || fetched.AuthorizationEndpoint == "" || fetched.TokenEndpoint == "" || fetched.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: %s discovery document does not match its issuer", errors.ErrDependencyFailure, name)
+	}
+	fetched.fetchedAt = time.Now()
+	if up != nil {
+		fetched.keys, fetched.keysFetchedAt = up.keys, up.keysFetchedAt
+	}
+
+	s.mu.Lock()
+	s.upstreams[name] = fetched
+	s.mu.Unlock()
+	return fetched, nil
+}
+
+// keys returns the provider's signing keys, refetching them when asked to
+// and the last fetch is not too recent.
+func (s *FederationService) keys(ctx context.Context, name string, up *upstream, refetch bool) ([]*jwt.Key, error) {
+	s.mu.Lock()
+	keys, fetchedAt := up.keys, up.keysFetchedAt
+	s.mu.Unlock()
+	if keys != nil && (!refetch || time.Since(fetchedAt) < keyRefetchInterval) {
+		return keys, nil
+	}
+
+	keys, err := jwt.FetchJWKS(ctx, s.HTTP, up.JWKSURI)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s jwks: %v", errors.ErrDependencyFailure, name, err)
+	}
+	s.mu.Lock()
+	up.keys, up.keysFetchedAt = keys, time.Now()
+	s.mu.Unlock()
+	return keys, nil
+}
+
+func (s *FederationService) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// randomToken is n random bytes, base64url encoded.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
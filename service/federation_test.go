@@ -0,0 +1,437 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"test123/config"
+	"test123/errors"
+	"test123/models"
+	"test123/repositories"
+	"test123/utils/jwt"
+
+	"github.com/google/uuid"
+	"github.com/willf/bloom"
+)
+
+// testIdP is an OpenID Connect provider on an httptest server. Codes are
+// handed out by approve instead of a browser round trip.
+type testIdP struct {
+	srv    *httptest.Server
+	keys   *jwt.KeyRing
+	signer *jwt.Jwt
+
+	mu    sync.Mutex
+	codes map[string]idpCode
+}
+
+type idpCode struct {
+	challenge string
+	nonce     string
+	subject   int
+	email     string
+}
+
+const (
+	idpClientID     = "auth-service"
+	idpClientSecret = "s3cret"
+)
+
+func startTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	key, err := jwt.GenerateKey(jwt.AlgES256)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	key.ActivatesAt = time.Now().Add(-time.Minute)
+
+	p := &testIdP{keys: jwt.NewKeyRing(key), codes: map[string]idpCode{}}
+	p.signer = jwt.NewJwtWithKeys(p.keys)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.srv.URL,
+			"authorization_endpoint": p.srv.URL + "/authorize",
+			"token_endpoint":         p.srv.URL + "/token",
+			"jwks_uri":               p.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(p.keys.JWKS(time.Now()))
+	})
+	mux.HandleFunc("/token", p.token)
+	p.srv = httptest.NewServer(mux)
+	t.Cleanup(p.srv.Close)
+	return p
+}
+
+// approve plays the user signing in at the provider as email: it reads
+// the authorization URL and returns the code the browser would bring back.
+func (p *testIdP) approve(t *testing.T, authURL string, subject int, email string) string {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("authorization URL: %v", err)
+	}
+	q := u.Query()
+	if q.Get("client_id") != idpClientID || q.Get("code_challenge_method") != "S256" || q.Get("nonce") == "" {
+		t.Fatalf("authorization request is missing client, PKCE or nonce: %s", authURL)
+	}
+
+	code := uuid.NewString()
+	p.mu.Lock()
+	p.codes[code] = idpCode{challenge: q.Get("code_challenge"), nonce: q.Get("nonce"), subject: subject, email: email}
+	p.mu.Unlock()
+	return code
+}
+
+// idTokenFor signs the id_token the token endpoint answers with; tests
+// replace it to send a doctored one.
+var idTokenFor = func(p *testIdP, c idpCode) (string, error) {
+	return p.signer.GenerateIDToken(p.srv.URL, idpClientID, c.subject, 5*time.Minute, map[string]interface{}{
+		"nonce":          c.nonce,
+		"email":          c.email,
+		"email_verified": true,
+		"name":           "Fed User",
+	})
+}
+
+func (p *testIdP) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, _ := r.BasicAuth()
+	if id != idpClientID || secret != idpClientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	p.mu.Lock()
+	c, ok := p.codes[r.PostFormValue("code")]
+	delete(p.codes, r.PostFormValue("code"))
+	p.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != c.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	idToken, err := idTokenFor(p, c)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": idToken})
+}
+
+// memoryIdentities is an in-memory user_identities table with its two
+// unique constraints.
+type memoryIdentities struct {
+	repositories.IdentityRepoInterface
+
+	mu   sync.Mutex
+	rows []models.UserIdentity
+}
+
+func (r *memoryIdentities) Create(ctx context.Context, i models.UserIdentity) (*models.UserIdentity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, row := range r.rows {
+		if row.Provider == i.Provider && (row.Subject == i.Subject || row.UserID == i.UserID) {
+			return nil, errors.ErrDuplicateRequest
+		}
+	}
+	i.ID = len(r.rows) + 1
+	i.CreatedAt = time.Now()
+	r.rows = append(r.rows, i)
+	return &i, nil
+}
+
+func (r *memoryIdentities) GetBySubject(ctx context.Context, provider, subject string) (*models.UserIdentity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, row := range r.rows {
+		if row.Provider == provider && row.Subject == subject {
+			return &row, nil
+		}
+	}
+	return nil, errors.ErrResourceNotFound
+}
+
+func (r *memoryIdentities) TouchLogin(ctx context.Context, id int) error {
+	return nil
+}
+
+type federationTest struct {
+	svc        *FederationService
+	idp        *testIdP
+	users      *memoryUsers
+	identities *memoryIdentities
+	outbox     *memoryOutbox
+}
+
+// newFederationTest wires a FederationService to a fresh test IdP, with
+// alice as the only local user.
+func newFederationTest(t *testing.T, autoCreate bool) *federationTest {
+	t.Helper()
+	rdb, _ := newTestRedis(t)
+	j := newTestJWT(t)
+	idp := startTestIdP(t)
+	users := newMemoryUsers(models.User{ID: 1, Username: "alice", Name: "Alice", Email: "alice@example.com"})
+	outbox := &memoryOutbox{}
+	identities := &memoryIdentities{}
+
+	authCfg := config.Auth{AccessTokenTTL: 15 * time.Minute, RefreshTokenTTL: time.Hour, EmailVerifyTTL: time.Hour}
+	hasher := NewBcryptHasher(4)
+	userService := &UserService{
+		UserRepo:     users,
+		Outbox:       outbox,
+		Webhooks:     noWebhooks{},
+		Tx:           noTx{},
+		UserRoleRepo: noRoles{},
+		Redis:        rdb,
+		Bloom:        bloom.NewWithEstimates(1000, 0.01),
+		Hasher:       hasher,
+		Verifier:     NewEmailVerifier(j, outbox, rdb, authCfg),
+		Policy:       NewPasswordPolicy(nil, hasher, nil, config.PasswordPolicy{MaxLength: 128}),
+	}
+	auth := &AuthService{
+		UserService: userService,
+		Redis:       rdb,
+		JWT:         j,
+		Outbox:      outbox,
+		Sessions:    NewSessionService(rdb, time.Hour),
+		MFA:         &MFAService{Repo: &memoryMFA{}},
+		Settings:    &SettingsService{Repo: noSettings{}, CacheTTL: time.Minute},
+		Config:      authCfg,
+	}
+	cfg := config.Federation{
+		Providers: map[string]config.FederationProvider{
+			"test": {Issuer: idp.srv.URL, ClientID: idpClientID, ClientSecret: idpClientSecret, AutoCreate: autoCreate},
+		},
+		StateTTL: 5 * time.Minute,
+	}
+
+	return &federationTest{
+		svc:        NewFederationService(auth, identities, rdb, cfg, "https://auth.example.com"),
+		idp:        idp,
+		users:      users,
+		identities: identities,
+		outbox:     outbox,
+	}
+}
+
+// signIn runs a whole federated sign-in, or a link when linkUserID is set,
+// as the upstream account subject/email.
+func (f *federationTest) signIn(t *testing.T, linkUserID, subject int, email string) (int, map[string]interface{}) {
+	t.Helper()
+	ctx := context.Background()
+	authURL, state, err := f.svc.AuthorizationURL(ctx, "test", linkUserID, "")
+	if err != nil {
+		t.Fatalf("AuthorizationURL: %v", err)
+	}
+	code := f.idp.approve(t, authURL, subject, email)
+	return f.svc.Callback(ctx, "test", state, code, "", models.SessionClient{})
+}
+
+// signedInAs returns the user the access token of a sign-in is for.
+func (f *federationTest) signedInAs(t *testing.T, body map[string]interface{}) int {
+	t.Helper()
+	access, _ := body["access_token"].(string)
+	claims, err := f.svc.Auth.JWT.DecodeAs(access, jwt.TokenTypeAccess)
+	if err != nil {
+		t.Fatalf("access token: %v (body %v)", err, body)
+	}
+	return int(claims["user"].(float64))
+}
+
+func TestFederatedIDTokenValidation(t *testing.T) {
+	stranger, err := jwt.GenerateKey(jwt.AlgES256)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	stranger.ActivatesAt = time.Now().Add(-time.Minute)
+	forger := jwt.NewJwtWithKeys(jwt.NewKeyRing(stranger))
+
+	claims := func(c idpCode) map[string]interface{} {
+		return map[string]interface{}{"nonce": c.nonce, "email": c.email, "email_verified": true}
+	}
+
+	tests := []struct {
+		name    string
+		idToken func(p *testIdP, c idpCode) (string, error)
+		status  int
+	}{
+		{"valid", idTokenFor, 200},
+		{"wrong issuer", func(p *testIdP, c idpCode) (string, error) {
+			return p.signer.GenerateIDToken("https://idp.example.net", idpClientID, c.subject, time.Minute, claims(c))
+		}, 401},
+		{"issued to another client", func(p *testIdP, c idpCode) (string, error) {
+			return p.signer.GenerateIDToken(p.srv.URL, "someone-else", c.subject, time.Minute, claims(c))
+		}, 401},
+		{"nonce of another request", func(p *testIdP, c idpCode) (string, error) {
+			cl := claims(c)
+			cl["nonce"] = "replayed-nonce"
+			return p.signer.GenerateIDToken(p.srv.URL, idpClientID, c.subject, time.Minute, cl)
+		}, 401},
+		{"no nonce", func(p *testIdP, c idpCode) (string, error) {
+			cl := claims(c)
+			delete(cl, "nonce")
+			return p.signer.GenerateIDToken(p.srv.URL, idpClientID, c.subject, time.Minute, cl)
+		}, 401},
+		{"expired", func(p *testIdP, c idpCode) (string, error) {
+			return p.signer.GenerateIDToken(p.srv.URL, idpClientID, c.subject, -10*time.Minute, claims(c))
+		}, 401},
+		{"signed by a key not in the JWKS", func(p *testIdP, c idpCode) (string, error) {
+			return forger.GenerateIDToken(p.srv.URL, idpClientID, c.subject, time.Minute, claims(c))
+		}, 401},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFederationTest(t, false)
+			if _, err := f.identities.Create(context.Background(), models.UserIdentity{UserID: 1, Provider: "test", Subject: "1001"}); err != nil {
+				t.Fatalf("link alice: %v", err)
+			}
+			saved := idTokenFor
+			idTokenFor = tt.idToken
+			defer func() { idTokenFor = saved }()
+
+			status, body := f.signIn(t, 0, 1001, "alice@example.com")
+			if status != tt.status {
+				t.Fatalf("got %d %v, want %d", status, body, tt.status)
+			}
+			if status == 200 && f.signedInAs(t, body) != 1 {
+				t.Errorf("signed in as %d, want alice", f.signedInAs(t, body))
+			}
+		})
+	}
+}
+
+func TestFederatedStateIsSingleUse(t *testing.T) {
+	ctx := context.Background()
+	f := newFederationTest(t, true)
+
+	authURL, state, err := f.svc.AuthorizationURL(ctx, "test", 0, "")
+	if err != nil {
+		t.Fatalf("AuthorizationURL: %v", err)
+	}
+	code := f.idp.approve(t, authURL, 2002, "bob@example.com")
+	if status, body := f.svc.Callback(ctx, "test", state, code, "", models.SessionClient{}); status != 200 {
+		t.Fatalf("first callback: %d %v", status, body)
+	}
+	if status, _ := f.svc.Callback(ctx, "test", state, code, "", models.SessionClient{}); status != 400 {
+		t.Fatalf("replayed callback: got %d, want 400", status)
+	}
+}
+
+func TestFederatedJITProvisioning(t *testing.T) {
+	f := newFederationTest(t, true)
+
+	status, body := f.signIn(t, 0, 2002, "Bob.Builder@example.com")
+	if status != 200 {
+		t.Fatalf("first sign-in: %d %v", status, body)
+	}
+	bob, err := f.users.GetUserByEmail(context.Background(), "Bob.Builder@example.com")
+	if err != nil {
+		t.Fatalf("no user was created: %v", err)
+	}
+	if got := f.signedInAs(t, body); got != bob.ID {
+		t.Errorf("signed in as %d, want the new user %d", got, bob.ID)
+	}
+	if bob.Username != "bob.builder" || bob.Name != "Fed User" {
+		t.Errorf("created %q (%q), want bob.builder (Fed User)", bob.Username, bob.Name)
+	}
+	if bob.EmailVerifiedAt == nil {
+		t.Error("address the provider vouched for is not verified")
+	}
+	if identity, err := f.identities.GetBySubject(context.Background(), "test", "2002"); err != nil || identity.UserID != bob.ID {
+		t.Fatalf("identity not linked to the new user: %v %v", identity, err)
+	}
+	if got := f.outbox.actions(); len(got) == 0 || got[0] != "user_created" {
+		t.Errorf("enqueued %v, want the sign-up notifications", got)
+	}
+
+	// the second sign-in finds the link and creates nobody
+	status, body = f.signIn(t, 0, 2002, "Bob.Builder@example.com")
+	if status != 200 || f.signedInAs(t, body) != bob.ID {
+		t.Fatalf("second sign-in: %d %v", status, body)
+	}
+	f.users.mu.Lock()
+	count := len(f.users.users)
+	f.users.mu.Unlock()
+	if count != 2 {
+		t.Errorf("%d users, want alice and bob", count)
+	}
+}
+
+func TestFederatedUnlinkedAccounts(t *testing.T) {
+	tests := []struct {
+		name       string
+		autoCreate bool
+		email      string
+		status     int
+	}{
+		// an upstream account must not take over a local one by its email
+		{"email of an existing user", true, "alice@example.com", 409},
+		{"email of an existing user, other case", true, "ALICE@example.com", 409},
+		{"new email, provisioning off", false, "carol@example.com", 403},
+		{"no email shared", true, "", 403},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFederationTest(t, tt.autoCreate)
+			status, body := f.signIn(t, 0, 3003, tt.email)
+			if status != tt.status {
+				t.Fatalf("got %d %v, want %d", status, body, tt.status)
+			}
+			if _, err := f.identities.GetBySubject(context.Background(), "test", "3003"); err == nil {
+				t.Error("a refused sign-in linked the upstream account")
+			}
+		})
+	}
+}
+
+func TestFederatedLinkExistingAccount(t *testing.T) {
+	f := newFederationTest(t, false)
+
+	// alice links the upstream account while signed in, whatever its email
+	status, body := f.signIn(t, 1, 4004, "alice.elsewhere@example.org")
+	if status != 200 {
+		t.Fatalf("link: %d %v", status, body)
+	}
+	if identity, err := f.identities.GetBySubject(context.Background(), "test", "4004"); err != nil || identity.UserID != 1 {
+		t.Fatalf("identity not linked to alice: %v %v", identity, err)
+	}
+
+	// from then on the upstream account signs in as alice
+	status, body = f.signIn(t, 0, 4004, "alice.elsewhere@example.org")
+	if status != 200 {
+		t.Fatalf("sign-in after linking: %d %v", status, body)
+	}
+	if got := f.signedInAs(t, body); got != 1 {
+		t.Errorf("signed in as %d, want alice", got)
+	}
+
+	// linking it again is a no-op, to someone else a conflict
+	if status, body := f.signIn(t, 1, 4004, "alice.elsewhere@example.org"); status != 200 {
+		t.Errorf("relink by alice: %d %v", status, body)
+	}
+	other, err := f.users.CreateUserTx(context.Background(), nil, models.User{Username: "mallory", Email: "mallory@example.com"})
+	if err != nil {
+		t.Fatalf("create mallory: %v", err)
+	}
+	if status, body := f.signIn(t, other, 4004, "alice.elsewhere@example.org"); status != 409 {
+		t.Errorf("link by another user: got %d %v, want 409", status, body)
+	}
+}
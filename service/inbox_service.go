@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+	"test123/repositories"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type InboxService struct {
+	Repo  repositories.InboxRepoInterface
+	Redis *redis.Client
+}
+
+func NewInboxService(repo repositories.InboxRepoInterface, rdb *redis.Client) *InboxService {
+	return &InboxService{Repo: repo, Redis: rdb}
+}
+
+func (s *InboxService) List(ctx context.Context, userID int, cursor int64, limit int, unreadOnly bool) ([]models.InboxItem, error) {
+	if userID <= 0 {
+		return nil, errors.ErrInvalidParams
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	return s.Repo.List(ctx, userID, cursor, limit, unreadOnly)
+}
+
+func (s *InboxService) UnreadCount(ctx context.Context, userID int) (int, error) {
+	if userID <= 0 {
+		return 0, errors.ErrInvalidParams
+	}
+	return s.Repo.UnreadCount(ctx, userID)
+}
+
+// MarkRead marks the given items read, or every unread item when all is
+// set, and returns how many changed.
+func (s *InboxService) MarkRead(ctx context.Context, userID int, ids []int64, all bool) (int64, error) {
+	if userID <= 0 {
+		return 0, errors.ErrInvalidParams
+	}
+
+	logger.Info("InboxService.MarkRead", "marking inbox items read", map[string]interface{}{
+		"user_id": userID,
+		"count":   len(ids),
+		"all":     all,
+	})
+
+	if all {
+		return s.Repo.MarkAllRead(ctx, userID)
+	}
+	if len(ids) == 0 {
+		return 0, errors.ErrMissingField
+	}
+	return s.Repo.MarkRead(ctx, userID, ids)
+}
+
+// Subscribe streams items the notifier adds to the user's inbox. The caller
+// must close the returned subscription.
+func (s *InboxService) Subscribe(ctx context.Context, userID int) *redis.PubSub {
+	return s.Redis.Subscribe(ctx, models.InboxChannelKey(userID))
+}
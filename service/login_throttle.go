@@ -0,0 +1,237 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"test123/config"
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+	"test123/repositories"
+	"test123/utils"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Failed logins are kept per scope ("account" or "ip") in Redis:
+// login_failures:<scope>:<id> is a sorted set of failure times forming the
+// sliding window, login_delay:<scope>:<id> exists while the next attempt
+// must wait, and login_lock:<scope>:<id> while the account or IP is locked.
+const (
+	throttleAccount = "account"
+	throttleIP      = "ip"
+
+	loginFailuresPrefix = "login_failures:"
+	loginDelayPrefix    = "login_delay:"
+	loginLockPrefix     = "login_lock:"
+)
+
+// recordFailureScript adds a failure at ARGV[1] (unix ms), drops those
+// older than the window ARGV[2] (ms) and returns how many remain.
+var recordFailureScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', tonumber(ARGV[1]) - tonumber(ARGV[2]))
+redis.call('ZADD', KEYS[1], ARGV[1], ARGV[3])
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return redis.call('ZCARD', KEYS[1])
+`)
+
+// LoginThrottle counts failed password logins per account and per client
+// IP, delays further attempts and locks either out when failures pile up.
+type LoginThrottle struct {
+	Redis  *redis.Client
+	Outbox repositories.OutboxRepoInterface
+	Config config.LoginThrottle
+}
+
+func NewLoginThrottle(rdb *redis.Client, outbox repositories.OutboxRepoInterface, cfg config.LoginThrottle) *LoginThrottle {
+	return &LoginThrottle{Redis: rdb, Outbox: outbox, Config: cfg}
+}
+
+// loginAccount is what failures of a login count against: the user when
+// the name resolves to one, so the username and the email share a
+// counter, and otherwise the name itself, so unknown names are throttled
+// exactly like real ones.
+func loginAccount(user *models.User, name string) string {
+	if user != nil {
+		return "user:" + strconv.Itoa(user.ID)
+	}
+	return "name:" + strings.ToLower(strings.TrimSpace(name))
+}
+
+// canonicalIP writes an IP one way, so IPv6 spellings share a counter.
+func canonicalIP(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		return parsed.String()
+	}
+	return ip
+}
+
+func throttleKey(prefix, scope, id string) string {
+	return prefix + scope + ":" + id
+}
+
+// Wait returns how long the caller must wait before account may try to
+// log in from ip, or 0 when it may try now. Either may be empty when the
+// attempt has none.
+func (t *LoginThrottle) Wait(ctx context.Context, account, ip string) (time.Duration, error) {
+	ip = canonicalIP(ip)
+	var keys []string
+	if account != "" {
+		keys = append(keys,
+			throttleKey(loginLockPrefix, throttleAccount, account),
+			throttleKey(loginDelayPrefix, throttleAccount, account),
+		)
+	}
+	if ip != "" {
+		keys = append(keys,
+			throttleKey(loginLockPrefix, throttleIP, ip),
+			throttleKey(loginDelayPrefix, throttleIP, ip),
+		)
+	}
+
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	pipe := t.Redis.Pipeline()
+	cmds := make([]*redis.DurationCmd, len(keys))
+	for i, k := range keys {
+		cmds[i] = pipe.PTTL(ctx, k)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("%w: %v", errors.ErrCacheFailure, err)
+	}
+
+	var wait time.Duration
+	for _, cmd := range cmds {
+		// missing keys report a negative TTL
+		if d := cmd.Val(); d > wait {
+			wait = d
+		}
+	}
+	return wait, nil
+}
+
+// Failure records a failed login of account from ip. user is the account's
+// owner, or nil when the name matched nobody; only real users are told
+// their account was locked. An empty account counts against ip alone.
+func (t *LoginThrottle) Failure(ctx context.Context, account, ip string, user *models.User) error {
+	if account != "" {
+		if err := t.fail(ctx, throttleAccount, account, t.Config.AccountDelayAfter, t.Config.AccountLockoutAfter, t.Config.AccountLockout, user); err != nil {
+			return err
+		}
+	}
+	if ip == "" {
+		return nil
+	}
+	return t.fail(ctx, throttleIP, canonicalIP(ip), t.Config.IPDelayAfter, t.Config.IPLockoutAfter, t.Config.IPLockout, nil)
+}
+
+func (t *LoginThrottle) fail(ctx context.Context, scope, id string, delayAfter, lockoutAfter int, lockout time.Duration, user *models.User) error {
+	failures := throttleKey(loginFailuresPrefix, scope, id)
+	n, err := recordFailureScript.Run(ctx, t.Redis, []string{failures},
+		time.Now().UnixMilli(), t.Config.Window.Milliseconds(), uuid.NewString(),
+	).Int()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errors.ErrCacheFailure, err)
+	}
+
+	if n >= lockoutAfter {
+		locked, err := t.Redis.SetNX(ctx, throttleKey(loginLockPrefix, scope, id), 1, lockout).Result()
+		if err != nil {
+			return fmt.Errorf("%w: %v", errors.ErrCacheFailure, err)
+		}
+		// the lock replaces the window; counting starts over once it ends
+		t.Redis.Del(ctx, failures, throttleKey(loginDelayPrefix, scope, id))
+		if locked {
+			t.lockedOut(ctx, scope, id, lockout, user)
+		}
+		return nil
+	}
+
+	if n > delayAfter {
+		// double per failure past the threshold, capped; the shift is
+		// bounded so it cannot overflow
+		shift := n - delayAfter - 1
+		if shift > 20 {
+			shift = 20
+		}
+		delay := t.Config.BaseDelay << shift
+		if delay > t.Config.MaxDelay {
+			delay = t.Config.MaxDelay
+		}
+		if err := t.Redis.Set(ctx, throttleKey(loginDelayPrefix, scope, id), 1, delay).Err(); err != nil {
+			return fmt.Errorf("%w: %v", errors.ErrCacheFailure, err)
+		}
+	}
+	return nil
+}
+
+// lockedOut logs a new lockout and, for a real account, puts an
+// account_locked event on the notification bus.
+func (t *LoginThrottle) lockedOut(ctx context.Context, scope, id string, lockout time.Duration, user *models.User) {
+	logger.Warn("LoginThrottle", "login locked out", map[string]interface{}{
+		"scope":    scope,
+		"id":       id,
+		"duration": lockout.String(),
+	})
+	if user == nil {
+		return
+	}
+
+	event := utils.NewEmailNotificationEvent(
+		user.ID,
+		"account_locked",
+		"Your account was locked",
+		"Too many failed sign-in attempts",
+		user.Email,
+		map[string]string{
+			"username": user.Username,
+			"message":  fmt.Sprintf("after too many failed sign-in attempts, signing in to your account is blocked for %s. If this was not you, consider changing your password.", lockout),
+		},
+	)
+	if err := enqueueNotification(ctx, t.Outbox, nil, event); err != nil {
+		logger.Error("LoginThrottle", "failed to store lockout event", map[string]interface{}{"user_id": user.ID, "error": err.Error()})
+	}
+}
+
+// Success forgets the account's failures after a correct password. Those
+// of the IP stay, or one known password would reset a guessing run.
+func (t *LoginThrottle) Success(ctx context.Context, account string) {
+	t.Redis.Del(ctx,
+		throttleKey(loginFailuresPrefix, throttleAccount, account),
+		throttleKey(loginDelayPrefix, throttleAccount, account),
+	)
+}
+
+// UnlockUser lifts the user's lockout and forgets their failures.
+func (t *LoginThrottle) UnlockUser(ctx context.Context, userID int) error {
+	return t.unlock(ctx, throttleAccount, loginAccount(&models.User{ID: userID}, ""))
+}
+
+// UnlockIP lifts the lockout of a client IP and forgets its failures.
+func (t *LoginThrottle) UnlockIP(ctx context.Context, ip string) error {
+	if ip == "" {
+		return errors.ErrInvalidParams
+	}
+	return t.unlock(ctx, throttleIP, canonicalIP(ip))
+}
+
+func (t *LoginThrottle) unlock(ctx context.Context, scope, id string) error {
+	err := t.Redis.Del(ctx,
+		throttleKey(loginLockPrefix, scope, id),
+		throttleKey(loginDelayPrefix, scope, id),
+		throttleKey(loginFailuresPrefix, scope, id),
+	).Err()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errors.ErrCacheFailure, err)
+	}
+	logger.Info("LoginThrottle", "login unlocked", map[string]interface{}{"scope": scope, "id": id})
+	return nil
+}
@@ -0,0 +1,231 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"math/big"
+	"net/url"
+	"strconv"
+
+	"test123/logger"
+	"test123/models"
+	"test123/utils"
+)
+
+// Passwordless login follows the password reset flow: one active link or
+// code per user, stored in Redis under the TTL, and a counter of invalid
+// attempts that burns the code when it runs out. The active marker outlives
+// a burnt code, so no new code can be requested before the TTL is over.
+// Wrong codes and unknown links also count towards the login throttle.
+
+const (
+	MagicLinkModeLink = "link"
+	MagicLinkModeOTP  = "otp"
+)
+
+// SendMagicLink mails a one-time sign-in link, or a 6-digit code when mode
+// is "otp", to a request from ip. The answer does not reveal whether the
+// address is registered.
+func (s *AuthService) SendMagicLink(ctx context.Context, email, mode, ip string) (int, map[string]string) {
+	logger.Info("SendMagicLink", "called", map[string]interface{}{"email": email, "mode": mode})
+
+	if email == "" {
+		return 400, map[string]string{"error": "email required"}
+	}
+	if mode == "" {
+		mode = MagicLinkModeLink
+	}
+	if mode != MagicLinkModeLink && mode != MagicLinkModeOTP {
+		return 400, map[string]string{"error": "mode must be link or otp"}
+	}
+
+	sent := map[string]string{"message": "if the address belongs to an account, a sign-in " + mode + " is on its way"}
+
+	user, err := s.UserService.GetByUserByEmail(ctx, email)
+	if err != nil {
+		user = nil
+	}
+	if wait, err := s.Throttle.Wait(ctx, loginAccount(user, email), ip); err != nil {
+		logger.Error("SendMagicLink", "failed to check login throttle", map[string]interface{}{"error": err.Error()})
+		return 500, map[string]string{"error": "internal server error"}
+	} else if wait > 0 {
+		logger.Warn("SendMagicLink", "throttled", map[string]interface{}{"email": email, "ip": ip, "wait": wait.String()})
+		return 429, map[string]string{
+			"error":       "too many failed sign-in attempts, try again later",
+			"retry_after": strconv.Itoa(int(math.Ceil(wait.Seconds()))),
+		}
+	}
+	if user == nil {
+		logger.Warn("SendMagicLink", "user not found", map[string]interface{}{"email": email})
+		return 200, sent
+	}
+
+	// answered like a fresh send, or the reply would tell registered
+	// addresses apart
+	if active, _ := s.Redis.Get(ctx, "magic:active:"+user.Username).Result(); active != "" {
+		logger.Info("SendMagicLink", "active token exists", map[string]interface{}{"username": user.Username})
+		return 200, sent
+	}
+
+	expiresIn := strconv.Itoa(int(s.Config.MagicLinkTTL.Minutes()))
+	metadata := map[string]string{"username": user.Username, "expires_in_minutes": expiresIn}
+	var key, value, title, message string
+
+	if mode == MagicLinkModeOTP {
+		n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+		if err != nil {
+			logger.Error("SendMagicLink", "failed to generate code", map[string]interface{}{"error": err.Error()})
+			return 500, map[string]string{"error": "internal server error"}
+		}
+		code := fmt.Sprintf("%06d", n.Int64())
+
+		key, value = "magic_otp:"+user.Username, code
+		title, message = "Your sign-in code", "Use this code to sign in. It expires in "+expiresIn+" minutes."
+		metadata["code"] = code
+	} else {
+		tokenBytes := make([]byte, 32)
+		rand.Read(tokenBytes)
+		token := base64.URLEncoding.EncodeToString(tokenBytes)
+
+		key, value = "magic_token:"+token, user.Username
+		title, message = "Your sign-in link", "Click the link to sign in. It expires in "+expiresIn+" minutes."
+		metadata["magic_link"] = s.Config.MagicLinkURLBase + "?token=" + url.QueryEscape(token)
+	}
+
+	event := utils.NewEmailNotificationEvent(user.ID, "magic_link", title, message, user.Email, metadata)
+
+	if err := s.Redis.Set(ctx, key, value, s.Config.MagicLinkTTL).Err(); err != nil {
+		logger.Error("SendMagicLink", "failed to store token in redis", map[string]interface{}{"error": err.Error()})
+		return 500, map[string]string{"error": "internal server error"}
+	}
+	if err := s.Redis.Set(ctx, "magic:active:"+user.Username, mode, s.Config.MagicLinkTTL).Err(); err != nil {
+		logger.Error("SendMagicLink", "failed to store active token in redis", map[string]interface{}{"error": err.Error()})
+		return 500, map[string]string{"error": "failed to store active token reference"}
+	}
+
+	s.Redis.Set(ctx, "magic:invalid:"+user.Username, 0, s.Config.MagicLinkTTL)
+
+	if err := enqueueNotification(ctx, s.Outbox, nil, event); err != nil {
+		logger.Error("SendMagicLink", "failed to store notification event", map[string]interface{}{"error": err.Error()})
+		return 500, map[string]string{"error": "failed to send notification event"}
+	}
+
+	logger.Info("SendMagicLink", "sign-in "+mode+" sent", map[string]interface{}{"username": user.Username})
+	return 200, sent
+}
+
+// ConsumeMagicLink exchanges a sign-in link token, or an email address and
+// code, for the usual token pair (or an MFA challenge).
+func (s *AuthService) ConsumeMagicLink(ctx context.Context, token, email, code string, client models.SessionClient) (int, map[string]interface{}) {
+	logger.Info("ConsumeMagicLink", "called", nil)
+
+	var username string
+	switch {
+	case token != "":
+		if status, body := s.magicLinkThrottled(ctx, "", client.IP); status != 0 {
+			return status, body
+		}
+
+		// GETDEL makes the link single use even under concurrent clicks
+		u, err := s.Redis.GetDel(ctx, "magic_token:"+token).Result()
+		if err != nil {
+			s.magicLinkFailure(ctx, "", client.IP, nil)
+			logger.Error("ConsumeMagicLink", "invalid or expired token", nil)
+			return 400, map[string]interface{}{"error": "invalid or expired token"}
+		}
+		username = u
+
+	case email != "" && code != "":
+		user, err := s.UserService.GetByUserByEmail(ctx, email)
+		if err != nil {
+			user = nil
+		}
+		account := loginAccount(user, email)
+		if status, body := s.magicLinkThrottled(ctx, account, client.IP); status != 0 {
+			return status, body
+		}
+		if user == nil {
+			s.magicLinkFailure(ctx, account, client.IP, nil)
+			return 400, map[string]interface{}{"error": "invalid or expired code"}
+		}
+		username = user.Username
+
+		want, err := s.Redis.Get(ctx, "magic_otp:"+username).Result()
+		if err != nil {
+			s.magicLinkFailure(ctx, account, client.IP, user)
+			return 400, map[string]interface{}{"error": "invalid or expired code"}
+		}
+
+		key := "magic:invalid:" + username
+		count, _ := s.Redis.Get(ctx, key).Int()
+		if count >= s.Config.MagicLinkMaxAttempts {
+			// magic:active stays until the TTL ends, so the next code
+			// cannot be requested straight away
+			s.Redis.Del(ctx, "magic_otp:"+username)
+			logger.Error("ConsumeMagicLink", "too many invalid attempts", map[string]interface{}{"username": username})
+			return 429, map[string]interface{}{"error": "Too many invalid attempts. Request a new code later."}
+		}
+
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) != 1 {
+			s.Redis.Incr(ctx, key)
+			s.magicLinkFailure(ctx, account, client.IP, user)
+			logger.Error("ConsumeMagicLink", "wrong code", map[string]interface{}{"username": username})
+			return 401, map[string]interface{}{"error": "invalid or expired code"}
+		}
+		if n, _ := s.Redis.Del(ctx, "magic_otp:"+username).Result(); n == 0 {
+			return 400, map[string]interface{}{"error": "invalid or expired code"}
+		}
+		s.Throttle.Success(ctx, account)
+
+	default:
+		return 400, map[string]interface{}{"error": "token, or email and code, required"}
+	}
+
+	// Cleanup Redis keys
+	s.Redis.Del(ctx, "magic:active:"+username)
+	s.Redis.Del(ctx, "magic:invalid:"+username)
+
+	user, err := s.UserService.GetUserByUsername(ctx, username)
+	if err != nil {
+		return 400, map[string]interface{}{"error": "invalid or expired token"}
+	}
+
+	// reading the mail proves the address
+	if user.EmailVerifiedAt == nil {
+		if err := s.UserService.MarkEmailVerified(ctx, user.ID, user.Email); err != nil {
+			logger.Warn("ConsumeMagicLink", "failed to mark email verified", map[string]interface{}{"username": username, "error": err.Error()})
+		}
+	}
+
+	logger.Info("ConsumeMagicLink", "passwordless sign-in accepted", map[string]interface{}{"username": username})
+	return s.completeLogin(ctx, user, client)
+}
+
+// magicLinkThrottled returns the status and body to answer with while the
+// login throttle holds back account or ip, and 0 otherwise. account is
+// empty for link tokens, which name nobody until they are looked up.
+func (s *AuthService) magicLinkThrottled(ctx context.Context, account, ip string) (int, map[string]interface{}) {
+	wait, err := s.Throttle.Wait(ctx, account, ip)
+	if err != nil {
+		logger.Error("ConsumeMagicLink", "failed to check login throttle", map[string]interface{}{"error": err.Error()})
+		return 500, map[string]interface{}{"error": "internal server error"}
+	}
+	if wait > 0 {
+		logger.Warn("ConsumeMagicLink", "throttled", map[string]interface{}{"ip": ip, "wait": wait.String()})
+		return 429, map[string]interface{}{
+			"error":       "too many failed sign-in attempts, try again later",
+			"retry_after": int(math.Ceil(wait.Seconds())),
+		}
+	}
+	return 0, nil
+}
+
+func (s *AuthService) magicLinkFailure(ctx context.Context, account, ip string, user *models.User) {
+	if err := s.Throttle.Failure(ctx, account, ip, user); err != nil {
+		logger.Error("ConsumeMagicLink", "failed to record login failure", map[string]interface{}{"error": err.Error()})
+	}
+}
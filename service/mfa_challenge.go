@@ -0,0 +1,46 @@
+package service
+
+import (
+	"context"
+
+	"test123/models"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// A challenge is single use: its jti is stored in Redis with the number of
+// codes tried against it, and deleted once a code is accepted.
+
+const mfaChallengePrefix = "mfa_challenge:"
+
+// mfaAttemptScript counts a code attempt against a live challenge and
+// returns the new count, or -1 when the challenge is gone.
+var mfaAttemptScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return -1
+end
+return redis.call("INCR", KEYS[1])
+`)
+
+func (s *AuthService) issueMFAChallenge(ctx context.Context, user *models.User) (string, error) {
+	jti := uuid.NewString()
+	token, err := s.JWT.GenerateMFAChallenge(user.ID, s.Config.MFAChallengeTTL, jti)
+	if err != nil {
+		return "", err
+	}
+	if err := s.Redis.Set(ctx, mfaChallengePrefix+jti, 0, s.Config.MFAChallengeTTL).Err(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (s *AuthService) countMFAAttempt(ctx context.Context, jti string) (int, error) {
+	return mfaAttemptScript.Run(ctx, s.Redis, []string{mfaChallengePrefix + jti}).Int()
+}
+
+// spendMFAChallenge reports false when a concurrent request got there first.
+func (s *AuthService) spendMFAChallenge(ctx context.Context, jti string) (bool, error) {
+	n, err := s.Redis.Del(ctx, mfaChallengePrefix+jti).Result()
+	return n == 1, err
+}
@@ -0,0 +1,323 @@
+package service
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"test123/config"
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+	"test123/repositories"
+	"test123/utils"
+	"test123/utils/totp"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const recoveryCodeCount = 10
+
+// MFAService manages TOTP enrollment and checks second-factor codes.
+// Secrets are stored sealed under a key derived from the JWT secret;
+// recovery codes are stored as SHA-256 hashes and work once each.
+type MFAService struct {
+	Repo   repositories.MFARepoInterface
+	Users  *UserService
+	Outbox repositories.OutboxRepoInterface
+	Tx     repositories.Transactor
+	Issuer string
+
+	sealKey []byte
+}
+
+func NewMFAService(repo repositories.MFARepoInterface, users *UserService, outbox repositories.OutboxRepoInterface, tx repositories.Transactor, cfg config.Auth) *MFAService {
+	sum := sha256.Sum256([]byte("totp-secret:" + cfg.JWTSecret))
+	return &MFAService{
+		Repo:    repo,
+		Users:   users,
+		Outbox:  outbox,
+		Tx:      tx,
+		Issuer:  cfg.MFAIssuer,
+		sealKey: sum[:],
+	}
+}
+
+// Status reports whether TOTP is on and how many recovery codes are left.
+func (s *MFAService) Status(ctx context.Context, userID int) (*models.MFAStatus, error) {
+	st := &models.MFAStatus{}
+
+	t, err := s.Repo.GetTOTP(ctx, userID)
+	if err != nil && err != errors.ErrResourceNotFound {
+		return nil, err
+	}
+	if t == nil || t.ConfirmedAt == nil {
+		return st, nil
+	}
+
+	st.TOTPEnabled, st.EnabledAt = true, t.ConfirmedAt
+	if st.RecoveryCodesRemaining, err = s.Repo.CountRecoveryCodes(ctx, userID); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Enabled reports whether login needs a second factor for the user.
+func (s *MFAService) Enabled(ctx context.Context, userID int) (bool, error) {
+	t, err := s.Repo.GetTOTP(ctx, userID)
+	if err == errors.ErrResourceNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return t.ConfirmedAt != nil, nil
+}
+
+// Enroll starts (or restarts) TOTP setup and returns the secret with its
+// otpauth:// provisioning URI for a QR code. Nothing changes for login
+// until Confirm succeeds.
+func (s *MFAService) Enroll(ctx context.Context, userID int) (secret, uri string, err error) {
+	user, err := s.Users.GetUserByID(ctx, userID)
+	if err != nil {
+		return "", "", err
+	}
+
+	if secret, err = totp.GenerateSecret(); err != nil {
+		return "", "", fmt.Errorf("%w: %v", errors.ErrInternalFailure, err)
+	}
+	sealed, err := s.seal(userID, secret)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", errors.ErrInternalFailure, err)
+	}
+
+	if err := s.Repo.SavePendingTOTP(ctx, userID, sealed); err != nil {
+		return "", "", err
+	}
+
+	logger.Info("MFAService.Enroll", "totp enrollment started", map[string]interface{}{"user_id": userID})
+	return secret, totp.ProvisioningURI(s.Issuer, user.Email, secret), nil
+}
+
+// Confirm turns TOTP on once the user proves their app produces valid
+// codes, and returns freshly generated recovery codes. They are shown
+// this one time only.
+func (s *MFAService) Confirm(ctx context.Context, userID int, code string) ([]string, error) {
+	t, err := s.Repo.GetTOTP(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if t.ConfirmedAt != nil {
+		return nil, fmt.Errorf("%w: two-factor authentication is already enabled", errors.ErrAlreadyProcessed)
+	}
+
+	secret, err := s.open(userID, t.SecretSealed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrInternalFailure, err)
+	}
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return nil, fmt.Errorf("%w: code does not match", errors.ErrInvalidToken)
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrInternalFailure, err)
+	}
+
+	user, err := s.Users.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.Tx.WithTx(ctx, func(tx pgx.Tx) error {
+		if err := s.Repo.ConfirmTOTP(ctx, tx, userID, step); err != nil {
+			return err
+		}
+		if err := s.Repo.ReplaceRecoveryCodes(ctx, tx, userID, hashes); err != nil {
+			return err
+		}
+		return s.securityEvent(ctx, tx, user.ID, user.Username, user.Email,
+			"Two-factor authentication enabled",
+			"two-factor authentication was turned on for your account. If this was not you, contact support immediately.")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("MFAService.Confirm", "totp enabled", map[string]interface{}{"user_id": userID})
+	return codes, nil
+}
+
+// Disable turns TOTP off after checking a current code or recovery code.
+func (s *MFAService) Disable(ctx context.Context, userID int, code string) error {
+	if err := s.Verify(ctx, userID, code); err != nil {
+		return err
+	}
+
+	user, err := s.Users.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	err = s.Tx.WithTx(ctx, func(tx pgx.Tx) error {
+		if err := s.Repo.DeleteTOTP(ctx, tx, userID); err != nil {
+			return err
+		}
+		return s.securityEvent(ctx, tx, user.ID, user.Username, user.Email,
+			"Two-factor authentication disabled",
+			"two-factor authentication was turned off for your account. If this was not you, change your password and turn it back on.")
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Info("MFAService.Disable", "totp disabled", map[string]interface{}{"user_id": userID})
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after checking a
+// current code or recovery code.
+func (s *MFAService) RegenerateRecoveryCodes(ctx context.Context, userID int, code string) ([]string, error) {
+	if err := s.Verify(ctx, userID, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrInternalFailure, err)
+	}
+	if err := s.Repo.ReplaceRecoveryCodes(ctx, nil, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Verify checks a second-factor code: a 6-digit TOTP code, which is
+// refused if its time step was already used, or an unused recovery code,
+// which is spent.
+func (s *MFAService) Verify(ctx context.Context, userID int, code string) error {
+	t, err := s.Repo.GetTOTP(ctx, userID)
+	if err == errors.ErrResourceNotFound || (err == nil && t.ConfirmedAt == nil) {
+		return fmt.Errorf("%w: two-factor authentication is not enabled", errors.ErrResourceNotFound)
+	}
+	if err != nil {
+		return err
+	}
+
+	code = strings.TrimSpace(code)
+	if len(strings.ReplaceAll(code, " ", "")) == totp.Digits {
+		secret, err := s.open(userID, t.SecretSealed)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errors.ErrInternalFailure, err)
+		}
+		step, ok := totp.Validate(secret, code, time.Now())
+		if !ok {
+			return fmt.Errorf("%w: invalid code", errors.ErrUnauthorized)
+		}
+		fresh, err := s.Repo.UseTOTPStep(ctx, userID, step)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return fmt.Errorf("%w: code already used", errors.ErrUnauthorized)
+		}
+		return nil
+	}
+
+	used, err := s.Repo.UseRecoveryCode(ctx, userID, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return fmt.Errorf("%w: invalid code", errors.ErrUnauthorized)
+	}
+
+	logger.Warn("MFAService.Verify", "recovery code used", map[string]interface{}{"user_id": userID})
+	return nil
+}
+
+func (s *MFAService) securityEvent(ctx context.Context, db repositories.DBTX, userID int, username, email, title, message string) error {
+	event := utils.NewEmailNotificationEvent(
+		userID,
+		"security",
+		title,
+		message,
+		email,
+		map[string]string{
+			"username": username,
+			"message":  message,
+		},
+	)
+	return enqueueNotification(ctx, s.Outbox, db, event)
+}
+
+func (s *MFAService) seal(userID int, secret string) ([]byte, error) {
+	gcm, err := s.cipher()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	// bound to the user so a sealed secret cannot be copied to another row
+	return gcm.Seal(nonce, nonce, []byte(secret), []byte(strconv.Itoa(userID))), nil
+}
+
+func (s *MFAService) open(userID int, sealed []byte) (string, error) {
+	gcm, err := s.cipher()
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("sealed totp secret is truncated")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+
+	secret, err := gcm.Open(nil, nonce, ciphertext, []byte(strconv.Itoa(userID)))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt totp secret: %w", err)
+	}
+	return string(secret), nil
+}
+
+func (s *MFAService) cipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.sealKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+var recoveryEncoding = base32.NewEncoding("abcdefghijkmnpqrstuvwxyz23456789").WithPadding(base32.NoPadding)
+
+// newRecoveryCodes returns codes like "k3m9x-p2qrt" with their hashes.
+// At 50 random bits each a plain SHA-256 is enough.
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 7)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		raw := recoveryEncoding.EncodeToString(buf)[:10]
+		code := raw[:5] + "-" + raw[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"test123/config"
+	"test123/errors"
+	"test123/models"
+	"test123/repositories"
+	"test123/utils/totp"
+)
+
+// memoryMFA is an in-memory user_totp and user_recovery_codes table.
+type memoryMFA struct {
+	repositories.MFARepoInterface
+
+	mu       sync.Mutex
+	totp     map[int]*models.UserTOTP
+	recovery map[int]map[string]bool
+}
+
+func (r *memoryMFA) GetTOTP(ctx context.Context, userID int) (*models.UserTOTP, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.totp[userID]
+	if !ok {
+		return nil, errors.ErrResourceNotFound
+	}
+	c := *t
+	return &c, nil
+}
+
+// UseTOTPStep mirrors the repository: a step is only accepted when it is
+// later than the last accepted one.
+func (r *memoryMFA) UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.totp[userID]
+	if !ok || t.ConfirmedAt == nil || t.LastUsedStep >= step {
+		return false, nil
+	}
+	t.LastUsedStep = step
+	return true, nil
+}
+
+func (r *memoryMFA) UseRecoveryCode(ctx context.Context, userID int, hash string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.recovery[userID][hash] {
+		return false, nil
+	}
+	delete(r.recovery[userID], hash)
+	return true, nil
+}
+
+// newEnrolledMFA returns an MFAService with TOTP confirmed for user 1, its
+// secret and its recovery codes.
+func newEnrolledMFA(t *testing.T) (*MFAService, string, []string) {
+	t.Helper()
+	repo := &memoryMFA{totp: map[int]*models.UserTOTP{}, recovery: map[int]map[string]bool{}}
+	s := NewMFAService(repo, nil, nil, nil, config.Auth{JWTSecret: "test-secret-0123456789", MFAIssuer: "test"})
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret: %v", err)
+	}
+	sealed, err := s.seal(1, secret)
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	confirmed := time.Now().Add(-time.Hour)
+	repo.totp[1] = &models.UserTOTP{UserID: 1, SecretSealed: sealed, ConfirmedAt: &confirmed}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatalf("newRecoveryCodes: %v", err)
+	}
+	repo.recovery[1] = map[string]bool{}
+	for _, h := range hashes {
+		repo.recovery[1][h] = true
+	}
+	return s, secret, codes
+}
+
+func codeAtStep(t *testing.T, secret string, step int64) string {
+	t.Helper()
+	c, err := totp.Code(secret, step)
+	if err != nil {
+		t.Fatalf("totp.Code: %v", err)
+	}
+	return c
+}
+
+func TestVerifyRefusesReplayedSteps(t *testing.T) {
+	ctx := context.Background()
+	s, secret, _ := newEnrolledMFA(t)
+	now := totp.Step(time.Now())
+
+	// each attempt runs after the ones before it
+	attempts := []struct {
+		name string
+		code string
+		ok   bool
+	}{
+		{"previous step", codeAtStep(t, secret, now-1), true},
+		{"same code again", codeAtStep(t, secret, now-1), false},
+		{"current step", codeAtStep(t, secret, now), true},
+		{"current step again", codeAtStep(t, secret, now), false},
+		{"older step after a newer one", codeAtStep(t, secret, now-1), false},
+		{"wrong code", "000000", false},
+	}
+
+	for _, a := range attempts {
+		err := s.Verify(ctx, 1, a.code)
+		if a.ok && err != nil {
+			t.Fatalf("%s: Verify failed: %v", a.name, err)
+		}
+		if !a.ok && err == nil {
+			t.Fatalf("%s: Verify accepted the code", a.name)
+		}
+	}
+}
+
+func TestVerifyRecoveryCodesWorkOnce(t *testing.T) {
+	ctx := context.Background()
+	s, _, codes := newEnrolledMFA(t)
+
+	attempts := []struct {
+		name string
+		code string
+		ok   bool
+	}{
+		{"unused code", codes[0], true},
+		{"same code again", codes[0], false},
+		{"another code, upper case without dash", strings.ToUpper(strings.ReplaceAll(codes[1], "-", "")), true},
+		{"unknown code", "aaaaa-bbbbb", false},
+	}
+
+	for _, a := range attempts {
+		err := s.Verify(ctx, 1, a.code)
+		if a.ok && err != nil {
+			t.Fatalf("%s: Verify failed: %v", a.name, err)
+		}
+		if !a.ok && err == nil {
+			t.Fatalf("%s: Verify accepted the code", a.name)
+		}
+	}
+}
+
+func TestVerifyWithoutEnrollment(t *testing.T) {
+	s, secret, _ := newEnrolledMFA(t)
+	err := s.Verify(context.Background(), 2, codeAtStep(t, secret, totp.Step(time.Now())))
+	if err == nil {
+		t.Fatal("Verify accepted a code for a user without two-factor")
+	}
+}
+
+// TestLoginMFAThrottled checks wrong second factors count towards the login
+// throttle, so asking for a fresh challenge per guess does not lift the
+// limit, and that a locked account is refused before its code is checked.
+func TestLoginMFAThrottled(t *testing.T) {
+	ctx := context.Background()
+	rdb, _ := newTestRedis(t)
+	mfa, secret, _ := newEnrolledMFA(t)
+	outbox := &memoryOutbox{}
+	s := &AuthService{
+		UserService: &UserService{UserRepo: newMemoryUsers(models.User{ID: 1, Username: "alice", Email: "alice@example.com"})},
+		Redis:       rdb,
+		JWT:         newTestJWT(t),
+		MFA:         mfa,
+		Throttle: NewLoginThrottle(rdb, outbox, config.LoginThrottle{
+			Window:              time.Hour,
+			AccountDelayAfter:   10,
+			AccountLockoutAfter: 3,
+			AccountLockout:      15 * time.Minute,
+			IPDelayAfter:        10,
+			IPLockoutAfter:      10,
+			IPLockout:           time.Minute,
+		}),
+		Config: config.Auth{MFAChallengeTTL: 5 * time.Minute, MFAMaxAttempts: 5},
+	}
+	client := models.SessionClient{IP: "203.0.113.7"}
+	user := &models.User{ID: 1}
+
+	code := codeAtStep(t, secret, time.Now().Unix()/30)
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+
+	for i := 0; i < 3; i++ {
+		challenge, err := s.issueMFAChallenge(ctx, user)
+		if err != nil {
+			t.Fatalf("issueMFAChallenge: %v", err)
+		}
+		if status, body := s.LoginMFA(ctx, challenge, wrong, client); status != 401 {
+			t.Fatalf("wrong code %d: got %d %v, want 401", i+1, status, body)
+		}
+	}
+	if got := outbox.actions(); len(got) != 1 || got[0] != "account_locked" {
+		t.Errorf("queued %v, want the account_locked notice", got)
+	}
+
+	challenge, err := s.issueMFAChallenge(ctx, user)
+	if err != nil {
+		t.Fatalf("issueMFAChallenge: %v", err)
+	}
+	status, body := s.LoginMFA(ctx, challenge, code, client)
+	if status != 429 || body["retry_after"] == nil {
+		t.Fatalf("right code on a locked account: got %d %v, want 429 with retry_after", status, body)
+	}
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+	"test123/repositories"
+)
+
+// OAuthClientService registers the applications allowed to get tokens
+// through OAuth2. Confidential clients get a secret that is shown once and
+// stored hashed; public clients have none and must use PKCE.
+type OAuthClientService struct {
+	Repo repositories.OAuthClientRepoInterface
+}
+
+func NewOAuthClientService(repo repositories.OAuthClientRepoInterface) *OAuthClientService {
+	return &OAuthClientService{Repo: repo}
+}
+
+func (s *OAuthClientService) List(ctx context.Context) ([]models.OAuthClient, error) {
+	return s.Repo.List(ctx)
+}
+
+func (s *OAuthClientService) Get(ctx context.Context, id string) (*models.OAuthClient, error) {
+	if id == "" {
+		return nil, errors.ErrInvalidParams
+	}
+	return s.Repo.Get(ctx, id)
+}
+
+// Create registers a client and returns it with its secret, which is empty
+// for public clients.
+func (s *OAuthClientService) Create(ctx context.Context, c models.OAuthClient) (*models.OAuthClient, string, error) {
+	if err := s.validate(ctx, &c); err != nil {
+		return nil, "", err
+	}
+
+	id, err := randomHex(16)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", errors.ErrInternalFailure, err)
+	}
+	c.ID = "cl_" + id
+
+	var secret string
+	if !c.Public {
+		if secret, err = generateClientSecret(); err != nil {
+			return nil, "", fmt.Errorf("%w: %v", errors.ErrInternalFailure, err)
+		}
+		c.SecretHash = hashClientSecret(secret)
+	}
+
+	created, err := s.Repo.Create(ctx, c)
+	if err != nil {
+		return nil, "", err
+	}
+
+	logger.Info("OAuthClientService.Create", "oauth client registered", map[string]interface{}{
+		"client_id": created.ID,
+		"name":      created.Name,
+		"public":    created.Public,
+	})
+	return created, secret, nil
+}
+
+func (s *OAuthClientService) Update(ctx context.Context, c models.OAuthClient) (*models.OAuthClient, error) {
+	current, err := s.Get(ctx, c.ID)
+	if err != nil {
+		return nil, err
+	}
+	c.Public = current.Public
+	if err := s.validate(ctx, &c); err != nil {
+		return nil, err
+	}
+
+	logger.Info("OAuthClientService.Update", "updating oauth client", map[string]interface{}{"client_id": c.ID})
+	return s.Repo.Update(ctx, c)
+}
+
+func (s *OAuthClientService) Delete(ctx context.Context, id string) error {
+	if id == "" {
+		return errors.ErrInvalidParams
+	}
+	return s.Repo.Delete(ctx, id)
+}
+
+// RotateSecret replaces a confidential client's secret. The old one stops
+// working immediately.
+func (s *OAuthClientService) RotateSecret(ctx context.Context, id string) (string, error) {
+	c, err := s.Get(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if c.Public {
+		return "", fmt.Errorf("%w: public clients have no secret", errors.ErrValidationFailed)
+	}
+
+	secret, err := generateClientSecret()
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errors.ErrInternalFailure, err)
+	}
+	if err := s.Repo.SetSecret(ctx, id, hashClientSecret(secret)); err != nil {
+		return "", err
+	}
+
+	logger.Info("OAuthClientService.RotateSecret", "client secret rotated", map[string]interface{}{"client_id": id})
+	return secret, nil
+}
+
+// Authenticate checks client credentials. Public clients authenticate by
+// id alone and must not send a secret.
+func (s *OAuthClientService) Authenticate(ctx context.Context, id, secret string) (*models.OAuthClient, error) {
+	if id == "" {
+		return nil, fmt.Errorf("%w: client_id required", errors.ErrUnauthorized)
+	}
+
+	c, err := s.Repo.Get(ctx, id)
+	if err != nil {
+		if err == errors.ErrResourceNotFound {
+			return nil, fmt.Errorf("%w: unknown client", errors.ErrUnauthorized)
+		}
+		return nil, err
+	}
+
+	if c.Public {
+		if secret != "" {
+			return nil, fmt.Errorf("%w: public clients have no secret", errors.ErrUnauthorized)
+		}
+		return c, nil
+	}
+	if secret == "" || subtle.ConstantTimeCompare([]byte(hashClientSecret(secret)), []byte(c.SecretHash)) != 1 {
+		logger.Warn("OAuthClientService.Authenticate", "wrong client secret", map[string]interface{}{"client_id": id})
+		return nil, fmt.Errorf("%w: invalid client credentials", errors.ErrUnauthorized)
+	}
+	return c, nil
+}
+
+// validate checks the registration and that every scope names a
+// permission or is an OpenID Connect scope.
+func (s *OAuthClientService) validate(ctx context.Context, c *models.OAuthClient) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	permissions := make([]string, 0, len(c.Scopes))
+	for _, sc := range c.Scopes {
+		if !models.IsOIDCScope(sc) {
+			permissions = append(permissions, sc)
+		}
+	}
+	unknown, err := s.Repo.UnknownPermissions(ctx, permissions)
+	if err != nil {
+		return err
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("%w: unknown scopes %s", errors.ErrInvalidField, strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+func generateClientSecret() (string, error) {
+	s, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+	return "cs_" + s, nil
+}
+
+func hashClientSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
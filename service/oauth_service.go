@@ -0,0 +1,457 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"test123/config"
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+	"test123/requests"
+	"test123/utils"
+	"test123/utils/jwt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// OAuthError is an RFC 6749 error: Code is one of the standard error
+// codes and goes out as "error", Description as "error_description".
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (e *OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// Status is the HTTP status the token endpoint answers with.
+func (e *OAuthError) Status() int {
+	switch e.Code {
+	case "invalid_client":
+		return 401
+	case "server_error":
+		return 500
+	default:
+		return 400
+	}
+}
+
+func oauthError(code, description string) *OAuthError {
+	return &OAuthError{Code: code, Description: description}
+}
+
+// errOAuthMFARequired tells the login form to ask for a second factor.
+var errOAuthMFARequired = fmt.Errorf("%w: two-factor code required", errors.ErrUnauthorized)
+
+// IsOAuthMFARequired reports whether AuthenticateUser needs a TOTP or
+// recovery code on top of the password.
+func IsOAuthMFARequired(err error) bool {
+	return err == errOAuthMFARequired
+}
+
+// OAuthService is the OAuth2 authorization server. Tokens are the same
+// JWTs /auth/login hands out, narrowed by a jwt.Grant to the client and
+// the scopes (permission names) it was given. User tokens live in the
+// user's session list like any other sign-in.
+type OAuthService struct {
+	Auth     *AuthService
+	Clients  *OAuthClientService
+	Profiles *ProfileService
+	Redis    *redis.Client
+	Config   config.Auth
+}
+
+func NewOAuthService(auth *AuthService, clients *OAuthClientService, profiles *ProfileService, rdb *redis.Client, cfg config.Auth) *OAuthService {
+	return &OAuthService{Auth: auth, Clients: clients, Profiles: profiles, Redis: rdb, Config: cfg}
+}
+
+// authorizationCode is what an issued code stands for. It is kept in Redis
+// under the hash of the code, so a leaked keyspace does not leak codes.
+type authorizationCode struct {
+	ClientID      string `json:"client_id"`
+	RedirectURI   string `json:"redirect_uri"`
+	Scope         string `json:"scope"`
+	UserID        int    `json:"user_id"`
+	CodeChallenge string `json:"code_challenge,omitempty"`
+	// OpenID Connect: echoed into the id_token
+	Nonce    string `json:"nonce,omitempty"`
+	AuthTime int64  `json:"auth_time"`
+}
+
+func oauthCodeKey(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return "oauth_code:" + hex.EncodeToString(sum[:])
+}
+
+// ResolveClient looks up the client of an authorization request and the
+// redirect URI to answer on. Its errors must be shown to the user, never
+// redirected: the redirect URI is not trusted until it matches.
+func (s *OAuthService) ResolveClient(ctx context.Context, clientID, redirectURI string) (*models.OAuthClient, string, error) {
+	if clientID == "" {
+		return nil, "", fmt.Errorf("%w: client_id", errors.ErrMissingField)
+	}
+	client, err := s.Clients.Get(ctx, clientID)
+	if err != nil {
+		if err == errors.ErrResourceNotFound {
+			return nil, "", fmt.Errorf("%w: unknown client", errors.ErrInvalidParams)
+		}
+		return nil, "", err
+	}
+
+	if redirectURI == "" {
+		if len(client.RedirectURIs) != 1 {
+			return nil, "", fmt.Errorf("%w: redirect_uri", errors.ErrMissingField)
+		}
+		redirectURI = client.RedirectURIs[0]
+	}
+	if !client.AllowsRedirect(redirectURI) {
+		return nil, "", fmt.Errorf("%w: redirect_uri is not registered for this client", errors.ErrInvalidParams)
+	}
+	return client, redirectURI, nil
+}
+
+// CheckAuthorize validates the rest of an authorization request and
+// returns the scope to grant. Its errors go back to the client on the
+// redirect URI.
+func (s *OAuthService) CheckAuthorize(ctx context.Context, client *models.OAuthClient, req requests.OAuthAuthorizeReq) (string, *OAuthError) {
+	if req.ResponseType != "code" {
+		return "", oauthError("unsupported_response_type", "only response_type=code is supported")
+	}
+	if !client.AllowsGrant(models.OAuthGrantAuthorizationCode) {
+		return "", oauthError("unauthorized_client", "client may not use the authorization code grant")
+	}
+
+	if req.CodeChallenge != "" && req.CodeChallengeMethod != "S256" {
+		return "", oauthError("invalid_request", "code_challenge_method must be S256")
+	}
+	if req.CodeChallenge == "" && client.Public {
+		return "", oauthError("invalid_request", "public clients must use PKCE")
+	}
+
+	return s.resolveScope(client, req.Scope, nil)
+}
+
+// AuthenticateUser checks the credentials typed into the authorization
+// form: the password and, when the user has two-factor on, a TOTP or
+// recovery code. Wrong codes count towards the login throttle like wrong
+// passwords do.
+func (s *OAuthService) AuthenticateUser(ctx context.Context, username, password, code, ip string) (*models.User, error) {
+	user, _, body := s.Auth.checkPassword(ctx, username, password, ip)
+	if user == nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrUnauthorized, body["error"])
+	}
+	if s.Auth.UserService.Policy.Expired(user) {
+		return nil, fmt.Errorf("%w: %s", errors.ErrForbidden, errPasswordExpired)
+	}
+
+	mfa, err := s.Auth.MFA.Enabled(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if mfa {
+		if code == "" {
+			return nil, errOAuthMFARequired
+		}
+		if err := s.Auth.MFA.Verify(ctx, user.ID, code); err != nil {
+			if ferr := s.Auth.Throttle.Failure(ctx, loginAccount(user, username), ip, user); ferr != nil {
+				logger.Error("OAuthService.AuthenticateUser", "failed to record login failure", map[string]interface{}{"error": ferr.Error()})
+			}
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+// IssueCode stores a one-time authorization code for userID, who signed
+// in at authTime. req.RedirectURI is as the client sent it, empty when it
+// relied on its only registered URI.
+func (s *OAuthService) IssueCode(ctx context.Context, client *models.OAuthClient, req requests.OAuthAuthorizeReq, scope string, userID int, authTime time.Time) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("%w: %v", errors.ErrInternalFailure, err)
+	}
+	code := base64.RawURLEncoding.EncodeToString(b)
+
+	data, err := json.Marshal(authorizationCode{
+		ClientID:      client.ID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         scope,
+		UserID:        userID,
+		CodeChallenge: req.CodeChallenge,
+		Nonce:         req.Nonce,
+		AuthTime:      authTime.Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errors.ErrInternalFailure, err)
+	}
+	if err := s.Redis.Set(ctx, oauthCodeKey(code), data, s.Config.OAuthCodeTTL).Err(); err != nil {
+		return "", fmt.Errorf("%w: %v", errors.ErrCacheFailure, err)
+	}
+
+	logger.Info("OAuthService.IssueCode", "authorization code issued", map[string]interface{}{"client_id": client.ID, "user_id": userID, "scope": scope})
+	return code, nil
+}
+
+// Token serves the token endpoint. basicID and basicSecret are the HTTP
+// Basic credentials, if any.
+func (s *OAuthService) Token(ctx context.Context, req requests.OAuthTokenReq, basicID, basicSecret string, device models.SessionClient) (map[string]interface{}, *OAuthError) {
+	client, oerr := s.authenticateClient(ctx, req.ClientID, req.ClientSecret, basicID, basicSecret)
+	if oerr != nil {
+		return nil, oerr
+	}
+
+	if req.GrantType == "" {
+		return nil, oauthError("invalid_request", "grant_type required")
+	}
+	if !client.AllowsGrant(req.GrantType) {
+		return nil, oauthError("unauthorized_client", "client may not use the "+req.GrantType+" grant")
+	}
+
+	switch req.GrantType {
+	case models.OAuthGrantAuthorizationCode:
+		return s.exchangeCode(ctx, client, req, device)
+	case models.OAuthGrantClientCredentials:
+		return s.clientCredentials(client, req.Scope)
+	case models.OAuthGrantRefreshToken:
+		return s.refresh(ctx, client, req)
+	default:
+		return nil, oauthError("unsupported_grant_type", "unsupported grant_type "+req.GrantType)
+	}
+}
+
+// authenticateClient checks the credentials a client sent in the form or,
+// as basicID and basicSecret, with HTTP Basic; only one may be used.
+func (s *OAuthService) authenticateClient(ctx context.Context, formID, formSecret, basicID, basicSecret string) (*models.OAuthClient, *OAuthError) {
+	clientID, secret := formID, formSecret
+	if basicID != "" {
+		if formSecret != "" || (formID != "" && formID != basicID) {
+			return nil, oauthError("invalid_request", "use one client authentication method")
+		}
+		clientID, secret = basicID, basicSecret
+	}
+
+	client, err := s.Clients.Authenticate(ctx, clientID, secret)
+	if err != nil {
+		if utils.HttpStatusFromError(err) == 401 {
+			return nil, oauthError("invalid_client", "client authentication failed")
+		}
+		logger.Error("OAuthService.authenticateClient", "failed to authenticate client", map[string]interface{}{"client_id": clientID, "error": err.Error()})
+		return nil, oauthError("server_error", "failed to authenticate client")
+	}
+	return client, nil
+}
+
+func (s *OAuthService) exchangeCode(ctx context.Context, client *models.OAuthClient, req requests.OAuthTokenReq, device models.SessionClient) (map[string]interface{}, *OAuthError) {
+	if req.Code == "" {
+		return nil, oauthError("invalid_request", "code required")
+	}
+
+	// GETDEL makes the code single use even under concurrent requests
+	raw, err := s.Redis.GetDel(ctx, oauthCodeKey(req.Code)).Result()
+	if err != nil {
+		if err != redis.Nil {
+			logger.Error("OAuthService.exchangeCode", "failed to load code", map[string]interface{}{"error": err.Error()})
+			return nil, oauthError("server_error", "failed to redeem code")
+		}
+		return nil, oauthError("invalid_grant", "code is invalid or expired")
+	}
+
+	var code authorizationCode
+	if err := json.Unmarshal([]byte(raw), &code); err != nil {
+		return nil, oauthError("invalid_grant", "code is invalid or expired")
+	}
+	if code.ClientID != client.ID {
+		logger.Warn("OAuthService.exchangeCode", "code presented by another client", map[string]interface{}{"client_id": client.ID, "issued_to": code.ClientID})
+		return nil, oauthError("invalid_grant", "code is invalid or expired")
+	}
+	// RFC 6749 4.1.3: required, and must match, only if it was part of the
+	// authorization request
+	if code.RedirectURI != "" && req.RedirectURI != code.RedirectURI {
+		return nil, oauthError("invalid_grant", "redirect_uri does not match the authorization request")
+	}
+	if !verifyPKCE(code.CodeChallenge, req.CodeVerifier) {
+		return nil, oauthError("invalid_grant", "code_verifier does not match the code_challenge")
+	}
+
+	user, err := s.Auth.UserService.GetUserByID(ctx, code.UserID)
+	if err != nil {
+		return nil, oauthError("invalid_grant", "the user no longer exists")
+	}
+
+	sid := NewSessionID()
+	grant := &jwt.Grant{ClientID: client.ID, Scope: code.Scope}
+	tokens, err := s.Auth.signTokenPair(user, sid, grant)
+	if err != nil {
+		logger.Error("OAuthService.exchangeCode", "failed to generate tokens", map[string]interface{}{"client_id": client.ID, "error": err.Error()})
+		return nil, oauthError("server_error", "failed to generate tokens")
+	}
+
+	device.Device = "oauth:" + client.Name
+	if _, err := s.Auth.Sessions.Create(ctx, sid, user.ID, device, tokens.JTI); err != nil {
+		logger.Error("OAuthService.exchangeCode", "failed to create session", map[string]interface{}{"client_id": client.ID, "error": err.Error()})
+		return nil, oauthError("server_error", "failed to create session")
+	}
+
+	var idToken string
+	if scopes := strings.Fields(code.Scope); contains(scopes, models.OIDCScopeOpenID) {
+		if idToken, err = s.issueIDToken(ctx, user, client.ID, scopes, sid, code.Nonce, code.AuthTime); err != nil {
+			logger.Error("OAuthService.exchangeCode", "failed to generate id token", map[string]interface{}{"client_id": client.ID, "error": err.Error()})
+			return nil, oauthError("server_error", "failed to generate tokens")
+		}
+	}
+
+	logger.Info("OAuthService.exchangeCode", "authorization code redeemed", map[string]interface{}{"client_id": client.ID, "user_id": user.ID, "sid": sid})
+	return s.tokenResponse(client, tokens, code.Scope, idToken), nil
+}
+
+func (s *OAuthService) clientCredentials(client *models.OAuthClient, requested string) (map[string]interface{}, *OAuthError) {
+	scope, oerr := s.resolveScope(client, requested, nil)
+	if oerr != nil {
+		return nil, oerr
+	}
+
+	token, err := s.Auth.JWT.GenerateClientToken(client.ID, s.Config.OAuthClientTokenTTL, scope)
+	if err != nil {
+		logger.Error("OAuthService.clientCredentials", "failed to generate token", map[string]interface{}{"client_id": client.ID, "error": err.Error()})
+		return nil, oauthError("server_error", "failed to generate token")
+	}
+
+	logger.Info("OAuthService.clientCredentials", "client token issued", map[string]interface{}{"client_id": client.ID, "scope": scope})
+	return map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(s.Config.OAuthClientTokenTTL.Seconds()),
+		"scope":        scope,
+	}, nil
+}
+
+// refresh rotates an OAuth refresh token like /auth/access-token does. The
+// scope may be narrowed, never widened, and loses whatever the client is
+// no longer registered for.
+func (s *OAuthService) refresh(ctx context.Context, client *models.OAuthClient, req requests.OAuthTokenReq) (map[string]interface{}, *OAuthError) {
+	if req.RefreshToken == "" {
+		return nil, oauthError("invalid_request", "refresh_token required")
+	}
+
+	rt, err := refreshClaims(s.Auth.JWT, req.RefreshToken)
+	if err != nil || rt.Grant == nil || rt.Grant.ClientID != client.ID {
+		return nil, oauthError("invalid_grant", "refresh token is invalid or was issued to another client")
+	}
+
+	requested := req.Scope
+	if requested == "" {
+		requested = rt.Grant.Scope
+	}
+	scope, oerr := s.resolveScope(client, requested, strings.Fields(rt.Grant.Scope))
+	if oerr != nil {
+		return nil, oerr
+	}
+
+	user, err := s.Auth.UserService.GetUserByID(ctx, rt.UserID)
+	if err != nil {
+		return nil, oauthError("invalid_grant", "the user no longer exists")
+	}
+
+	// sign first: once the session moves on, the presented token is spent
+	tokens, err := s.Auth.signTokenPair(user, rt.SID, &jwt.Grant{ClientID: client.ID, Scope: scope})
+	if err != nil {
+		logger.Error("OAuthService.refresh", "failed to generate tokens", map[string]interface{}{"client_id": client.ID, "error": err.Error()})
+		return nil, oauthError("server_error", "failed to generate tokens")
+	}
+
+	result, err := s.Auth.Sessions.Rotate(ctx, user.ID, rt.SID, rt.JTI, tokens.JTI)
+	if err != nil {
+		logger.Error("OAuthService.refresh", "failed to rotate refresh token", map[string]interface{}{"client_id": client.ID, "error": err.Error()})
+		return nil, oauthError("server_error", "failed to rotate refresh token")
+	}
+
+	switch result {
+	case refreshRotated:
+	case refreshReplayed:
+		s.Auth.handleRefreshReuse(ctx, user, rt.SID)
+		return nil, oauthError("invalid_grant", "refresh token already used")
+	default:
+		return nil, oauthError("invalid_grant", "refresh token is invalid or expired")
+	}
+
+	var idToken string
+	if scopes := strings.Fields(scope); contains(scopes, models.OIDCScopeOpenID) {
+		if idToken, err = s.issueIDToken(ctx, user, client.ID, scopes, rt.SID, "", 0); err != nil {
+			logger.Error("OAuthService.refresh", "failed to generate id token", map[string]interface{}{"client_id": client.ID, "error": err.Error()})
+			return nil, oauthError("server_error", "failed to generate tokens")
+		}
+	}
+
+	logger.Info("OAuthService.refresh", "token pair rotated", map[string]interface{}{"client_id": client.ID, "user_id": user.ID, "sid": rt.SID})
+	return s.tokenResponse(client, tokens, scope, idToken), nil
+}
+
+func (s *OAuthService) tokenResponse(client *models.OAuthClient, tokens *tokenPair, scope, idToken string) map[string]interface{} {
+	resp := map[string]interface{}{
+		"access_token": tokens.Access,
+		"token_type":   "Bearer",
+		"expires_in":   int(s.Config.AccessTokenTTL.Seconds()),
+		"scope":        scope,
+	}
+	if client.AllowsGrant(models.OAuthGrantRefreshToken) {
+		resp["refresh_token"] = tokens.Refresh
+	}
+	if idToken != "" {
+		resp["id_token"] = idToken
+	}
+	return resp
+}
+
+// resolveScope turns a requested scope into the one to grant: by default
+// everything the client is registered for. Each scope must be registered
+// for the client and, when within is given, be one of those.
+func (s *OAuthService) resolveScope(client *models.OAuthClient, requested string, within []string) (string, *OAuthError) {
+	scopes := strings.Fields(requested)
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+
+	granted := make([]string, 0, len(scopes))
+	for _, sc := range scopes {
+		if !client.AllowsScope(sc) {
+			return "", oauthError("invalid_scope", "scope "+sc+" is not allowed for this client")
+		}
+		if within != nil && !contains(within, sc) {
+			return "", oauthError("invalid_scope", "scope "+sc+" was not granted")
+		}
+		granted = append(granted, sc)
+	}
+	return strings.Join(granted, " "), nil
+}
+
+// verifyPKCE checks an S256 code_verifier. Codes issued without a
+// challenge must be redeemed without a verifier.
+func verifyPKCE(challenge, verifier string) bool {
+	if challenge == "" {
+		return verifier == ""
+	}
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(challenge)) == 1
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
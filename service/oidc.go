@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+)
+
+// OpenID Connect sits on the OAuth2 server: an authorization request with
+// the openid scope also gets an id_token, and the profile, email, phone
+// and address scopes decide which user claims it and /userinfo carry.
+
+// oidcClaims are the claims published in discovery.
+var oidcClaims = []string{
+	"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "sid",
+	"name", "preferred_username", "picture", "birthdate", "updated_at",
+	"email", "email_verified", "phone_number", "phone_number_verified", "address",
+}
+
+// userClaims maps a user and their profile onto the standard claims the
+// scopes ask for.
+func (s *OAuthService) userClaims(ctx context.Context, user *models.User, scopes []string) map[string]interface{} {
+	claims := map[string]interface{}{"sub": strconv.Itoa(user.ID)}
+
+	var profile *models.UserProfile
+	if contains(scopes, models.OIDCScopeProfile) || contains(scopes, models.OIDCScopeAddress) {
+		// a missing profile just means fewer claims
+		profile, _ = s.Profiles.GetProfileByUserID(ctx, user.ID)
+	}
+
+	if contains(scopes, models.OIDCScopeProfile) {
+		claims["name"] = user.Name
+		claims["preferred_username"] = user.Username
+		claims["updated_at"] = user.CreatedAt.Unix()
+		if profile != nil {
+			if profile.AvatarURL != "" {
+				claims["picture"] = profile.AvatarURL
+			}
+			if profile.DOB != nil {
+				claims["birthdate"] = profile.DOB.Format("2006-01-02")
+			}
+			claims["updated_at"] = profile.UpdatedAt.Unix()
+		}
+	}
+	if contains(scopes, models.OIDCScopeEmail) {
+		claims["email"] = user.Email
+		claims["email_verified"] = user.EmailVerifiedAt != nil
+	}
+	if contains(scopes, models.OIDCScopePhone) && user.MobileNumber != "" {
+		claims["phone_number"] = user.MobileNumber
+		claims["phone_number_verified"] = user.MobileVerifiedAt != nil
+	}
+	if contains(scopes, models.OIDCScopeAddress) && profile != nil && profile.Location != "" {
+		claims["address"] = map[string]string{"formatted": profile.Location}
+	}
+	return claims
+}
+
+// issueIDToken signs an id_token for clientID about the session sid.
+// nonce and authTime are left out when empty.
+func (s *OAuthService) issueIDToken(ctx context.Context, user *models.User, clientID string, scopes []string, sid, nonce string, authTime int64) (string, error) {
+	claims := s.userClaims(ctx, user, scopes)
+	claims["sid"] = sid
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	if authTime > 0 {
+		claims["auth_time"] = authTime
+	}
+	return s.Auth.JWT.GenerateIDToken(s.Config.Issuer, clientID, user.ID, s.Config.IDTokenTTL, claims)
+}
+
+// UserInfo returns the claims about the token's user that its scopes
+// allow. First-party tokens see every claim.
+func (s *OAuthService) UserInfo(ctx context.Context, p *models.Principal) (map[string]interface{}, error) {
+	if p.UserID == 0 {
+		return nil, fmt.Errorf("%w: client tokens have no user", errors.ErrForbidden)
+	}
+	scopes := p.Scopes
+	if scopes == nil {
+		scopes = models.OIDCScopes
+	}
+	if !contains(scopes, models.OIDCScopeOpenID) {
+		return nil, fmt.Errorf("%w: token was not granted the openid scope", errors.ErrForbidden)
+	}
+
+	user, err := s.Auth.UserService.GetUserByID(ctx, p.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return s.userClaims(ctx, user, scopes), nil
+}
+
+// AuthTime is when the session p belongs to was signed in, for the
+// auth_time claim of codes approved with an existing session.
+func (s *OAuthService) AuthTime(ctx context.Context, p *models.Principal) time.Time {
+	sessions, err := s.Auth.Sessions.List(ctx, p.UserID)
+	if err == nil {
+		for _, sess := range sessions {
+			if sess.ID == p.SessionID {
+				return sess.CreatedAt
+			}
+		}
+	}
+	return time.Now()
+}
+
+// Discovery is the /.well-known/openid-configuration document.
+func (s *OAuthService) Discovery() map[string]interface{} {
+	return map[string]interface{}{
+		"issuer":                                s.Config.Issuer,
+		"authorization_endpoint":                s.Config.Issuer + "/oauth/authorize",
+		"token_endpoint":                        s.Config.Issuer + "/oauth/token",
+		"userinfo_endpoint":                     s.Config.Issuer + "/oauth/userinfo",
+		"end_session_endpoint":                  s.Config.Issuer + "/oauth/logout",
+		"jwks_uri":                              s.Config.Issuer + "/.well-known/jwks.json",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 models.OAuthGrantTypes,
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{s.Config.SigningAlgorithm},
+		"scopes_supported":                      models.OIDCScopes,
+		"claims_supported":                      oidcClaims,
+		"code_challenge_methods_supported":      []string{"S256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+
+		"introspection_endpoint":                        s.Config.Issuer + "/api/v1/auth/introspect",
+		"introspection_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+		"revocation_endpoint":                           s.Config.Issuer + "/api/v1/auth/revoke",
+		"revocation_endpoint_auth_methods_supported":    []string{"client_secret_basic", "client_secret_post", "none"},
+	}
+}
+
+// EndSession is RP-initiated logout. It signs out the session the
+// id_token_hint was issued for and returns where to send the browser
+// next, which is empty unless the client registered the given URI.
+func (s *OAuthService) EndSession(ctx context.Context, idTokenHint, clientID, postLogoutRedirectURI, state string) (string, error) {
+	if idTokenHint == "" {
+		return "", fmt.Errorf("%w: id_token_hint", errors.ErrMissingField)
+	}
+
+	claims, err := s.Auth.JWT.DecodeIDTokenHint(idTokenHint)
+	if err != nil {
+		return "", fmt.Errorf("%w: id_token_hint: %v", errors.ErrInvalidToken, err)
+	}
+	audience := s.Auth.JWT.FetchClaim("aud", claims)
+	if clientID != "" && clientID != audience {
+		return "", fmt.Errorf("%w: id_token_hint was issued to another client", errors.ErrInvalidParams)
+	}
+	userID, err := strconv.Atoi(s.Auth.JWT.FetchClaim("sub", claims))
+	if err != nil {
+		return "", fmt.Errorf("%w: id_token_hint has no subject", errors.ErrInvalidToken)
+	}
+
+	var redirect string
+	if postLogoutRedirectURI != "" {
+		client, err := s.Clients.Get(ctx, audience)
+		if err != nil || !client.AllowsPostLogoutRedirect(postLogoutRedirectURI) {
+			return "", fmt.Errorf("%w: post_logout_redirect_uri is not registered for this client", errors.ErrInvalidParams)
+		}
+		u, _ := url.Parse(postLogoutRedirectURI)
+		if state != "" {
+			q := u.Query()
+			q.Set("state", state)
+			u.RawQuery = q.Encode()
+		}
+		redirect = u.String()
+	}
+
+	// already signed out is fine: the user wanted out and is out
+	sid := s.Auth.JWT.FetchClaim("sid", claims)
+	if err := s.Auth.endSession(ctx, userID, sid); err != nil && err != errors.ErrResourceNotFound {
+		return "", err
+	}
+
+	logger.Info("OAuthService.EndSession", "rp-initiated logout", map[string]interface{}{"client_id": audience, "user_id": userID, "sid": sid})
+	return redirect, nil
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"test123/events"
+	kafka "test123/kafka/producers"
+	"test123/logger"
+	"test123/models"
+	"test123/repositories"
+)
+
+// enqueueNotification stores event in the outbox using db, which should be
+// the transaction of the domain change that produced the event (nil when
+// there is none). The relay publishes it to Kafka after commit.
+func enqueueNotification(ctx context.Context, outbox repositories.OutboxRepoInterface, db repositories.DBTX, event events.NotificationEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification event: %v", err)
+	}
+
+	return outbox.Enqueue(ctx, db, models.OutboxMessage{
+		EventID: event.EventID,
+		Key:     event.EventID.String(),
+		Payload: payload,
+	})
+}
+
+// OutboxRelay polls the outbox table and publishes pending rows to Kafka,
+// giving at-least-once delivery of every stored event.
+type OutboxRelay struct {
+	Outbox    repositories.OutboxRepoInterface
+	Producer  *kafka.KafkaNotificationProducer
+	Interval  time.Duration
+	BatchSize int
+	Lease     time.Duration
+	MaxDelay  time.Duration
+}
+
+func NewOutboxRelay(outbox repositories.OutboxRepoInterface, producer *kafka.KafkaNotificationProducer) *OutboxRelay {
+	return &OutboxRelay{
+		Outbox:    outbox,
+		Producer:  producer,
+		Interval:  time.Second,
+		BatchSize: 100,
+		Lease:     30 * time.Second,
+		MaxDelay:  5 * time.Minute,
+	}
+}
+
+// Run relays batches until ctx is cancelled.
+func (r *OutboxRelay) Run(ctx context.Context) {
+	logger.Info("OutboxRelay.Run", "outbox relay started")
+
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		// drain full batches back to back, then wait for the next tick
+		for {
+			n, err := r.RelayBatch(ctx)
+			if err != nil || n < r.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.Info("OutboxRelay.Run", "outbox relay stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayBatch publishes one batch of due rows and returns how many it claimed.
+func (r *OutboxRelay) RelayBatch(ctx context.Context) (int, error) {
+	msgs, err := r.Outbox.ClaimPending(ctx, r.BatchSize, r.Lease)
+	if err != nil {
+		logger.Error("OutboxRelay.RelayBatch", "failed to claim outbox rows", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return 0, err
+	}
+
+	for _, m := range msgs {
+		if err := r.Producer.Publish(ctx, m.Topic, []byte(m.Key), m.Payload, nil); err != nil {
+			next := time.Now().Add(r.backoff(m.Attempts))
+			logger.Warn("OutboxRelay.RelayBatch", "publish failed, will retry", map[string]interface{}{
+				"id":           m.ID,
+				"event_id":     m.EventID,
+				"attempts":     m.Attempts,
+				"next_attempt": next,
+				"error":        err.Error(),
+			})
+			_ = r.Outbox.MarkFailed(ctx, m.ID, next, err.Error())
+			continue
+		}
+
+		if err := r.Outbox.MarkSent(ctx, m.ID); err != nil {
+			// the lease expires and the row is published again, which is
+			// fine for at-least-once delivery
+			logger.Error("OutboxRelay.RelayBatch", "failed to mark row sent", map[string]interface{}{
+				"id":    m.ID,
+				"error": err.Error(),
+			})
+		}
+	}
+
+	return len(msgs), nil
+}
+
+// backoff doubles the retry delay per attempt, capped at MaxDelay.
+func (r *OutboxRelay) backoff(attempts int) time.Duration {
+	if attempts > 16 {
+		return r.MaxDelay
+	}
+	d := time.Second << attempts
+	if d > r.MaxDelay {
+		return r.MaxDelay
+	}
+	return d
+}
@@ -0,0 +1,359 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"test123/config"
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+	"test123/repositories"
+	"test123/requests"
+	"test123/utils/webauthn"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const maxPasskeyName = 64
+
+// PasskeyService registers WebAuthn passkeys and signs users in with them.
+// Challenges live in Redis for one ceremony: registration ones per user,
+// login ones under the challenge itself since the user may not be known
+// until the browser picks a passkey.
+type PasskeyService struct {
+	Auth   *AuthService
+	Repo   repositories.WebAuthnRepoInterface
+	Redis  *redis.Client
+	Config config.Auth
+}
+
+func NewPasskeyService(auth *AuthService, repo repositories.WebAuthnRepoInterface, rdb *redis.Client, cfg config.Auth) *PasskeyService {
+	return &PasskeyService{Auth: auth, Repo: repo, Redis: rdb, Config: cfg}
+}
+
+func passkeyRegistrationKey(userID int) string {
+	return "webauthn_reg:" + strconv.Itoa(userID)
+}
+
+func passkeyLoginKey(challenge string) string {
+	return "webauthn_login:" + challenge
+}
+
+// userHandle is the opaque user.id given to authenticators; it comes back
+// in assertions made with discoverable credentials.
+func userHandle(userID int) string {
+	return webauthn.Encode([]byte(strconv.Itoa(userID)))
+}
+
+func credentialDescriptors(creds []models.WebAuthnCredential) []map[string]interface{} {
+	list := make([]map[string]interface{}, 0, len(creds))
+	for _, c := range creds {
+		d := map[string]interface{}{"type": "public-key", "id": c.CredentialID}
+		if len(c.Transports) > 0 {
+			d["transports"] = c.Transports
+		}
+		list = append(list, d)
+	}
+	return list
+}
+
+// BeginRegistration returns the PublicKeyCredentialCreationOptions for
+// navigator.credentials.create(). Starting again replaces the challenge.
+func (s *PasskeyService) BeginRegistration(ctx context.Context, userID int) (map[string]interface{}, error) {
+	user, err := s.Auth.UserService.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := s.Repo.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrInternalFailure, err)
+	}
+	if err := s.Redis.Set(ctx, passkeyRegistrationKey(userID), challenge, s.Config.WebAuthnChallengeTTL).Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrCacheFailure, err)
+	}
+
+	params := make([]map[string]interface{}, 0, len(webauthn.SupportedAlgorithms))
+	for _, alg := range webauthn.SupportedAlgorithms {
+		params = append(params, map[string]interface{}{"type": "public-key", "alg": alg})
+	}
+
+	return map[string]interface{}{
+		"challenge": challenge,
+		"rp":        map[string]string{"id": s.Config.WebAuthnRPID, "name": s.Config.WebAuthnRPName},
+		"user": map[string]string{
+			"id":          userHandle(user.ID),
+			"name":        user.Username,
+			"displayName": user.Name,
+		},
+		"pubKeyCredParams": params,
+		"timeout":          s.Config.WebAuthnChallengeTTL.Milliseconds(),
+		"attestation":      "none",
+		// a passkey is a discoverable credential, so login needs no username
+		"authenticatorSelection": map[string]interface{}{
+			"residentKey":        "required",
+			"requireResidentKey": true,
+			"userVerification":   "preferred",
+		},
+		// stops the same authenticator being registered twice
+		"excludeCredentials": credentialDescriptors(existing),
+	}, nil
+}
+
+// FinishRegistration checks the attestation returned by the browser and
+// stores the new passkey.
+func (s *PasskeyService) FinishRegistration(ctx context.Context, userID int, req requests.PasskeyRegistrationReq) (*models.WebAuthnCredential, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+	if len(name) > maxPasskeyName {
+		return nil, fmt.Errorf("%w: name must be at most %d characters", errors.ErrInvalidField, maxPasskeyName)
+	}
+	if req.Type != "public-key" || req.Response.ClientDataJSON == "" || req.Response.AttestationObject == "" {
+		return nil, fmt.Errorf("%w: expected a public-key credential with clientDataJSON and attestationObject", errors.ErrMissingField)
+	}
+
+	challenge, err := s.Redis.GetDel(ctx, passkeyRegistrationKey(userID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("%w: no registration in progress, start again", errors.ErrInvalidToken)
+	}
+
+	rawClientData, err := webauthn.Decode(req.Response.ClientDataJSON)
+	if err != nil {
+		return nil, fmt.Errorf("%w: clientDataJSON", errors.ErrInvalidField)
+	}
+	clientData, err := webauthn.ParseClientData(rawClientData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrInvalidField, err)
+	}
+	if err := clientData.Verify(webauthn.TypeCreate, challenge, s.Config.WebAuthnOrigins); err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrValidationFailed, err)
+	}
+
+	rawAttestation, err := webauthn.Decode(req.Response.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: attestationObject", errors.ErrInvalidField)
+	}
+	format, authData, err := webauthn.ParseAttestationObject(rawAttestation)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrInvalidField, err)
+	}
+	if err := authData.VerifyRPID(s.Config.WebAuthnRPID); err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrValidationFailed, err)
+	}
+	if !authData.UserPresent() {
+		return nil, fmt.Errorf("%w: user presence was not confirmed", errors.ErrValidationFailed)
+	}
+	if _, _, err := webauthn.ParsePublicKey(authData.PublicKey); err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrValidationFailed, err)
+	}
+
+	credentialID := webauthn.Encode(authData.CredentialID)
+	if req.ID != "" && strings.TrimRight(req.ID, "=") != credentialID {
+		return nil, fmt.Errorf("%w: credential id does not match the attestation", errors.ErrValidationFailed)
+	}
+
+	var aaguid string
+	if !bytes.Equal(authData.AAGUID, make([]byte, 16)) {
+		aaguid = hex.EncodeToString(authData.AAGUID)
+	}
+
+	created, err := s.Repo.Create(ctx, models.WebAuthnCredential{
+		UserID:       userID,
+		CredentialID: credentialID,
+		PublicKey:    authData.PublicKey,
+		SignCount:    int64(authData.SignCount),
+		Transports:   req.Response.Transports,
+		AAGUID:       aaguid,
+		Name:         name,
+	})
+	if err != nil {
+		if err == errors.ErrDuplicateRequest {
+			return nil, fmt.Errorf("%w: this passkey is already registered", errors.ErrDuplicateRequest)
+		}
+		return nil, err
+	}
+
+	logger.Info("PasskeyService.FinishRegistration", "passkey registered", map[string]interface{}{
+		"user_id":     userID,
+		"passkey_id":  created.ID,
+		"attestation": format,
+	})
+	return created, nil
+}
+
+// BeginLogin returns the PublicKeyCredentialRequestOptions for
+// navigator.credentials.get(). With a username the user's passkeys are
+// listed; an unknown username gets the same answer with none.
+func (s *PasskeyService) BeginLogin(ctx context.Context, username string) (map[string]interface{}, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrInternalFailure, err)
+	}
+
+	var userID int
+	allowed := []map[string]interface{}{}
+	if username != "" {
+		if user, err := s.Auth.UserService.GetUserByEmailOrUsername(ctx, username); err == nil {
+			creds, err := s.Repo.ListByUser(ctx, user.ID)
+			if err != nil {
+				return nil, err
+			}
+			userID, allowed = user.ID, credentialDescriptors(creds)
+		}
+	}
+
+	if err := s.Redis.Set(ctx, passkeyLoginKey(challenge), userID, s.Config.WebAuthnChallengeTTL).Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrCacheFailure, err)
+	}
+
+	return map[string]interface{}{
+		"challenge":        challenge,
+		"rpId":             s.Config.WebAuthnRPID,
+		"timeout":          s.Config.WebAuthnChallengeTTL.Milliseconds(),
+		"userVerification": "preferred",
+		"allowCredentials": allowed,
+	}, nil
+}
+
+// FinishLogin verifies an assertion and answers like Login. A passkey that
+// verified the user (PIN or biometrics) counts as both factors; otherwise
+// users with TOTP still get the MFA challenge.
+func (s *PasskeyService) FinishLogin(ctx context.Context, req requests.PasskeyLoginReq, client models.SessionClient) (int, map[string]interface{}) {
+	logger.Info("PasskeyLogin", "called", nil)
+
+	failed := map[string]interface{}{"error": "passkey sign-in failed"}
+	if req.Type != "public-key" || req.ID == "" || req.Response.ClientDataJSON == "" || req.Response.AuthenticatorData == "" || req.Response.Signature == "" {
+		return 400, map[string]interface{}{"error": "expected a public-key credential with clientDataJSON, authenticatorData and signature"}
+	}
+
+	rawClientData, err1 := webauthn.Decode(req.Response.ClientDataJSON)
+	rawAuthData, err2 := webauthn.Decode(req.Response.AuthenticatorData)
+	signature, err3 := webauthn.Decode(req.Response.Signature)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 400, map[string]interface{}{"error": "credential fields must be base64url encoded"}
+	}
+	clientData, err := webauthn.ParseClientData(rawClientData)
+	if err != nil {
+		return 400, map[string]interface{}{"error": err.Error()}
+	}
+
+	// the challenge is spent whatever happens next
+	expected, err := s.Redis.GetDel(ctx, passkeyLoginKey(clientData.Challenge)).Int()
+	if err != nil {
+		logger.Warn("PasskeyLogin", "unknown or expired challenge", nil)
+		return 401, map[string]interface{}{"error": "invalid or expired challenge"}
+	}
+	if err := clientData.Verify(webauthn.TypeGet, clientData.Challenge, s.Config.WebAuthnOrigins); err != nil {
+		logger.Warn("PasskeyLogin", "client data rejected", map[string]interface{}{"error": err.Error()})
+		return 401, failed
+	}
+
+	cred, err := s.Repo.GetByCredentialID(ctx, strings.TrimRight(req.ID, "="))
+	if err != nil {
+		if err == errors.ErrResourceNotFound {
+			logger.Warn("PasskeyLogin", "unknown credential", nil)
+			return 401, failed
+		}
+		return 500, map[string]interface{}{"error": "failed to look up passkey"}
+	}
+	if expected != 0 && cred.UserID != expected {
+		logger.Warn("PasskeyLogin", "passkey belongs to another user", map[string]interface{}{"passkey_id": cred.ID})
+		return 401, failed
+	}
+	if req.Response.UserHandle != "" && strings.TrimRight(req.Response.UserHandle, "=") != userHandle(cred.UserID) {
+		logger.Warn("PasskeyLogin", "user handle mismatch", map[string]interface{}{"passkey_id": cred.ID})
+		return 401, failed
+	}
+
+	authData, err := webauthn.ParseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return 400, map[string]interface{}{"error": err.Error()}
+	}
+	if err := authData.VerifyRPID(s.Config.WebAuthnRPID); err != nil || !authData.UserPresent() {
+		logger.Warn("PasskeyLogin", "authenticator data rejected", map[string]interface{}{"passkey_id": cred.ID})
+		return 401, failed
+	}
+	if err := webauthn.VerifyAssertion(cred.PublicKey, rawAuthData, rawClientData, signature); err != nil {
+		logger.Warn("PasskeyLogin", "bad signature", map[string]interface{}{"passkey_id": cred.ID})
+		return 401, failed
+	}
+
+	// authenticators that count must always count up; going back means the
+	// key was probably cloned. Synced passkeys report 0 and are exempt.
+	signCount := int64(authData.SignCount)
+	if (signCount != 0 || cred.SignCount != 0) && signCount <= cred.SignCount {
+		logger.Warn("PasskeyLogin", "sign count went backwards, possible cloned authenticator", map[string]interface{}{
+			"user_id":    cred.UserID,
+			"passkey_id": cred.ID,
+			"stored":     cred.SignCount,
+			"received":   signCount,
+		})
+		return 401, failed
+	}
+	ok, err := s.Repo.RecordUse(ctx, cred.ID, cred.SignCount, signCount)
+	if err != nil {
+		return 500, map[string]interface{}{"error": "failed to record passkey use"}
+	}
+	if !ok {
+		return 401, failed
+	}
+
+	user, err := s.Auth.UserService.GetUserByID(ctx, cred.UserID)
+	if err != nil {
+		return 401, failed
+	}
+	rules, err := s.Auth.Settings.Auth(ctx)
+	if err != nil {
+		logger.Error("PasskeyLogin", "failed to load auth settings", map[string]interface{}{"error": err.Error()})
+		return 500, map[string]interface{}{"error": "passkey sign-in failed"}
+	}
+	if rules.RequireVerifiedEmail && user.EmailVerifiedAt == nil {
+		logger.Warn("PasskeyLogin", "email not verified", map[string]interface{}{"username": user.Username})
+		return 403, map[string]interface{}{"error": "email address not verified"}
+	}
+
+	if client.Device == "" {
+		client.Device = "passkey:" + cred.Name
+	}
+	logger.Info("PasskeyLogin", "passkey accepted", map[string]interface{}{"username": user.Username, "passkey_id": cred.ID, "user_verified": authData.UserVerified()})
+	if authData.UserVerified() {
+		return s.Auth.openSession(ctx, user, client)
+	}
+	return s.Auth.completeLogin(ctx, user, client)
+}
+
+func (s *PasskeyService) List(ctx context.Context, userID int) ([]models.WebAuthnCredential, error) {
+	return s.Repo.ListByUser(ctx, userID)
+}
+
+func (s *PasskeyService) Rename(ctx context.Context, userID, id int, name string) (*models.WebAuthnCredential, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("%w: name", errors.ErrMissingField)
+	}
+	if len(name) > maxPasskeyName {
+		return nil, fmt.Errorf("%w: name must be at most %d characters", errors.ErrInvalidField, maxPasskeyName)
+	}
+	return s.Repo.Rename(ctx, userID, id, name)
+}
+
+// Delete removes a passkey. The authenticator keeps its copy, which then
+// no longer signs anyone in.
+func (s *PasskeyService) Delete(ctx context.Context, userID, id int) error {
+	if err := s.Repo.Delete(ctx, userID, id); err != nil {
+		return err
+	}
+	logger.Info("PasskeyService.Delete", "passkey removed", map[string]interface{}{"user_id": userID, "passkey_id": id})
+	return nil
+}
@@ -0,0 +1,311 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"test123/config"
+	"test123/errors"
+	"test123/models"
+	"test123/repositories"
+	"test123/requests"
+	"test123/utils/webauthn"
+)
+
+// memoryWebAuthn is an in-memory webauthn_credentials table.
+type memoryWebAuthn struct {
+	repositories.WebAuthnRepoInterface
+
+	mu    sync.Mutex
+	creds map[int]*models.WebAuthnCredential
+}
+
+func (r *memoryWebAuthn) GetByCredentialID(ctx context.Context, credentialID string) (*models.WebAuthnCredential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.creds {
+		if c.CredentialID == credentialID {
+			cp := *c
+			return &cp, nil
+		}
+	}
+	return nil, errors.ErrResourceNotFound
+}
+
+func (r *memoryWebAuthn) ListByUser(ctx context.Context, userID int) ([]models.WebAuthnCredential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := []models.WebAuthnCredential{}
+	for _, c := range r.creds {
+		if c.UserID == userID {
+			list = append(list, *c)
+		}
+	}
+	return list, nil
+}
+
+// RecordUse mirrors the repository: the count only moves from prevCount.
+func (r *memoryWebAuthn) RecordUse(ctx context.Context, id int, prevCount, signCount int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.creds[id]
+	if !ok || c.SignCount != prevCount {
+		return false, nil
+	}
+	c.SignCount = signCount
+	return true, nil
+}
+
+func (r *memoryWebAuthn) signCount(id int) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.creds[id].SignCount
+}
+
+// testAuthenticator is a P-256 authenticator holding one passkey.
+type testAuthenticator struct {
+	key    *ecdsa.PrivateKey
+	credID string
+}
+
+func newTestAuthenticator(t *testing.T) *testAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &testAuthenticator{key: key, credID: webauthn.Encode(id)}
+}
+
+// cose is the passkey's public key as stored at registration.
+func (a *testAuthenticator) cose() []byte {
+	x, y := make([]byte, 32), make([]byte, 32)
+	a.key.PublicKey.X.FillBytes(x)
+	a.key.PublicKey.Y.FillBytes(y)
+	b := []byte{0xa5, 0x01, 0x02, 0x03, 0x26, 0x20, 0x01, 0x21, 0x58, 0x20}
+	b = append(b, x...)
+	b = append(b, 0x22, 0x58, 0x20)
+	return append(b, y...)
+}
+
+// assertion is what navigator.credentials.get() hands the page.
+type assertion struct {
+	rpID      string
+	origin    string
+	typ       string
+	flags     byte
+	signCount uint32
+}
+
+func (a *testAuthenticator) assert(t *testing.T, challenge string, as assertion) requests.PasskeyLoginReq {
+	t.Helper()
+	clientData, _ := json.Marshal(webauthn.ClientData{Type: as.typ, Challenge: challenge, Origin: as.origin})
+	rpHash := sha256.Sum256([]byte(as.rpID))
+	authData := binary.BigEndian.AppendUint32(append(rpHash[:], as.flags), as.signCount)
+
+	clientHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	req := requests.PasskeyLoginReq{ID: a.credID, Type: "public-key"}
+	req.Response.ClientDataJSON = webauthn.Encode(clientData)
+	req.Response.AuthenticatorData = webauthn.Encode(authData)
+	req.Response.Signature = webauthn.Encode(sig)
+	return req
+}
+
+func newPasskeyTest(t *testing.T, storedCount int64) (*PasskeyService, *memoryWebAuthn, *testAuthenticator) {
+	t.Helper()
+	rdb, _ := newTestRedis(t)
+	cfg := config.Auth{
+		AccessTokenTTL:       15 * time.Minute,
+		RefreshTokenTTL:      time.Hour,
+		WebAuthnRPID:         "example.com",
+		WebAuthnOrigins:      []string{"https://example.com"},
+		WebAuthnChallengeTTL: time.Minute,
+	}
+	auth := &AuthService{
+		UserService: &UserService{UserRepo: newMemoryUsers(models.User{ID: 1, Username: "alice", Email: "alice@example.com"})},
+		Redis:       rdb,
+		JWT:         newTestJWT(t),
+		Sessions:    NewSessionService(rdb, time.Hour),
+		MFA:         &MFAService{Repo: &memoryMFA{}},
+		Settings:    &SettingsService{Repo: noSettings{}, CacheTTL: time.Minute},
+		Config:      cfg,
+	}
+
+	authn := newTestAuthenticator(t)
+	repo := &memoryWebAuthn{creds: map[int]*models.WebAuthnCredential{
+		1: {ID: 1, UserID: 1, CredentialID: authn.credID, PublicKey: authn.cose(), SignCount: storedCount, Name: "laptop"},
+	}}
+	return NewPasskeyService(auth, repo, rdb, cfg), repo, authn
+}
+
+func beginPasskeyLogin(t *testing.T, s *PasskeyService, username string) string {
+	t.Helper()
+	options, err := s.BeginLogin(context.Background(), username)
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	return options["challenge"].(string)
+}
+
+var goodAssertion = assertion{
+	rpID:   "example.com",
+	origin: "https://example.com",
+	typ:    webauthn.TypeGet,
+	flags:  webauthn.FlagUserPresent | webauthn.FlagUserVerified,
+}
+
+func TestPasskeyAssertion(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(as *assertion)
+		status int
+	}{
+		{"valid", func(as *assertion) {}, 200},
+		{"another relying party", func(as *assertion) { as.rpID = "evil.example" }, 401},
+		{"another origin", func(as *assertion) { as.origin = "https://evil.example" }, 401},
+		{"registration ceremony", func(as *assertion) { as.typ = webauthn.TypeCreate }, 401},
+		{"user not present", func(as *assertion) { as.flags = webauthn.FlagUserVerified }, 401},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, authn := newPasskeyTest(t, 0)
+			as := goodAssertion
+			tt.change(&as)
+
+			req := authn.assert(t, beginPasskeyLogin(t, s, "alice"), as)
+			status, body := s.FinishLogin(context.Background(), req, models.SessionClient{})
+			if status != tt.status {
+				t.Fatalf("got %d %v, want %d", status, body, tt.status)
+			}
+			if status == 200 && body["access_token"] == nil {
+				t.Errorf("no tokens in %v", body)
+			}
+		})
+	}
+}
+
+func TestPasskeyAssertionChecks(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("challenge used twice", func(t *testing.T) {
+		s, _, authn := newPasskeyTest(t, 0)
+		req := authn.assert(t, beginPasskeyLogin(t, s, "alice"), goodAssertion)
+		if status, body := s.FinishLogin(ctx, req, models.SessionClient{}); status != 200 {
+			t.Fatalf("first use: %d %v", status, body)
+		}
+		if status, _ := s.FinishLogin(ctx, req, models.SessionClient{}); status != 401 {
+			t.Fatalf("replay: got %d, want 401", status)
+		}
+	})
+
+	t.Run("challenge never issued", func(t *testing.T) {
+		s, _, authn := newPasskeyTest(t, 0)
+		req := authn.assert(t, "made-up-challenge", goodAssertion)
+		if status, _ := s.FinishLogin(ctx, req, models.SessionClient{}); status != 401 {
+			t.Fatalf("got %d, want 401", status)
+		}
+	})
+
+	t.Run("signed by another authenticator", func(t *testing.T) {
+		s, _, authn := newPasskeyTest(t, 0)
+		forger := newTestAuthenticator(t)
+		forger.credID = authn.credID
+		req := forger.assert(t, beginPasskeyLogin(t, s, "alice"), goodAssertion)
+		if status, _ := s.FinishLogin(ctx, req, models.SessionClient{}); status != 401 {
+			t.Fatalf("got %d, want 401", status)
+		}
+	})
+
+	t.Run("passkey of another user than asked for", func(t *testing.T) {
+		s, _, authn := newPasskeyTest(t, 0)
+		s.Auth.UserService.UserRepo.(*memoryUsers).users[2] = &models.User{ID: 2, Username: "bob", Email: "bob@example.com"}
+		req := authn.assert(t, beginPasskeyLogin(t, s, "bob"), goodAssertion)
+		if status, _ := s.FinishLogin(ctx, req, models.SessionClient{}); status != 401 {
+			t.Fatalf("got %d, want 401", status)
+		}
+	})
+}
+
+func TestPasskeySignCount(t *testing.T) {
+	tests := []struct {
+		name     string
+		stored   int64
+		received uint32
+		status   int
+		want     int64
+	}{
+		{"counts up", 5, 6, 200, 6},
+		{"skips ahead", 5, 50, 200, 50},
+		{"first use of a counting key", 0, 1, 200, 1},
+		{"synced passkey that never counts", 0, 0, 200, 0},
+		{"same count again", 5, 5, 401, 5},
+		{"count went back", 5, 3, 401, 5},
+		{"counting key reports zero", 5, 0, 401, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, repo, authn := newPasskeyTest(t, tt.stored)
+			as := goodAssertion
+			as.signCount = tt.received
+
+			req := authn.assert(t, beginPasskeyLogin(t, s, ""), as)
+			status, body := s.FinishLogin(context.Background(), req, models.SessionClient{})
+			if status != tt.status {
+				t.Fatalf("got %d %v, want %d", status, body, tt.status)
+			}
+			if got := repo.signCount(1); got != tt.want {
+				t.Errorf("stored sign count %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPasskeyConcurrentSameCount(t *testing.T) {
+	// two assertions with the same count racing: only one may sign in
+	s, _, authn := newPasskeyTest(t, 5)
+	as := goodAssertion
+	as.signCount = 6
+	reqs := []requests.PasskeyLoginReq{
+		authn.assert(t, beginPasskeyLogin(t, s, ""), as),
+		authn.assert(t, beginPasskeyLogin(t, s, ""), as),
+	}
+
+	var wg sync.WaitGroup
+	statuses := make([]int, len(reqs))
+	for i, req := range reqs {
+		wg.Add(1)
+		go func(i int, req requests.PasskeyLoginReq) {
+			defer wg.Done()
+			statuses[i], _ = s.FinishLogin(context.Background(), req, models.SessionClient{})
+		}(i, req)
+	}
+	wg.Wait()
+
+	ok := 0
+	for _, st := range statuses {
+		if st == 200 {
+			ok++
+		}
+	}
+	if ok != 1 {
+		t.Fatalf("statuses %v, want exactly one sign-in", statuses)
+	}
+}
@@ -0,0 +1,239 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher hashes and verifies user passwords. Encoded hashes carry
+// their algorithm and parameters so they can be verified (and upgraded)
+// long after the defaults change.
+type PasswordHasher interface {
+	Algorithm() string
+	Hash(password string) (string, error)
+	Verify(password, encoded string) (bool, error)
+	NeedsRehash(encoded string) bool
+}
+
+const (
+	AlgorithmArgon2id  = "argon2id"
+	AlgorithmBcrypt    = "bcrypt"
+	AlgorithmPlaintext = "plaintext"
+)
+
+// algorithmOf detects which algorithm produced an encoded password.
+// Anything that is not a recognised hash is a legacy plaintext row.
+func algorithmOf(encoded string) string {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return AlgorithmArgon2id
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		return AlgorithmBcrypt
+	default:
+		return AlgorithmPlaintext
+	}
+}
+
+// ===========================
+//  ARGON2ID
+// ===========================
+
+// Argon2idHasher encodes hashes in the PHC string format:
+// $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<hash>
+type Argon2idHasher struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+func NewArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 4,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+func (h *Argon2idHasher) Algorithm() string {
+	return AlgorithmArgon2id
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *Argon2idHasher) Verify(password, encoded string) (bool, error) {
+	p, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	p, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return p.Memory != h.Memory ||
+		p.Iterations != h.Iterations ||
+		p.Parallelism != h.Parallelism ||
+		uint32(len(salt)) != h.SaltLength ||
+		uint32(len(key)) != h.KeyLength
+}
+
+func decodeArgon2id(encoded string) (*Argon2idHasher, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id {
+		return nil, nil, nil, fmt.Errorf("invalid argon2id hash format")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid argon2id version: %w", err)
+	}
+	if version != argon2.Version {
+		return nil, nil, nil, fmt.Errorf("unsupported argon2id version %d", version)
+	}
+
+	p := &Argon2idHasher{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid argon2id parameters: %w", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid argon2id hash: %w", err)
+	}
+
+	return p, salt, key, nil
+}
+
+// ===========================
+//  BCRYPT
+// ===========================
+
+// BcryptHasher uses the standard modular crypt format, which already
+// encodes the algorithm version and cost.
+type BcryptHasher struct {
+	Cost int
+}
+
+func NewBcryptHasher(cost int) *BcryptHasher {
+	return &BcryptHasher{Cost: cost}
+}
+
+func (h *BcryptHasher) Algorithm() string {
+	return AlgorithmBcrypt
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (h *BcryptHasher) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return true
+	}
+	return cost != h.Cost
+}
+
+// ===========================
+//  MIGRATING HASHER
+// ===========================
+
+// MigratingHasher hashes with a preferred algorithm but still verifies
+// hashes from legacy algorithms, including plaintext rows written before
+// hashing existed. Anything not produced by the preferred hasher with its
+// current parameters reports NeedsRehash so it can be upgraded on login.
+type MigratingHasher struct {
+	Preferred PasswordHasher
+	hashers   map[string]PasswordHasher
+}
+
+func NewMigratingHasher(preferred PasswordHasher, legacy ...PasswordHasher) *MigratingHasher {
+	m := &MigratingHasher{
+		Preferred: preferred,
+		hashers:   map[string]PasswordHasher{preferred.Algorithm(): preferred},
+	}
+	for _, h := range legacy {
+		m.hashers[h.Algorithm()] = h
+	}
+	return m
+}
+
+func (m *MigratingHasher) Algorithm() string {
+	return m.Preferred.Algorithm()
+}
+
+func (m *MigratingHasher) Hash(password string) (string, error) {
+	return m.Preferred.Hash(password)
+}
+
+func (m *MigratingHasher) Verify(password, encoded string) (bool, error) {
+	if encoded == "" {
+		return false, nil
+	}
+
+	algorithm := algorithmOf(encoded)
+	if algorithm == AlgorithmPlaintext {
+		return subtle.ConstantTimeCompare([]byte(password), []byte(encoded)) == 1, nil
+	}
+
+	h, ok := m.hashers[algorithm]
+	if !ok {
+		return false, fmt.Errorf("no hasher registered for %s", algorithm)
+	}
+	return h.Verify(password, encoded)
+}
+
+func (m *MigratingHasher) NeedsRehash(encoded string) bool {
+	if algorithmOf(encoded) != m.Preferred.Algorithm() {
+		return true
+	}
+	return m.Preferred.NeedsRehash(encoded)
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"test123/config"
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+	"test123/repositories"
+	"test123/utils/passwordcheck"
+)
+
+// PasswordPolicy decides whether a user may pick a password: length and
+// character classes, an estimated strength, the breached password list and
+// the user's own recent passwords. It also says when a password is too old
+// to keep signing in with.
+type PasswordPolicy struct {
+	History  repositories.PasswordHistoryRepoInterface
+	Hasher   PasswordHasher
+	Breached *passwordcheck.BreachedList
+	Config   config.PasswordPolicy
+}
+
+func NewPasswordPolicy(history repositories.PasswordHistoryRepoInterface, hasher PasswordHasher, breached *passwordcheck.BreachedList, cfg config.PasswordPolicy) *PasswordPolicy {
+	return &PasswordPolicy{
+		History:  history,
+		Hasher:   hasher,
+		Breached: breached,
+		Config:   cfg,
+	}
+}
+
+// Validate checks password as the new password of user. user.Password is
+// the current hash, empty for a user still being created. Every broken
+// rule is listed in the returned ErrWeakPassword.
+func (p *PasswordPolicy) Validate(ctx context.Context, user *models.User, password string) error {
+	var reasons []string
+
+	if n := utf8.RuneCountInString(password); n < p.Config.MinLength {
+		reasons = append(reasons, fmt.Sprintf("must be at least %d characters", p.Config.MinLength))
+	} else if n > p.Config.MaxLength {
+		reasons = append(reasons, fmt.Sprintf("must be at most %d characters", p.Config.MaxLength))
+	}
+	if missing := p.missingClasses(password); len(missing) > 0 {
+		reasons = append(reasons, "must contain "+strings.Join(missing, ", "))
+	}
+	if len(reasons) > 0 {
+		// the costlier checks would only pile on to a password that is
+		// already refused
+		return weakPassword(reasons)
+	}
+
+	if r := passwordcheck.Estimate(password, user.Username, user.Name, user.Email); r.Score < p.Config.MinStrength {
+		reason := "is too easy to guess"
+		if r.Warning != "" {
+			reason += ", it " + r.Warning
+		}
+		reasons = append(reasons, reason)
+	}
+	if p.Breached.Contains(password) {
+		reasons = append(reasons, "has appeared in a data breach")
+	}
+	if len(reasons) == 0 {
+		reused, err := p.reused(ctx, user, password)
+		if err != nil {
+			return err
+		}
+		if reused {
+			reasons = append(reasons, fmt.Sprintf("must not be one of your last %d passwords", p.Config.HistorySize))
+		}
+	}
+	if len(reasons) > 0 {
+		return weakPassword(reasons)
+	}
+	return nil
+}
+
+func weakPassword(reasons []string) error {
+	return fmt.Errorf("%w: password %s", errors.ErrWeakPassword, strings.Join(reasons, "; "))
+}
+
+func (p *PasswordPolicy) missingClasses(password string) []string {
+	var upper, lower, digit, symbol bool
+	for _, ch := range password {
+		switch {
+		case unicode.IsUpper(ch):
+			upper = true
+		case unicode.IsLower(ch):
+			lower = true
+		case unicode.IsDigit(ch):
+			digit = true
+		case unicode.IsPunct(ch) || unicode.IsSymbol(ch) || unicode.IsSpace(ch):
+			symbol = true
+		}
+	}
+
+	var missing []string
+	if p.Config.RequireUpper && !upper {
+		missing = append(missing, "an upper case letter")
+	}
+	if p.Config.RequireLower && !lower {
+		missing = append(missing, "a lower case letter")
+	}
+	if p.Config.RequireDigit && !digit {
+		missing = append(missing, "a digit")
+	}
+	if p.Config.RequireSymbol && !symbol {
+		missing = append(missing, "a symbol")
+	}
+	return missing
+}
+
+// reused reports whether password is the current password or one of the
+// HistorySize-1 before it.
+func (p *PasswordPolicy) reused(ctx context.Context, user *models.User, password string) (bool, error) {
+	if p.Config.HistorySize == 0 || user.ID == 0 || user.Password == "" {
+		return false, nil
+	}
+
+	hashes := []string{user.Password}
+	if p.Config.HistorySize > 1 {
+		previous, err := p.History.Recent(ctx, user.ID, p.Config.HistorySize-1)
+		if err != nil {
+			return false, err
+		}
+		hashes = append(hashes, previous...)
+	}
+
+	for _, h := range hashes {
+		ok, err := p.Hasher.Verify(password, h)
+		if err != nil {
+			// an unreadable old hash cannot match, it must not block a change
+			logger.Warn("PasswordPolicy.reused", "failed to verify old password hash", map[string]interface{}{
+				"user_id": user.ID,
+				"error":   err.Error(),
+			})
+			continue
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RememberTx moves the user's current hash into the history as it is being
+// replaced, keeping only what reuse checks still need.
+func (p *PasswordPolicy) RememberTx(ctx context.Context, db repositories.DBTX, user *models.User) error {
+	keep := p.Config.HistorySize - 1
+	if keep < 0 {
+		keep = 0
+	}
+	if keep > 0 && user.Password != "" {
+		if err := p.History.AddTx(ctx, db, user.ID, user.Password); err != nil {
+			return err
+		}
+	}
+	return p.History.PruneTx(ctx, db, user.ID, keep)
+}
+
+// Expired reports whether the user has to change their password before
+// signing in again. Service accounts have no usable password to change.
+func (p *PasswordPolicy) Expired(user *models.User) bool {
+	if p.Config.MaxAge <= 0 || user.ServiceAccount || user.PasswordChangedAt.IsZero() {
+		return false
+	}
+	return time.Since(user.PasswordChangedAt) > p.Config.MaxAge
+}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	stderrors "errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"test123/config"
+	"test123/errors"
+	"test123/models"
+	"test123/repositories"
+	"test123/utils/passwordcheck"
+)
+
+// memoryPasswordHistory is an in-memory password_history table, newest
+// hash first.
+type memoryPasswordHistory struct {
+	mu     sync.Mutex
+	hashes map[int][]string
+}
+
+func (r *memoryPasswordHistory) AddTx(ctx context.Context, db repositories.DBTX, userID int, hash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hashes[userID] = append([]string{hash}, r.hashes[userID]...)
+	return nil
+}
+
+func (r *memoryPasswordHistory) Recent(ctx context.Context, userID, limit int) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h := r.hashes[userID]
+	if len(h) > limit {
+		h = h[:limit]
+	}
+	return append([]string(nil), h...), nil
+}
+
+func (r *memoryPasswordHistory) PruneTx(ctx context.Context, db repositories.DBTX, userID, keep int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.hashes[userID]) > keep {
+		r.hashes[userID] = r.hashes[userID][:keep]
+	}
+	return nil
+}
+
+// defaultPasswordPolicy is the policy shipped in application.yaml.
+var defaultPasswordPolicy = config.PasswordPolicy{
+	MinLength:     8,
+	MaxLength:     128,
+	RequireUpper:  true,
+	RequireLower:  true,
+	RequireDigit:  true,
+	RequireSymbol: true,
+	MinStrength:   2,
+	HistorySize:   5,
+}
+
+// breachedList writes a list holding the given passwords and loads it.
+func breachedList(t *testing.T, passwords ...string) *passwordcheck.BreachedList {
+	t.Helper()
+	var lines []string
+	for _, p := range passwords {
+		sum := sha1.Sum([]byte(p))
+		lines = append(lines, strings.ToUpper(hex.EncodeToString(sum[:]))+":42")
+	}
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o600); err != nil {
+		t.Fatalf("write list: %v", err)
+	}
+	l, err := passwordcheck.LoadBreachedList(path)
+	if err != nil {
+		t.Fatalf("LoadBreachedList: %v", err)
+	}
+	return l
+}
+
+func TestPasswordPolicyRules(t *testing.T) {
+	policy := NewPasswordPolicy(nil, NewBcryptHasher(4), breachedList(t, "Hx7#pLq2!vWz"), defaultPasswordPolicy)
+	user := &models.User{Username: "zanzibar", Name: "Zed Quill", Email: "zed@example.com"}
+
+	tests := []struct {
+		name     string
+		password string
+		reasons  []string
+	}{
+		{"strong", "vX9#qLm2!tRw", nil},
+		{"too short", "aB1!x", []string{"at least 8 characters"}},
+		{"too long", strings.Repeat("aB1!", 33), []string{"at most 128 characters"}},
+		{"no upper case", "vx9#qlm2!trw", []string{"an upper case letter"}},
+		{"letters only", "vXqLmtRwPkNb", []string{"a digit, a symbol"}},
+		{"every broken rule listed", "abc", []string{"at least 8 characters", "an upper case letter, a digit, a symbol"}},
+		{"common password", "Password123!", []string{"too easy to guess", "commonly used password"}},
+		{"own username", "Zanzibar2024!", []string{"too easy to guess", "your username, name or email"}},
+		{"breached", "Hx7#pLq2!vWz", []string{"appeared in a data breach"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Validate(context.Background(), user, tt.password)
+			if tt.reasons == nil {
+				if err != nil {
+					t.Fatalf("Validate: %v", err)
+				}
+				return
+			}
+			if !stderrors.Is(err, errors.ErrWeakPassword) {
+				t.Fatalf("got %v, want a weak password error", err)
+			}
+			for _, reason := range tt.reasons {
+				if !strings.Contains(err.Error(), reason) {
+					t.Errorf("%q does not say %q", err.Error(), reason)
+				}
+			}
+		})
+	}
+}
+
+// TestPasswordHistory changes the password through UserService so the
+// history is kept the way it is in production: the replaced hash moves in,
+// and only HistorySize-1 old hashes stay next to the current one.
+func TestPasswordHistory(t *testing.T) {
+	ctx := context.Background()
+	hasher := NewBcryptHasher(4)
+	cfg := defaultPasswordPolicy
+	cfg.HistorySize = 3
+
+	first, err := hasher.Hash("First#Pass1x")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	users := newMemoryUsers(models.User{ID: 1, Username: "zanzibar", Email: "zed@example.com", Password: first})
+	history := &memoryPasswordHistory{hashes: map[int][]string{}}
+	s := &UserService{
+		UserRepo: users,
+		Webhooks: noWebhooks{},
+		Tx:       noTx{},
+		Hasher:   hasher,
+		Policy:   NewPasswordPolicy(history, hasher, nil, cfg),
+	}
+
+	change := func(password string) error {
+		return s.ResetPassword(ctx, "zanzibar", password)
+	}
+	for _, p := range []string{"Second#Pass2y", "Third#Pass3zq"} {
+		if err := change(p); err != nil {
+			t.Fatalf("change to %s: %v", p, err)
+		}
+	}
+
+	// current is Third, history holds Second and First
+	reuse := []struct {
+		name     string
+		password string
+	}{
+		{"current password", "Third#Pass3zq"},
+		{"previous password", "Second#Pass2y"},
+		{"oldest remembered password", "First#Pass1x"},
+	}
+	for _, tt := range reuse {
+		t.Run(tt.name, func(t *testing.T) {
+			err := change(tt.password)
+			if !stderrors.Is(err, errors.ErrWeakPassword) || !strings.Contains(err.Error(), "last 3 passwords") {
+				t.Fatalf("got %v, want the history rule", err)
+			}
+		})
+	}
+
+	if err := change("Fourth#Pass4w"); err != nil {
+		t.Fatalf("change to a new password: %v", err)
+	}
+	if got := len(history.hashes[1]); got != 2 {
+		t.Errorf("history keeps %d hashes, want 2", got)
+	}
+	// First has now dropped out of the last three and may come back
+	if err := change("First#Pass1x"); err != nil {
+		t.Errorf("password older than the history refused: %v", err)
+	}
+}
+
+func TestPasswordPolicyExpired(t *testing.T) {
+	policy := NewPasswordPolicy(nil, nil, nil, config.PasswordPolicy{MaxAge: 90 * 24 * time.Hour})
+	now := time.Now()
+
+	tests := []struct {
+		name string
+		user models.User
+		want bool
+	}{
+		{"changed recently", models.User{PasswordChangedAt: now.Add(-24 * time.Hour)}, false},
+		{"older than max age", models.User{PasswordChangedAt: now.Add(-91 * 24 * time.Hour)}, true},
+		{"service account", models.User{ServiceAccount: true, PasswordChangedAt: now.Add(-365 * 24 * time.Hour)}, false},
+		{"never recorded", models.User{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.Expired(&tt.user); got != tt.want {
+				t.Errorf("Expired = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if NewPasswordPolicy(nil, nil, nil, config.PasswordPolicy{}).Expired(&models.User{PasswordChangedAt: now.Add(-10 * 365 * 24 * time.Hour)}) {
+		t.Error("max age 0 must never expire passwords")
+	}
+}
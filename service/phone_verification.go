@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+
+	"test123/config"
+	"test123/errors"
+	"test123/logger"
+	"test123/repositories"
+	"test123/utils"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// PhoneVerificationService proves a user can receive texts at their mobile
+// number. Codes go out as sms notifications and only their hash, bound to
+// the user and number, is kept in Redis.
+type PhoneVerificationService struct {
+	UserRepo repositories.UserRepoInterface
+	Outbox   repositories.OutboxRepoInterface
+	Redis    *redis.Client
+	Config   config.Auth
+}
+
+func NewPhoneVerificationService(userRepo repositories.UserRepoInterface, outbox repositories.OutboxRepoInterface, rdb *redis.Client, cfg config.Auth) *PhoneVerificationService {
+	return &PhoneVerificationService{UserRepo: userRepo, Outbox: outbox, Redis: rdb, Config: cfg}
+}
+
+func phoneOTPKey(userID int) string {
+	return "phone_otp:" + strconv.Itoa(userID)
+}
+
+// SendCode texts a fresh 6-digit code to the user's mobile number,
+// replacing any earlier one.
+func (s *PhoneVerificationService) SendCode(ctx context.Context, userID int) error {
+	user, err := s.UserRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.MobileNumber == "" {
+		return fmt.Errorf("%w: no mobile number on the account", errors.ErrMissingField)
+	}
+	if user.MobileVerifiedAt != nil {
+		return fmt.Errorf("%w: mobile number already verified", errors.ErrAlreadyProcessed)
+	}
+
+	ok, err := s.Redis.SetNX(ctx, "phone_otp:resend:"+strconv.Itoa(userID), 1, time.Minute).Result()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errors.ErrCacheFailure, err)
+	}
+	if !ok {
+		return fmt.Errorf("%w: wait a minute before requesting another code", errors.ErrRateLimitExceeded)
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errors.ErrInternalFailure, err)
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+
+	key := phoneOTPKey(userID)
+	pipe := s.Redis.TxPipeline()
+	pipe.Del(ctx, key)
+	pipe.HSet(ctx, key, "hash", hashPhoneOTP(userID, user.MobileNumber, code), "number", user.MobileNumber, "attempts", 0)
+	pipe.Expire(ctx, key, s.Config.PhoneOTPTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("%w: %v", errors.ErrCacheFailure, err)
+	}
+
+	expiresIn := strconv.Itoa(int(s.Config.PhoneOTPTTL.Minutes()))
+	event := utils.NewSMSNotificationEvent(
+		user.ID,
+		"phone_verification",
+		"Your verification code is "+code+". It expires in "+expiresIn+" minutes.",
+		user.MobileNumber,
+		map[string]string{
+			"username":           user.Username,
+			"code":               code,
+			"expires_in_minutes": expiresIn,
+		},
+	)
+	if err := enqueueNotification(ctx, s.Outbox, nil, event); err != nil {
+		return err
+	}
+
+	logger.Info("PhoneVerificationService.SendCode", "verification code sent", map[string]interface{}{"user_id": userID})
+	return nil
+}
+
+// Confirm checks a code and marks the mobile number verified. A code stops
+// working after PhoneOTPMaxAttempts wrong guesses or once the number on
+// the account changes.
+func (s *PhoneVerificationService) Confirm(ctx context.Context, userID int, code string) error {
+	key := phoneOTPKey(userID)
+
+	stored, err := s.Redis.HGetAll(ctx, key).Result()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errors.ErrCacheFailure, err)
+	}
+	if len(stored) == 0 {
+		return fmt.Errorf("%w: code is invalid or expired", errors.ErrInvalidToken)
+	}
+
+	attempts, err := s.Redis.HIncrBy(ctx, key, "attempts", 1).Result()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errors.ErrCacheFailure, err)
+	}
+	if attempts > int64(s.Config.PhoneOTPMaxAttempts) {
+		s.Redis.Del(ctx, key)
+		return fmt.Errorf("%w: too many invalid attempts, request a new code", errors.ErrRateLimitExceeded)
+	}
+
+	number := stored["number"]
+	if subtle.ConstantTimeCompare([]byte(hashPhoneOTP(userID, number, code)), []byte(stored["hash"])) != 1 {
+		logger.Warn("PhoneVerificationService.Confirm", "wrong code", map[string]interface{}{"user_id": userID, "attempts": attempts})
+		return fmt.Errorf("%w: code is invalid or expired", errors.ErrInvalidToken)
+	}
+
+	// single use: only the request that removes the code may verify
+	if n, err := s.Redis.Del(ctx, key).Result(); err != nil || n == 0 {
+		return fmt.Errorf("%w: code is invalid or expired", errors.ErrInvalidToken)
+	}
+
+	if err := s.UserRepo.MarkMobileVerified(ctx, userID, number); err != nil {
+		if err == errors.ErrResourceNotFound {
+			return fmt.Errorf("%w: mobile number changed since the code was sent", errors.ErrInvalidToken)
+		}
+		return err
+	}
+
+	logger.Info("PhoneVerificationService.Confirm", "mobile number verified", map[string]interface{}{"user_id": userID})
+	return nil
+}
+
+func hashPhoneOTP(userID int, number, code string) string {
+	sum := sha256.Sum256([]byte(strconv.Itoa(userID) + ":" + number + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
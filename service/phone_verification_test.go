@@ -0,0 +1,238 @@
+package service
+
+import (
+	"context"
+	stderrors "errors"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"test123/config"
+	"test123/errors"
+	"test123/models"
+	"test123/notifier"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// fakeSMS is an SMS gateway that keeps the texts instead of sending them.
+type fakeSMS struct {
+	mu    sync.Mutex
+	texts map[string][]string
+}
+
+func (f *fakeSMS) SendSMS(ctx context.Context, to, body string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.texts[to] = append(f.texts[to], body)
+	return nil
+}
+
+var smsCode = regexp.MustCompile(`\b\d{6}\b`)
+
+type phoneTest struct {
+	svc    *PhoneVerificationService
+	users  *memoryUsers
+	outbox *memoryOutbox
+	sms    *fakeSMS
+	redis  *miniredis.Miniredis
+	inbox  []models.InboxItem
+}
+
+func newPhoneTest(t *testing.T) *phoneTest {
+	t.Helper()
+	rdb, mr := newTestRedis(t)
+	users := newMemoryUsers(models.User{ID: 1, Username: "alice", Email: "alice@example.com", MobileNumber: "5550100200"})
+	outbox := &memoryOutbox{}
+	cfg := config.Auth{PhoneOTPTTL: 10 * time.Minute, PhoneOTPMaxAttempts: 3}
+	return &phoneTest{
+		svc:    NewPhoneVerificationService(users, outbox, rdb, cfg),
+		users:  users,
+		outbox: outbox,
+		sms:    &fakeSMS{texts: map[string][]string{}},
+		redis:  mr,
+	}
+}
+
+// sendCode requests a code and delivers the queued event through the
+// notifier, returning the code the user reads off the text.
+func (p *phoneTest) sendCode(t *testing.T) string {
+	t.Helper()
+	if err := p.svc.SendCode(context.Background(), 1); err != nil {
+		t.Fatalf("SendCode: %v", err)
+	}
+	event := p.outbox.last()
+	if event.NotificationType != "sms" || event.Action != "phone_verification" {
+		t.Fatalf("queued %s/%s, want sms/phone_verification", event.NotificationType, event.Action)
+	}
+	if !models.IsMandatoryAction(event.Action) {
+		t.Errorf("%s may be suppressed by notification preferences", event.Action)
+	}
+
+	p.inbox = dispatch(t, event, notifier.NewSMSChannel(p.sms))
+
+	p.sms.mu.Lock()
+	defer p.sms.mu.Unlock()
+	texts := p.sms.texts["5550100200"]
+	if len(texts) == 0 {
+		t.Fatal("no text was sent to the mobile number")
+	}
+	code := smsCode.FindString(texts[len(texts)-1])
+	if code == "" {
+		t.Fatalf("no code in %q", texts[len(texts)-1])
+	}
+	return code
+}
+
+func (p *phoneTest) verified(t *testing.T) bool {
+	t.Helper()
+	u, err := p.users.GetUserByID(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	return u.MobileVerifiedAt != nil
+}
+
+func wrongCode(code string) string {
+	if code == "000000" {
+		return "111111"
+	}
+	return "000000"
+}
+
+func TestPhoneVerification(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		run      func(t *testing.T, p *phoneTest, code string) error
+		wantErr  error
+		verified bool
+	}{
+		{
+			name: "right code",
+			run: func(t *testing.T, p *phoneTest, code string) error {
+				return p.svc.Confirm(ctx, 1, code)
+			},
+			verified: true,
+		},
+		{
+			name: "right code after a wrong one",
+			run: func(t *testing.T, p *phoneTest, code string) error {
+				p.svc.Confirm(ctx, 1, wrongCode(code))
+				return p.svc.Confirm(ctx, 1, code)
+			},
+			verified: true,
+		},
+		{
+			name: "code used twice",
+			run: func(t *testing.T, p *phoneTest, code string) error {
+				if err := p.svc.Confirm(ctx, 1, code); err != nil {
+					t.Fatalf("first Confirm: %v", err)
+				}
+				return p.svc.Confirm(ctx, 1, code)
+			},
+			wantErr:  errors.ErrInvalidToken,
+			verified: true,
+		},
+		{
+			name: "expired code",
+			run: func(t *testing.T, p *phoneTest, code string) error {
+				p.redis.FastForward(11 * time.Minute)
+				return p.svc.Confirm(ctx, 1, code)
+			},
+			wantErr: errors.ErrInvalidToken,
+		},
+		{
+			name: "right code after max wrong attempts",
+			run: func(t *testing.T, p *phoneTest, code string) error {
+				for i := 0; i < 3; i++ {
+					if err := p.svc.Confirm(ctx, 1, wrongCode(code)); !stderrors.Is(err, errors.ErrInvalidToken) {
+						t.Fatalf("wrong attempt %d: %v", i+1, err)
+					}
+				}
+				return p.svc.Confirm(ctx, 1, code)
+			},
+			wantErr: errors.ErrRateLimitExceeded,
+		},
+		{
+			name: "number changed since the code was sent",
+			run: func(t *testing.T, p *phoneTest, code string) error {
+				p.users.mu.Lock()
+				p.users.users[1].MobileNumber = "5550999999"
+				p.users.mu.Unlock()
+				return p.svc.Confirm(ctx, 1, code)
+			},
+			wantErr: errors.ErrInvalidToken,
+		},
+		{
+			name: "second request within a minute",
+			run: func(t *testing.T, p *phoneTest, code string) error {
+				return p.svc.SendCode(ctx, 1)
+			},
+			wantErr: errors.ErrRateLimitExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPhoneTest(t)
+			code := p.sendCode(t)
+
+			err := tt.run(t, p, code)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if tt.wantErr != nil && !stderrors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got := p.verified(t); got != tt.verified {
+				t.Errorf("mobile verified = %v, want %v", got, tt.verified)
+			}
+		})
+	}
+}
+
+func TestPhoneVerificationNewCodeReplacesOld(t *testing.T) {
+	ctx := context.Background()
+	p := newPhoneTest(t)
+	first := p.sendCode(t)
+
+	// past the resend cooldown
+	p.redis.FastForward(61 * time.Second)
+	second := p.sendCode(t)
+	if first == second {
+		t.Skip("both codes happen to be equal")
+	}
+
+	if err := p.svc.Confirm(ctx, 1, first); !stderrors.Is(err, errors.ErrInvalidToken) {
+		t.Fatalf("replaced code: got %v, want invalid", err)
+	}
+	if err := p.svc.Confirm(ctx, 1, second); err != nil {
+		t.Fatalf("current code: %v", err)
+	}
+	if !p.verified(t) {
+		t.Error("mobile number not verified")
+	}
+}
+
+// TestPhoneVerificationCodeOnlyBySMS checks the code reaches the mobile
+// number and nowhere else: whoever can read the inbox must not be able to
+// verify the number.
+func TestPhoneVerificationCodeOnlyBySMS(t *testing.T) {
+	p := newPhoneTest(t)
+	code := p.sendCode(t)
+
+	if len(p.inbox) != 1 {
+		t.Fatalf("inbox has %d items, want 1", len(p.inbox))
+	}
+	item := p.inbox[0]
+	if strings.Contains(item.Title, code) || strings.Contains(item.Body, code) {
+		t.Errorf("inbox item %q / %q holds the code", item.Title, item.Body)
+	}
+	if item.Title != "Confirm your mobile number" {
+		t.Errorf("inbox title %q, want the neutral one", item.Title)
+	}
+}
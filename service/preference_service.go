@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+	"test123/repositories"
+)
+
+type PreferenceService struct {
+	Repo repositories.PreferenceRepoInterface
+}
+
+func NewPreferenceService(repo repositories.PreferenceRepoInterface) *PreferenceService {
+	return &PreferenceService{Repo: repo}
+}
+
+// Get returns the user's preferences, or the all-enabled defaults when the
+// user never saved any.
+func (s *PreferenceService) Get(ctx context.Context, userID int) (*models.NotificationPreferences, error) {
+	if userID <= 0 {
+		return nil, errors.ErrInvalidParams
+	}
+
+	p, err := s.Repo.Get(ctx, userID)
+	if err == errors.ErrResourceNotFound {
+		return models.DefaultNotificationPreferences(userID), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Channels == nil {
+		p.Channels = map[string]bool{}
+	}
+	if p.Actions == nil {
+		p.Actions = map[string]map[string]bool{}
+	}
+	return p, nil
+}
+
+func (s *PreferenceService) Update(ctx context.Context, p models.NotificationPreferences) error {
+	if p.UserID <= 0 {
+		return errors.ErrInvalidParams
+	}
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	if p.Channels == nil {
+		p.Channels = map[string]bool{}
+	}
+	if p.Actions == nil {
+		p.Actions = map[string]map[string]bool{}
+	}
+
+	logger.Info("PreferenceService.Update", "updating notification preferences", map[string]interface{}{
+		"user_id": p.UserID,
+	})
+	return s.Repo.Upsert(ctx, p)
+}
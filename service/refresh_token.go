@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"test123/logger"
+	"test123/models"
+	"test123/utils"
+	"test123/utils/jwt"
+
+	"github.com/google/uuid"
+)
+
+// Refresh tokens are one-time use. Each session remembers the jti of the
+// only refresh token that may still be redeemed; redeeming it swaps in the
+// successor's jti, so presenting any older token of the session is reuse.
+
+type tokenPair struct {
+	Access  string
+	Refresh string
+	JTI     string
+}
+
+// signTokenPair signs an access token and a refresh token for session sid
+// without storing anything. grant is nil for first-party logins.
+func (s *AuthService) signTokenPair(user *models.User, sid string, grant *jwt.Grant) (*tokenPair, error) {
+	p := &tokenPair{JTI: uuid.NewString()}
+
+	var err error
+	if p.Access, err = s.JWT.GenerateJWTtoken(user.ID, int(s.Config.AccessTokenTTL.Minutes()), sid, grant); err != nil {
+		return nil, fmt.Errorf("failed to generate access token: %w", err)
+	}
+	if p.Refresh, err = s.JWT.GenerateRefreshToken(user.ID, int(s.Config.RefreshTokenTTL.Minutes()), sid, p.JTI, grant); err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	return p, nil
+}
+
+// handleRefreshReuse ends the session of a replayed refresh token and
+// alerts the user: either they or an attacker holds a stolen token.
+func (s *AuthService) handleRefreshReuse(ctx context.Context, user *models.User, sid string) {
+	logger.Warn("handleRefreshReuse", "refresh token reuse detected, revoking session", map[string]interface{}{
+		"username": user.Username,
+		"sid":      sid,
+	})
+
+	if err := s.Sessions.Revoke(ctx, user.ID, sid); err != nil {
+		logger.Error("handleRefreshReuse", "failed to revoke session", map[string]interface{}{"error": err.Error()})
+	}
+
+	event := utils.NewEmailNotificationEvent(
+		user.ID,
+		"security",
+		"Suspicious sign-in activity",
+		"A refresh token that was already used was presented again",
+		user.Email,
+		map[string]string{
+			"username": user.Username,
+			"message":  "an old sign-in token for your account was used again, so we signed that session out. If this was not you, change your password.",
+		},
+	)
+	if err := enqueueNotification(ctx, s.Outbox, nil, event); err != nil {
+		logger.Error("handleRefreshReuse", "failed to store security event", map[string]interface{}{"error": err.Error()})
+	}
+}
+
+// refreshToken is what a presented refresh token claims.
+type refreshToken struct {
+	UserID int
+	SID    string
+	JTI    string
+	// IssuedAt and ExpiresAt are unix times
+	IssuedAt  int64
+	ExpiresAt int64
+	// Grant is set for tokens issued through OAuth
+	Grant *jwt.Grant
+}
+
+func refreshClaims(j *jwt.Jwt, token string) (*refreshToken, error) {
+	claims, err := j.DecodeAs(token, jwt.TokenTypeRefresh)
+	if err != nil {
+		return nil, err
+	}
+	id, ok := claims["user"].(float64)
+	rt := &refreshToken{UserID: int(id), SID: j.FetchClaim("sid", claims), JTI: j.FetchClaim("jti", claims)}
+	if !ok || rt.SID == "" || rt.JTI == "" {
+		return nil, fmt.Errorf("refresh token is missing claims")
+	}
+	if v, ok := claims["iat"].(float64); ok {
+		rt.IssuedAt = int64(v)
+	}
+	if v, ok := claims["exp"].(float64); ok {
+		rt.ExpiresAt = int64(v)
+	}
+	if cid := j.FetchClaim("cid", claims); cid != "" {
+		rt.Grant = &jwt.Grant{ClientID: cid, Scope: j.FetchClaim("scope", claims)}
+	}
+	return rt, nil
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"test123/config"
+	"test123/models"
+	"test123/utils/jwt"
+)
+
+func newRefreshTestService(t *testing.T) (*AuthService, *memoryOutbox, *models.User) {
+	t.Helper()
+	rdb, _ := newTestRedis(t)
+	user := models.User{ID: 1, Username: "alice", Email: "alice@example.com"}
+	outbox := &memoryOutbox{}
+	s := &AuthService{
+		UserService: &UserService{UserRepo: newMemoryUsers(user)},
+		Redis:       rdb,
+		JWT:         newTestJWT(t),
+		Outbox:      outbox,
+		Sessions:    NewSessionService(rdb, time.Hour),
+		Config:      config.Auth{AccessTokenTTL: 15 * time.Minute, RefreshTokenTTL: time.Hour},
+	}
+	return s, outbox, &user
+}
+
+// openSession signs in user on a new session and returns its refresh token.
+func openSession(t *testing.T, s *AuthService, user *models.User, grant *jwt.Grant) (string, string) {
+	t.Helper()
+	sid := NewSessionID()
+	tokens, err := s.signTokenPair(user, sid, grant)
+	if err != nil {
+		t.Fatalf("signTokenPair: %v", err)
+	}
+	if _, err := s.Sessions.Create(context.Background(), sid, user.ID, models.SessionClient{}, tokens.JTI); err != nil {
+		t.Fatalf("Sessions.Create: %v", err)
+	}
+	return sid, tokens.Refresh
+}
+
+func TestRefreshRotationAndReuse(t *testing.T) {
+	ctx := context.Background()
+	s, outbox, user := newRefreshTestService(t)
+	sid, first := openSession(t, s, user, nil)
+
+	status, body := s.GenerateAccessToken(ctx, first)
+	if status != 200 {
+		t.Fatalf("first refresh: %d %v", status, body)
+	}
+	second := body["refresh_token"]
+	if second == "" || second == first {
+		t.Fatalf("refresh token was not rotated: %q", second)
+	}
+
+	steps := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		// the spent token is reuse: the session ends and the user is told
+		{"replayed token", first, 401},
+		// and with it the token that was legitimately handed out
+		{"successor after reuse", second, 403},
+	}
+	for _, step := range steps {
+		if status, body := s.GenerateAccessToken(ctx, step.token); status != step.status {
+			t.Fatalf("%s: got %d %v, want %d", step.name, status, body, step.status)
+		}
+	}
+
+	if err := s.Sessions.Check(ctx, user.ID, sid); err == nil {
+		t.Error("session survived refresh token reuse")
+	}
+	if got := outbox.actions(); len(got) != 1 || got[0] != "security" {
+		t.Errorf("enqueued %v, want one security alert", got)
+	}
+}
+
+func TestRefreshRejectsInvalidTokens(t *testing.T) {
+	ctx := context.Background()
+	s, _, user := newRefreshTestService(t)
+	_, oauth := openSession(t, s, user, &jwt.Grant{ClientID: "app", Scope: "openid"})
+
+	access, err := s.JWT.GenerateJWTtoken(user.ID, 5, NewSessionID(), nil)
+	if err != nil {
+		t.Fatalf("GenerateJWTtoken: %v", err)
+	}
+	other, err := newTestJWT(t).GenerateRefreshToken(user.ID, 60, NewSessionID(), "jti", nil)
+	if err != nil {
+		t.Fatalf("GenerateRefreshToken: %v", err)
+	}
+	unknownSession, err := s.JWT.GenerateRefreshToken(user.ID, 60, NewSessionID(), "jti", nil)
+	if err != nil {
+		t.Fatalf("GenerateRefreshToken: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"empty", "", 400},
+		{"garbage", "not-a-token", 401},
+		{"access token", access, 401},
+		{"signed by another issuer", other, 401},
+		{"issued to an OAuth client", oauth, 400},
+		{"session does not exist", unknownSession, 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, body := s.GenerateAccessToken(ctx, tt.token); status != tt.status {
+				t.Fatalf("got %d %v, want %d", status, body, tt.status)
+			}
+		})
+	}
+}
+
+func TestConcurrentRefreshOnlyOneWins(t *testing.T) {
+	ctx := context.Background()
+	s, _, user := newRefreshTestService(t)
+	_, token := openSession(t, s, user, nil)
+
+	const n = 8
+	statuses := make([]int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			statuses[i], _ = s.GenerateAccessToken(ctx, token)
+		}(i)
+	}
+	wg.Wait()
+
+	ok := 0
+	for _, status := range statuses {
+		if status == 200 {
+			ok++
+		}
+	}
+	if ok != 1 {
+		t.Errorf("%d refreshes of one token succeeded (%v), want exactly 1", ok, statuses)
+	}
+}
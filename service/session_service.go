@@ -0,0 +1,308 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Sessions live in Redis: session:<sid> is a hash with the device details
+// and the jti of the one refresh token that may still be redeemed, and
+// user_sessions:<userID> indexes a user's sids. Both expire after TTL
+// without a refresh.
+const (
+	sessionPrefix      = "session:"
+	userSessionsPrefix = "user_sessions:"
+)
+
+// Results of rotateSessionScript.
+const (
+	refreshRotated  = 1
+	refreshUnknown  = 0
+	refreshReplayed = -1
+)
+
+// rotateSessionScript atomically replaces the session's refresh jti
+// (ARGV[1]) with its successor (ARGV[2]), so two concurrent refreshes
+// cannot both succeed and any older token shows up as replayed.
+var rotateSessionScript = redis.NewScript(`
+local cur = redis.call('HGET', KEYS[1], 'refresh_jti')
+if not cur then return 0 end
+if cur ~= ARGV[1] then return -1 end
+redis.call('HSET', KEYS[1], 'refresh_jti', ARGV[2], 'last_seen_at', ARGV[4])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return 1
+`)
+
+type SessionService struct {
+	Redis *redis.Client
+	TTL   time.Duration
+}
+
+func NewSessionService(rdb *redis.Client, ttl time.Duration) *SessionService {
+	return &SessionService{Redis: rdb, TTL: ttl}
+}
+
+// NewSessionID returns an ID for a session about to be created, so its
+// tokens can be signed first.
+func NewSessionID() string {
+	return uuid.NewString()
+}
+
+// Create stores session sid whose first refresh token has refreshJTI.
+func (s *SessionService) Create(ctx context.Context, sid string, userID int, client models.SessionClient, refreshJTI string) (*models.Session, error) {
+	now := time.Now().UTC()
+	sess := &models.Session{
+		ID:         sid,
+		UserID:     userID,
+		Device:     client.Device,
+		UserAgent:  client.UserAgent,
+		IP:         client.IP,
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}
+	if sess.Device == "" {
+		sess.Device = deviceFromUserAgent(client.UserAgent)
+	}
+
+	key := sessionPrefix + sess.ID
+	index := userSessionsPrefix + strconv.Itoa(userID)
+
+	pipe := s.Redis.TxPipeline()
+	pipe.HSet(ctx, key, map[string]interface{}{
+		"user_id":      userID,
+		"device":       sess.Device,
+		"user_agent":   sess.UserAgent,
+		"ip":           sess.IP,
+		"created_at":   now.Format(time.RFC3339),
+		"last_seen_at": now.Format(time.RFC3339),
+		"refresh_jti":  refreshJTI,
+	})
+	pipe.Expire(ctx, key, s.TTL)
+	pipe.SAdd(ctx, index, sess.ID)
+	pipe.Expire(ctx, index, s.TTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger.Error("SessionService.Create", "failed to store session", map[string]interface{}{
+			"user_id": userID,
+			"error":   err.Error(),
+		})
+		return nil, fmt.Errorf("%w: %v", errors.ErrCacheFailure, err)
+	}
+	return sess, nil
+}
+
+// Check reports whether sid is a live session of userID.
+func (s *SessionService) Check(ctx context.Context, userID int, sid string) error {
+	owner, err := s.Redis.HGet(ctx, sessionPrefix+sid, "user_id").Result()
+	if err == redis.Nil || (err == nil && owner != strconv.Itoa(userID)) {
+		return errors.ErrUnauthorized
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", errors.ErrCacheFailure, err)
+	}
+	return nil
+}
+
+// Touch checks that sid is a live session of userID and records activity.
+func (s *SessionService) Touch(ctx context.Context, userID int, sid string) error {
+	if err := s.Check(ctx, userID, sid); err != nil {
+		return err
+	}
+
+	s.Redis.HSet(ctx, sessionPrefix+sid, "last_seen_at", time.Now().UTC().Format(time.RFC3339))
+	return nil
+}
+
+// Rotate redeems the refresh token jti of session sid for nextJTI and
+// returns one of refreshRotated, refreshUnknown or refreshReplayed.
+func (s *SessionService) Rotate(ctx context.Context, userID int, sid, jti, nextJTI string) (int, error) {
+	result, err := rotateSessionScript.Run(ctx, s.Redis,
+		[]string{sessionPrefix + sid},
+		jti, nextJTI, s.TTL.Milliseconds(), time.Now().UTC().Format(time.RFC3339),
+	).Int()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", errors.ErrCacheFailure, err)
+	}
+	if result == refreshRotated {
+		s.Redis.Expire(ctx, userSessionsPrefix+strconv.Itoa(userID), s.TTL)
+	}
+	return result, nil
+}
+
+// RefreshJTI returns the jti of the session's current refresh token.
+func (s *SessionService) RefreshJTI(ctx context.Context, sid string) (string, error) {
+	jti, err := s.Redis.HGet(ctx, sessionPrefix+sid, "refresh_jti").Result()
+	if err == redis.Nil {
+		return "", errors.ErrResourceNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errors.ErrCacheFailure, err)
+	}
+	return jti, nil
+}
+
+// List returns the user's live sessions, most recently used first, and
+// drops index entries whose session has expired.
+func (s *SessionService) List(ctx context.Context, userID int) ([]models.Session, error) {
+	index := userSessionsPrefix + strconv.Itoa(userID)
+
+	sids, err := s.Redis.SMembers(ctx, index).Result()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrCacheFailure, err)
+	}
+
+	pipe := s.Redis.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(sids))
+	for i, sid := range sids {
+		cmds[i] = pipe.HGetAll(ctx, sessionPrefix+sid)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrCacheFailure, err)
+	}
+
+	list := []models.Session{}
+	var stale []interface{}
+	for i, cmd := range cmds {
+		h := cmd.Val()
+		if len(h) == 0 {
+			stale = append(stale, sids[i])
+			continue
+		}
+		list = append(list, sessionFromHash(sids[i], h))
+	}
+	if len(stale) > 0 {
+		s.Redis.SRem(ctx, index, stale...)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].LastSeenAt.After(list[j].LastSeenAt) })
+	return list, nil
+}
+
+// Revoke ends one session of the user.
+func (s *SessionService) Revoke(ctx context.Context, userID int, sid string) error {
+	owner, err := s.Redis.HGet(ctx, sessionPrefix+sid, "user_id").Result()
+	if err == redis.Nil || (err == nil && owner != strconv.Itoa(userID)) {
+		return errors.ErrResourceNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", errors.ErrCacheFailure, err)
+	}
+
+	pipe := s.Redis.TxPipeline()
+	pipe.Del(ctx, sessionPrefix+sid)
+	pipe.SRem(ctx, userSessionsPrefix+strconv.Itoa(userID), sid)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("%w: %v", errors.ErrCacheFailure, err)
+	}
+
+	logger.Info("SessionService.Revoke", "session revoked", map[string]interface{}{
+		"user_id": userID,
+		"sid":     sid,
+	})
+	return nil
+}
+
+// RevokeAll ends every session of the user except keep (which may be
+// empty) and returns how many were ended.
+func (s *SessionService) RevokeAll(ctx context.Context, userID int, keep string) (int, error) {
+	index := userSessionsPrefix + strconv.Itoa(userID)
+
+	sids, err := s.Redis.SMembers(ctx, index).Result()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", errors.ErrCacheFailure, err)
+	}
+
+	pipe := s.Redis.TxPipeline()
+	n := 0
+	for _, sid := range sids {
+		if sid == keep {
+			continue
+		}
+		pipe.Del(ctx, sessionPrefix+sid)
+		pipe.SRem(ctx, index, sid)
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("%w: %v", errors.ErrCacheFailure, err)
+	}
+
+	logger.Info("SessionService.RevokeAll", "sessions revoked", map[string]interface{}{
+		"user_id": userID,
+		"count":   n,
+	})
+	return n, nil
+}
+
+func sessionFromHash(sid string, h map[string]string) models.Session {
+	userID, _ := strconv.Atoi(h["user_id"])
+	created, _ := time.Parse(time.RFC3339, h["created_at"])
+	lastSeen, _ := time.Parse(time.RFC3339, h["last_seen_at"])
+
+	return models.Session{
+		ID:         sid,
+		UserID:     userID,
+		Device:     h["device"],
+		UserAgent:  h["user_agent"],
+		IP:         h["ip"],
+		CreatedAt:  created,
+		LastSeenAt: lastSeen,
+	}
+}
+
+// deviceFromUserAgent gives sessions a readable default name.
+func deviceFromUserAgent(ua string) string {
+	platforms := []struct{ marker, name string }{
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Macintosh", "Mac"},
+		{"Linux", "Linux"},
+	}
+	browsers := []struct{ marker, name string }{
+		{"Edg/", "Edge"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+		{"PostmanRuntime/", "Postman"},
+	}
+
+	platform, browser := "", ""
+	for _, p := range platforms {
+		if strings.Contains(ua, p.marker) {
+			platform = p.name
+			break
+		}
+	}
+	for _, b := range browsers {
+		if strings.Contains(ua, b.marker) {
+			browser = b.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && platform != "":
+		return browser + " on " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	default:
+		return "Unknown device"
+	}
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+	"test123/repositories"
+)
+
+const authSettingsKey = "auth"
+
+// SettingsService serves runtime settings. Reads are cached briefly since
+// login consults them on every attempt; other instances pick up a change
+// within CacheTTL.
+type SettingsService struct {
+	Repo     repositories.SettingsRepoInterface
+	CacheTTL time.Duration
+
+	mu        sync.Mutex
+	auth      models.AuthSettings
+	fetchedAt time.Time
+}
+
+func NewSettingsService(repo repositories.SettingsRepoInterface) *SettingsService {
+	return &SettingsService{Repo: repo, CacheTTL: 30 * time.Second}
+}
+
+// Auth returns the login rules, or the defaults when none were saved.
+func (s *SettingsService) Auth(ctx context.Context) (models.AuthSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.fetchedAt.IsZero() && time.Since(s.fetchedAt) < s.CacheTTL {
+		return s.auth, nil
+	}
+
+	var a models.AuthSettings
+	if err := s.Repo.Get(ctx, authSettingsKey, &a); err != nil && err != errors.ErrResourceNotFound {
+		return models.AuthSettings{}, err
+	}
+
+	s.auth, s.fetchedAt = a, time.Now()
+	return a, nil
+}
+
+func (s *SettingsService) UpdateAuth(ctx context.Context, a models.AuthSettings) error {
+	if err := s.Repo.Set(ctx, authSettingsKey, a); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.auth, s.fetchedAt = a, time.Now()
+	s.mu.Unlock()
+
+	logger.Info("SettingsService.UpdateAuth", "auth settings updated", map[string]interface{}{
+		"require_verified_email": a.RequireVerifiedEmail,
+	})
+	return nil
+}
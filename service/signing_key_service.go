@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"test123/config"
+	"test123/logger"
+	"test123/models"
+	"test123/repositories"
+	"test123/utils/jwt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// SigningKeyService keeps the JWT key ring in sync with the signing_keys
+// table and rotates keys on schedule. A new key is published in the JWKS
+// PublishLead before it starts signing, and its predecessor keeps
+// verifying until every token it signed has expired.
+type SigningKeyService struct {
+	Repo        repositories.SigningKeyRepoInterface
+	Tx          repositories.Transactor
+	Ring        *jwt.KeyRing
+	Secret      string
+	Algorithm   string
+	RotateEvery time.Duration
+	PublishLead time.Duration
+	ReloadEvery time.Duration
+	// longest lifetime of any token we sign
+	MaxTokenTTL time.Duration
+}
+
+func NewSigningKeyService(repo repositories.SigningKeyRepoInterface, tx repositories.Transactor, ring *jwt.KeyRing, cfg config.Auth) *SigningKeyService {
+	return &SigningKeyService{
+		Repo:        repo,
+		Tx:          tx,
+		Ring:        ring,
+		Secret:      cfg.JWTSecret,
+		Algorithm:   cfg.SigningAlgorithm,
+		RotateEvery: cfg.KeyRotationInterval,
+		PublishLead: cfg.KeyPublishLead,
+		ReloadEvery: cfg.KeyReloadInterval,
+		MaxTokenTTL: maxSignedTokenTTL(cfg),
+	}
+}
+
+// maxSignedTokenTTL is the longest lifetime among the tokens signed with
+// the key ring. Emailed links can outlive the refresh token by days.
+func maxSignedTokenTTL(cfg config.Auth) time.Duration {
+	longest := cfg.AccessTokenTTL
+	for _, ttl := range []time.Duration{
+		cfg.RefreshTokenTTL,
+		cfg.MFAChallengeTTL,
+		cfg.EmailVerifyTTL,
+		cfg.EmailUndoTTL,
+		cfg.OAuthClientTokenTTL,
+		cfg.IDTokenTTL,
+	} {
+		if ttl > longest {
+			longest = ttl
+		}
+	}
+	return longest
+}
+
+func (s *SigningKeyService) List(ctx context.Context) ([]models.SigningKey, error) {
+	return s.Repo.ListUsable(ctx, nil)
+}
+
+// EnsureCurrent creates the first key, or schedules the next one once the
+// newest key is due for rotation, then reloads the ring.
+func (s *SigningKeyService) EnsureCurrent(ctx context.Context) error {
+	err := s.Tx.WithTx(ctx, func(tx pgx.Tx) error {
+		if err := s.Repo.LockRotation(ctx, tx); err != nil {
+			return err
+		}
+
+		keys, err := s.Repo.ListUsable(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if len(keys) == 0 {
+			// nobody can have cached an older JWKS yet, sign right away
+			_, err := s.createKey(ctx, tx, now)
+			return err
+		}
+
+		due := keys[0].ActivatesAt.Add(s.RotateEvery)
+		if now.Before(due.Add(-s.PublishLead)) {
+			return nil
+		}
+		if earliest := now.Add(s.PublishLead); due.Before(earliest) {
+			due = earliest
+		}
+		_, err = s.createKey(ctx, tx, due)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return s.Reload(ctx)
+}
+
+// Rotate schedules a new key right away, e.g. after a suspected leak. It
+// still waits PublishLead before signing so verifiers can fetch it.
+func (s *SigningKeyService) Rotate(ctx context.Context) (*models.SigningKey, error) {
+	var created *models.SigningKey
+	err := s.Tx.WithTx(ctx, func(tx pgx.Tx) error {
+		if err := s.Repo.LockRotation(ctx, tx); err != nil {
+			return err
+		}
+		k, err := s.createKey(ctx, tx, time.Now().Add(s.PublishLead))
+		created = k
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, s.Reload(ctx)
+}
+
+// createKey retires the current key as of the new key's activation plus
+// the longest token lifetime, then stores the new key.
+func (s *SigningKeyService) createKey(ctx context.Context, tx pgx.Tx, activatesAt time.Time) (*models.SigningKey, error) {
+	k, err := jwt.GenerateKey(s.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := k.SealPrivateKey(s.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to seal signing key: %w", err)
+	}
+	public, err := k.MarshalPublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Repo.RetireCurrent(ctx, tx, activatesAt.Add(s.MaxTokenTTL)); err != nil {
+		return nil, err
+	}
+
+	stored := models.SigningKey{
+		KID:         k.ID,
+		Algorithm:   k.Algorithm,
+		PrivateKey:  sealed,
+		PublicKey:   public,
+		ActivatesAt: activatesAt,
+	}
+	if err := s.Repo.Insert(ctx, tx, stored); err != nil {
+		return nil, err
+	}
+
+	logger.Info("SigningKeyService.createKey", "signing key created", map[string]interface{}{
+		"kid":          k.ID,
+		"algorithm":    k.Algorithm,
+		"activates_at": activatesAt,
+	})
+	return &stored, nil
+}
+
+// Reload replaces the ring with the keys currently in the table.
+func (s *SigningKeyService) Reload(ctx context.Context) error {
+	stored, err := s.Repo.ListUsable(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]*jwt.Key, 0, len(stored))
+	for _, sk := range stored {
+		k, err := jwt.OpenKey(sk.KID, sk.Algorithm, sk.PrivateKey, s.Secret)
+		if err != nil {
+			// most likely sealed under a different jwt_secret
+			logger.Error("SigningKeyService.Reload", "failed to open signing key", map[string]interface{}{
+				"kid":   sk.KID,
+				"error": err.Error(),
+			})
+			continue
+		}
+		k.ActivatesAt = sk.ActivatesAt
+		k.RetiresAt = sk.RetiresAt
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no usable signing keys")
+	}
+
+	s.Ring.Replace(keys)
+	return nil
+}
+
+// Run rotates and reloads keys until ctx is cancelled, so every instance
+// picks up keys created by the others.
+func (s *SigningKeyService) Run(ctx context.Context) {
+	logger.Info("SigningKeyService.Run", "signing key rotation started")
+
+	ticker := time.NewTicker(s.ReloadEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("SigningKeyService.Run", "signing key rotation stopped")
+			return
+		case <-ticker.C:
+		}
+
+		if err := s.EnsureCurrent(ctx); err != nil {
+			logger.Error("SigningKeyService.Run", "key rotation failed", map[string]interface{}{
+				"error": err.Error(),
+			})
+			continue
+		}
+
+		// keep retired keys around for a day to help debugging old tokens
+		if _, err := s.Repo.DeleteRetired(ctx, time.Now().Add(-24*time.Hour)); err != nil {
+			logger.Warn("SigningKeyService.Run", "failed to delete retired keys", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+	"test123/repositories"
+)
+
+type TemplateService struct {
+	Repo repositories.TemplateRepoInterface
+}
+
+func NewTemplateService(repo repositories.TemplateRepoInterface) *TemplateService {
+	return &TemplateService{Repo: repo}
+}
+
+func (s *TemplateService) List(ctx context.Context, action string) ([]models.NotificationTemplate, error) {
+	return s.Repo.List(ctx, action)
+}
+
+func (s *TemplateService) Get(ctx context.Context, id int) (*models.NotificationTemplate, error) {
+	if id <= 0 {
+		return nil, errors.ErrInvalidParams
+	}
+	return s.Repo.GetByID(ctx, id)
+}
+
+func (s *TemplateService) Create(ctx context.Context, t models.NotificationTemplate) (*models.NotificationTemplate, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	if err := parseTemplate(t); err != nil {
+		return nil, err
+	}
+
+	logger.Info("TemplateService.Create", "creating template", map[string]interface{}{
+		"action": t.Action,
+		"locale": t.Locale,
+	})
+	return s.Repo.Create(ctx, t)
+}
+
+func (s *TemplateService) Update(ctx context.Context, t models.NotificationTemplate) (*models.NotificationTemplate, error) {
+	if t.ID <= 0 {
+		return nil, errors.ErrInvalidParams
+	}
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	if err := parseTemplate(t); err != nil {
+		return nil, err
+	}
+
+	logger.Info("TemplateService.Update", "updating template", map[string]interface{}{
+		"id": t.ID,
+	})
+	return s.Repo.Update(ctx, t)
+}
+
+func (s *TemplateService) Delete(ctx context.Context, id int) error {
+	if id <= 0 {
+		return errors.ErrInvalidParams
+	}
+	return s.Repo.Delete(ctx, id)
+}
+
+// Resolve finds the template for action in the closest available locale:
+// the exact locale, then its base language ("pt-BR" -> "pt"), then the
+// default locale.
+func (s *TemplateService) Resolve(ctx context.Context, action, locale string) (*models.NotificationTemplate, error) {
+	for _, l := range localeCandidates(locale) {
+		t, err := s.Repo.Find(ctx, action, l)
+		if err == nil {
+			return t, nil
+		}
+		if err != errors.ErrResourceNotFound {
+			return nil, err
+		}
+	}
+	return nil, errors.ErrResourceNotFound
+}
+
+// Render resolves and renders the template for action with data.
+func (s *TemplateService) Render(ctx context.Context, action, locale string, data map[string]string) (*models.RenderedTemplate, error) {
+	t, err := s.Resolve(ctx, action, locale)
+	if err != nil {
+		return nil, err
+	}
+	return RenderTemplate(*t, data)
+}
+
+// Preview renders a stored template by ID with sample data.
+func (s *TemplateService) Preview(ctx context.Context, id int, data map[string]string) (*models.RenderedTemplate, error) {
+	t, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return RenderTemplate(*t, data)
+}
+
+func localeCandidates(locale string) []string {
+	locale = strings.TrimSpace(locale)
+	var out []string
+	if locale != "" {
+		out = append(out, locale)
+		if i := strings.IndexAny(locale, "-_"); i > 0 {
+			out = append(out, locale[:i])
+		}
+	}
+	if locale != models.DefaultLocale {
+		out = append(out, models.DefaultLocale)
+	}
+	return out
+}
+
+// RenderTemplate renders subject and text with text/template and the HTML
+// body with html/template so metadata values are escaped. Missing keys
+// render as empty strings.
+func RenderTemplate(t models.NotificationTemplate, data map[string]string) (*models.RenderedTemplate, error) {
+	if data == nil {
+		data = map[string]string{}
+	}
+
+	out := &models.RenderedTemplate{TemplateID: t.ID, Locale: t.Locale}
+
+	var err error
+	if out.Subject, err = renderText("subject", t.Subject, data); err != nil {
+		return nil, err
+	}
+	if out.Text, err = renderText("text", t.TextBody, data); err != nil {
+		return nil, err
+	}
+
+	if t.HTMLBody != "" {
+		tmpl, err := htmltemplate.New("html").Option("missingkey=zero").Parse(t.HTMLBody)
+		if err != nil {
+			return nil, fmt.Errorf("%w: html body: %v", errors.ErrValidationFailed, err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("%w: html body: %v", errors.ErrValidationFailed, err)
+		}
+		out.HTML = buf.String()
+	}
+
+	return out, nil
+}
+
+func renderText(name, body string, data map[string]string) (string, error) {
+	tmpl, err := texttemplate.New(name).Option("missingkey=zero").Parse(body)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %v", errors.ErrValidationFailed, name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("%w: %s: %v", errors.ErrValidationFailed, name, err)
+	}
+	return buf.String(), nil
+}
+
+// parseTemplate rejects templates that would fail at delivery time.
+func parseTemplate(t models.NotificationTemplate) error {
+	_, err := RenderTemplate(t, nil)
+	return err
+}
@@ -0,0 +1,247 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"test123/logger"
+	"test123/models"
+	"test123/requests"
+	"test123/utils"
+)
+
+// Token type hints of RFC 7662 and RFC 7009. API keys are not a standard
+// type but introspect like tokens.
+const (
+	tokenTypeHintAccess  = "access_token"
+	tokenTypeHintRefresh = "refresh_token"
+	tokenTypeAPIKey      = "api_key"
+)
+
+// Introspect serves RFC 7662 introspection for resource servers, so they
+// need not verify tokens themselves: a token is active only if it is
+// genuine, unexpired, not revoked and, for user tokens, its session is
+// still signed in. Only confidential clients may ask. A token without
+// "scope" carries its user's full permissions.
+func (s *OAuthService) Introspect(ctx context.Context, req requests.OAuthTokenHintReq, basicID, basicSecret string) (map[string]interface{}, *OAuthError) {
+	client, oerr := s.authenticateClient(ctx, req.ClientID, req.ClientSecret, basicID, basicSecret)
+	if oerr != nil {
+		return nil, oerr
+	}
+	if client.Public {
+		return nil, oauthError("invalid_client", "public clients may not introspect tokens")
+	}
+	if req.Token == "" {
+		return nil, oauthError("invalid_request", "token required")
+	}
+
+	var (
+		resp map[string]interface{}
+		err  error
+	)
+	switch {
+	case strings.HasPrefix(req.Token, apiKeyPrefix):
+		resp, err = s.introspectAPIKey(ctx, req.Token)
+	case req.TokenTypeHint == tokenTypeHintRefresh:
+		if resp, err = s.introspectRefresh(ctx, req.Token); resp == nil && err == nil {
+			resp, err = s.introspectAccess(ctx, req.Token)
+		}
+	default:
+		if resp, err = s.introspectAccess(ctx, req.Token); resp == nil && err == nil {
+			resp, err = s.introspectRefresh(ctx, req.Token)
+		}
+	}
+	if err != nil {
+		logger.Error("OAuthService.Introspect", "failed to introspect token", map[string]interface{}{"client_id": client.ID, "error": err.Error()})
+		return nil, oauthError("server_error", "failed to introspect token")
+	}
+	if resp == nil {
+		return map[string]interface{}{"active": false}, nil
+	}
+	resp["active"] = true
+	resp["iss"] = s.Config.Issuer
+	return resp, nil
+}
+
+// introspectAccess describes an active access token, or returns nil when
+// token is not one. Errors are only for failures on our side.
+func (s *OAuthService) introspectAccess(ctx context.Context, token string) (map[string]interface{}, error) {
+	at, err := s.Auth.parseAccessToken(ctx, token)
+	if err != nil {
+		return nil, internalOnly(err)
+	}
+	p := at.Principal
+
+	resp := map[string]interface{}{
+		"token_type": tokenTypeHintAccess,
+		"sub":        p.ClientID,
+		"exp":        at.ExpiresAt,
+		"iat":        at.IssuedAt,
+	}
+	if at.JTI != "" {
+		resp["jti"] = at.JTI
+	}
+	if p.ClientID != "" {
+		resp["client_id"] = p.ClientID
+		resp["scope"] = strings.Join(p.Scopes, " ")
+	}
+	if p.UserID > 0 {
+		if err := s.Auth.Sessions.Check(ctx, p.UserID, p.SessionID); err != nil {
+			return nil, internalOnly(err)
+		}
+		resp["sub"] = strconv.Itoa(p.UserID)
+		resp["sid"] = p.SessionID
+	}
+	return resp, nil
+}
+
+// introspectRefresh describes a refresh token that can still be redeemed,
+// or returns nil when token is not one.
+func (s *OAuthService) introspectRefresh(ctx context.Context, token string) (map[string]interface{}, error) {
+	rt, err := refreshClaims(s.Auth.JWT, token)
+	if err != nil {
+		return nil, nil
+	}
+	if err := s.Auth.Sessions.Check(ctx, rt.UserID, rt.SID); err != nil {
+		return nil, internalOnly(err)
+	}
+	current, err := s.Auth.Sessions.RefreshJTI(ctx, rt.SID)
+	if err != nil {
+		return nil, internalOnly(err)
+	}
+	// an older token of the session has been spent
+	if current != rt.JTI {
+		return nil, nil
+	}
+
+	resp := map[string]interface{}{
+		"token_type": tokenTypeHintRefresh,
+		"sub":        strconv.Itoa(rt.UserID),
+		"sid":        rt.SID,
+		"jti":        rt.JTI,
+		"exp":        rt.ExpiresAt,
+		"iat":        rt.IssuedAt,
+	}
+	if rt.Grant != nil {
+		resp["client_id"] = rt.Grant.ClientID
+		resp["scope"] = rt.Grant.Scope
+	}
+	return resp, nil
+}
+
+// introspectAPIKey describes an active API key, or returns nil.
+func (s *OAuthService) introspectAPIKey(ctx context.Context, raw string) (map[string]interface{}, error) {
+	key, err := s.Auth.APIKeys.Authenticate(ctx, raw)
+	if err != nil {
+		return nil, internalOnly(err)
+	}
+
+	resp := map[string]interface{}{
+		"token_type": tokenTypeAPIKey,
+		"sub":        strconv.Itoa(key.UserID),
+		"scope":      strings.Join(key.Scopes, " "),
+		"iat":        key.CreatedAt.Unix(),
+	}
+	if key.ExpiresAt != nil {
+		resp["exp"] = key.ExpiresAt.Unix()
+	}
+	return resp, nil
+}
+
+// Revoke serves RFC 7009 revocation. Confidential and public clients
+// identify themselves and may only revoke tokens issued to them; tokens
+// from /auth/login carry no client and are revoked without credentials,
+// holding them being proof enough. Revoking a refresh token signs its
+// session out, which ends the session's access tokens too; revoking an
+// access token ends just that token. As the RFC asks, tokens that are
+// invalid, already revoked or not the caller's are not an error.
+func (s *OAuthService) Revoke(ctx context.Context, req requests.OAuthTokenHintReq, basicID, basicSecret string) *OAuthError {
+	var client *models.OAuthClient
+	if basicID != "" || req.ClientID != "" {
+		var oerr *OAuthError
+		if client, oerr = s.authenticateClient(ctx, req.ClientID, req.ClientSecret, basicID, basicSecret); oerr != nil {
+			return oerr
+		}
+	}
+	if req.Token == "" {
+		return oauthError("invalid_request", "token required")
+	}
+	if strings.HasPrefix(req.Token, apiKeyPrefix) {
+		return oauthError("unsupported_token_type", "api keys are revoked through /users/{id}/api-keys")
+	}
+
+	clientID := ""
+	if client != nil {
+		clientID = client.ID
+	}
+
+	var (
+		done bool
+		err  error
+	)
+	if req.TokenTypeHint == tokenTypeHintRefresh {
+		if done, err = s.revokeRefresh(ctx, req.Token, clientID); !done && err == nil {
+			_, err = s.revokeAccess(ctx, req.Token, clientID)
+		}
+	} else {
+		if done, err = s.revokeAccess(ctx, req.Token, clientID); !done && err == nil {
+			_, err = s.revokeRefresh(ctx, req.Token, clientID)
+		}
+	}
+	if err != nil {
+		logger.Error("OAuthService.Revoke", "failed to revoke token", map[string]interface{}{"client_id": clientID, "error": err.Error()})
+		return oauthError("server_error", "failed to revoke token")
+	}
+	return nil
+}
+
+// revokeAccess revokes token if it is an access token issued to clientID
+// and reports whether it was an access token at all.
+func (s *OAuthService) revokeAccess(ctx context.Context, token, clientID string) (bool, error) {
+	at, err := s.Auth.parseAccessToken(ctx, token)
+	if err != nil {
+		return false, internalOnly(err)
+	}
+	if at.Principal.ClientID != clientID {
+		logger.Warn("OAuthService.Revoke", "token belongs to another client", map[string]interface{}{"client_id": clientID})
+		return true, nil
+	}
+	return true, s.Auth.revokeAccessToken(ctx, at)
+}
+
+// revokeRefresh signs out the session of token if it is the current
+// refresh token of a session issued to clientID, and reports whether it
+// was a refresh token at all.
+func (s *OAuthService) revokeRefresh(ctx context.Context, token, clientID string) (bool, error) {
+	rt, err := refreshClaims(s.Auth.JWT, token)
+	if err != nil {
+		return false, nil
+	}
+	owner := ""
+	if rt.Grant != nil {
+		owner = rt.Grant.ClientID
+	}
+	if owner != clientID {
+		logger.Warn("OAuthService.Revoke", "token belongs to another client", map[string]interface{}{"client_id": clientID})
+		return true, nil
+	}
+
+	current, err := s.Auth.Sessions.RefreshJTI(ctx, rt.SID)
+	if err != nil || current != rt.JTI {
+		return true, internalOnly(err)
+	}
+	if err := s.Auth.endSession(ctx, rt.UserID, rt.SID); err != nil {
+		return true, internalOnly(err)
+	}
+	return true, nil
+}
+
+// internalOnly drops errors that just mean the token is not valid and
+// keeps the ones that are failures on our side.
+func internalOnly(err error) error {
+	if err == nil || utils.HttpStatusFromError(err) < 500 {
+		return nil
+	}
+	return err
+}
@@ -2,39 +2,54 @@ package service
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"test123/errors"
-	kafka "test123/kafka/producers"
 	"test123/logger"
 	"test123/models"
 	"test123/repositories"
 	"test123/utils"
+	"test123/utils/jwt"
 
-	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/redis/go-redis/v9"
 	"github.com/willf/bloom"
 )
 
+// serviceAccountUsername keeps service account names usable in the
+// placeholder email address they are given.
+var serviceAccountUsername = regexp.MustCompile(`^[A-Za-z0-9._-]{3,64}$`)
+
 type UserService struct {
 	UserRepo     repositories.UserRepoInterface
-	prod         *kafka.KafkaNotificationProducer
+	Outbox       repositories.OutboxRepoInterface
+	Webhooks     repositories.WebhookRepoInterface
+	Tx           repositories.Transactor
 	UserRoleRepo repositories.UserRoleRepoInterface
 	Redis        *redis.Client
 	Bloom        *bloom.BloomFilter
+	Hasher       PasswordHasher
+	Verifier     *EmailVerifier
+	Policy       *PasswordPolicy
 }
 
 // Constructor
-func NewUserService(repo repositories.UserRepoInterface, Prod *kafka.KafkaNotificationProducer, userRoleRepo repositories.UserRoleRepoInterface, redis *redis.Client, bf *bloom.BloomFilter) *UserService {
+func NewUserService(repo repositories.UserRepoInterface, outbox repositories.OutboxRepoInterface, webhooks repositories.WebhookRepoInterface, tx repositories.Transactor, userRoleRepo repositories.UserRoleRepoInterface, redis *redis.Client, bf *bloom.BloomFilter, hasher PasswordHasher, verifier *EmailVerifier, policy *PasswordPolicy) *UserService {
 	return &UserService{
 		UserRepo:     repo,
-		prod:         Prod,
+		Outbox:       outbox,
+		Webhooks:     webhooks,
+		Tx:           tx,
 		UserRoleRepo: userRoleRepo,
 		Redis:        redis,
 		Bloom:        bf,
+		Hasher:       hasher,
+		Verifier:     verifier,
+		Policy:       policy,
 	}
 }
 
@@ -58,68 +73,149 @@ func (s *UserService) CreateUser(ctx context.Context, user models.User) error {
 		logger.Warn("CreateUser", "missing email")
 		return fmt.Errorf("%w: email is required", errors.ErrMissingField)
 	}
-
-	// Save user
-	err := s.UserRepo.CreateUser(ctx, user)
-	if err != nil {
-		logger.Error("CreateUser", "DB create failed", map[string]interface{}{
-			"error": err.Error(),
+	if err := s.Policy.Validate(ctx, &user, user.Password); err != nil {
+		logger.Warn("CreateUser", "password rejected by policy", map[string]interface{}{
+			"email": user.Email,
 		})
 		return err
 	}
 
-	res, err := s.UserRepo.GetUserByEmail(ctx, user.Email)
+	hashed, err := s.Hasher.Hash(user.Password)
 	if err != nil {
-		logger.Error("CreateUser", "Failed to fetch created user", map[string]interface{}{
+		logger.Error("CreateUser", "password hashing failed", map[string]interface{}{
 			"error": err.Error(),
 		})
-		return err
+		return fmt.Errorf("%w: %v", errors.ErrInternalFailure, err)
 	}
+	user.Password = hashed
+
+	// Save user, its role and the welcome notification atomically
+	var userID int
+	err = s.Tx.WithTx(ctx, func(tx pgx.Tx) error {
+		id, err := s.UserRepo.CreateUserTx(ctx, tx, user)
+		if err != nil {
+			logger.Error("CreateUser", "DB create failed", map[string]interface{}{
+				"error": err.Error(),
+			})
+			return err
+		}
+		userID = id
+
+		// Assign role
+		if err := s.UserRoleRepo.AddUserRoleTx(ctx, tx, "user", id); err != nil {
+			logger.Error("CreateUser", "Failed to assign role", map[string]interface{}{
+				"user_id": id,
+				"error":   err.Error(),
+			})
+			return fmt.Errorf("failed to add role: %v", err)
+		}
 
-	// Build notification event
-	event := utils.NewEmailNotificationEvent(
-		res.ID,
-		"user_created",
-		"Successful Account Creation",
-		"Hi "+res.Username+", your account has been created successfully.",
-		res.Email,
-		nil,
-	)
+		// Build notification event
+		event := utils.NewEmailNotificationEvent(
+			id,
+			"user_created",
+			"Successful Account Creation",
+			"Hi "+user.Username+", your account has been created successfully.",
+			user.Email,
+			map[string]string{"username": user.Username, "name": user.Name},
+		)
+
+		if err := enqueueNotification(ctx, s.Outbox, tx, event); err != nil {
+			logger.Error("CreateUser", "Failed to store notification event", map[string]interface{}{
+				"error": err.Error(),
+			})
+			return err
+		}
 
-	event.EventID = uuid.New()
-	event.CreatedAt = time.Now()
-	event.NotificationType = "email"
+		if err := s.Verifier.SendVerification(ctx, tx, id, user.Username, user.Email); err != nil {
+			logger.Error("CreateUser", "Failed to store verification email", map[string]interface{}{
+				"error": err.Error(),
+			})
+			return err
+		}
 
-	message, err := json.Marshal(event)
+		user.ID = id
+		user.Password = ""
+		return enqueueWebhook(ctx, s.Webhooks, tx, models.WebhookEventUserCreated, newWebhookUser(user))
+	})
 	if err != nil {
-		logger.Error("CreateUser", "Failed to marshal Kafka event", map[string]interface{}{
-			"error": err.Error(),
-		})
-		return fmt.Errorf("failed to marshal notification event: %v", err)
+		return err
 	}
 
-	// Publish to Kafka
-	if err := s.prod.Send(ctx, message); err != nil {
-		logger.Error("CreateUser", "Kafka publish failed", map[string]interface{}{
-			"error": err.Error(),
-		})
-		return fmt.Errorf("failed to publish notification event: %v", err)
+	logger.Info("CreateUser", "User created successfully", map[string]interface{}{
+		"user_id": userID,
+	})
+
+	return nil
+}
+
+// CreateServiceAccount creates a user for a machine. It has no usable
+// password, gets no default role and no email, and authenticates with API
+// keys; admins grant it roles like any other user.
+func (s *UserService) CreateServiceAccount(ctx context.Context, name, username string) (*models.User, error) {
+	if name == "" || username == "" {
+		return nil, fmt.Errorf("%w: name and username are required", errors.ErrMissingField)
+	}
+	if !serviceAccountUsername.MatchString(username) {
+		return nil, fmt.Errorf("%w: username may only contain letters, digits, '.', '_' and '-'", errors.ErrInvalidField)
 	}
 
-	// Assign role
-	if err := s.UserRoleRepo.AddUserRole(ctx, "user", res.ID); err != nil {
-		logger.Error("CreateUser", "Failed to assign role", map[string]interface{}{
-			"user_id": res.ID,
-			"error":   err.Error(),
-		})
-		return fmt.Errorf("failed to add role: %v", err)
+	// nobody knows this password; checkPassword refuses service accounts
+	// anyway
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrInternalFailure, err)
+	}
+	hashed, err := s.Hasher.Hash(secret)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrInternalFailure, err)
 	}
 
-	logger.Info("CreateUser", "User created successfully", map[string]interface{}{
-		"user_id": res.ID,
+	user := models.User{
+		Name:           name,
+		Username:       username,
+		Email:          username + "@service-accounts.invalid",
+		Password:       hashed,
+		ServiceAccount: true,
+	}
+	err = s.Tx.WithTx(ctx, func(tx pgx.Tx) error {
+		id, err := s.UserRepo.CreateUserTx(ctx, tx, user)
+		if err != nil {
+			return err
+		}
+		if err := s.UserRepo.MarkServiceAccountTx(ctx, tx, id); err != nil {
+			return err
+		}
+		user.ID = id
+		user.Password = ""
+		return enqueueWebhook(ctx, s.Webhooks, tx, models.WebhookEventUserCreated, newWebhookUser(user))
 	})
+	if err != nil {
+		return nil, err
+	}
+	s.Bloom.AddString(user.Username)
 
-	return nil
+	logger.Info("CreateServiceAccount", "service account created", map[string]interface{}{
+		"user_id":  user.ID,
+		"username": user.Username,
+	})
+	return s.UserRepo.GetUserByID(ctx, user.ID)
+}
+
+func (s *UserService) ListServiceAccounts(ctx context.Context) ([]models.User, error) {
+	return s.UserRepo.ListServiceAccounts(ctx)
+}
+
+// GetServiceAccount returns the user only if it is a service account.
+func (s *UserService) GetServiceAccount(ctx context.Context, id int) (*models.User, error) {
+	user, err := s.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !user.ServiceAccount {
+		return nil, fmt.Errorf("%w: not a service account", errors.ErrUserNotFound)
+	}
+	return user, nil
 }
 
 func (s *UserService) Get(ctx context.Context) ([]models.User, error) {
@@ -156,7 +252,169 @@ func (s *UserService) UpdateUser(ctx context.Context, user models.User) error {
 		return fmt.Errorf("%w: nothing to update", errors.ErrMissingField)
 	}
 
-	return s.UserRepo.UpdateUser(ctx, user)
+	current, err := s.UserRepo.GetUserByID(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	// a new address only becomes active once confirmed; until then the old
+	// one stays in place and can undo the change
+	newEmail := ""
+	if user.Email != "" && !strings.EqualFold(user.Email, current.Email) {
+		if other, err := s.UserRepo.GetUserByEmail(ctx, user.Email); err == nil && other.ID != user.ID {
+			return fmt.Errorf("%w: email already in use", errors.ErrUserExists)
+		}
+		newEmail = user.Email
+	}
+	user.Email = current.Email
+
+	return s.Tx.WithTx(ctx, func(tx pgx.Tx) error {
+		if err := s.UserRepo.UpdateUserTx(ctx, tx, user); err != nil {
+			return err
+		}
+		if newEmail != "" {
+			if err := s.UserRepo.SetPendingEmailTx(ctx, tx, user.ID, newEmail); err != nil {
+				return err
+			}
+			if err := s.Verifier.SendVerification(ctx, tx, user.ID, user.Username, newEmail); err != nil {
+				return err
+			}
+			if err := s.Verifier.SendChangeAlert(ctx, tx, user.ID, user.Username, current.Email, newEmail); err != nil {
+				return err
+			}
+		}
+		return enqueueWebhook(ctx, s.Webhooks, tx, models.WebhookEventUserUpdated, newWebhookUser(user))
+	})
+}
+
+// VerifyEmail redeems a verification link. It confirms either the current
+// address or a pending change, which then becomes the active address.
+func (s *UserService) VerifyEmail(ctx context.Context, token string) error {
+	link, err := s.Verifier.parse(token, jwt.TokenTypeEmailVerify)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.UserRepo.GetUserByID(ctx, link.UserID)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case link.Email == user.Email && user.EmailVerifiedAt != nil:
+		return nil
+	case link.Email == user.Email, link.Email == user.PendingEmail:
+	default:
+		return fmt.Errorf("%w: link no longer applies", errors.ErrInvalidToken)
+	}
+
+	if link.Email == user.PendingEmail {
+		if other, err := s.UserRepo.GetUserByEmail(ctx, link.Email); err == nil && other.ID != user.ID {
+			return fmt.Errorf("%w: email already in use", errors.ErrUserExists)
+		}
+	}
+
+	if fresh, err := s.Verifier.spend(ctx, link); err != nil {
+		return err
+	} else if !fresh {
+		return fmt.Errorf("%w: link already used", errors.ErrInvalidToken)
+	}
+
+	if link.Email == user.Email {
+		if err := s.UserRepo.MarkEmailVerifiedTx(ctx, nil, user.ID, link.Email); err != nil {
+			return err
+		}
+		logger.Info("VerifyEmail", "email verified", map[string]interface{}{"user_id": user.ID})
+		return nil
+	}
+
+	err = s.Tx.WithTx(ctx, func(tx pgx.Tx) error {
+		if err := s.UserRepo.ConfirmPendingEmailTx(ctx, tx, user.ID, link.Email); err != nil {
+			return err
+		}
+		user.Email, user.Password = link.Email, ""
+		return enqueueWebhook(ctx, s.Webhooks, tx, models.WebhookEventUserUpdated, newWebhookUser(*user))
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Info("VerifyEmail", "email change confirmed", map[string]interface{}{"user_id": user.ID})
+	return nil
+}
+
+// MarkEmailVerified confirms the user's current address after they proved
+// they can read its mail some other way, such as a sign-in link.
+func (s *UserService) MarkEmailVerified(ctx context.Context, userID int, email string) error {
+	return s.UserRepo.MarkEmailVerifiedTx(ctx, nil, userID, email)
+}
+
+// UndoEmailChange redeems the link sent to the old address: it restores
+// that address, drops any pending change and returns the user's ID so the
+// caller can end their sessions.
+func (s *UserService) UndoEmailChange(ctx context.Context, token string) (int, error) {
+	link, err := s.Verifier.parse(token, jwt.TokenTypeEmailUndo)
+	if err != nil {
+		return 0, err
+	}
+	if link.Previous == "" {
+		return 0, fmt.Errorf("%w: link is invalid or expired", errors.ErrInvalidToken)
+	}
+
+	if fresh, err := s.Verifier.spend(ctx, link); err != nil {
+		return 0, err
+	} else if !fresh {
+		return 0, fmt.Errorf("%w: link already used", errors.ErrInvalidToken)
+	}
+
+	user, err := s.UserRepo.GetUserByID(ctx, link.UserID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.Tx.WithTx(ctx, func(tx pgx.Tx) error {
+		if err := s.UserRepo.RestoreEmailTx(ctx, tx, user.ID, link.Previous, link.Email); err != nil {
+			if err == errors.ErrResourceNotFound {
+				return fmt.Errorf("%w: link no longer applies", errors.ErrInvalidToken)
+			}
+			return err
+		}
+		user.Email, user.Password = link.Previous, ""
+		return enqueueWebhook(ctx, s.Webhooks, tx, models.WebhookEventUserUpdated, newWebhookUser(*user))
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	logger.Warn("UndoEmailChange", "email change undone", map[string]interface{}{"user_id": user.ID})
+	return user.ID, nil
+}
+
+// ResendVerification mails a fresh verification link for the pending
+// address, or the current one while it is unverified.
+func (s *UserService) ResendVerification(ctx context.Context, userID int) error {
+	user, err := s.UserRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	email := user.PendingEmail
+	if email == "" {
+		if user.EmailVerifiedAt != nil {
+			return fmt.Errorf("%w: email already verified", errors.ErrAlreadyProcessed)
+		}
+		email = user.Email
+	}
+
+	ok, err := s.Redis.SetNX(ctx, "email_verify:resend:"+strconv.Itoa(userID), 1, time.Minute).Result()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errors.ErrCacheFailure, err)
+	}
+	if !ok {
+		return fmt.Errorf("%w: wait a minute before requesting another link", errors.ErrRateLimitExceeded)
+	}
+
+	return s.Verifier.SendVerification(ctx, nil, user.ID, user.Username, email)
 }
 
 func (s *UserService) DeleteUser(ctx context.Context, id int) error {
@@ -172,7 +430,12 @@ func (s *UserService) DeleteUser(ctx context.Context, id int) error {
 		return fmt.Errorf("%w: invalid user ID", errors.ErrMissingField)
 	}
 
-	return s.UserRepo.DeleteUser(ctx, id)
+	return s.Tx.WithTx(ctx, func(tx pgx.Tx) error {
+		if err := s.UserRepo.DeleteUserTx(ctx, tx, id); err != nil {
+			return err
+		}
+		return enqueueWebhook(ctx, s.Webhooks, tx, models.WebhookEventUserDeleted, webhookUser{ID: id})
+	})
 }
 
 func (s *UserService) GetByUserByEmail(ctx context.Context, email string) (*models.User, error) {
@@ -184,13 +447,106 @@ func (s *UserService) GetByUserByEmail(ctx context.Context, email string) (*mode
 	return s.UserRepo.GetUserByEmail(ctx, email)
 }
 
-func (s *UserService) UpdatePassword(ctx context.Context, email string, password string) error {
+func (s *UserService) UpdatePassword(ctx context.Context, username string, password string) error {
 
 	logger.Info("UpdatePassword", "Updating password", map[string]interface{}{
-		"email": email,
+		"username": username,
+	})
+
+	hashed, err := s.Hasher.Hash(password)
+	if err != nil {
+		logger.Error("UpdatePassword", "password hashing failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return fmt.Errorf("%w: %v", errors.ErrInternalFailure, err)
+	}
+
+	return s.UserRepo.UpdatePassword(ctx, username, hashed)
+}
+
+// ResetPassword sets a new password after a completed reset flow and tells
+// webhook subscribers about it in the same transaction.
+func (s *UserService) ResetPassword(ctx context.Context, username string, password string) error {
+
+	logger.Info("ResetPassword", "Resetting password", map[string]interface{}{
+		"username": username,
+	})
+
+	user, err := s.UserRepo.GetUserByUsername(ctx, username)
+	if err != nil {
+		return err
+	}
+	return s.setPassword(ctx, user, password, models.WebhookEventUserPasswordReset)
+}
+
+// ChangePassword replaces the password of a user who proved they know the
+// current one. user must carry its current hash.
+func (s *UserService) ChangePassword(ctx context.Context, user *models.User, password string) error {
+
+	logger.Info("ChangePassword", "Changing password", map[string]interface{}{
+		"username": user.Username,
 	})
 
-	return s.UserRepo.UpdatePassword(ctx, email, password)
+	return s.setPassword(ctx, user, password, models.WebhookEventUserPasswordChanged)
+}
+
+// setPassword applies the password policy, then stores the new hash, moves
+// the old one into the history and queues the webhook event atomically.
+func (s *UserService) setPassword(ctx context.Context, user *models.User, password, event string) error {
+	if err := s.Policy.Validate(ctx, user, password); err != nil {
+		return err
+	}
+
+	hashed, err := s.Hasher.Hash(password)
+	if err != nil {
+		logger.Error("setPassword", "password hashing failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return fmt.Errorf("%w: %v", errors.ErrInternalFailure, err)
+	}
+
+	return s.Tx.WithTx(ctx, func(tx pgx.Tx) error {
+		if err := s.Policy.RememberTx(ctx, tx, user); err != nil {
+			return err
+		}
+		if err := s.UserRepo.SetPasswordTx(ctx, tx, user.ID, hashed); err != nil {
+			return err
+		}
+		return enqueueWebhook(ctx, s.Webhooks, tx, event, webhookUser{ID: user.ID, Username: user.Username})
+	})
+}
+
+// VerifyPassword checks a password against the user's stored hash. When the
+// stored hash is plaintext or uses outdated parameters it is replaced with a
+// fresh hash from the preferred algorithm.
+func (s *UserService) VerifyPassword(ctx context.Context, user *models.User, password string) (bool, error) {
+
+	ok, err := s.Hasher.Verify(password, user.Password)
+	if err != nil {
+		logger.Error("VerifyPassword", "password verification failed", map[string]interface{}{
+			"username": user.Username,
+			"error":    err.Error(),
+		})
+		return false, err
+	}
+	if !ok {
+		return false, nil
+	}
+
+	if s.Hasher.NeedsRehash(user.Password) {
+		logger.Info("VerifyPassword", "upgrading stored password hash", map[string]interface{}{
+			"username": user.Username,
+		})
+		if err := s.UpdatePassword(ctx, user.Username, password); err != nil {
+			// login still succeeds, the upgrade is retried next time
+			logger.Warn("VerifyPassword", "password rehash failed", map[string]interface{}{
+				"username": user.Username,
+				"error":    err.Error(),
+			})
+		}
+	}
+
+	return true, nil
 }
 
 func (s *UserService) GetUserByEmailOrUsername(ctx context.Context, key string) (*models.User, error) {
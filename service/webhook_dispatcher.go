@@ -0,0 +1,206 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"test123/logger"
+	"test123/models"
+	"test123/repositories"
+)
+
+const (
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookIDHeader        = "X-Webhook-Id"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// SignWebhook returns the signature header value for body sent at ts:
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">". Receivers
+// recompute it and should reject timestamps too far from their clock.
+func SignWebhook(secret string, ts time.Time, body []byte) string {
+	t := strconv.FormatInt(ts.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookDispatcher polls due webhook deliveries and POSTs them to their
+// subscriptions, retrying failures with exponential backoff and disabling
+// subscriptions that keep failing.
+type WebhookDispatcher struct {
+	Repo         repositories.WebhookRepoInterface
+	Client       *http.Client
+	Interval     time.Duration
+	BatchSize    int
+	Lease        time.Duration
+	MaxAttempts  int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	DisableAfter int
+}
+
+func NewWebhookDispatcher(repo repositories.WebhookRepoInterface) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		Repo:         repo,
+		Client:       &http.Client{Timeout: 10 * time.Second},
+		Interval:     2 * time.Second,
+		BatchSize:    50,
+		Lease:        time.Minute,
+		MaxAttempts:  8,
+		BaseDelay:    30 * time.Second,
+		MaxDelay:     6 * time.Hour,
+		DisableAfter: 20,
+	}
+}
+
+// Run dispatches batches until ctx is cancelled.
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	logger.Info("WebhookDispatcher.Run", "webhook dispatcher started")
+
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := d.DispatchBatch(ctx)
+			if err != nil || n < d.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.Info("WebhookDispatcher.Run", "webhook dispatcher stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DispatchBatch sends one batch of due deliveries and returns how many it
+// claimed.
+func (d *WebhookDispatcher) DispatchBatch(ctx context.Context) (int, error) {
+	list, err := d.Repo.ClaimDue(ctx, d.BatchSize, d.Lease)
+	if err != nil {
+		logger.Error("WebhookDispatcher.DispatchBatch", "failed to claim deliveries", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return 0, err
+	}
+
+	for _, w := range list {
+		d.deliver(ctx, w)
+	}
+	return len(list), nil
+}
+
+func (d *WebhookDispatcher) deliver(ctx context.Context, w models.WebhookDispatch) {
+	a := d.post(ctx, w)
+	del := w.Delivery
+
+	if a.Error == "" {
+		if err := d.Repo.RecordSuccess(ctx, del, a); err != nil {
+			// the lease expires and the event is sent again; receivers
+			// dedupe on X-Webhook-Id
+			logger.Error("WebhookDispatcher.deliver", "failed to record success", map[string]interface{}{
+				"id":    del.ID,
+				"error": err.Error(),
+			})
+		}
+		return
+	}
+
+	var next *time.Time
+	if del.Attempts < d.MaxAttempts {
+		t := time.Now().Add(d.backoff(del.Attempts))
+		next = &t
+	}
+
+	logger.Warn("WebhookDispatcher.deliver", "webhook delivery failed", map[string]interface{}{
+		"id":              del.ID,
+		"subscription_id": del.SubscriptionID,
+		"attempts":        del.Attempts,
+		"status":          a.ResponseStatus,
+		"next_attempt":    next,
+		"error":           a.Error,
+	})
+
+	disabled, err := d.Repo.RecordFailure(ctx, del, a, next, d.DisableAfter)
+	if err != nil {
+		logger.Error("WebhookDispatcher.deliver", "failed to record failure", map[string]interface{}{
+			"id":    del.ID,
+			"error": err.Error(),
+		})
+		return
+	}
+	if disabled {
+		logger.Warn("WebhookDispatcher.deliver", "webhook disabled after repeated failures", map[string]interface{}{
+			"subscription_id": del.SubscriptionID,
+			"url":             w.URL,
+		})
+	}
+}
+
+// post sends one signed request. Any non-2xx response is a failure.
+func (d *WebhookDispatcher) post(ctx context.Context, w models.WebhookDispatch) models.WebhookAttempt {
+	start := time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(w.Delivery.Payload))
+	if err != nil {
+		return models.WebhookAttempt{Error: err.Error()}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "test123-webhooks/1")
+	req.Header.Set(WebhookEventHeader, w.Delivery.EventType)
+	req.Header.Set(WebhookIDHeader, w.Delivery.EventID.String())
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(w.Delivery.ID, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(w.Secret, start, w.Delivery.Payload))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return models.WebhookAttempt{Error: err.Error(), Duration: time.Since(start)}
+	}
+	defer resp.Body.Close()
+
+	// keep a short excerpt for the delivery log
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	a := models.WebhookAttempt{
+		ResponseStatus: resp.StatusCode,
+		ResponseBody:   string(body),
+		Duration:       time.Since(start),
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		a.Error = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+	}
+	return a
+}
+
+// backoff doubles BaseDelay per attempt, capped at MaxDelay.
+func (d *WebhookDispatcher) backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	if attempts > 20 {
+		return d.MaxDelay
+	}
+	delay := d.BaseDelay << (attempts - 1)
+	if delay > d.MaxDelay || delay <= 0 {
+		return d.MaxDelay
+	}
+	return delay
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"test123/errors"
+	"test123/logger"
+	"test123/models"
+	"test123/repositories"
+
+	"github.com/google/uuid"
+)
+
+// WebhookEvent is the JSON body POSTed to subscribers.
+type WebhookEvent struct {
+	ID        uuid.UUID   `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// webhookUser is the user representation shared with partners. It never
+// carries the password hash.
+type webhookUser struct {
+	ID           int    `json:"id"`
+	Username     string `json:"username,omitempty"`
+	Email        string `json:"email,omitempty"`
+	Name         string `json:"name,omitempty"`
+	MobileNumber string `json:"mobile_number,omitempty"`
+}
+
+func newWebhookUser(u models.User) webhookUser {
+	return webhookUser{
+		ID:           u.ID,
+		Username:     u.Username,
+		Email:        u.Email,
+		Name:         u.Name,
+		MobileNumber: u.MobileNumber,
+	}
+}
+
+// enqueueWebhook fans eventType out to matching subscriptions using db,
+// which should be the transaction of the change the event describes.
+func enqueueWebhook(ctx context.Context, webhooks repositories.WebhookRepoInterface, db repositories.DBTX, eventType string, data interface{}) error {
+	event := WebhookEvent{
+		ID:        uuid.New(),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook event: %v", err)
+	}
+
+	_, err = webhooks.Enqueue(ctx, db, event.ID, eventType, payload)
+	return err
+}
+
+type WebhookService struct {
+	Repo repositories.WebhookRepoInterface
+}
+
+func NewWebhookService(repo repositories.WebhookRepoInterface) *WebhookService {
+	return &WebhookService{Repo: repo}
+}
+
+func (s *WebhookService) List(ctx context.Context) ([]models.WebhookSubscription, error) {
+	return s.Repo.ListSubscriptions(ctx)
+}
+
+func (s *WebhookService) Get(ctx context.Context, id int) (*models.WebhookSubscription, error) {
+	if id <= 0 {
+		return nil, errors.ErrInvalidParams
+	}
+	return s.Repo.GetSubscription(ctx, id)
+}
+
+// Create registers a subscription. When no secret is given one is
+// generated; either way the caller must hand it to the partner now, as it
+// is not shown again.
+func (s *WebhookService) Create(ctx context.Context, sub models.WebhookSubscription) (*models.WebhookSubscription, error) {
+	if err := sub.Validate(); err != nil {
+		return nil, err
+	}
+
+	if sub.Secret == "" {
+		secret, err := generateWebhookSecret()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errors.ErrInternalFailure, err)
+		}
+		sub.Secret = secret
+	} else if len(sub.Secret) < 16 {
+		return nil, fmt.Errorf("%w: secret must be at least 16 characters", errors.ErrInvalidField)
+	}
+
+	created, err := s.Repo.CreateSubscription(ctx, sub)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("WebhookService.Create", "webhook registered", map[string]interface{}{
+		"id":     created.ID,
+		"url":    created.URL,
+		"events": created.Events,
+	})
+	return created, nil
+}
+
+func (s *WebhookService) Update(ctx context.Context, sub models.WebhookSubscription) (*models.WebhookSubscription, error) {
+	if sub.ID <= 0 {
+		return nil, errors.ErrInvalidParams
+	}
+	if err := sub.Validate(); err != nil {
+		return nil, err
+	}
+
+	logger.Info("WebhookService.Update", "updating webhook", map[string]interface{}{
+		"id":     sub.ID,
+		"active": sub.Active,
+	})
+	return s.Repo.UpdateSubscription(ctx, sub)
+}
+
+func (s *WebhookService) Delete(ctx context.Context, id int) error {
+	if id <= 0 {
+		return errors.ErrInvalidParams
+	}
+	return s.Repo.DeleteSubscription(ctx, id)
+}
+
+func (s *WebhookService) ListDeliveries(ctx context.Context, subscriptionID int, status string, cursor int64, limit int) ([]models.WebhookDelivery, error) {
+	if _, err := s.Get(ctx, subscriptionID); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	return s.Repo.ListDeliveries(ctx, subscriptionID, status, cursor, limit)
+}
+
+func (s *WebhookService) GetDelivery(ctx context.Context, subscriptionID int, id int64) (*models.WebhookDelivery, error) {
+	if subscriptionID <= 0 || id <= 0 {
+		return nil, errors.ErrInvalidParams
+	}
+	return s.Repo.GetDelivery(ctx, subscriptionID, id)
+}
+
+// Redeliver queues the logged payload again. The subscription must be
+// active, otherwise the copy would never be picked up.
+func (s *WebhookService) Redeliver(ctx context.Context, subscriptionID int, id int64) (*models.WebhookDelivery, error) {
+	sub, err := s.Get(ctx, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	if !sub.Active {
+		return nil, fmt.Errorf("%w: webhook is disabled", errors.ErrValidationFailed)
+	}
+
+	d, err := s.Repo.Redeliver(ctx, subscriptionID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("WebhookService.Redeliver", "delivery queued again", map[string]interface{}{
+		"subscription_id": subscriptionID,
+		"original_id":     id,
+		"id":              d.ID,
+	})
+	return d, nil
+}
+
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
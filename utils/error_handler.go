@@ -2,6 +2,7 @@ package utils
 
 import (
 	"encoding/json"
+	"errors"
 
 	"net/http"
 	e "test123/errors"
@@ -15,6 +16,11 @@ func RespondJSON(w http.ResponseWriter, status int, payload interface{}) {
 }
 
 func HttpStatusFromError(err error) int {
+	// services wrap sentinels as fmt.Errorf("%w: detail"), match the sentinel
+	for u := errors.Unwrap(err); u != nil; u = errors.Unwrap(err) {
+		err = u
+	}
+
 	switch err {
 
 	// 400
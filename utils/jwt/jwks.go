@@ -0,0 +1,201 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWK is the public part of a key in RFC 7517 form.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+var b64 = base64.RawURLEncoding
+
+// JWKS publishes every key in the ring that can still verify a token,
+// including keys that will only start signing later.
+func (r *KeyRing) JWKS(now time.Time) JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, k := range r.Keys() {
+		if k.RetiresAt != nil && now.After(*k.RetiresAt) {
+			continue
+		}
+		if jwk, err := k.JWK(); err == nil {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
+
+func (k *Key) JWK() (JWK, error) {
+	jwk := JWK{Kid: k.ID, Use: "sig", Alg: k.Algorithm}
+
+	switch pub := k.Public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64.EncodeToString(pub.N.Bytes())
+		jwk.E = b64.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return JWK{}, fmt.Errorf("unsupported curve %s", pub.Curve.Params().Name)
+		}
+		jwk.Kty = "EC"
+		jwk.Crv = "P-256"
+		jwk.X = b64.EncodeToString(pub.X.FillBytes(make([]byte, 32)))
+		jwk.Y = b64.EncodeToString(pub.Y.FillBytes(make([]byte, 32)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = b64.EncodeToString(pub)
+	default:
+		return JWK{}, fmt.Errorf("unsupported key type %T", k.Public)
+	}
+	return jwk, nil
+}
+
+// Key converts a published JWK back into a verification-only key.
+func (j JWK) Key() (*Key, error) {
+	k := &Key{ID: j.Kid, Algorithm: j.Alg}
+
+	switch j.Kty {
+	case "RSA":
+		n, err := b64.DecodeString(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64.DecodeString(j.E)
+		if err != nil {
+			return nil, err
+		}
+		k.Public = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	case "EC":
+		if j.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", j.Crv)
+		}
+		x, err := b64.DecodeString(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64.DecodeString(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		k.Public = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	case "OKP":
+		x, err := b64.DecodeString(j.X)
+		if err != nil || j.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key %q", j.Kid)
+		}
+		k.Public = ed25519.PublicKey(x)
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", j.Kty)
+	}
+	return k, nil
+}
+
+// FetchJWKS downloads a JWKS document, for services that verify tokens
+// without holding the signing keys:
+//
+//	keys, err := jwt.FetchJWKS(ctx, http.DefaultClient, "http://auth:8083/.well-known/jwks.json")
+//	verifier := jwt.NewJwtWithKeys(jwt.NewKeyRing(keys...))
+//
+// Refetch periodically (and on an unknown kid) to pick up rotated keys.
+func FetchJWKS(ctx context.Context, client *http.Client, url string) ([]*Key, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks fetch failed: %s", resp.Status)
+	}
+
+	var set JWKSet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("invalid jwks document: %w", err)
+	}
+
+	keys := make([]*Key, 0, len(set.Keys))
+	for _, j := range set.Keys {
+		k, err := j.Key()
+		if err != nil {
+			continue // skip keys this verifier cannot use
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// ErrUnknownKey is returned by VerifyExternal when no key has the token's
+// kid; the issuer has probably rotated and its JWKS should be refetched.
+var ErrUnknownKey = errors.New("unknown key id")
+
+// VerifyExternal checks a token signed by another issuer, such as an
+// upstream identity provider's id_token, against keys from its JWKS. Only
+// asymmetric algorithms are accepted, and the token must be unexpired and
+// carry the given issuer and audience.
+func VerifyExternal(tokenStr string, keys []*Key, issuer, audience string) (jwt.MapClaims, error) {
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		for _, k := range keys {
+			// a JWKS with a single key may leave kid out of the token
+			if k.ID != kid && !(kid == "" && len(keys) == 1) {
+				continue
+			}
+			if k.Algorithm != "" && k.Algorithm != token.Method.Alg() {
+				return nil, fmt.Errorf("unexpected signing method %v for key %q", token.Header["alg"], kid)
+			}
+			return k.Public, nil
+		}
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+	}
+
+	token, err := jwt.Parse(tokenStr, keyFunc,
+		jwt.WithValidMethods([]string{AlgRS256, AlgES256, AlgEdDSA}),
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	return claims, nil
+}
@@ -2,58 +2,206 @@ package jwt
 
 import (
 	"fmt"
+	"strconv"
 	"time"
 
-	"log"
-
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
+// Jwt signs tokens with the current key in Keys and sets its kid header.
+// Only tokens naming one of those keys by kid verify.
 type Jwt struct {
-	SecretKey     string
-	SecretKeyByte []byte
+	Keys *KeyRing
 }
 
-func NewJwt(secret string) *Jwt {
-	return &Jwt{
-		SecretKey:     secret,
-		SecretKeyByte: []byte(secret),
-	}
+func NewJwtWithKeys(keys *KeyRing) *Jwt {
+	return &Jwt{Keys: keys}
 }
 
+// validMethods pins what Decode accepts, so a token cannot pick its own
+// algorithm (e.g. "none", or HS256 keyed with a public key).
+var validMethods = []string{AlgRS256, AlgES256, AlgEdDSA}
 
+// Values of the "typ" claim. Access and refresh tokens carry the "sid"
+// claim naming the session they belong to; an MFA challenge only proves the
+// password step and opens no session. Email tokens travel in links. ID
+// tokens tell OpenID Connect clients who signed in and grant nothing.
+const (
+	TokenTypeAccess       = "access"
+	TokenTypeRefresh      = "refresh"
+	TokenTypeMFAChallenge = "mfa_challenge"
+	TokenTypeEmailVerify  = "email_verify"
+	TokenTypeEmailUndo    = "email_undo"
+	TokenTypeID           = "id"
+)
 
-func (J *Jwt) GenerateJWTtoken(Id int, duration int) (string, error) {
+// Grant narrows a token to what an OAuth client was given. A nil Grant
+// means a first-party token carrying the user's full permissions.
+type Grant struct {
+	ClientID string
+	Scope    string // space separated
+}
+
+func (g *Grant) apply(claims jwt.MapClaims) {
+	if g != nil {
+		claims["cid"] = g.ClientID
+		claims["scope"] = g.Scope
+	}
+}
+
+// GenerateJWTtoken issues an access token for session sid valid for
+// duration minutes.
+func (J *Jwt) GenerateJWTtoken(Id int, duration int, sid string, grant *Grant) (string, error) {
 
 	//claims creation and adding to token
 
 	claims := jwt.MapClaims{
 		"user": Id,
+		"typ":  TokenTypeAccess,
+		"sid":  sid,
+		"jti":  uuid.NewString(),
 		"exp":  time.Now().Add(time.Duration(duration) * time.Minute).Unix(),
 
 		"iat": time.Now().Unix(),
-		
 	}
-	//New With Claims give *JWTToken (its aint string)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS384, claims)
+	grant.apply(claims)
+	return J.sign(claims)
+}
+
+// GenerateRefreshToken issues a one-time refresh token with the given jti
+// for session sid.
+func (J *Jwt) GenerateRefreshToken(Id int, duration int, sid, jti string, grant *Grant) (string, error) {
+	claims := jwt.MapClaims{
+		"user": Id,
+		"typ":  TokenTypeRefresh,
+		"sid":  sid,
+		"jti":  jti,
+		"exp":  time.Now().Add(time.Duration(duration) * time.Minute).Unix(),
+		"iat":  time.Now().Unix(),
+	}
+	grant.apply(claims)
+	return J.sign(claims)
+}
+
+// GenerateClientToken issues an access token for an OAuth client acting
+// for itself (client_credentials). It has no user and no session.
+func (J *Jwt) GenerateClientToken(clientID string, ttl time.Duration, scope string) (string, error) {
+	claims := jwt.MapClaims{
+		"sub":   clientID,
+		"typ":   TokenTypeAccess,
+		"cid":   clientID,
+		"scope": scope,
+		"jti":   uuid.NewString(),
+		"exp":   time.Now().Add(ttl).Unix(),
+		"iat":   time.Now().Unix(),
+	}
+	return J.sign(claims)
+}
+
+// GenerateMFAChallenge issues the token a user exchanges, together with a
+// second-factor code, for a session after passing the password check.
+func (J *Jwt) GenerateMFAChallenge(Id int, ttl time.Duration, jti string) (string, error) {
+	claims := jwt.MapClaims{
+		"user": Id,
+		"typ":  TokenTypeMFAChallenge,
+		"jti":  jti,
+		"exp":  time.Now().Add(ttl).Unix(),
+		"iat":  time.Now().Unix(),
+	}
+	return J.sign(claims)
+}
+
+// GenerateEmailToken issues an emailed link token of type typ for address
+// email. previous is the address being replaced, for undo links.
+func (J *Jwt) GenerateEmailToken(Id int, typ, email, previous string, ttl time.Duration, jti string) (string, error) {
+	claims := jwt.MapClaims{
+		"user":  Id,
+		"typ":   typ,
+		"email": email,
+		"jti":   jti,
+		"exp":   time.Now().Add(ttl).Unix(),
+		"iat":   time.Now().Unix(),
+	}
+	if previous != "" {
+		claims["prev"] = previous
+	}
+	return J.sign(claims)
+}
+
+// GenerateIDToken issues an OpenID Connect id_token about user Id for the
+// client audience. extra holds the user claims and nonce, auth_time and sid.
+func (J *Jwt) GenerateIDToken(issuer, audience string, Id int, ttl time.Duration, extra map[string]interface{}) (string, error) {
+	claims := jwt.MapClaims{}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	claims["iss"] = issuer
+	claims["sub"] = strconv.Itoa(Id)
+	claims["aud"] = audience
+	claims["typ"] = TokenTypeID
+	claims["exp"] = time.Now().Add(ttl).Unix()
+	claims["iat"] = time.Now().Unix()
+	return J.sign(claims)
+}
+
+func (J *Jwt) sign(claims jwt.MapClaims) (string, error) {
+	if J.Keys == nil {
+		return "", fmt.Errorf("no signing keys configured")
+	}
 
-	tokenString, err := token.SignedString([]byte(J.SecretKey))
+	key, err := J.Keys.Signer(time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.ID
+
+	tokenString, err := token.SignedString(key.Private)
 	if err != nil {
 		return "", err
 	}
-	log.Println(token.Header)
-	log.Println(token.Claims)
-	log.Println(token.Signature)
 	return tokenString, nil
 
 }
+
+// DecodeAs decodes the token and checks its "typ" claim. Tokens without
+// one are rejected.
+func (j *Jwt) DecodeAs(tokenStr, typ string) (jwt.MapClaims, error) {
+	claims, err := j.Decode(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+
+	got := j.FetchClaim("typ", claims)
+	if got != typ {
+		return nil, fmt.Errorf("expected %s token, got %s", typ, got)
+	}
+	return claims, nil
+}
+
+// DecodeIDTokenHint checks the signature of an id_token we issued but not
+// its expiry: clients send the last one they got as id_token_hint at
+// logout, however old it is.
+func (j *Jwt) DecodeIDTokenHint(tokenStr string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenStr, j.verificationKey, jwt.WithValidMethods(validMethods), jwt.WithoutClaimsValidation())
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	if typ := j.FetchClaim("typ", claims); typ != TokenTypeID {
+		return nil, fmt.Errorf("expected %s token, got %s", TokenTypeID, typ)
+	}
+	return claims, nil
+}
+
 func (j *Jwt) Decode(tokenStr string) (jwt.MapClaims, error) {
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return j.SecretKeyByte, nil
-	})
+	token, err := jwt.Parse(tokenStr, j.verificationKey, jwt.WithValidMethods(validMethods))
 
 	if err != nil {
 		return nil, err
@@ -72,6 +220,27 @@ func (j *Jwt) Decode(tokenStr string) (jwt.MapClaims, error) {
 	return claims, nil
 }
 
+// verificationKey picks the key by kid and checks the token's alg matches
+// the key's own algorithm.
+func (j *Jwt) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, fmt.Errorf("token has no key id")
+	}
+
+	if j.Keys == nil {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	key, ok := j.Keys.Lookup(kid, time.Now())
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method %v for key %q", token.Header["alg"], kid)
+	}
+	return key.Public, nil
+}
+
 func (j *Jwt) FetchClaim(key string, claims jwt.MapClaims) string {
 	if value, exists := claims[key].(string); exists && value != "" {
 		return value
@@ -0,0 +1,166 @@
+package jwt
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func mustKey(t *testing.T, alg string, activates time.Time) *Key {
+	t.Helper()
+	k, err := GenerateKey(alg)
+	if err != nil {
+		t.Fatalf("GenerateKey(%s): %v", alg, err)
+	}
+	k.ActivatesAt = activates
+	return k
+}
+
+func claimsOf(typ string) jwt.MapClaims {
+	c := jwt.MapClaims{
+		"user": 1,
+		"sid":  "s1",
+		"jti":  "j1",
+		"exp":  time.Now().Add(time.Hour).Unix(),
+		"iat":  time.Now().Unix(),
+	}
+	if typ != "" {
+		c["typ"] = typ
+	}
+	return c
+}
+
+// signWith signs claims with method and key, setting kid when not empty.
+func signWith(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign %s: %v", method.Alg(), err)
+	}
+	return s
+}
+
+func TestDecodeKeyAndAlgorithm(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	rsaKey := mustKey(t, AlgRS256, past)
+	ecKey := mustKey(t, AlgES256, past.Add(-time.Hour))
+	edKey := mustKey(t, AlgEdDSA, past.Add(-2*time.Hour))
+	retired := mustKey(t, AlgRS256, past.Add(-3*time.Hour))
+	retiredAt := time.Now().Add(-time.Minute)
+	retired.RetiresAt = &retiredAt
+	stranger := mustKey(t, AlgRS256, past)
+
+	j := NewJwtWithKeys(NewKeyRing(rsaKey, ecKey, edKey, retired))
+
+	der, err := x509.MarshalPKIXPublicKey(rsaKey.Public)
+	if err != nil {
+		t.Fatalf("marshal public key: %v", err)
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"current RS256 key", signWith(t, jwt.SigningMethodRS256, rsaKey.Private, rsaKey.ID, claimsOf(TokenTypeAccess)), true},
+		{"older ES256 key", signWith(t, jwt.SigningMethodES256, ecKey.Private, ecKey.ID, claimsOf(TokenTypeAccess)), true},
+		{"older EdDSA key", signWith(t, jwt.SigningMethodEdDSA, edKey.Private, edKey.ID, claimsOf(TokenTypeAccess)), true},
+		{"HS384 without kid", signWith(t, jwt.SigningMethodHS384, []byte("shared-secret-of-sixteen"), "", claimsOf(TokenTypeAccess)), false},
+		{"HS256 keyed with the public key", signWith(t, jwt.SigningMethodHS256, publicPEM, rsaKey.ID, claimsOf(TokenTypeAccess)), false},
+		{"alg none", signWith(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, rsaKey.ID, claimsOf(TokenTypeAccess)), false},
+		{"alg differs from the key's", signWith(t, jwt.SigningMethodRS256, stranger.Private, ecKey.ID, claimsOf(TokenTypeAccess)), false},
+		{"kid of another key", signWith(t, jwt.SigningMethodRS256, stranger.Private, rsaKey.ID, claimsOf(TokenTypeAccess)), false},
+		{"unknown kid", signWith(t, jwt.SigningMethodRS256, stranger.Private, stranger.ID, claimsOf(TokenTypeAccess)), false},
+		{"no kid", signWith(t, jwt.SigningMethodRS256, rsaKey.Private, "", claimsOf(TokenTypeAccess)), false},
+		{"retired key", signWith(t, jwt.SigningMethodRS256, retired.Private, retired.ID, claimsOf(TokenTypeAccess)), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := j.Decode(tt.token)
+			if tt.ok && err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("Decode accepted the token")
+			}
+		})
+	}
+}
+
+func TestDecodeAsChecksType(t *testing.T) {
+	key := mustKey(t, AlgES256, time.Now().Add(-time.Minute))
+	j := NewJwtWithKeys(NewKeyRing(key))
+
+	tests := []struct {
+		name string
+		typ  string
+		want string
+		ok   bool
+	}{
+		{"matching type", TokenTypeAccess, TokenTypeAccess, true},
+		{"refresh as access", TokenTypeRefresh, TokenTypeAccess, false},
+		{"access as refresh", TokenTypeAccess, TokenTypeRefresh, false},
+		{"MFA challenge as access", TokenTypeMFAChallenge, TokenTypeAccess, false},
+		{"missing type as access", "", TokenTypeAccess, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := signWith(t, jwt.SigningMethodES256, key.Private, key.ID, claimsOf(tt.typ))
+			_, err := j.DecodeAs(token, tt.want)
+			if tt.ok && err != nil {
+				t.Fatalf("DecodeAs failed: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("DecodeAs accepted the token")
+			}
+		})
+	}
+}
+
+func TestSignUsesNewestActiveKey(t *testing.T) {
+	old := mustKey(t, AlgRS256, time.Now().Add(-time.Hour))
+	current := mustKey(t, AlgES256, time.Now().Add(-time.Minute))
+	upcoming := mustKey(t, AlgEdDSA, time.Now().Add(time.Hour))
+	ring := NewKeyRing(old, current, upcoming)
+	j := NewJwtWithKeys(ring)
+
+	token, err := j.GenerateJWTtoken(1, 5, "s1", nil)
+	if err != nil {
+		t.Fatalf("GenerateJWTtoken: %v", err)
+	}
+
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if kid := parsed.Header["kid"]; kid != current.ID {
+		t.Errorf("signed with kid %v, want the current key %s", kid, current.ID)
+	}
+	if alg := parsed.Header["alg"]; alg != AlgES256 {
+		t.Errorf("signed with alg %v, want %s", alg, AlgES256)
+	}
+
+	// a token from before the rotation keeps verifying
+	oldToken := signWith(t, jwt.SigningMethodRS256, old.Private, old.ID, claimsOf(TokenTypeAccess))
+	if _, err := j.Decode(oldToken); err != nil {
+		t.Errorf("token of the previous key rejected: %v", err)
+	}
+}
+
+func TestSignWithoutKeys(t *testing.T) {
+	j := NewJwtWithKeys(nil)
+	if _, err := j.GenerateJWTtoken(1, 5, "s1", nil); err == nil || !strings.Contains(err.Error(), "no signing keys") {
+		t.Fatalf("GenerateJWTtoken without keys: %v", err)
+	}
+}
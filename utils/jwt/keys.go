@@ -0,0 +1,201 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Supported asymmetric signing algorithms.
+const (
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+)
+
+// Key is one signing key. Verification-only keys (from a JWKS) have no
+// Private half.
+type Key struct {
+	ID          string
+	Algorithm   string
+	Private     crypto.Signer
+	Public      crypto.PublicKey
+	ActivatesAt time.Time
+	// RetiresAt is when tokens signed with the key can no longer be valid;
+	// nil while the key is current.
+	RetiresAt *time.Time
+}
+
+// GenerateKey creates a fresh key for alg with a random kid.
+func GenerateKey(alg string) (*Key, error) {
+	var priv crypto.Signer
+	var err error
+
+	switch alg {
+	case AlgRS256:
+		priv, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgES256:
+		priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate %s key: %w", alg, err)
+	}
+
+	kid := make([]byte, 12)
+	if _, err := rand.Read(kid); err != nil {
+		return nil, err
+	}
+
+	return &Key{
+		ID:        base64.RawURLEncoding.EncodeToString(kid),
+		Algorithm: alg,
+		Private:   priv,
+		Public:    priv.Public(),
+	}, nil
+}
+
+// IsSupportedAlgorithm reports whether alg can be used by GenerateKey.
+func IsSupportedAlgorithm(alg string) bool {
+	return alg == AlgRS256 || alg == AlgES256 || alg == AlgEdDSA
+}
+
+// MarshalPublicKey encodes the public half as PKIX DER.
+func (k *Key) MarshalPublicKey() ([]byte, error) {
+	return x509.MarshalPKIXPublicKey(k.Public)
+}
+
+// SealPrivateKey encodes the private half as PKCS#8 and encrypts it with
+// AES-256-GCM under a key derived from secret, for storage at rest.
+func (k *Key) SealPrivateKey(secret string) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.Private)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := keyCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, der, []byte(k.ID)), nil
+}
+
+// OpenKey rebuilds a Key from stored material sealed by SealPrivateKey.
+func OpenKey(kid, alg string, sealed []byte, secret string) (*Key, error) {
+	gcm, err := keyCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("sealed key %s is truncated", kid)
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+
+	der, err := gcm.Open(nil, nonce, ciphertext, []byte(kid))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt key %s: %w", kid, err)
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key %s: %w", kid, err)
+	}
+	priv, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("key %s is not a signing key", kid)
+	}
+
+	return &Key{ID: kid, Algorithm: alg, Private: priv, Public: priv.Public()}, nil
+}
+
+func keyCipher(secret string) (cipher.AEAD, error) {
+	sum := sha256.Sum256([]byte("signing-key:" + secret))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// KeyRing holds every key that may still verify tokens and picks the one
+// to sign with. It is safe for concurrent use and is swapped wholesale
+// when keys are reloaded.
+type KeyRing struct {
+	mu   sync.RWMutex
+	keys map[string]*Key
+	// newest activation first
+	order []*Key
+}
+
+func NewKeyRing(keys ...*Key) *KeyRing {
+	r := &KeyRing{}
+	r.Replace(keys)
+	return r
+}
+
+// Replace swaps in a new set of keys.
+func (r *KeyRing) Replace(keys []*Key) {
+	m := make(map[string]*Key, len(keys))
+	order := make([]*Key, 0, len(keys))
+	for _, k := range keys {
+		m[k.ID] = k
+		order = append(order, k)
+	}
+	sort.Slice(order, func(i, j int) bool { return order[i].ActivatesAt.After(order[j].ActivatesAt) })
+
+	r.mu.Lock()
+	r.keys, r.order = m, order
+	r.mu.Unlock()
+}
+
+// Signer returns the newest key that is active at now and has a private
+// half. Keys that activate later are published for verification first.
+func (r *KeyRing) Signer(now time.Time) (*Key, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, k := range r.order {
+		if k.Private != nil && !k.ActivatesAt.After(now) {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("no active signing key")
+}
+
+// Lookup finds a verification key by kid, ignoring retired keys.
+func (r *KeyRing) Lookup(kid string, now time.Time) (*Key, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	k, ok := r.keys[kid]
+	if !ok || (k.RetiresAt != nil && now.After(*k.RetiresAt)) {
+		return nil, false
+	}
+	return k, true
+}
+
+// Keys returns the keys in the ring, newest first.
+func (r *KeyRing) Keys() []*Key {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]*Key(nil), r.order...)
+}
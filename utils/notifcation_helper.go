@@ -29,3 +29,23 @@ func NewEmailNotificationEvent(
 		CreatedAt:        time.Now(),
 	}
 }
+
+func NewSMSNotificationEvent(
+	userID int,
+	action string,
+	message string,
+	target string, // phone number
+	metadata map[string]string,
+) events.NotificationEvent {
+
+	return events.NotificationEvent{
+		EventID:          uuid.New(),
+		UserID:           userID,
+		Action:           action,
+		NotificationType: "sms",
+		Message:          message,
+		Target:           target,
+		Metadata:         metadata,
+		CreatedAt:        time.Now(),
+	}
+}
@@ -0,0 +1,77 @@
+package passwordcheck
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// BreachedList holds the SHA-1 hashes of passwords known from breaches, in
+// the format of the Have I Been Pwned downloads: one upper or lower case
+// hex hash per line, optionally followed by ":count". Blank lines and lines
+// starting with # are skipped. A nil list contains nothing.
+type BreachedList struct {
+	hashes [][sha1.Size]byte
+}
+
+// LoadBreachedList reads the list at path. An empty path disables the
+// check and returns a nil list.
+func LoadBreachedList(path string) (*BreachedList, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password list: %w", err)
+	}
+	defer f.Close()
+
+	l := &BreachedList{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			line = line[:i]
+		}
+		var h [sha1.Size]byte
+		if len(line) != hex.EncodedLen(sha1.Size) {
+			return nil, fmt.Errorf("breached password list line %d is not a SHA-1 hash", n)
+		}
+		if _, err := hex.Decode(h[:], []byte(line)); err != nil {
+			return nil, fmt.Errorf("breached password list line %d is not a SHA-1 hash", n)
+		}
+		l.hashes = append(l.hashes, h)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read breached password list: %w", err)
+	}
+
+	sort.Slice(l.hashes, func(i, j int) bool { return bytes.Compare(l.hashes[i][:], l.hashes[j][:]) < 0 })
+	return l, nil
+}
+
+// Len is the number of hashes in the list.
+func (l *BreachedList) Len() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.hashes)
+}
+
+// Contains reports whether password is on the list.
+func (l *BreachedList) Contains(password string) bool {
+	if l == nil {
+		return false
+	}
+	h := sha1.Sum([]byte(password))
+	i := sort.Search(len(l.hashes), func(i int) bool { return bytes.Compare(l.hashes[i][:], h[:]) >= 0 })
+	return i < len(l.hashes) && l.hashes[i] == h
+}
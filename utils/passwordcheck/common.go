@@ -0,0 +1,45 @@
+package passwordcheck
+
+// commonPasswords are among the most used passwords and password stems,
+// most common first. The position is the guess rank.
+var commonPasswords = []string{
+	"password", "123456", "qwerty", "admin", "welcome", "letmein", "monkey",
+	"dragon", "iloveyou", "football", "baseball", "sunshine", "princess",
+	"master", "shadow", "superman", "batman", "trustno", "abc123", "login",
+	"passw0rd", "starwars", "whatever", "freedom", "hello", "secret",
+	"charlie", "michael", "jennifer", "jordan", "hunter", "ranger", "buster",
+	"soccer", "hockey", "killer", "george", "andrew", "thomas", "daniel",
+	"jessica", "ashley", "bailey", "pepper", "ginger", "cookie", "summer",
+	"winter", "spring", "autumn", "flower", "orange", "banana", "purple",
+	"yellow", "silver", "golden", "diamond", "matrix", "mustang", "corvette",
+	"harley", "maverick", "cheese", "chocolate", "computer", "internet",
+	"access", "changeme", "default", "guest", "root", "user", "test",
+	"tester", "testing", "demo", "love", "lovely", "angel", "baby", "family",
+	"friend", "friends", "forever", "money", "pass", "passwd", "pokemon",
+	"naruto", "google", "apple", "samsung", "microsoft", "london", "paris",
+	"berlin", "india", "america", "canada", "england", "liverpool",
+	"chelsea", "arsenal", "barcelona", "madrid", "qazwsx", "zaq1", "asdf",
+	"zxcv", "qwer", "azerty", "111111", "000000", "121212", "654321",
+	"666666", "696969", "7777777", "888888", "987654321", "1q2w3e",
+	"q1w2e3r4", "blink182", "jordan23", "security", "company", "office",
+	"server", "system", "service", "support", "manager", "network",
+	"database", "oracle", "postgres", "mysql", "welcome1", "summer2024",
+	"hello123", "superstar", "rockstar", "player", "gamer", "hacker",
+	"ninja", "tigger", "dolphin", "eagle", "tiger", "lion", "horse",
+	"kitten", "puppy", "doggy", "secret1", "nothing", "mother", "father",
+	"sister", "brother", "jesus", "heaven", "blessed", "happy", "smile",
+}
+
+var commonRank, maxWordLen = func() (map[string]int, int) {
+	ranks := make(map[string]int, len(commonPasswords))
+	longest := 0
+	for i, w := range commonPasswords {
+		if _, ok := ranks[w]; !ok {
+			ranks[w] = i + 1
+		}
+		if n := len([]rune(w)); n > longest {
+			longest = n
+		}
+	}
+	return ranks, longest
+}()
@@ -0,0 +1,314 @@
+// Package passwordcheck estimates how guessable a password is and checks
+// passwords against a list of known breached ones.
+//
+// The estimator follows zxcvbn: the password is split into the patterns an
+// attacker tries first (common passwords, the user's own details,
+// sequences, repeats, keyboard runs, years) and the guesses needed for
+// each part are multiplied. Whatever no pattern covers is brute forced.
+package passwordcheck
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Result is the estimate for one password.
+type Result struct {
+	// Score is 0 (guessed almost instantly) to 4 (very unlikely to be
+	// guessed), on the zxcvbn scale.
+	Score int
+	// Guesses is the estimated number of guesses needed.
+	Guesses float64
+	// Warning names the weakest pattern found, empty when there was none.
+	Warning string
+}
+
+const (
+	warnCommon    = "contains a commonly used password"
+	warnPersonal  = "contains your username, name or email"
+	warnSequence  = "contains a sequence like abc or 123"
+	warnRepeat    = "contains repeated characters"
+	warnKeyboard  = "contains a keyboard pattern like qwerty"
+	warnYear      = "contains a year"
+	minInputChars = 3
+)
+
+var keyboardRows = []string{
+	"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./",
+	"~!@#$%^&*()_+", "1qaz2wsx3edc4rfv5tgb6yhn7ujm8ik9ol0p",
+}
+
+// leet maps common substitutions back to the letter they stand for.
+var leet = map[rune]rune{
+	'4': 'a', '@': 'a', '8': 'b', '(': 'c', '3': 'e', '6': 'g', '1': 'i',
+	'!': 'i', '0': 'o', '5': 's', '$': 's', '7': 't', '+': 't', '2': 'z',
+}
+
+type match struct {
+	length  int
+	guesses float64
+	warning string
+}
+
+// Estimate scores password. userInputs are the user's own details, such as
+// username, name and email, which an attacker targeting them tries first.
+func Estimate(password string, userInputs ...string) Result {
+	runes := []rune(password)
+	lower := []rune(strings.ToLower(password))
+	inputs := normalizeInputs(userInputs)
+
+	guesses := 1.0
+	warning := ""
+	weakest := math.Inf(1)
+	for i := 0; i < len(runes); {
+		m := bestMatch(runes, lower, i, inputs)
+		if m.length == 0 {
+			guesses *= cardinality(runes[i])
+			i++
+			continue
+		}
+		guesses *= m.guesses
+		if perChar := m.guesses / float64(m.length); perChar < weakest {
+			weakest, warning = perChar, m.warning
+		}
+		i += m.length
+	}
+	return Result{Score: score(guesses), Guesses: guesses, Warning: warning}
+}
+
+// score buckets guesses with the zxcvbn thresholds.
+func score(guesses float64) int {
+	switch {
+	case guesses < 1e3+5:
+		return 0
+	case guesses < 1e6+5:
+		return 1
+	case guesses < 1e8+5:
+		return 2
+	case guesses < 1e10+5:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// bestMatch picks the pattern starting at i that covers the most
+// characters, preferring the cheaper one on a tie.
+func bestMatch(runes, lower []rune, i int, inputs []string) match {
+	var best match
+	consider := func(m match) {
+		if m.length > best.length || (m.length == best.length && m.length > 0 && m.guesses < best.guesses) {
+			best = m
+		}
+	}
+	consider(dictionaryMatch(runes, lower, i))
+	consider(inputMatch(lower, i, inputs))
+	consider(sequenceMatch(lower, i))
+	consider(repeatMatch(runes, i))
+	consider(keyboardMatch(lower, i))
+	consider(yearMatch(runes, i))
+	return best
+}
+
+func dictionaryMatch(runes, lower []rune, i int) match {
+	var best match
+	for l := len(lower) - i; l >= minInputChars; l-- {
+		if l > maxWordLen {
+			continue
+		}
+		word := lower[i : i+l]
+		plain, unleeted := string(word), unleet(word)
+		for _, candidate := range []struct {
+			w      string
+			factor float64
+		}{
+			{plain, 1},
+			{unleeted, 2},
+			{reverse(plain), 2},
+		} {
+			rank, ok := commonRank[candidate.w]
+			if !ok || (candidate.factor == 2 && candidate.w == plain) {
+				continue
+			}
+			g := float64(rank) * candidate.factor * capitalization(runes[i:i+l])
+			if best.length == 0 || g < best.guesses {
+				best = match{length: l, guesses: g, warning: warnCommon}
+			}
+		}
+		if best.length > 0 {
+			return best
+		}
+	}
+	return best
+}
+
+func inputMatch(lower []rune, i int, inputs []string) match {
+	rest := string(lower[i:])
+	unleeted := unleet(lower[i:])
+	var best match
+	for _, in := range inputs {
+		n := len([]rune(in))
+		if n > best.length && (strings.HasPrefix(rest, in) || strings.HasPrefix(unleeted, in)) {
+			best = match{length: n, guesses: 2, warning: warnPersonal}
+		}
+	}
+	return best
+}
+
+// sequenceMatch finds runs such as abc, 9876 or ace with a constant step.
+func sequenceMatch(lower []rune, i int) match {
+	if i+2 >= len(lower) {
+		return match{}
+	}
+	step := lower[i+1] - lower[i]
+	if step == 0 || step > 2 || step < -2 {
+		return match{}
+	}
+	j := i + 1
+	for j+1 < len(lower) && lower[j+1]-lower[j] == step {
+		j++
+	}
+	n := j - i + 1
+	if n < minInputChars {
+		return match{}
+	}
+	base := 26.0
+	if unicode.IsDigit(lower[i]) {
+		base = 10
+	}
+	if strings.ContainsRune("a1z90", lower[i]) {
+		base = 4
+	}
+	if step < 0 {
+		base *= 2
+	}
+	return match{length: n, guesses: base * float64(n), warning: warnSequence}
+}
+
+// repeatMatch finds a character repeated three or more times.
+func repeatMatch(runes []rune, i int) match {
+	j := i
+	for j+1 < len(runes) && runes[j+1] == runes[i] {
+		j++
+	}
+	n := j - i + 1
+	if n < minInputChars {
+		return match{}
+	}
+	return match{length: n, guesses: cardinality(runes[i]) * float64(n), warning: warnRepeat}
+}
+
+// keyboardMatch finds four or more adjacent keys typed along a row, or
+// down a column for the last row.
+func keyboardMatch(lower []rune, i int) match {
+	var best match
+	for _, row := range keyboardRows {
+		for _, r := range []string{row, reverse(row)} {
+			rr := []rune(r)
+			for start := range rr {
+				n := 0
+				for i+n < len(lower) && start+n < len(rr) && lower[i+n] == rr[start+n] {
+					n++
+				}
+				if n >= 4 && n > best.length {
+					best = match{length: n, guesses: 40 * float64(n), warning: warnKeyboard}
+				}
+			}
+		}
+	}
+	return best
+}
+
+// yearMatch finds years from 1900 to 2099.
+func yearMatch(runes []rune, i int) match {
+	if i+4 > len(runes) {
+		return match{}
+	}
+	y := string(runes[i : i+4])
+	for _, r := range y {
+		if !unicode.IsDigit(r) {
+			return match{}
+		}
+	}
+	if !strings.HasPrefix(y, "19") && !strings.HasPrefix(y, "20") {
+		return match{}
+	}
+	return match{length: 4, guesses: 200, warning: warnYear}
+}
+
+// capitalization is how many more guesses the upper case letters of word
+// cost over the all lower case version.
+func capitalization(word []rune) float64 {
+	upper, lower := 0, 0
+	for _, r := range word {
+		if unicode.IsUpper(r) {
+			upper++
+		} else if unicode.IsLower(r) {
+			lower++
+		}
+	}
+	switch {
+	case upper == 0:
+		return 1
+	case lower == 0 || (upper == 1 && unicode.IsUpper(word[0])) || (upper == 1 && unicode.IsUpper(word[len(word)-1])):
+		// ALL CAPS and a capitalized first or last letter are tried first
+		return 2
+	default:
+		return math.Pow(2, float64(upper))
+	}
+}
+
+func cardinality(r rune) float64 {
+	switch {
+	case unicode.IsLower(r), unicode.IsUpper(r):
+		return 26
+	case unicode.IsDigit(r):
+		return 10
+	case r < unicode.MaxASCII:
+		return 33
+	default:
+		return 100
+	}
+}
+
+func normalizeInputs(inputs []string) []string {
+	var out []string
+	add := func(s string) {
+		if s = strings.ToLower(strings.TrimSpace(s)); len([]rune(s)) >= minInputChars {
+			out = append(out, s)
+		}
+	}
+	for _, in := range inputs {
+		add(in)
+		// an email's local part and each word of a name are tried alone
+		if at := strings.IndexByte(in, '@'); at > 0 {
+			add(in[:at])
+		}
+		for _, f := range strings.FieldsFunc(in, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			add(f)
+		}
+	}
+	return out
+}
+
+func unleet(word []rune) string {
+	out := make([]rune, len(word))
+	for i, r := range word {
+		if l, ok := leet[r]; ok {
+			r = l
+		}
+		out[i] = r
+	}
+	return string(out)
+}
+
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
@@ -19,3 +19,15 @@ func ExtractToken(r *http.Request) (string, error) {
 
 	return "", fmt.Errorf("missing token in Authorization header")
 }
+
+// ExtractAPIKey returns the API key sent in the X-API-Key header or as
+// "Authorization: ApiKey <key>", or "" when there is none.
+func ExtractAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return strings.TrimSpace(key)
+	}
+	if authHeader := r.Header.Get("Authorization"); strings.HasPrefix(authHeader, "ApiKey ") {
+		return strings.TrimSpace(strings.TrimPrefix(authHeader, "ApiKey "))
+	}
+	return ""
+}
@@ -0,0 +1,83 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of the RFC 6238 appendix B test vectors.
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeMatchesRFC6238(t *testing.T) {
+	// the RFC lists 8-digit codes; ours are their last 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code at %d: %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Step(now)
+
+	codeAt := func(step int64) string {
+		c, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatalf("Code: %v", err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		ok       bool
+	}{
+		{"current step", codeAt(current), current, true},
+		{"previous step", codeAt(current - 1), current - 1, true},
+		{"next step", codeAt(current + 1), current + 1, true},
+		{"two steps old", codeAt(current - 2), 0, false},
+		{"two steps ahead", codeAt(current + 2), 0, false},
+		{"spaces are ignored", codeAt(current)[:3] + " " + codeAt(current)[3:], current, true},
+		{"too short", codeAt(current)[:5], 0, false},
+		{"too long", codeAt(current) + "0", 0, false},
+		{"empty", "", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tt.code, now)
+			if ok != tt.ok {
+				t.Fatalf("Validate(%q) ok = %v, want %v", tt.code, ok, tt.ok)
+			}
+			if ok && step != tt.wantStep {
+				t.Errorf("Validate(%q) matched step %d, want %d", tt.code, step, tt.wantStep)
+			}
+		})
+	}
+}
+
+func TestValidateRejectsBadSecret(t *testing.T) {
+	if _, ok := Validate("not base32!", "123456", time.Now()); ok {
+		t.Fatal("Validate accepted a code for an undecodable secret")
+	}
+}
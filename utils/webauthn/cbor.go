@@ -0,0 +1,130 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// decodeCBOR reads one CBOR item from b and returns it with the bytes that
+// follow. It covers what authenticators emit (CTAP2 canonical CBOR):
+// definite lengths only, integers as int64, byte and text strings, arrays,
+// maps keyed by int64 or string, booleans, null and floats.
+func decodeCBOR(b []byte) (interface{}, []byte, error) {
+	return decodeItem(b, 0)
+}
+
+// maxDepth stops hostile input from recursing without bound.
+const maxDepth = 16
+
+func decodeItem(b []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxDepth {
+		return nil, nil, fmt.Errorf("cbor: nested too deep")
+	}
+	if len(b) == 0 {
+		return nil, nil, fmt.Errorf("cbor: unexpected end of data")
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, b[1:], nil
+		case 21:
+			return true, b[1:], nil
+		case 22, 23:
+			return nil, b[1:], nil
+		case 26:
+			if len(b) < 5 {
+				return nil, nil, fmt.Errorf("cbor: truncated float")
+			}
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(b[1:5]))), b[5:], nil
+		case 27:
+			if len(b) < 9 {
+				return nil, nil, fmt.Errorf("cbor: truncated float")
+			}
+			return math.Float64frombits(binary.BigEndian.Uint64(b[1:9])), b[9:], nil
+		}
+		return nil, nil, fmt.Errorf("cbor: unsupported simple value %d", info)
+	}
+
+	n, rest, err := decodeArgument(b, info)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0:
+		if n > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("cbor: integer overflow")
+		}
+		return int64(n), rest, nil
+	case 1:
+		if n > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("cbor: integer overflow")
+		}
+		return -1 - int64(n), rest, nil
+	case 2, 3:
+		if uint64(len(rest)) < n {
+			return nil, nil, fmt.Errorf("cbor: truncated string")
+		}
+		if major == 2 {
+			return append([]byte(nil), rest[:n]...), rest[n:], nil
+		}
+		return string(rest[:n]), rest[n:], nil
+	case 4:
+		if n > uint64(len(rest)) {
+			return nil, nil, fmt.Errorf("cbor: truncated array")
+		}
+		list := make([]interface{}, 0, n)
+		for i := uint64(0); i < n; i++ {
+			var v interface{}
+			if v, rest, err = decodeItem(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+			list = append(list, v)
+		}
+		return list, rest, nil
+	case 5:
+		if n > uint64(len(rest)) {
+			return nil, nil, fmt.Errorf("cbor: truncated map")
+		}
+		m := make(map[interface{}]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			var k, v interface{}
+			if k, rest, err = decodeItem(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("cbor: unsupported map key %T", k)
+			}
+			if v, rest, err = decodeItem(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+			m[k] = v
+		}
+		return m, rest, nil
+	}
+	return nil, nil, fmt.Errorf("cbor: unsupported major type %d", major)
+}
+
+// decodeArgument reads the length or value that follows the initial byte.
+func decodeArgument(b []byte, info byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), b[1:], nil
+	case info == 24 && len(b) >= 2:
+		return uint64(b[1]), b[2:], nil
+	case info == 25 && len(b) >= 3:
+		return uint64(binary.BigEndian.Uint16(b[1:3])), b[3:], nil
+	case info == 26 && len(b) >= 5:
+		return uint64(binary.BigEndian.Uint32(b[1:5])), b[5:], nil
+	case info == 27 && len(b) >= 9:
+		return binary.BigEndian.Uint64(b[1:9]), b[9:], nil
+	case info >= 28:
+		return 0, nil, fmt.Errorf("cbor: indefinite lengths are not supported")
+	}
+	return 0, nil, fmt.Errorf("cbor: truncated header")
+}
@@ -0,0 +1,268 @@
+// Package webauthn verifies the relying party side of WebAuthn (passkey)
+// ceremonies: client data, authenticator data and assertion signatures.
+// Attestation statements are not verified; registrations ask for "none"
+// since we do not restrict which authenticators users may bring.
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// COSE algorithm identifiers we accept, in order of preference.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+var SupportedAlgorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+// Ceremony types found in client data.
+const (
+	TypeCreate = "webauthn.create"
+	TypeGet    = "webauthn.get"
+)
+
+// Authenticator data flags.
+const (
+	FlagUserPresent            = 0x01
+	FlagUserVerified           = 0x04
+	FlagAttestedCredentialData = 0x40
+	FlagExtensionData          = 0x80
+)
+
+var b64 = base64.RawURLEncoding
+
+// Encode and Decode are the base64url form credential IDs, challenges and
+// binary ceremony fields travel in.
+func Encode(b []byte) string {
+	return b64.EncodeToString(b)
+}
+
+func Decode(s string) ([]byte, error) {
+	// some clients pad
+	return b64.DecodeString(strings.TrimRight(s, "="))
+}
+
+// NewChallenge returns 32 random bytes, base64url encoded.
+func NewChallenge() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return Encode(b), nil
+}
+
+// ClientData is the collectedClientData the browser signs over.
+type ClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+func ParseClientData(raw []byte) (*ClientData, error) {
+	var c ClientData
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, fmt.Errorf("invalid client data: %w", err)
+	}
+	return &c, nil
+}
+
+// Verify checks the ceremony type, the challenge we issued and that the
+// page was served from one of origins.
+func (c *ClientData) Verify(typ, challenge string, origins []string) error {
+	if c.Type != typ {
+		return fmt.Errorf("client data type is %q, want %q", c.Type, typ)
+	}
+	if c.Challenge != challenge {
+		return fmt.Errorf("challenge mismatch")
+	}
+	if c.CrossOrigin {
+		return fmt.Errorf("cross-origin ceremonies are not allowed")
+	}
+	for _, o := range origins {
+		if c.Origin == o {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %q is not allowed", c.Origin)
+}
+
+// AuthenticatorData is the authenticator's signed statement. CredentialID,
+// AAGUID and PublicKey (a COSE key) are only set during registration.
+type AuthenticatorData struct {
+	RPIDHash  []byte
+	Flags     byte
+	SignCount uint32
+
+	AAGUID       []byte
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+func (a *AuthenticatorData) UserPresent() bool  { return a.Flags&FlagUserPresent != 0 }
+func (a *AuthenticatorData) UserVerified() bool { return a.Flags&FlagUserVerified != 0 }
+
+// VerifyRPID checks the data was produced for rpID.
+func (a *AuthenticatorData) VerifyRPID(rpID string) error {
+	sum := sha256.Sum256([]byte(rpID))
+	if !bytes.Equal(a.RPIDHash, sum[:]) {
+		return fmt.Errorf("authenticator data is for another relying party")
+	}
+	return nil
+}
+
+func ParseAuthenticatorData(b []byte) (*AuthenticatorData, error) {
+	if len(b) < 37 {
+		return nil, fmt.Errorf("authenticator data too short")
+	}
+	a := &AuthenticatorData{
+		RPIDHash:  append([]byte(nil), b[:32]...),
+		Flags:     b[32],
+		SignCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+	rest := b[37:]
+
+	if a.Flags&FlagAttestedCredentialData != 0 {
+		if len(rest) < 18 {
+			return nil, fmt.Errorf("attested credential data too short")
+		}
+		a.AAGUID = append([]byte(nil), rest[:16]...)
+		n := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if n == 0 || n > 1023 || len(rest) < n {
+			return nil, fmt.Errorf("invalid credential id length")
+		}
+		a.CredentialID = append([]byte(nil), rest[:n]...)
+		rest = rest[n:]
+
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid credential public key: %w", err)
+		}
+		a.PublicKey = append([]byte(nil), rest[:len(rest)-len(after)]...)
+		rest = after
+	}
+	if a.Flags&FlagExtensionData != 0 {
+		var err error
+		if _, rest, err = decodeCBOR(rest); err != nil {
+			return nil, fmt.Errorf("invalid extension data: %w", err)
+		}
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("trailing bytes after authenticator data")
+	}
+	return a, nil
+}
+
+// ParseAttestationObject returns the attestation format and the
+// authenticator data of a registration. The statement itself is ignored.
+func ParseAttestationObject(b []byte) (string, *AuthenticatorData, error) {
+	v, rest, err := decodeCBOR(b)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid attestation object: %w", err)
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok || len(rest) != 0 {
+		return "", nil, fmt.Errorf("invalid attestation object")
+	}
+	format, _ := m["fmt"].(string)
+	raw, ok := m["authData"].([]byte)
+	if !ok {
+		return "", nil, fmt.Errorf("attestation object has no authData")
+	}
+	authData, err := ParseAuthenticatorData(raw)
+	if err != nil {
+		return "", nil, err
+	}
+	if authData.CredentialID == nil {
+		return "", nil, fmt.Errorf("attestation has no credential")
+	}
+	return format, authData, nil
+}
+
+// ParsePublicKey decodes a COSE key and returns it with its algorithm.
+func ParsePublicKey(cose []byte) (crypto.PublicKey, int, error) {
+	v, _, err := decodeCBOR(cose)
+	if err != nil {
+		return nil, 0, err
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, fmt.Errorf("COSE key is not a map")
+	}
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+	crv, _ := m[int64(-1)].(int64)
+
+	switch {
+	case kty == 2 && alg == AlgES256 && crv == 1:
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if len(x) != 32 || len(y) != 32 {
+			return nil, 0, fmt.Errorf("invalid P-256 key")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, 0, fmt.Errorf("P-256 point is not on the curve")
+		}
+		return pub, AlgES256, nil
+	case kty == 1 && alg == AlgEdDSA && crv == 6:
+		x, _ := m[int64(-2)].([]byte)
+		if len(x) != ed25519.PublicKeySize {
+			return nil, 0, fmt.Errorf("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), AlgEdDSA, nil
+	case kty == 3 && alg == AlgRS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, fmt.Errorf("invalid RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, AlgRS256, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported COSE key (kty %d, alg %d)", kty, alg)
+}
+
+// VerifyAssertion checks sig over authData and the hash of clientDataJSON
+// with the credential's COSE public key.
+func VerifyAssertion(cose, authData, clientDataJSON, sig []byte) error {
+	pub, alg, err := ParsePublicKey(cose)
+	if err != nil {
+		return err
+	}
+	clientHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), authData...), clientHash[:]...)
+
+	switch alg {
+	case AlgES256:
+		digest := sha256.Sum256(signed)
+		if !ecdsa.VerifyASN1(pub.(*ecdsa.PublicKey), digest[:], sig) {
+			return fmt.Errorf("invalid signature")
+		}
+	case AlgEdDSA:
+		if !ed25519.Verify(pub.(ed25519.PublicKey), signed, sig) {
+			return fmt.Errorf("invalid signature")
+		}
+	case AlgRS256:
+		digest := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(pub.(*rsa.PublicKey), crypto.SHA256, digest[:], sig); err != nil {
+			return fmt.Errorf("invalid signature")
+		}
+	}
+	return nil
+}
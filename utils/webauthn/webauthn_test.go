@@ -0,0 +1,164 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+)
+
+// coseES256 is the CTAP2 canonical COSE encoding of a P-256 public key.
+func coseES256(pub *ecdsa.PublicKey) []byte {
+	x, y := make([]byte, 32), make([]byte, 32)
+	pub.X.FillBytes(x)
+	pub.Y.FillBytes(y)
+	b := []byte{0xa5, 0x01, 0x02, 0x03, 0x26, 0x20, 0x01, 0x21, 0x58, 0x20}
+	b = append(b, x...)
+	b = append(b, 0x22, 0x58, 0x20)
+	return append(b, y...)
+}
+
+// coseEdDSA is the COSE encoding of an Ed25519 public key.
+func coseEdDSA(pub ed25519.PublicKey) []byte {
+	b := []byte{0xa4, 0x01, 0x01, 0x03, 0x27, 0x20, 0x06, 0x21, 0x58, 0x20}
+	return append(b, pub...)
+}
+
+func authenticatorData(rpID string, flags byte, signCount uint32) []byte {
+	sum := sha256.Sum256([]byte(rpID))
+	b := append(sum[:], flags)
+	return binary.BigEndian.AppendUint32(b, signCount)
+}
+
+// signer signs what an authenticator signs: authData || sha256(clientData).
+type signer func(t *testing.T, authData, clientData []byte) []byte
+
+func es256Signer(key *ecdsa.PrivateKey) signer {
+	return func(t *testing.T, authData, clientData []byte) []byte {
+		h := sha256.Sum256(clientData)
+		digest := sha256.Sum256(append(append([]byte(nil), authData...), h[:]...))
+		sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		return sig
+	}
+}
+
+func edDSASigner(key ed25519.PrivateKey) signer {
+	return func(t *testing.T, authData, clientData []byte) []byte {
+		h := sha256.Sum256(clientData)
+		return ed25519.Sign(key, append(append([]byte(nil), authData...), h[:]...))
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	otherEC, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	authData := authenticatorData("example.com", FlagUserPresent|FlagUserVerified, 7)
+	clientData := []byte(`{"type":"webauthn.get","challenge":"abc","origin":"https://example.com"}`)
+
+	tests := []struct {
+		name       string
+		cose       []byte
+		sign       signer
+		authData   []byte
+		clientData []byte
+		ok         bool
+	}{
+		{"ES256", coseES256(&ecKey.PublicKey), es256Signer(ecKey), nil, nil, true},
+		{"EdDSA", coseEdDSA(edPub), edDSASigner(edKey), nil, nil, true},
+		{"signed by another key", coseES256(&ecKey.PublicKey), es256Signer(otherEC), nil, nil, false},
+		{"EdDSA signature for an ES256 key", coseES256(&ecKey.PublicKey), edDSASigner(edKey), nil, nil, false},
+		{"sign count raised after signing", coseES256(&ecKey.PublicKey), es256Signer(ecKey), authenticatorData("example.com", FlagUserPresent|FlagUserVerified, 8), nil, false},
+		{"client data swapped after signing", coseES256(&ecKey.PublicKey), es256Signer(ecKey), nil, []byte(`{"type":"webauthn.get","challenge":"xyz","origin":"https://example.com"}`), false},
+		{"unsupported key", []byte{0xa1, 0x01, 0x04}, es256Signer(ecKey), nil, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig := tt.sign(t, authData, clientData)
+			ad, cd := authData, clientData
+			if tt.authData != nil {
+				ad = tt.authData
+			}
+			if tt.clientData != nil {
+				cd = tt.clientData
+			}
+			err := VerifyAssertion(tt.cose, ad, cd, sig)
+			if tt.ok && err != nil {
+				t.Fatalf("VerifyAssertion: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("VerifyAssertion accepted the assertion")
+			}
+		})
+	}
+}
+
+func TestParseAuthenticatorData(t *testing.T) {
+	a, err := ParseAuthenticatorData(authenticatorData("example.com", FlagUserPresent, 42))
+	if err != nil {
+		t.Fatalf("ParseAuthenticatorData: %v", err)
+	}
+	if a.SignCount != 42 || !a.UserPresent() || a.UserVerified() {
+		t.Errorf("got count %d, present %v, verified %v", a.SignCount, a.UserPresent(), a.UserVerified())
+	}
+	if err := a.VerifyRPID("example.com"); err != nil {
+		t.Errorf("VerifyRPID: %v", err)
+	}
+	if err := a.VerifyRPID("evil.example"); err == nil {
+		t.Error("data for example.com accepted for evil.example")
+	}
+
+	for name, b := range map[string][]byte{
+		"too short":      authenticatorData("example.com", FlagUserPresent, 1)[:36],
+		"trailing bytes": append(authenticatorData("example.com", FlagUserPresent, 1), 0x00),
+	} {
+		if _, err := ParseAuthenticatorData(b); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestClientDataVerify(t *testing.T) {
+	origins := []string{"https://example.com"}
+
+	tests := []struct {
+		name string
+		data ClientData
+		ok   bool
+	}{
+		{"matching", ClientData{Type: TypeGet, Challenge: "abc", Origin: "https://example.com"}, true},
+		{"registration data in a login", ClientData{Type: TypeCreate, Challenge: "abc", Origin: "https://example.com"}, false},
+		{"another challenge", ClientData{Type: TypeGet, Challenge: "abd", Origin: "https://example.com"}, false},
+		{"another origin", ClientData{Type: TypeGet, Challenge: "abc", Origin: "https://example.com.evil"}, false},
+		{"cross-origin iframe", ClientData{Type: TypeGet, Challenge: "abc", Origin: "https://example.com", CrossOrigin: true}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.data.Verify(TypeGet, "abc", origins)
+			if tt.ok && err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("Verify accepted the client data")
+			}
+		})
+	}
+}